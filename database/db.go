@@ -31,6 +31,17 @@ func initModels() error {
 	models := []any{
 		&model.User{},
 		&model.Inbound{},
+		&model.InboundClient{},
+		&model.TrafficStat{},
+		&model.TrafficExport{},
+		&model.ClientAlertPolicy{},
+		&model.ClientAlert{},
+		&model.Webhook{},
+		&model.WebhookDelivery{},
+		&model.ClientDevicePolicy{},
+		&model.DeviceLimitState{},
+		&model.DeviceIPSighting{},
+		&model.SpeedRule{},
 		&model.OutboundTraffics{},
 		&model.Setting{},
 		&model.InboundClientIps{},
@@ -39,6 +50,12 @@ func initModels() error {
 		&LinkHistory{},   // 把 LinkHistory 表也迁移
 		&ShortLink{},     // 新增 ShortLink 模型
 		&model.LotteryWin{}, 
+		&model.APIToken{},
+		&model.AuditLog{},
+		&model.LoginHistory{},
+		&model.LoginSession{},
+		&model.Passkey{},
+		&model.RecoveryCode{},
 	}
 	for _, model := range models {
 		if err := db.AutoMigrate(model); err != nil {
@@ -66,6 +83,7 @@ func initUser() error {
 		user := &model.User{
 			Username: defaultUsername,
 			Password: hashedPassword,
+			Role:     model.RoleOwner,
 		}
 		return db.Create(user).Error
 	}
@@ -83,11 +101,37 @@ func runSeeders(isUsersEmpty bool) error {
 		hashSeeder := &model.HistoryOfSeeders{
 			SeederName: "UserPasswordHash",
 		}
-		return db.Create(hashSeeder).Error
+		if err := db.Create(hashSeeder).Error; err != nil {
+			return err
+		}
+		if err := db.Create(&model.HistoryOfSeeders{SeederName: "LimitBackendFail2Ban"}).Error; err != nil {
+			return err
+		}
+		if err := db.Create(&model.HistoryOfSeeders{SeederName: "UserRoleOwner"}).Error; err != nil {
+			return err
+		}
+		if err := db.Create(&model.HistoryOfSeeders{SeederName: "UserTwoFactor"}).Error; err != nil {
+			return err
+		}
+		return db.Create(&model.HistoryOfSeeders{SeederName: "TrustedProxiesLoopback"}).Error
 	} else {
 		var seedersHistory []string
 		db.Model(&model.HistoryOfSeeders{}).Pluck("seeder_name", &seedersHistory)
 
+		if !slices.Contains(seedersHistory, "LimitBackendFail2Ban") {
+			// limitIp used to be enforced by Fail2Ban only; inbounds that relied on it alone keep that backend.
+			// Inbounds with a device limit of their own keep it for every client without a deviceLimit.
+			err := db.Model(&model.Inbound{}).
+				Where("device_limit = 0 AND id IN (SELECT inbound_id FROM clients WHERE limit_ip > 0 AND device_limit = 0)").
+				Update("limit_backend", model.LimitBackendFail2Ban).Error
+			if err != nil {
+				return err
+			}
+			if err := db.Create(&model.HistoryOfSeeders{SeederName: "LimitBackendFail2Ban"}).Error; err != nil {
+				return err
+			}
+		}
+
 		if !slices.Contains(seedersHistory, "UserPasswordHash") && !isUsersEmpty {
 			var users []model.User
 			db.Find(&users)
@@ -104,13 +148,143 @@ func runSeeders(isUsersEmpty bool) error {
 			hashSeeder := &model.HistoryOfSeeders{
 				SeederName: "UserPasswordHash",
 			}
-			return db.Create(hashSeeder).Error
+			if err := db.Create(hashSeeder).Error; err != nil {
+				return err
+			}
+		}
+
+		if !slices.Contains(seedersHistory, "UserRoleOwner") && !isUsersEmpty {
+			// Databases from the single-admin era: the first user becomes the owner
+			var firstUser model.User
+			if err := db.Model(&model.User{}).Order("id asc").First(&firstUser).Error; err != nil {
+				log.Printf("Error loading first user for role migration: %v", err)
+				return err
+			}
+			if err := db.Model(&firstUser).Update("role", model.RoleOwner).Error; err != nil {
+				return err
+			}
+
+			roleSeeder := &model.HistoryOfSeeders{
+				SeederName: "UserRoleOwner",
+			}
+			if err := db.Create(roleSeeder).Error; err != nil {
+				return err
+			}
+		}
+
+		if !slices.Contains(seedersHistory, "UserTwoFactor") {
+			if err := migrateTwoFactor(isUsersEmpty); err != nil {
+				return err
+			}
+		}
+
+		if !slices.Contains(seedersHistory, "TrustedProxiesLoopback") {
+			// trustedProxies used to default to empty, which behind a local reverse proxy made
+			// every request come from 127.0.0.1. Such installs now trust the loopback proxy.
+			err := db.Model(&model.Setting{}).
+				Where("key = ? AND value = ?", "trustedProxies", "").
+				Update("value", "127.0.0.1/8,::1").Error
+			if err != nil {
+				return err
+			}
+			if err := db.Create(&model.HistoryOfSeeders{SeederName: "TrustedProxiesLoopback"}).Error; err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
+// migrateTwoFactor moves the panel-wide TOTP secret of older versions to the first
+// owner, the only account that could change it, and removes the global settings.
+func migrateTwoFactor(isUsersEmpty bool) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var settings []model.Setting
+		err := tx.Where("key IN ?", []string{"twoFactorEnable", "twoFactorToken"}).Find(&settings).Error
+		if err != nil {
+			return err
+		}
+		enable, token := false, ""
+		for _, setting := range settings {
+			switch setting.Key {
+			case "twoFactorEnable":
+				enable = setting.Value == "true"
+			case "twoFactorToken":
+				token = setting.Value
+			}
+		}
+		if enable && token != "" && !isUsersEmpty {
+			var owner model.User
+			err := tx.Model(&model.User{}).Where("role = ?", model.RoleOwner).Order("id asc").First(&owner).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Model(&owner).Updates(map[string]any{"two_factor_enable": true, "two_factor_token": token}).Error
+			if err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("key IN ?", []string{"twoFactorEnable", "twoFactorToken"}).Delete(&model.Setting{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.HistoryOfSeeders{SeederName: "UserTwoFactor"}).Error
+	})
+}
+
+// migrateClients moves the clients arrays still stored in inbounds.settings into
+// the clients table. Inbounds that were already migrated are skipped.
+func migrateClients() error {
+	// Read the raw column, loading inbounds would merge already migrated clients back in
+	var rows []struct {
+		Id       int
+		Settings string
+	}
+	if err := db.Table("inbounds").Select("id, settings").Scan(&rows).Error; err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, row := range rows {
+			_, clients, ok, err := model.SplitClients(row.Settings)
+			if err != nil || !ok {
+				continue
+			}
+			inbound := &model.Inbound{}
+			if err := tx.First(inbound, row.Id).Error; err != nil {
+				return err
+			}
+			// The save hooks store the clients as rows and strip them from settings
+			if err := tx.Save(inbound).Error; err != nil {
+				log.Printf("Error migrating clients of inbound %d: %v", row.Id, err)
+				return err
+			}
+			log.Printf("Migrated %d clients of inbound %d to the clients table", len(clients), row.Id)
+		}
+		return nil
+	})
+}
+
+// RevertClients writes every client back into inbounds.settings and drops the
+// clients table, so that the database can be opened by older versions again.
+// The next start of this version migrates the clients forward again.
+func RevertClients() error {
+	var inbounds []*model.Inbound
+	// Loading merges the clients back into Settings
+	if err := db.Find(&inbounds).Error; err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, inbound := range inbounds {
+			// Written past the model on purpose, the clients table is dropped below
+			err := tx.Table("inbounds").Where("id = ?", inbound.Id).UpdateColumn("settings", inbound.Settings).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Migrator().DropTable(&model.InboundClient{})
+	})
+}
+
 func isTableEmpty(tableName string) (bool, error) {
 	var count int64
 	err := db.Table(tableName).Count(&count).Error
@@ -139,10 +313,16 @@ func InitDB(dbPath string) error {
 	if err != nil {
 		return err
 	}
+	if err := model.RegisterCallbacks(db); err != nil {
+		return err
+	}
 
 	if err := initModels(); err != nil {
 		return err
 	}
+	if err := migrateClients(); err != nil {
+		return err
+	}
 
 	isUsersEmpty, err := isTableEmpty("users")
 
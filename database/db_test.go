@@ -0,0 +1,280 @@
+package database
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"x-ui/database/model"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// legacySettings are inbound settings as stored before the clients table existed
+var legacySettings = map[string]string{
+	"vless-in": `{
+  "clients": [
+    {"id": "1b0f5a7e-7a4e-4ad6-9a2a-7f4c8d1e0b21", "flow": "xtls-rprx-vision", "email": "alice", "limitIp": 2,
+     "totalGB": 10737418240, "expiryTime": 1767225600000, "enable": true, "tgId": 12345, "subId": "sub-alice",
+     "comment": "first", "reset": 30, "speedLimit": 1024, "uploadLimit": 512, "group": "vip",
+     "created_at": 1700000000000, "updated_at": 1700000001000, "customField": "kept", "burstLimit": 64},
+    {"id": "2c1f6b8f-8b5f-4be7-8b3b-8f5d9e2f1c32", "flow": "", "email": "bob", "limitIp": 0,
+     "totalGB": 0, "expiryTime": 0, "enable": false, "tgId": 0, "subId": "sub-bob", "comment": "", "reset": 0}
+  ],
+  "decryption": "none",
+  "fallbacks": [{"dest": 8080}]
+}`,
+	"ss-in": `{
+  "method": "2022-blake3-aes-128-gcm",
+  "password": "c2VydmVyLWtleQ==",
+  "network": "tcp,udp",
+  "clients": [
+    {"method": "", "password": "Y2xpZW50LWtleQ==", "email": "carol", "limitIp": 0, "totalGB": 0,
+     "expiryTime": 0, "enable": true, "tgId": 0, "subId": "sub-carol", "comment": "", "reset": 0}
+  ]
+}`,
+}
+
+var legacyProtocols = map[string]model.Protocol{"vless-in": model.VLESS, "ss-in": model.Shadowsocks}
+
+func openTestDB(t *testing.T) {
+	t.Helper()
+	var err error
+	db, err = gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "x-ui.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := model.RegisterCallbacks(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := initModels(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { CloseDB() })
+	// Raw inserts skip the save hooks, like rows written by an older version
+	id := 1
+	for _, tag := range []string{"vless-in", "ss-in"} {
+		err := db.Exec("INSERT INTO inbounds (id, enable, port, protocol, settings, tag) VALUES (?, ?, ?, ?, ?, ?)",
+			id, true, 10000+id, legacyProtocols[tag], legacySettings[tag], tag).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		id++
+	}
+}
+
+func decodeSettings(t *testing.T, settings string) map[string]any {
+	t.Helper()
+	var parsed map[string]any
+	if err := json.Unmarshal([]byte(settings), &parsed); err != nil {
+		t.Fatalf("%v in %s", err, settings)
+	}
+	return parsed
+}
+
+// assertRoundTrip checks that every legacy field of the settings and of each client survived
+func assertRoundTrip(t *testing.T, tag string, settings string) {
+	t.Helper()
+	want := decodeSettings(t, legacySettings[tag])
+	got := decodeSettings(t, settings)
+	for key, value := range want {
+		if key == "clients" {
+			continue
+		}
+		if !reflect.DeepEqual(got[key], value) {
+			t.Errorf("%s: settings.%s = %v, want %v", tag, key, got[key], value)
+		}
+	}
+	wantClients := want["clients"].([]any)
+	gotClients, _ := got["clients"].([]any)
+	if len(gotClients) != len(wantClients) {
+		t.Fatalf("%s: got %d clients, want %d", tag, len(gotClients), len(wantClients))
+	}
+	for i := range wantClients {
+		wantClient := wantClients[i].(map[string]any)
+		gotClient := gotClients[i].(map[string]any)
+		for key, value := range wantClient {
+			if !reflect.DeepEqual(gotClient[key], value) {
+				t.Errorf("%s: client %d %s = %v, want %v", tag, i, key, gotClient[key], value)
+			}
+		}
+	}
+}
+
+func TestMigrateClients(t *testing.T) {
+	openTestDB(t)
+	if err := migrateClients(); err != nil {
+		t.Fatal(err)
+	}
+
+	var raw []struct {
+		Tag      string
+		Settings string
+	}
+	if err := db.Table("inbounds").Select("tag, settings").Scan(&raw).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range raw {
+		if _, ok := decodeSettings(t, row.Settings)["clients"]; ok {
+			t.Errorf("%s: clients are still stored in inbounds.settings", row.Tag)
+		}
+	}
+
+	var rows []*model.InboundClient
+	if err := db.Order("inbound_id asc, position asc").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d client rows, want 3", len(rows))
+	}
+	alice := rows[0]
+	if alice.InboundId != 1 || alice.Position != 0 || alice.Email != "alice" || alice.ClientId != "1b0f5a7e-7a4e-4ad6-9a2a-7f4c8d1e0b21" ||
+		alice.SpeedLimit != 1024 || alice.UploadLimit != 512 || alice.BurstLimit != 64 || alice.TgID != 12345 || alice.Group != "vip" ||
+		alice.TotalGB != 10737418240 || alice.CreatedAt != 1700000000000 {
+		t.Errorf("alice was migrated as %+v", alice)
+	}
+	if alice.Extra != `{"customField":"kept"}` {
+		t.Errorf("alice.Extra = %s", alice.Extra)
+	}
+	if rows[1].Email != "bob" || rows[1].Position != 1 || rows[1].Enable {
+		t.Errorf("bob was migrated as %+v", rows[1])
+	}
+	if rows[2].InboundId != 2 || rows[2].Password != "Y2xpZW50LWtleQ==" || rows[2].Extra != `{"method":""}` {
+		t.Errorf("carol was migrated as %+v", rows[2])
+	}
+
+	// A list is merged back with one query for all inbounds, a single inbound the same way
+	var inbounds []*model.Inbound
+	if err := db.Order("id asc").Find(&inbounds).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(inbounds) != 2 {
+		t.Fatalf("got %d inbounds, want 2", len(inbounds))
+	}
+	for _, inbound := range inbounds {
+		assertRoundTrip(t, inbound.Tag, inbound.Settings)
+	}
+	inbound := &model.Inbound{}
+	if err := db.First(inbound, 2).Error; err != nil {
+		t.Fatal(err)
+	}
+	assertRoundTrip(t, "ss-in", inbound.Settings)
+
+	// Migrating again changes nothing
+	if err := migrateClients(); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&model.InboundClient{}).Count(&count)
+	if count != 3 {
+		t.Errorf("got %d client rows after a second migration, want 3", count)
+	}
+}
+
+func TestRevertClients(t *testing.T) {
+	openTestDB(t)
+	if err := migrateClients(); err != nil {
+		t.Fatal(err)
+	}
+	if err := RevertClients(); err != nil {
+		t.Fatal(err)
+	}
+	if db.Migrator().HasTable(&model.InboundClient{}) {
+		t.Error("the clients table was not dropped")
+	}
+	var raw []struct {
+		Tag      string
+		Settings string
+	}
+	if err := db.Table("inbounds").Select("tag, settings").Scan(&raw).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range raw {
+		assertRoundTrip(t, row.Tag, row.Settings)
+	}
+}
+
+func TestMigrateTwoFactor(t *testing.T) {
+	openTestDB(t)
+	users := []*model.User{
+		{Username: "operator", Role: model.RoleOperator},
+		{Username: "owner", Role: model.RoleOwner},
+		{Username: "second-owner", Role: model.RoleOwner},
+	}
+	if err := db.Create(users).Error; err != nil {
+		t.Fatal(err)
+	}
+	settings := []*model.Setting{
+		{Key: "twoFactorEnable", Value: "true"},
+		{Key: "twoFactorToken", Value: "JBSWY3DPEHPK3PXP"},
+	}
+	if err := db.Create(settings).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateTwoFactor(false); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []*model.User
+	if err := db.Order("id asc").Find(&got).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range got {
+		want := user.Username == "owner"
+		if user.TwoFactorEnable != want || (user.TwoFactorToken == "JBSWY3DPEHPK3PXP") != want {
+			t.Errorf("%s: two factor = %v/%q after the migration", user.Username, user.TwoFactorEnable, user.TwoFactorToken)
+		}
+	}
+	var count int64
+	db.Model(&model.Setting{}).Where("key IN ?", []string{"twoFactorEnable", "twoFactorToken"}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d global two-factor settings are left", count)
+	}
+}
+
+func TestSingleClientWrites(t *testing.T) {
+	openTestDB(t)
+	if err := migrateClients(); err != nil {
+		t.Fatal(err)
+	}
+
+	bob := map[string]any{"id": "2c1f6b8f-8b5f-4be7-8b3b-8f5d9e2f1c32", "email": "bob", "enable": true, "comment": "edited"}
+	if err := model.SaveClient(db, 1, 1, bob); err != nil {
+		t.Fatal(err)
+	}
+	dave := map[string]any{"id": "3d2f7c9a-9c6a-4cf8-9c4d-9a6e0f3a2d43", "email": "dave", "enable": true}
+	if err := model.SaveClient(db, 1, 2, dave); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.DeleteClient(db, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := model.LoadClients(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Email != "bob" || rows[0].Position != 0 || !rows[0].Enable || rows[0].Comment != "edited" ||
+		rows[1].Email != "dave" || rows[1].Position != 1 {
+		t.Errorf("got clients %+v %+v", rows[0], rows[len(rows)-1])
+	}
+	var count int64
+	db.Model(&model.InboundClient{}).Where("inbound_id = ?", 2).Count(&count)
+	if count != 1 {
+		t.Errorf("the clients of the other inbound were changed, %d left", count)
+	}
+
+	// Writing settings past the save hooks would leave the clients table behind
+	if err := db.Model(&model.Inbound{Id: 1}).Update("settings", `{"clients": []}`).Error; err == nil {
+		t.Error("Update of inbounds.settings was not refused")
+	}
+	if err := db.Model(&model.Inbound{Id: 1}).UpdateColumns(&model.Inbound{Settings: `{"clients": []}`}).Error; err == nil {
+		t.Error("UpdateColumns of inbounds.settings was not refused")
+	}
+	if err := db.Model(&model.Inbound{Id: 1}).Update("enable", false).Error; err != nil {
+		t.Errorf("Update of another column was refused: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package model
+
+import "strings"
+
+// APIScope 限定 API 令牌可以调用的接口范围
+type APIScope string
+
+const (
+	// ScopeRead 只读接口：入站列表、流量、服务器状态等
+	ScopeRead APIScope = "read"
+	// ScopeClients 客户端管理：增删改客户端、重置流量
+	ScopeClients APIScope = "clients"
+	// ScopeServer 服务器控制：入站结构、Xray 启停、账号管理等
+	ScopeServer APIScope = "server"
+)
+
+func (s APIScope) IsValid() bool {
+	switch s {
+	case ScopeRead, ScopeClients, ScopeServer:
+		return true
+	}
+	return false
+}
+
+// APIToken 用于脚本等无界面调用 /panel/api 的长期令牌，数据库中只保存其哈希
+type APIToken struct {
+	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserId     int    `json:"userId" gorm:"index"`
+	Name       string `json:"name"`
+	TokenHash  string `json:"-" gorm:"uniqueIndex"`
+	Prefix     string `json:"prefix"`
+	Scopes     string `json:"scopes"`
+	CreatedAt  int64  `json:"createdAt"`
+	LastUsedAt int64  `json:"lastUsedAt"`
+}
+
+// HasScope reports whether the token was granted the given scope.
+// The server scope implies clients, and clients implies read.
+func (t *APIToken) HasScope(scope APIScope) bool {
+	for _, s := range strings.Split(t.Scopes, ",") {
+		granted := APIScope(strings.TrimSpace(s))
+		if granted == scope || granted == ScopeServer ||
+			(granted == ScopeClients && scope == ScopeRead) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package model
+
+// 审计日志中操作者的来源
+const (
+	ActorWeb    = "web"    // 面板登录会话
+	ActorAPI    = "api"    // API 令牌
+	ActorTgBot  = "tgbot"  // Telegram 机器人
+	ActorSystem = "system" // 定时任务等内部调用
+)
+
+// AuditActor 描述触发一次修改操作的主体
+type AuditActor struct {
+	Type string // ActorWeb / ActorAPI / ActorTgBot / ActorSystem
+	Name string // 用户名、令牌名称或 TG chat id
+	IP   string
+}
+
+// AuditLog 记录一次管理操作，Before/After 为 JSON，Diff 只包含发生变化的字段
+type AuditLog struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	CreatedAt int64  `json:"createdAt" gorm:"index"`
+	ActorType string `json:"actorType" gorm:"index"`
+	Actor     string `json:"actor" gorm:"index"`
+	IP        string `json:"ip"`
+	Action    string `json:"action" gorm:"index"`
+	Target    string `json:"target"`
+	Before    string `json:"before"`
+	After     string `json:"after"`
+	Diff      string `json:"diff"`
+}
@@ -0,0 +1,417 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// InboundClient 是 clients 表的一行：某个入站下的一个客户端。
+// 它是客户端数据的唯一来源，inbounds.settings 中不再保存 clients 数组，
+// 读取入站时再由钩子把它们拼回 settings，因此旧代码看到的 Settings 保持不变。
+type InboundClient struct {
+	Id            int    `json:"-" gorm:"primaryKey;autoIncrement"`
+	InboundId     int    `json:"inboundId" gorm:"index"`
+	Position      int    `json:"-"`               // clients 数组中的顺序
+	ClientId      string `json:"id" gorm:"index"` // VMess/VLESS 的 UUID
+	Security      string `json:"security"`
+	Password      string `json:"password" gorm:"index"`
+	Flow          string `json:"flow"`
+	Email         string `json:"email" gorm:"index"`
+	SpeedLimit    int    `json:"speedLimit"`
+	UploadLimit   int    `json:"uploadLimit"`   // 0 表示沿用 SpeedLimit
+	DownloadLimit int    `json:"downloadLimit"` // 0 表示沿用 SpeedLimit
+	BurstLimit    int    `json:"burstLimit"`
+	LimitIP       int    `json:"limitIp"`
+	DeviceLimit   int    `json:"deviceLimit"` // 0 表示沿用入站的设备限制
+	TotalGB       int64  `json:"totalGB"`
+	ExpiryTime    int64  `json:"expiryTime"`
+	Enable        bool   `json:"enable"`
+	TgID          int64  `json:"tgId" gorm:"index"`
+	SubID         string `json:"subId" gorm:"index"`
+	Comment       string `json:"comment"`
+	Group         string `json:"group" gorm:"index"`
+	Reset         int    `json:"reset"`
+	CreatedAt     int64  `json:"created_at" gorm:"autoCreateTime:false"` // 毫秒，沿用 settings 中的值
+	UpdatedAt     int64  `json:"updated_at" gorm:"autoUpdateTime:false"`
+	// Extra 保存模型不认识的字段（如 Shadowsocks 的 method），保证拼回后不丢数据
+	Extra string `json:"-"`
+}
+
+func (InboundClient) TableName() string {
+	return "clients"
+}
+
+// clientProtocols 是使用 settings.clients 数组的协议
+var clientProtocols = map[Protocol]bool{VMESS: true, VLESS: true, Trojan: true, Shadowsocks: true}
+
+// clientKeys 是 InboundClient 中有独立列的 JSON 字段
+var clientKeys = map[string]bool{
+	"id": true, "security": true, "password": true, "flow": true, "email": true,
+	"speedLimit": true, "uploadLimit": true, "downloadLimit": true, "burstLimit": true,
+	"limitIp": true, "deviceLimit": true, "totalGB": true, "expiryTime": true, "enable": true,
+	"tgId": true, "subId": true, "comment": true, "group": true, "reset": true,
+	"created_at": true, "updated_at": true,
+}
+
+// ToClient 转换为 settings 中使用的 Client 结构
+func (c *InboundClient) ToClient() Client {
+	return Client{
+		ID:            c.ClientId,
+		Security:      c.Security,
+		Password:      c.Password,
+		SpeedLimit:    c.SpeedLimit,
+		UploadLimit:   c.UploadLimit,
+		DownloadLimit: c.DownloadLimit,
+		BurstLimit:    c.BurstLimit,
+		Flow:          c.Flow,
+		Email:         c.Email,
+		LimitIP:       c.LimitIP,
+		DeviceLimit:   c.DeviceLimit,
+		TotalGB:       c.TotalGB,
+		ExpiryTime:    c.ExpiryTime,
+		Enable:        c.Enable,
+		TgID:          c.TgID,
+		SubID:         c.SubID,
+		Comment:       c.Comment,
+		Group:         c.Group,
+		Reset:         c.Reset,
+		CreatedAt:     c.CreatedAt,
+		UpdatedAt:     c.UpdatedAt,
+	}
+}
+
+// key 用于保存时匹配已有行：有邮箱按邮箱，否则按位置
+func (c *InboundClient) key() string {
+	if c.Email != "" {
+		return c.Email
+	}
+	return "#" + strconv.Itoa(c.Position)
+}
+
+// clientFromEntry 把 settings.clients 中的一项转换为表中的一行
+func clientFromEntry(entry map[string]any) (*InboundClient, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	client := Client{}
+	// 与 GetClients 一致：类型不对的旧字段按零值处理
+	json.Unmarshal(data, &client)
+
+	extra := map[string]any{}
+	for key, value := range entry {
+		if !clientKeys[key] {
+			extra[key] = value
+		}
+	}
+	row := &InboundClient{
+		ClientId:      client.ID,
+		Security:      client.Security,
+		Password:      client.Password,
+		Flow:          client.Flow,
+		Email:         client.Email,
+		SpeedLimit:    client.SpeedLimit,
+		UploadLimit:   client.UploadLimit,
+		DownloadLimit: client.DownloadLimit,
+		BurstLimit:    client.BurstLimit,
+		LimitIP:       client.LimitIP,
+		DeviceLimit:   client.DeviceLimit,
+		TotalGB:       client.TotalGB,
+		ExpiryTime:    client.ExpiryTime,
+		Enable:        client.Enable,
+		TgID:          client.TgID,
+		SubID:         client.SubID,
+		Comment:       client.Comment,
+		Group:         client.Group,
+		Reset:         client.Reset,
+		CreatedAt:     client.CreatedAt,
+		UpdatedAt:     client.UpdatedAt,
+	}
+	if len(extra) > 0 {
+		data, err = json.Marshal(extra)
+		if err != nil {
+			return nil, err
+		}
+		row.Extra = string(data)
+	}
+	return row, nil
+}
+
+// entry 是 clientFromEntry 的逆过程
+func (c *InboundClient) entry() map[string]any {
+	entry := map[string]any{}
+	if c.Extra != "" {
+		json.Unmarshal([]byte(c.Extra), &entry)
+	}
+	data, _ := json.Marshal(c)
+	json.Unmarshal(data, &entry)
+	delete(entry, "inboundId")
+	return entry
+}
+
+// SplitClients 从入站 settings 中取出 clients 数组。ok 为 false 表示 settings 中没有该数组。
+func SplitClients(settings string) (rest string, clients []*InboundClient, ok bool, err error) {
+	var parsed map[string]any
+	if err = json.Unmarshal([]byte(settings), &parsed); err != nil {
+		return settings, nil, false, err
+	}
+	raw, ok := parsed["clients"]
+	if !ok {
+		return settings, nil, false, nil
+	}
+	entries, _ := raw.([]any)
+	clients = make([]*InboundClient, 0, len(entries))
+	for _, item := range entries {
+		entry, isMap := item.(map[string]any)
+		if !isMap {
+			continue
+		}
+		client, err := clientFromEntry(entry)
+		if err != nil {
+			return settings, nil, false, err
+		}
+		client.Position = len(clients)
+		clients = append(clients, client)
+	}
+	delete(parsed, "clients")
+	data, err := json.MarshalIndent(parsed, "", "  ")
+	if err != nil {
+		return settings, nil, false, err
+	}
+	return string(data), clients, true, nil
+}
+
+// MergeClients 把表中的客户端放回 settings 的 clients 数组
+func MergeClients(settings string, protocol Protocol, clients []*InboundClient) (string, error) {
+	if len(clients) == 0 && !clientProtocols[protocol] {
+		return settings, nil
+	}
+	var parsed map[string]any
+	if err := json.Unmarshal([]byte(settings), &parsed); err != nil {
+		return settings, err
+	}
+	if parsed == nil {
+		parsed = map[string]any{}
+	}
+	entries := make([]any, 0, len(clients))
+	for _, client := range clients {
+		entries = append(entries, client.entry())
+	}
+	parsed["clients"] = entries
+	data, err := json.MarshalIndent(parsed, "", "  ")
+	if err != nil {
+		return settings, err
+	}
+	return string(data), nil
+}
+
+// SyncClients 让某个入站在 clients 表中的行与给定列表一致，只写入有变化的行
+func SyncClients(tx *gorm.DB, inboundId int, clients []*InboundClient) error {
+	var existing []*InboundClient
+	if err := tx.Where("inbound_id = ?", inboundId).Find(&existing).Error; err != nil {
+		return err
+	}
+	byKey := make(map[string]*InboundClient, len(existing))
+	for _, row := range existing {
+		byKey[row.key()] = row
+	}
+	for position, client := range clients {
+		client.InboundId = inboundId
+		client.Position = position
+		old, found := byKey[client.key()]
+		if !found {
+			client.Id = 0
+			if err := tx.Create(client).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		delete(byKey, client.key())
+		client.Id = old.Id
+		if *old == *client {
+			continue
+		}
+		if err := tx.Save(client).Error; err != nil {
+			return err
+		}
+	}
+	if len(byKey) == 0 {
+		return nil
+	}
+	stale := make([]int, 0, len(byKey))
+	for _, row := range byKey {
+		stale = append(stale, row.Id)
+	}
+	return tx.Where("id IN ?", stale).Delete(InboundClient{}).Error
+}
+
+// SaveClient 只写入入站中第 position 个客户端所在的行，该位置还没有行时新建。
+// 单个客户端的增改用它代替保存整个入站，不必序列化并比较其余客户端。
+func SaveClient(tx *gorm.DB, inboundId int, position int, entry map[string]any) error {
+	client, err := clientFromEntry(entry)
+	if err != nil {
+		return err
+	}
+	client.InboundId = inboundId
+	client.Position = position
+	var ids []int
+	err = tx.Model(InboundClient{}).Where("inbound_id = ? AND position = ?", inboundId, position).Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return tx.Create(client).Error
+	}
+	client.Id = ids[0]
+	return tx.Save(client).Error
+}
+
+// DeleteClient 删除入站中第 position 个客户端，并把其后的客户端前移一位
+func DeleteClient(tx *gorm.DB, inboundId int, position int) error {
+	err := tx.Where("inbound_id = ? AND position = ?", inboundId, position).Delete(InboundClient{}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(InboundClient{}).
+		Where("inbound_id = ? AND position > ?", inboundId, position).
+		UpdateColumn("position", gorm.Expr("position - 1")).Error
+}
+
+// LoadClients 读取某个入站的客户端，按原顺序排列
+func LoadClients(tx *gorm.DB, inboundId int) ([]*InboundClient, error) {
+	var clients []*InboundClient
+	err := tx.Where("inbound_id = ?", inboundId).Order("position asc").Find(&clients).Error
+	return clients, err
+}
+
+// RegisterCallbacks 注册查询入站后拼回客户端的回调。它取代了逐个入站查询 clients 表的
+// AfterFind：一次查出多个入站时，只用一条 SQL 读取它们全部的客户端。
+//
+// clients 表只由 Inbound 的 BeforeSave/AfterSave 钩子同步，因此 inbounds.settings 只能通过
+// Save/Create 整个 Inbound 写入。Update/UpdateColumn/Updates 不运行这些钩子，会把 clients
+// 数组原样写进 settings，之后读取时该入站被当作未迁移的数据，表中的客户端不再生效；
+// 这类写入由 x-ui:guard_settings 拒绝。原生 SQL（Exec）无法拦截，不要用它修改 settings。
+func RegisterCallbacks(db *gorm.DB) error {
+	err := db.Callback().Query().After("gorm:after_query").Register("x-ui:merge_clients", mergeClientsCallback)
+	if err != nil {
+		return err
+	}
+	return db.Callback().Update().Before("gorm:update").Register("x-ui:guard_settings", guardSettingsCallback)
+}
+
+var errSettingsBypass = errors.New("inbounds.settings must be written by saving the inbound, so that the clients table stays in sync")
+
+func guardSettingsCallback(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil || db.Statement.Schema.Table != "inbounds" {
+		return
+	}
+	bypass := false
+	switch dest := db.Statement.Dest.(type) {
+	case map[string]any:
+		_, bypass = dest["settings"]
+	case *Inbound:
+		bypass = db.Statement.SkipHooks && dest.Settings != ""
+	case Inbound:
+		bypass = db.Statement.SkipHooks && dest.Settings != ""
+	}
+	if bypass {
+		db.AddError(errSettingsBypass)
+	}
+}
+
+func mergeClientsCallback(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	var inbounds []*Inbound
+	switch dest := db.Statement.Dest.(type) {
+	case *Inbound:
+		inbounds = []*Inbound{dest}
+	case *[]*Inbound:
+		inbounds = *dest
+	case []*Inbound:
+		inbounds = dest
+	case *[]Inbound:
+		for k := range *dest {
+			inbounds = append(inbounds, &(*dest)[k])
+		}
+	default:
+		return
+	}
+	if err := mergeInboundClients(db.Session(&gorm.Session{NewDB: true}), inbounds); err != nil {
+		db.AddError(err)
+	}
+}
+
+// mergeInboundClients 把 clients 表中的客户端拼回这些入站的 Settings。
+// settings 中仍带有 clients 数组的是尚未迁移的旧数据，保持原样。
+func mergeInboundClients(tx *gorm.DB, inbounds []*Inbound) error {
+	pending := make(map[int]*Inbound, len(inbounds))
+	ids := make([]int, 0, len(inbounds))
+	for _, inbound := range inbounds {
+		if inbound == nil || inbound.Id == 0 || inbound.Settings == "" {
+			continue
+		}
+		if _, _, ok, err := SplitClients(inbound.Settings); ok || err != nil {
+			continue
+		}
+		pending[inbound.Id] = inbound
+		ids = append(ids, inbound.Id)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	byInbound := make(map[int][]*InboundClient, len(ids))
+	// SQLite 限制了一条语句的参数个数，入站很多时分批查询
+	for start := 0; start < len(ids); start += 500 {
+		var clients []*InboundClient
+		err := tx.Where("inbound_id IN ?", ids[start:min(start+500, len(ids))]).
+			Order("inbound_id asc, position asc").Find(&clients).Error
+		if err != nil {
+			return err
+		}
+		for _, client := range clients {
+			byInbound[client.InboundId] = append(byInbound[client.InboundId], client)
+		}
+	}
+	for _, id := range ids {
+		inbound := pending[id]
+		settings, err := MergeClients(inbound.Settings, inbound.Protocol, byInbound[id])
+		if err != nil {
+			return err
+		}
+		inbound.Settings = settings
+	}
+	return nil
+}
+
+// BeforeSave 从 Settings 中取出 clients，只把其余部分写入 inbounds 表
+func (i *Inbound) BeforeSave(tx *gorm.DB) error {
+	if i.Settings == "" {
+		return nil
+	}
+	rest, clients, ok, err := SplitClients(i.Settings)
+	if err != nil || !ok {
+		// 无法解析的 settings 原样保存，与以前的行为一致
+		return nil
+	}
+	i.fullSettings = i.Settings
+	i.clients = clients
+	i.Settings = rest
+	return nil
+}
+
+// AfterSave 同步 clients 表，并恢复调用方手中完整的 Settings
+func (i *Inbound) AfterSave(tx *gorm.DB) error {
+	if i.clients == nil {
+		return nil
+	}
+	err := SyncClients(tx.Session(&gorm.Session{NewDB: true}), i.Id, i.clients)
+	i.Settings = i.fullSettings
+	i.clients = nil
+	i.fullSettings = ""
+	return err
+}
@@ -0,0 +1,43 @@
+package model
+
+// ClientAlertKind 客户端告警的类型
+type ClientAlertKind string
+
+const (
+	ClientAlertTraffic ClientAlertKind = "traffic" // 已用流量达到总流量的百分比
+	ClientAlertExpiry  ClientAlertKind = "expiry"  // 距离到期还剩的天数
+)
+
+// ClientAlertPolicy 的作用范围
+type ClientAlertScope string
+
+const (
+	ClientAlertScopeClient ClientAlertScope = "client" // Name 为客户端邮箱
+	ClientAlertScopeGroup  ClientAlertScope = "group"  // Name 为客户端分组，作为组内客户端的默认值
+)
+
+// ClientAlertPolicy 为某个客户端或分组设置告警阈值。
+// 阈值为逗号分隔的整数，如 "50,80,95"；留空表示沿用上一级（分组、全局设置），"0" 表示不告警。
+type ClientAlertPolicy struct {
+	Id              int              `json:"id" gorm:"primaryKey;autoIncrement"`
+	Scope           ClientAlertScope `json:"scope" gorm:"uniqueIndex:idx_client_alert_policy"`
+	Name            string           `json:"name" gorm:"uniqueIndex:idx_client_alert_policy"`
+	TrafficPercents string           `json:"trafficPercents"`
+	ExpiryDays      string           `json:"expiryDays"`
+}
+
+// ClientAlert 记录一条已发送的告警。同一计费周期内每个阈值只发送一次，
+// 自动续期时把本周期的记录标记为已重置（ResetAt），记录本身保留。
+type ClientAlert struct {
+	Id        int             `json:"id" gorm:"primaryKey;autoIncrement"`
+	CreatedAt int64           `json:"createdAt" gorm:"index"`
+	Email     string          `json:"email" gorm:"index"`
+	Kind      ClientAlertKind `json:"kind"`
+	Threshold int             `json:"threshold"` // 百分比或天数
+	// Target 是告警针对的额度：流量告警为总流量，到期告警为到期时间（毫秒）。
+	// 额度改变后旧记录不再匹配，新的阈值会重新告警。
+	Target     int64  `json:"target"`
+	Value      int64  `json:"value"`      // 发送时的已用流量或剩余毫秒数
+	Recipients string `json:"recipients"` // 接收告警的 Telegram chat ID，逗号分隔
+	ResetAt    int64  `json:"resetAt"`    // 所在计费周期结束的时间，0 表示仍是当前周期
+}
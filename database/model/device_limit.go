@@ -0,0 +1,90 @@
+package model
+
+import "time"
+
+// DeviceLimitAction 客户端在线设备数超过限制后的处理方式
+type DeviceLimitAction string
+
+const (
+	// DeviceLimitBlock 把客户端的 UUID/密码换成随机值，所有设备断开，冷却结束后恢复
+	DeviceLimitBlock DeviceLimitAction = "block"
+	// DeviceLimitKick 断开当前连接并忘记最新出现的 IP，只让最早的设备保留名额，不封禁
+	DeviceLimitKick DeviceLimitAction = "kick"
+	// DeviceLimitThrottle 把客户端降到 ThrottleSpeed 限速等级，冷却结束后恢复原等级
+	DeviceLimitThrottle DeviceLimitAction = "throttle"
+	// DeviceLimitNotify 只发送通知，不做任何限制
+	DeviceLimitNotify DeviceLimitAction = "notify"
+)
+
+// LimitBackend 是执行设备限制的方式，按入站选择
+type LimitBackend string
+
+const (
+	// LimitBackendXray 通过 Xray API 按 DeviceLimitPolicy 处理超限的客户端
+	LimitBackendXray LimitBackend = "xray"
+	// LimitBackendFail2Ban 把超出限制的最新IP写入 IP 限制日志，由 Fail2Ban 用 iptables 封禁，
+	// 处理方式和冷却期不生效，封禁时长由 Fail2Ban 决定
+	LimitBackendFail2Ban LimitBackend = "fail2ban"
+	// LimitBackendFirewall 面板自己用 nftables（没有时用 iptables）在入站端口上封禁超出限制的最新IP，
+	// 处理方式和冷却期同样不生效，封禁时长由面板设置 firewallBanTime 决定
+	LimitBackendFirewall LimitBackend = "firewall"
+)
+
+// DefaultDeviceTTL 是未设置 TTL 时的在线判断窗口
+const DefaultDeviceTTL = 3 * time.Minute
+
+// DeviceLimitPolicy 是设备限制的处理策略。入站以 device_ 前缀的列保存一份，
+// 单个客户端可以用 ClientDevicePolicy 整体覆盖。
+type DeviceLimitPolicy struct {
+	Action DeviceLimitAction `json:"deviceAction" form:"deviceAction"` // 留空等同于 block
+	// TTL 秒：IP 在这段时间内有连接记录即算在线，0 使用 DefaultDeviceTTL
+	TTL int `json:"deviceTtl" form:"deviceTtl"`
+	// Grace 秒：持续超限这么久之后才处理，0 表示立即处理
+	Grace int `json:"deviceGrace" form:"deviceGrace"`
+	// Cooldown 秒：恢复到限制以内这么久之后才解除，0 表示立即解除
+	Cooldown int `json:"deviceCooldown" form:"deviceCooldown"`
+	// ThrottleSpeed 是 throttle 使用的限速等级，单位 KB/s
+	ThrottleSpeed int `json:"deviceThrottleSpeed" form:"deviceThrottleSpeed"`
+}
+
+// GetAction returns the action, treating an empty one as block
+func (p *DeviceLimitPolicy) GetAction() DeviceLimitAction {
+	if p.Action == "" {
+		return DeviceLimitBlock
+	}
+	return p.Action
+}
+
+// ActiveTTL returns how long an IP counts as online after its last connection
+func (p *DeviceLimitPolicy) ActiveTTL() time.Duration {
+	if p.TTL <= 0 {
+		return DefaultDeviceTTL
+	}
+	return time.Duration(p.TTL) * time.Second
+}
+
+// ClientDevicePolicy 为单个客户端覆盖其入站的设备限制策略
+type ClientDevicePolicy struct {
+	Id                int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Email             string `json:"email" form:"email" gorm:"unique"`
+	DeviceLimitPolicy `gorm:"embedded;embeddedPrefix:device_"`
+}
+
+// DeviceLimitState 是设备限制任务对某个客户端的跟踪状态，持久化后面板重启也能与 Xray 对账。
+// Action 为空表示只在计算宽限期或冷却期，客户端尚未被处理。时间均为 unix 秒，0 表示未开始。
+type DeviceLimitState struct {
+	Email            string            `json:"email" gorm:"primaryKey"`
+	Action           DeviceLimitAction `json:"action"`           // 正在执行的处理方式
+	ActedAt          int64             `json:"actedAt"`          // 开始处理的时间
+	OverLimitSince   int64             `json:"overLimitSince"`   // 开始超限的时间，用于宽限期
+	WithinLimitSince int64             `json:"withinLimitSince"` // 被处理后回到限制以内的时间，用于冷却期
+}
+
+// DeviceIPSighting 是客户端的一个在线 IP，超过策略的 TTL 没有新连接即被删除
+type DeviceIPSighting struct {
+	Id        int    `json:"-" gorm:"primaryKey;autoIncrement"`
+	Email     string `json:"email" gorm:"uniqueIndex:idx_device_ip_sighting"`
+	IP        string `json:"ip" gorm:"uniqueIndex:idx_device_ip_sighting"`
+	FirstSeen int64  `json:"firstSeen"` // unix 秒
+	LastSeen  int64  `json:"lastSeen"`
+}
@@ -0,0 +1,29 @@
+package model
+
+// 登录尝试的结果
+type LoginResult string
+
+const (
+	LoginResultSuccess        LoginResult = "success"
+	LoginResultUnknownUser    LoginResult = "unknown_user"
+	LoginResultWrongPassword  LoginResult = "wrong_password"
+	LoginResultWrongTwoFactor LoginResult = "wrong_2fa"
+	LoginResultWrongPasskey   LoginResult = "wrong_passkey"
+	// 密码正确，但还需要完成通行密钥验证
+	LoginResultPasskeyRequired LoginResult = "passkey_required"
+	LoginResultRateLimited     LoginResult = "rate_limited"
+	LoginResultError           LoginResult = "error"
+)
+
+// LoginHistory 记录一次面板登录尝试，绝不保存明文密码
+type LoginHistory struct {
+	Id           int         `json:"id" gorm:"primaryKey;autoIncrement"`
+	CreatedAt    int64       `json:"createdAt" gorm:"index"`
+	Username     string      `json:"username" gorm:"index"`
+	IP           string      `json:"ip" gorm:"index"`
+	UserAgent    string      `json:"userAgent"`
+	Success      bool        `json:"success" gorm:"index"`
+	Reason       LoginResult `json:"reason"`
+	PasswordHint string      `json:"passwordHint"` // 仅包含长度信息的掩码，如 "******(6)"
+	LockedUntil  int64       `json:"lockedUntil"`  // 本次失败触发锁定时的解锁时间，否则为 0
+}
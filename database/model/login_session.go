@@ -0,0 +1,13 @@
+package model
+
+// LoginSession 是保存在服务端的面板登录会话，Cookie 中只保存签名后的会话 ID
+type LoginSession struct {
+	Id         string `json:"id" gorm:"primaryKey"`
+	UserId     int    `json:"userId" gorm:"index"`
+	Data       []byte `json:"-"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"userAgent"`
+	CreatedAt  int64  `json:"createdAt"`
+	LastSeenAt int64  `json:"lastSeenAt"`
+	ExpiresAt  int64  `json:"expiresAt" gorm:"index"`
+}
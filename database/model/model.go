@@ -20,10 +20,41 @@ const (
 	WireGuard   Protocol = "wireguard"
 )
 
+// Role 面板账号的角色，决定其可访问的接口范围
+type Role string
+
+const (
+	// RoleOwner 拥有全部权限，包括面板设置、Xray 控制与账号管理
+	RoleOwner Role = "owner"
+	// RoleOperator 可以管理客户端，但不能修改入站结构、面板设置或控制服务器
+	RoleOperator Role = "operator"
+	// RoleAuditor 只读账号
+	RoleAuditor Role = "auditor"
+	// RoleReseller 代理账号，只能看到并管理自己名下的入站与客户端，受配额限制
+	RoleReseller Role = "reseller"
+)
+
+func (r Role) IsValid() bool {
+	switch r {
+	case RoleOwner, RoleOperator, RoleAuditor, RoleReseller:
+		return true
+	}
+	return false
+}
+
 type User struct {
 	Id       int    `json:"id" gorm:"primaryKey;autoIncrement"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Role     Role   `json:"role" gorm:"default:operator"`
+
+	// 代理配额，仅对 RoleReseller 生效，0 表示不限制
+	MaxClients int `json:"maxClients" form:"maxClients"`
+	MaxTotalGB int `json:"maxTotalGB" form:"maxTotalGB"`
+
+	// 每个账号独立的两步验证，密钥不会通过 JSON 返回
+	TwoFactorEnable bool   `json:"twoFactorEnable"`
+	TwoFactorToken  string `json:"-"`
 }
 
 type Inbound struct {
@@ -40,6 +71,10 @@ type Inbound struct {
 	// 中文注释: 新增设备限制字段，用于存储每个入站的设备数限制。
 	// gorm:"column:device_limit;default:0" 定义了数据库中的字段名和默认值。
 	DeviceLimit   int                  `json:"deviceLimit" form:"deviceLimit" gorm:"column:device_limit;default:0"`
+	// 超限后的处理策略，见 device_limit.go
+	DeviceLimitPolicy `gorm:"embedded;embeddedPrefix:device_"`
+	// 执行设备限制的方式，留空等同于 xray
+	LimitBackend LimitBackend `json:"limitBackend" form:"limitBackend"`
 
 	ClientStats []xray.ClientTraffic `gorm:"foreignKey:InboundId;references:Id" json:"clientStats" form:"clientStats"`
 
@@ -51,6 +86,10 @@ type Inbound struct {
 	StreamSettings string   `json:"streamSettings" form:"streamSettings"`
 	Tag            string   `json:"tag" form:"tag" gorm:"unique"`
 	Sniffing       string   `json:"sniffing" form:"sniffing"`
+
+	// clients 与 fullSettings 在保存钩子之间传递客户端列表，见 client.go
+	clients      []*InboundClient
+	fullSettings string
 }
 
 type OutboundTraffics struct {
@@ -101,16 +140,24 @@ type Client struct {
 	
 	// 中文注释: 新增“限速”字段，单位 KB/s，0 表示不限速。
     SpeedLimit   int           `json:"speedLimit" form:"speedLimit"`
+	// 中文注释: 单独的上传/下载限速，单位 KB/s，0 表示该方向沿用 SpeedLimit
+	UploadLimit   int `json:"uploadLimit" form:"uploadLimit"`
+	DownloadLimit int `json:"downloadLimit" form:"downloadLimit"`
+	// 中文注释: 突发额度，单位 KB，额度用完之前客户端不限速，额度由限速中未用完的部分补充
+	BurstLimit int `json:"burstLimit" form:"burstLimit"`
 	
 	Flow       string `json:"flow"`
 	Email      string `json:"email"`
 	LimitIP    int    `json:"limitIp"`
+	// 中文注释: 客户端自己的设备数限制，0 表示沿用入站的 deviceLimit
+	DeviceLimit int   `json:"deviceLimit" form:"deviceLimit"`
 	TotalGB    int64  `json:"totalGB" form:"totalGB"`
 	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"`
 	Enable     bool   `json:"enable" form:"enable"`
 	TgID       int64  `json:"tgId" form:"tgId"`
 	SubID      string `json:"subId" form:"subId"`
 	Comment    string `json:"comment" form:"comment"`
+	Group      string `json:"group" form:"group"`
 	Reset      int    `json:"reset" form:"reset"`
 	CreatedAt  int64  `json:"created_at,omitempty"`
 	UpdatedAt  int64  `json:"updated_at,omitempty"`
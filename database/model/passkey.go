@@ -0,0 +1,20 @@
+package model
+
+// Passkey 是账号注册的一个 WebAuthn 凭据，Credential 为库序列化后的 JSON
+type Passkey struct {
+	Id           int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserId       int    `json:"userId" gorm:"index"`
+	Name         string `json:"name"`
+	CredentialId string `json:"-" gorm:"uniqueIndex"` // base64url
+	Credential   string `json:"-"`
+	CreatedAt    int64  `json:"createdAt"`
+	LastUsedAt   int64  `json:"lastUsedAt"`
+}
+
+// RecoveryCode 是一次性的恢复码，数据库中只保存其哈希
+type RecoveryCode struct {
+	Id       int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserId   int    `json:"userId" gorm:"index"`
+	CodeHash string `json:"-" gorm:"index"`
+	UsedAt   int64  `json:"usedAt"`
+}
@@ -0,0 +1,112 @@
+package model
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// speedLevelBase 以上的 policy level 留给上下行不同的限速组合，
+// 以下的 level 编号就是对称限速的 KB/s 值，与以前生成的配置保持一致
+const (
+	speedLevelBase  = 1 << 30
+	speedLevelRange = 1 << 30
+)
+
+// MaxSpeedTiers 限制限速档位的数量，每个档位都会在 Xray 配置中预先生成一个上下行相同的 policy level
+const MaxSpeedTiers = 32
+
+// SpeedProfile 是客户端的限速组合，单位 KB/s，0 表示该方向不限速
+type SpeedProfile struct {
+	Up   int
+	Down int
+}
+
+// SpeedProfile 返回客户端的限速组合：单独设置的上传/下载限速优先，没有设置的方向使用 SpeedLimit
+func (c *Client) SpeedProfile() SpeedProfile {
+	profile := SpeedProfile{Up: c.SpeedLimit, Down: c.SpeedLimit}
+	if c.UploadLimit > 0 {
+		profile.Up = c.UploadLimit
+	}
+	if c.DownloadLimit > 0 {
+		profile.Down = c.DownloadLimit
+	}
+	return profile
+}
+
+// SnapSpeed 把限速值对齐到不超过它的最大档位，低于最低档位时使用最低档位。
+// tiers 需要从小到大排列，没有档位或不限速时原样返回。
+func SnapSpeed(tiers []int, speed int) int {
+	if len(tiers) == 0 || speed <= 0 {
+		return speed
+	}
+	snapped := tiers[0]
+	for _, tier := range tiers {
+		if tier > speed {
+			break
+		}
+		snapped = tier
+	}
+	return snapped
+}
+
+// SnapSpeeds 把客户端的限速、上传限速和下载限速都对齐到档位
+func (c *Client) SnapSpeeds(tiers []int) {
+	c.SpeedLimit = SnapSpeed(tiers, c.SpeedLimit)
+	c.UploadLimit = SnapSpeed(tiers, c.UploadLimit)
+	c.DownloadLimit = SnapSpeed(tiers, c.DownloadLimit)
+}
+
+// IsZero 表示不限速，使用 level 0
+func (p SpeedProfile) IsZero() bool {
+	return p.Up <= 0 && p.Down <= 0
+}
+
+// Cap 返回两个方向都不超过 speed 的限速组合，不限速的方向也会被限制
+func (p SpeedProfile) Cap(speed int) SpeedProfile {
+	capped := p
+	if capped.Up <= 0 || capped.Up > speed {
+		capped.Up = speed
+	}
+	if capped.Down <= 0 || capped.Down > speed {
+		capped.Down = speed
+	}
+	return capped
+}
+
+// Key 是限速组合的唯一标识
+func (p SpeedProfile) Key() string {
+	return fmt.Sprintf("%d/%d", p.Up, p.Down)
+}
+
+// Level 返回限速组合首选的 policy level。上下行相同时编号就是限速值，
+// 其他组合由 Key 的哈希得到 speedLevelBase 以上的编号。不同组合的哈希可能相同，
+// 实际使用的编号由生成配置时的分配决定，冲突时顺延到 NextLevel。
+func (p SpeedProfile) Level() int {
+	if p.IsZero() {
+		return 0
+	}
+	if p.Up == p.Down {
+		return p.Up
+	}
+	h := fnv.New32a()
+	h.Write([]byte(p.Key()))
+	return speedLevelBase + int(h.Sum32()%speedLevelRange)
+}
+
+// NextLevel 返回哈希编号冲突时下一个尝试的编号，在 speedLevelBase 以上的区间内循环
+func NextLevel(level int) int {
+	return speedLevelBase + (level-speedLevelBase+1)%speedLevelRange
+}
+
+// PolicyLevel 返回该限速组合在 Xray 配置 policy.levels 中的内容
+func (p SpeedProfile) PolicyLevel() map[string]any {
+	return map[string]any{
+		"uplinkOnly":        p.Up,
+		"downlinkOnly":      p.Down,
+		"handshake":         4,
+		"connIdle":          300,
+		"statsUserUplink":   true,
+		"statsUserDownlink": true,
+		"statsUserOnline":   true,
+	}
+}
@@ -0,0 +1,17 @@
+package model
+
+// SpeedRule 是自动限速规则：条件成立时把客户端降到 Speed 限速，条件结束后恢复客户端自己的限速。
+// 设置了多个条件时需要同时满足，至少要设置一个条件；多条规则同时命中时使用最低的限速。
+type SpeedRule struct {
+	Id        int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name      string `json:"name" form:"name"`
+	Enable    bool   `json:"enable" form:"enable"`
+	Group     string `json:"group" form:"group"`         // 只对该分组的客户端生效，留空表示所有客户端
+	InboundId int    `json:"inboundId" form:"inboundId"` // 只对该入站的客户端生效，0 表示所有入站
+	// TrafficPercent 已用流量达到总流量的这个百分比后生效，0 表示不按流量；没有总流量的客户端不会命中
+	TrafficPercent int `json:"trafficPercent" form:"trafficPercent"`
+	// StartTime/EndTime 为 "HH:MM"，按面板时区限定每天生效的时段，可以跨越零点；都留空表示不按时段
+	StartTime string `json:"startTime" form:"startTime"`
+	EndTime   string `json:"endTime" form:"endTime"`
+	Speed     int    `json:"speed" form:"speed"` // 生效时的限速，单位 KB/s，只会降低客户端的限速
+}
@@ -0,0 +1,9 @@
+package model
+
+// TrafficExport 是等待发送到外部流量接口的一条流量增量记录。
+// Id 即序列号，严格递增，接收方可以据此去重；发送成功后记录被删除。
+type TrafficExport struct {
+	Id        int64  `json:"seq" gorm:"primaryKey;autoIncrement"`
+	CreatedAt int64  `json:"time"` // unix 秒
+	Data      string `json:"data"` // 本次采集的入站、出站和客户端流量增量 (JSON)
+}
@@ -0,0 +1,28 @@
+package model
+
+// TrafficKind 流量统计的对象类型
+type TrafficKind string
+
+const (
+	TrafficKindClient   TrafficKind = "client"   // Name 为客户端邮箱
+	TrafficKindInbound  TrafficKind = "inbound"  // Name 为入站 tag
+	TrafficKindOutbound TrafficKind = "outbound" // Name 为出站 tag
+)
+
+// 统计桶的长度（秒）。新数据写入分钟桶，之后由任务压缩为小时桶和天桶
+const (
+	TrafficPeriodMinute int64 = 60
+	TrafficPeriodHour   int64 = 3600
+	TrafficPeriodDay    int64 = 86400
+)
+
+// TrafficStat 是某个对象在一个时间桶内产生的流量
+type TrafficStat struct {
+	Id          int         `json:"-" gorm:"primaryKey;autoIncrement"`
+	Kind        TrafficKind `json:"kind" gorm:"uniqueIndex:idx_traffic_stat_bucket"`
+	Name        string      `json:"name" gorm:"uniqueIndex:idx_traffic_stat_bucket"`
+	Period      int64       `json:"period" gorm:"uniqueIndex:idx_traffic_stat_bucket"`
+	BucketStart int64       `json:"bucketStart" gorm:"uniqueIndex:idx_traffic_stat_bucket;index"` // unix 秒
+	Up          int64       `json:"up"`
+	Down        int64       `json:"down"`
+}
@@ -0,0 +1,85 @@
+package model
+
+import "strings"
+
+// WebhookEvent 是可以订阅的面板事件
+type WebhookEvent string
+
+const (
+	WebhookEventClientCreated  WebhookEvent = "client.created"
+	WebhookEventClientDeleted  WebhookEvent = "client.deleted"
+	WebhookEventClientDisabled WebhookEvent = "client.disabled" // 管理员手动停用
+	WebhookEventClientExpired  WebhookEvent = "client.expired"  // 到期后被自动停用
+	WebhookEventClientDepleted WebhookEvent = "client.depleted" // 流量用尽后被自动停用
+	WebhookEventDeviceBanned   WebhookEvent = "device.banned"   // 超出设备数量限制被封禁
+	WebhookEventDeviceUnbanned WebhookEvent = "device.unbanned"
+	WebhookEventXrayCrashed    WebhookEvent = "xray.crashed"
+	WebhookEventXrayRestarted  WebhookEvent = "xray.restarted"
+	WebhookEventLoginSuccess   WebhookEvent = "login.success"
+	WebhookEventLoginFailure   WebhookEvent = "login.failure"
+	WebhookEventSettingChanged WebhookEvent = "settings.changed"
+	// WebhookEventPing 仅用于测试端点，不能被订阅
+	WebhookEventPing WebhookEvent = "ping"
+)
+
+// WebhookEvents 列出所有可订阅的事件
+var WebhookEvents = []WebhookEvent{
+	WebhookEventClientCreated,
+	WebhookEventClientDeleted,
+	WebhookEventClientDisabled,
+	WebhookEventClientExpired,
+	WebhookEventClientDepleted,
+	WebhookEventDeviceBanned,
+	WebhookEventDeviceUnbanned,
+	WebhookEventXrayCrashed,
+	WebhookEventXrayRestarted,
+	WebhookEventLoginSuccess,
+	WebhookEventLoginFailure,
+	WebhookEventSettingChanged,
+}
+
+// Webhook 是管理员登记的外部端点，订阅的事件发生时面板向其 POST 一个签名的 JSON
+type Webhook struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	Secret    string `json:"secret"` // 用于 HMAC-SHA256 签名
+	Events    string `json:"events"` // 逗号分隔的事件列表，"*" 表示全部
+	Enable    bool   `json:"enable"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// Subscribes reports whether the webhook wants the given event
+func (w *Webhook) Subscribes(event WebhookEvent) bool {
+	for _, e := range strings.Split(w.Events, ",") {
+		e = strings.TrimSpace(e)
+		if e == "*" || WebhookEvent(e) == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDeliveryStatus 投递状态
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending WebhookDeliveryStatus = "pending" // 等待首次发送或重试
+	WebhookDeliverySuccess WebhookDeliveryStatus = "success"
+	WebhookDeliveryFailed  WebhookDeliveryStatus = "failed" // 重试次数用尽
+)
+
+// WebhookDelivery 是一次事件投递，同时作为持久化的发送队列和投递日志
+type WebhookDelivery struct {
+	Id            int                   `json:"id" gorm:"primaryKey;autoIncrement"`
+	WebhookId     int                   `json:"webhookId" gorm:"index"`
+	Event         WebhookEvent          `json:"event"`
+	Payload       string                `json:"payload"`
+	Status        WebhookDeliveryStatus `json:"status" gorm:"index:idx_webhook_delivery_due"`
+	Attempts      int                   `json:"attempts"`
+	NextAttemptAt int64                 `json:"nextAttemptAt" gorm:"index:idx_webhook_delivery_due"` // unix 秒
+	LastAttemptAt int64                 `json:"lastAttemptAt"`
+	StatusCode    int                   `json:"statusCode"` // 最近一次响应的 HTTP 状态码，未收到响应为 0
+	Error         string                `json:"error"`
+	CreatedAt     int64                 `json:"createdAt" gorm:"index"`
+}
@@ -150,7 +150,8 @@ func runWebServer() {
 		
 		// 〔中文注释〕：步骤四：创建任务实例时，将 xrayService 和 可能为 nil 的 tgBotService 一同传入。
 		// 这样做是安全的，因为 check_client_ip_job.go 内部的 SendMessage 调用前，会先判断服务实例是否可用。
-		checkJob := job.NewCheckDeviceLimitJob(&xrayService, tgBotService)
+		// 中文注释: 访问日志任务只读取一次日志，同时更新客户端IP记录和执行设备限制
+		checkJob := job.Instrument("access_log", job.NewAccessLogJob(&xrayService, tgBotService))
 
 
 		// 中文注释: 使用一个无限循环，每次定时器触发，就执行一次任务的 Run() 函数
@@ -443,12 +444,14 @@ func updateSetting(port int, username string, password string, webBasePath strin
 	}
 
 	if resetTwoFactor {
-		err := settingService.SetTwoFactorEnable(false)
-
+		// Only the primary owner, other accounts are reset with -resetFactors
+		user, err := userService.GetFirstUser()
+		if err == nil {
+			err = userService.ResetTwoFactor(user)
+		}
 		if err != nil {
 			fmt.Println("Failed to reset two-factor authentication（设置两步验证失败）:", err)
 		} else {
-			settingService.SetTwoFactorToken("")
 			fmt.Println("Two-factor authentication reset successfully --------->>设置两步验证成功")
 		}
 	}
@@ -463,6 +466,83 @@ func updateSetting(port int, username string, password string, webBasePath strin
 	}
 }
 
+func manageAPITokens(name string, scopes string, revokeId int, list bool) {
+	err := database.InitDB(config.GetDBPath())
+	if err != nil {
+		fmt.Println("Database initialization failed（初始化数据库失败）:", err)
+		return
+	}
+
+	tokenService := service.APITokenService{}
+	userService := service.UserService{}
+
+	if name != "" {
+		owner, err := userService.GetFirstUser()
+		if err != nil {
+			fmt.Println("Failed to get owner account（获取管理员账号失败）:", err)
+			return
+		}
+		plain, token, err := tokenService.CreateToken(owner.Id, name, scopes)
+		if err != nil {
+			fmt.Println("Failed to create API token（创建 API 令牌失败）:", err)
+		} else {
+			fmt.Printf("API token %d (%s) created, scopes: %s\n", token.Id, token.Name, token.Scopes)
+			fmt.Println("Token（只显示一次，请妥善保存）:", plain)
+		}
+	}
+
+	if revokeId > 0 {
+		err := tokenService.RevokeToken(revokeId, 0)
+		if err != nil {
+			fmt.Println("Failed to revoke API token（撤销 API 令牌失败）:", err)
+		} else {
+			fmt.Printf("API token %d revoked ------>>API 令牌已撤销\n", revokeId)
+		}
+	}
+
+	if list {
+		tokens, err := tokenService.GetTokens(0)
+		if err != nil {
+			fmt.Println("Failed to list API tokens（获取 API 令牌失败）:", err)
+			return
+		}
+		for _, token := range tokens {
+			lastUsed := "never"
+			if token.LastUsedAt > 0 {
+				lastUsed = time.Unix(token.LastUsedAt, 0).Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%d\t%s\t%s...\tuser=%d\tscopes=%s\tlastUsed=%s\n", token.Id, token.Name, token.Prefix, token.UserId, token.Scopes, lastUsed)
+		}
+	}
+}
+
+// resetUserFactors removes the TOTP secret, passkeys and recovery codes of a locked-out account.
+func resetUserFactors(username string) {
+	err := database.InitDB(config.GetDBPath())
+	if err != nil {
+		fmt.Println("Database initialization failed（初始化数据库失败）:", err)
+		return
+	}
+
+	userService := service.UserService{}
+	passkeyService := service.PasskeyService{}
+
+	user, err := userService.GetUserByUsername(username)
+	if err != nil {
+		fmt.Println("Failed to find account（查找账号失败）:", err)
+		return
+	}
+	err = passkeyService.ResetFactors(user.Id)
+	if err == nil {
+		err = userService.ResetTwoFactor(user)
+	}
+	if err != nil {
+		fmt.Println("Failed to reset two-factor secret, passkeys and recovery codes（重置两步验证、通行密钥和恢复码失败）:", err)
+	} else {
+		fmt.Printf("Two-factor secret, passkeys and recovery codes of %s removed ------>>两步验证、通行密钥和恢复码已清除\n", user.Username)
+	}
+}
+
 func updateCert(publicKey string, privateKey string) {
 	err := database.InitDB(config.GetDBPath())
 	if err != nil {
@@ -521,13 +601,21 @@ func GetListenIP(getListen bool) {
 	}
 }
 
-func migrateDb() {
+func migrateDb(revertClients bool) {
 	inboundService := service.InboundService{}
 
 	err := database.InitDB(config.GetDBPath())
 	if err != nil {
 		log.Fatal(err)
 	}
+	if revertClients {
+		fmt.Println("Moving clients back into inbound settings... ---->>正在将客户端写回入站设置...")
+		if err := database.RevertClients(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Done, the database can now be used by older versions ------------>>完成，旧版本现在可以使用该数据库")
+		return
+	}
 	fmt.Println("Start migrating database... ---->>开始迁移数据库...")
 	inboundService.MigrateDB()
 	fmt.Println("Migration done! ------------>>迁移完成！")
@@ -544,6 +632,10 @@ func main() {
 
 	runCmd := flag.NewFlagSet("run", flag.ExitOnError)
 
+	migrateCmd := flag.NewFlagSet("migrate", flag.ExitOnError)
+	var revertClients bool
+	migrateCmd.BoolVar(&revertClients, "revertClients", false, "Move clients back into inbound settings before downgrading")
+
 	settingCmd := flag.NewFlagSet("setting", flag.ExitOnError)
 	var port int
 	var username string
@@ -561,6 +653,11 @@ func main() {
 	var show bool
 	var getCert bool
 	var resetTwoFactor bool
+	var apiTokenName string
+	var apiTokenScopes string
+	var revokeApiToken int
+	var listApiTokens bool
+	var resetFactors string
 	settingCmd.BoolVar(&reset, "reset", false, "Reset all settings")
 	settingCmd.BoolVar(&show, "show", false, "Display current settings")
 	settingCmd.IntVar(&port, "port", 0, "Set panel port number")
@@ -568,7 +665,7 @@ func main() {
 	settingCmd.StringVar(&password, "password", "", "Set login password")
 	settingCmd.StringVar(&webBasePath, "webBasePath", "", "Set base path for Panel")
 	settingCmd.StringVar(&listenIP, "listenIP", "", "set panel listenIP IP")
-	settingCmd.BoolVar(&resetTwoFactor, "resetTwoFactor", false, "Reset two-factor authentication settings")
+	settingCmd.BoolVar(&resetTwoFactor, "resetTwoFactor", false, "Reset two-factor authentication of the primary owner")
 	settingCmd.BoolVar(&getListen, "getListen", false, "Display current panel listenIP IP")
 	settingCmd.BoolVar(&getCert, "getCert", false, "Display current certificate settings")
 	settingCmd.StringVar(&webCertFile, "webCert", "", "Set path to public key file for panel")
@@ -577,6 +674,11 @@ func main() {
 	settingCmd.StringVar(&tgbotRuntime, "tgbotRuntime", "", "Set cron time for Telegram bot notifications")
 	settingCmd.StringVar(&tgbotchatid, "tgbotchatid", "", "Set chat ID for Telegram bot notifications")
 	settingCmd.BoolVar(&enabletgbot, "enabletgbot", false, "Enable notifications via Telegram bot")
+	settingCmd.StringVar(&apiTokenName, "createApiToken", "", "Create an API token with the given name for the first owner")
+	settingCmd.StringVar(&apiTokenScopes, "apiTokenScopes", "read", "Comma separated scopes of the new API token (read, clients, server)")
+	settingCmd.IntVar(&revokeApiToken, "revokeApiToken", 0, "Revoke the API token with the given ID")
+	settingCmd.BoolVar(&listApiTokens, "listApiTokens", false, "List all API tokens")
+	settingCmd.StringVar(&resetFactors, "resetFactors", "", "Remove the two-factor secret, passkeys and recovery codes of the given username")
 
 	oldUsage := flag.Usage
 	flag.Usage = func() {
@@ -603,7 +705,12 @@ func main() {
 		}
 		runWebServer()
 	case "migrate":
-		migrateDb()
+		err := migrateCmd.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		migrateDb(revertClients)
 	case "setting":
 		err := settingCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -630,6 +737,12 @@ func main() {
 		if enabletgbot {
 			updateTgbotEnableSts(enabletgbot)
 		}
+		if apiTokenName != "" || revokeApiToken > 0 || listApiTokens {
+			manageAPITokens(apiTokenName, apiTokenScopes, revokeApiToken, listApiTokens)
+		}
+		if resetFactors != "" {
+			resetUserFactors(resetFactors)
+		}
 	case "cert":
 		err := settingCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -646,6 +759,8 @@ func main() {
 		fmt.Println()
 		runCmd.Usage()
 		fmt.Println()
+		migrateCmd.Usage()
+		fmt.Println()
 		settingCmd.Usage()
 	}
 }
@@ -0,0 +1,210 @@
+package sub
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"x-ui/util/common"
+
+	"github.com/goccy/go-json"
+)
+
+// shareLink is a share link made by SubService split into its fields. The Clash and
+// sing-box subscriptions are built from these, so every format describes the same servers.
+type shareLink struct {
+	protocol   string // vmess, vless, trojan or ss
+	name       string
+	server     string
+	port       int
+	uuid       string // vmess and vless
+	password   string // trojan and ss
+	cipher     string // vmess security or ss method
+	flow       string
+	encryption string // vless encryption, empty for none
+	network    string // tcp, ws, grpc, httpupgrade, xhttp or kcp
+	header     string // header type of tcp
+	host       string
+	path       string // also the service name of grpc
+	mode       string
+	security   string // none, tls or reality
+	sni        string
+	alpn       string
+	fp         string
+	insecure   bool
+	pbk        string
+	sid        string
+}
+
+// splitLinks returns the links of GetSubs one by one, an inbound with external proxies gives one link per line
+func splitLinks(links []string) []string {
+	var result []string
+	for _, link := range links {
+		for _, line := range strings.Split(link, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				result = append(result, line)
+			}
+		}
+	}
+	return result
+}
+
+// uniqueName numbers repeated names, since clients tell the proxies apart by name
+func uniqueName(used map[string]int, name string) string {
+	used[name]++
+	if used[name] > 1 {
+		return fmt.Sprintf("%s %d", name, used[name])
+	}
+	return name
+}
+
+func parseShareLink(link string) (*shareLink, error) {
+	scheme, _, _ := strings.Cut(link, "://")
+	switch scheme {
+	case "vmess":
+		return parseVmessLink(link)
+	case "vless", "trojan":
+		return parseURLLink(scheme, link)
+	case "ss":
+		return parseShadowsocksLink(link)
+	}
+	return nil, common.NewError("unsupported link:", scheme)
+}
+
+func parseVmessLink(link string) (*shareLink, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(link, "vmess://"))
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]any
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	str := func(key string) string {
+		value, _ := obj[key].(string)
+		return value
+	}
+	port, _ := obj["port"].(float64)
+	l := &shareLink{
+		protocol: "vmess",
+		name:     str("ps"),
+		server:   str("add"),
+		port:     int(port),
+		uuid:     str("id"),
+		cipher:   str("scy"),
+		network:  str("net"),
+		header:   str("type"),
+		host:     str("host"),
+		path:     str("path"),
+		mode:     str("mode"),
+		security: str("tls"),
+		sni:      str("sni"),
+		alpn:     str("alpn"),
+		fp:       str("fp"),
+	}
+	l.insecure, _ = obj["allowInsecure"].(bool)
+	if l.cipher == "" {
+		l.cipher = "auto"
+	}
+	if l.security == "" {
+		l.security = "none"
+	}
+	return l, nil
+}
+
+func parseURLLink(protocol string, link string) (*shareLink, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return nil, common.NewError("invalid port in link:", u.Host)
+	}
+	q := u.Query()
+	l := &shareLink{
+		protocol: protocol,
+		name:     u.Fragment,
+		server:   u.Hostname(),
+		port:     port,
+		flow:     q.Get("flow"),
+		network:  q.Get("type"),
+		header:   q.Get("headerType"),
+		host:     q.Get("host"),
+		path:     q.Get("path"),
+		mode:     q.Get("mode"),
+		security: q.Get("security"),
+		sni:      q.Get("sni"),
+		alpn:     q.Get("alpn"),
+		fp:       q.Get("fp"),
+		insecure: q.Get("allowInsecure") == "1",
+		pbk:      q.Get("pbk"),
+		sid:      q.Get("sid"),
+	}
+	if protocol == "trojan" {
+		l.password = u.User.Username()
+	} else {
+		l.uuid = u.User.Username()
+		if encryption := q.Get("encryption"); encryption != "none" {
+			l.encryption = encryption
+		}
+	}
+	if l.network == "grpc" {
+		l.path = q.Get("serviceName")
+	}
+	if l.security == "" {
+		l.security = "none"
+	}
+	return l, nil
+}
+
+func parseShadowsocksLink(link string) (*shareLink, error) {
+	// The user info is standard base64, which may contain "/", so the link is split by hand
+	rest := strings.TrimPrefix(link, "ss://")
+	rest, fragment, _ := strings.Cut(rest, "#")
+	rest, query, _ := strings.Cut(rest, "?")
+	at := strings.LastIndex(rest, "@")
+	if at < 0 {
+		return nil, common.NewError("invalid shadowsocks link")
+	}
+	userInfo, err := base64.StdEncoding.DecodeString(rest[:at])
+	if err != nil {
+		return nil, err
+	}
+	// The password of a 2022 multi-user inbound is "server:client"
+	method, password, ok := strings.Cut(string(userInfo), ":")
+	if !ok {
+		return nil, common.NewError("invalid shadowsocks user info")
+	}
+	host, portStr, err := net.SplitHostPort(rest[at+1:])
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := url.PathUnescape(fragment)
+	q, _ := url.ParseQuery(query)
+	l := &shareLink{
+		protocol: "ss",
+		name:     name,
+		server:   host,
+		port:     port,
+		password: password,
+		cipher:   method,
+		network:  q.Get("type"),
+		header:   q.Get("headerType"),
+		security: q.Get("security"),
+	}
+	if l.network == "" {
+		l.network = "tcp"
+	}
+	if l.security == "" {
+		l.security = "none"
+	}
+	return l, nil
+}
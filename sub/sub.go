@@ -11,6 +11,7 @@ import (
 	"x-ui/config"
 	"x-ui/logger"
 	"x-ui/util/common"
+	"x-ui/web/entity"
 	"x-ui/web/middleware"
 	"x-ui/web/network"
 	"x-ui/web/service"
@@ -107,16 +108,36 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 		SubJsonRules = ""
 	}
 
+	ClashPath, err := s.settingService.GetSubClashPath()
+	if err != nil {
+		return nil, err
+	}
+
+	SubClashRules, err := s.settingService.GetSubClashRules()
+	if err != nil {
+		SubClashRules = ""
+	}
+
+	SingboxPath, err := s.settingService.GetSubSingboxPath()
+	if err != nil {
+		return nil, err
+	}
+
 	SubTitle, err := s.settingService.GetSubTitle()
 	if err != nil {
 		SubTitle = ""
 	}
 
+	// Paths saved before they were checked would make gin panic on the duplicate route
+	if err := entity.CheckSubPaths(LinksPath, JsonPath, ClashPath, SingboxPath); err != nil {
+		return nil, err
+	}
+
 	g := engine.Group("/")
 
 	s.sub = NewSUBController(
 		g, LinksPath, JsonPath, Encrypt, ShowInfo, RemarkModel, SubUpdates,
-		SubJsonFragment, SubJsonNoises, SubJsonMux, SubJsonRules, SubTitle)
+		SubJsonFragment, SubJsonNoises, SubJsonMux, SubJsonRules, ClashPath, SubClashRules, SingboxPath, SubTitle)
 
 	return engine, nil
 }
@@ -0,0 +1,249 @@
+package sub
+
+import (
+	"strings"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+
+	"github.com/goccy/go-yaml"
+)
+
+const (
+	// clashSelectGroup is the group the rules send traffic to, the user picks a proxy in it
+	clashSelectGroup = "Proxy"
+	// clashAutoGroup picks the proxy with the lowest latency
+	clashAutoGroup = "Auto"
+	clashTestURL   = "https://www.gstatic.com/generate_204"
+)
+
+// SubClashService renders a subscription as a Clash Meta (Mihomo) config. The proxies
+// are read back from the share links of SubService, so both always describe the same servers.
+type SubClashService struct {
+	rules      []string
+	SubService *SubService
+}
+
+// NewSubClashService takes the rules as one Clash rule per line, such as
+// "DOMAIN-SUFFIX,google.com,Proxy". A final "MATCH,Proxy" is added when no MATCH rule is given.
+func NewSubClashService(rules string, subService *SubService) *SubClashService {
+	var clashRules []string
+	for _, line := range strings.Split(rules, "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "- "))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		clashRules = append(clashRules, line)
+	}
+	hasMatch := false
+	for _, rule := range clashRules {
+		if strings.HasPrefix(rule, "MATCH,") {
+			hasMatch = true
+		}
+	}
+	if !hasMatch {
+		clashRules = append(clashRules, "MATCH,"+clashSelectGroup)
+	}
+	return &SubClashService{
+		rules:      clashRules,
+		SubService: subService,
+	}
+}
+
+func (s *SubClashService) GetClash(subId string, host string) (string, string, error) {
+	links, header, err := s.SubService.GetSubs(subId, host)
+	if err != nil || len(links) == 0 {
+		return "", "", err
+	}
+
+	var proxies []yaml.MapSlice
+	var names []string
+	used := make(map[string]int)
+	for _, link := range splitLinks(links) {
+		proxy, name, err := clashProxy(link)
+		if err != nil {
+			logger.Debug("SubClashService - skip link:", err)
+			continue
+		}
+		// Clash needs unique proxy names
+		name = uniqueName(used, name)
+		proxy[0].Value = name
+		proxies = append(proxies, proxy)
+		names = append(names, name)
+	}
+	if len(proxies) == 0 {
+		return "", "", nil
+	}
+
+	groups := []yaml.MapSlice{
+		{
+			{Key: "name", Value: clashSelectGroup},
+			{Key: "type", Value: "select"},
+			{Key: "proxies", Value: append([]string{clashAutoGroup}, append(names, "DIRECT")...)},
+		},
+		{
+			{Key: "name", Value: clashAutoGroup},
+			{Key: "type", Value: "url-test"},
+			{Key: "proxies", Value: names},
+			{Key: "url", Value: clashTestURL},
+			{Key: "interval", Value: 300},
+		},
+	}
+	config := yaml.MapSlice{
+		{Key: "mixed-port", Value: 7890},
+		{Key: "allow-lan", Value: false},
+		{Key: "mode", Value: "rule"},
+		{Key: "log-level", Value: "info"},
+		{Key: "proxies", Value: proxies},
+		{Key: "proxy-groups", Value: groups},
+		{Key: "rules", Value: s.rules},
+	}
+	result, err := yaml.Marshal(config)
+	if err != nil {
+		return "", "", err
+	}
+	return string(result), header, nil
+}
+
+// clashProxy converts a share link into a Clash proxy. The first item of the proxy is its name.
+func clashProxy(link string) (yaml.MapSlice, string, error) {
+	l, err := parseShareLink(link)
+	if err != nil {
+		return nil, "", err
+	}
+	proxy := clashBase(l)
+	switch l.protocol {
+	case "vmess":
+		proxy = append(proxy,
+			yaml.MapItem{Key: "uuid", Value: l.uuid},
+			yaml.MapItem{Key: "alterId", Value: 0},
+			yaml.MapItem{Key: "cipher", Value: l.cipher},
+		)
+	case "vless":
+		proxy = append(proxy, yaml.MapItem{Key: "uuid", Value: l.uuid})
+		if l.flow != "" {
+			proxy = append(proxy, yaml.MapItem{Key: "flow", Value: l.flow})
+		}
+		if l.encryption != "" {
+			proxy = append(proxy, yaml.MapItem{Key: "encryption", Value: l.encryption})
+		}
+	case "trojan":
+		// Clash only dials trojan over TLS
+		if l.security == "none" {
+			return nil, "", common.NewError("trojan without TLS is not supported by Clash:", l.name)
+		}
+		proxy = append(proxy, yaml.MapItem{Key: "password", Value: l.password})
+	case "ss":
+		if l.network != "tcp" || l.security != "none" {
+			return nil, "", common.NewError("shadowsocks over", l.network, l.security, "is not supported by Clash:", l.name)
+		}
+		proxy = append(proxy,
+			yaml.MapItem{Key: "cipher", Value: l.cipher},
+			yaml.MapItem{Key: "password", Value: l.password},
+		)
+		return proxy, l.name, nil
+	}
+	proxy, err = clashStream(proxy, l)
+	return proxy, l.name, err
+}
+
+func clashBase(l *shareLink) yaml.MapSlice {
+	return yaml.MapSlice{
+		{Key: "name", Value: l.name},
+		{Key: "type", Value: l.protocol},
+		{Key: "server", Value: l.server},
+		{Key: "port", Value: l.port},
+		{Key: "udp", Value: true},
+	}
+}
+
+// clashStream adds the TLS or Reality settings and the transport of the link
+func clashStream(proxy yaml.MapSlice, l *shareLink) (yaml.MapSlice, error) {
+	protocol := l.protocol
+	sniKey := "servername"
+	if protocol == "trojan" {
+		sniKey = "sni"
+	}
+	switch l.security {
+	case "tls", "reality":
+		if protocol != "trojan" {
+			proxy = append(proxy, yaml.MapItem{Key: "tls", Value: true})
+		}
+		if l.sni != "" {
+			proxy = append(proxy, yaml.MapItem{Key: sniKey, Value: l.sni})
+		}
+		if l.alpn != "" {
+			proxy = append(proxy, yaml.MapItem{Key: "alpn", Value: strings.Split(l.alpn, ",")})
+		}
+		fp := l.fp
+		if l.security == "reality" && fp == "" {
+			// Reality needs a uTLS fingerprint
+			fp = "chrome"
+		}
+		if fp != "" {
+			proxy = append(proxy, yaml.MapItem{Key: "client-fingerprint", Value: fp})
+		}
+		if l.insecure {
+			proxy = append(proxy, yaml.MapItem{Key: "skip-cert-verify", Value: true})
+		}
+		if l.security == "reality" {
+			proxy = append(proxy, yaml.MapItem{Key: "reality-opts", Value: yaml.MapSlice{
+				{Key: "public-key", Value: l.pbk},
+				{Key: "short-id", Value: l.sid},
+			}})
+		}
+	case "none":
+	default:
+		return nil, common.NewError("unsupported security:", l.security)
+	}
+
+	switch l.network {
+	case "tcp", "":
+		if l.header == "http" {
+			proxy = append(proxy,
+				yaml.MapItem{Key: "network", Value: "http"},
+				yaml.MapItem{Key: "http-opts", Value: yaml.MapSlice{
+					{Key: "method", Value: "GET"},
+					{Key: "path", Value: []string{l.path}},
+					{Key: "headers", Value: yaml.MapSlice{{Key: "Host", Value: []string{l.host}}}},
+				}},
+			)
+		}
+	case "ws", "httpupgrade":
+		opts := yaml.MapSlice{{Key: "path", Value: l.path}}
+		if l.host != "" {
+			opts = append(opts, yaml.MapItem{Key: "headers", Value: yaml.MapSlice{{Key: "Host", Value: l.host}}})
+		}
+		if l.network == "httpupgrade" {
+			opts = append(opts, yaml.MapItem{Key: "v2ray-http-upgrade", Value: true})
+		}
+		proxy = append(proxy,
+			yaml.MapItem{Key: "network", Value: "ws"},
+			yaml.MapItem{Key: "ws-opts", Value: opts},
+		)
+	case "grpc":
+		proxy = append(proxy,
+			yaml.MapItem{Key: "network", Value: "grpc"},
+			yaml.MapItem{Key: "grpc-opts", Value: yaml.MapSlice{{Key: "grpc-service-name", Value: l.path}}},
+		)
+	case "xhttp":
+		// Mihomo supports XHTTP for VLESS only
+		if protocol != "vless" {
+			return nil, common.NewError("xhttp is only supported for vless:", l.name)
+		}
+		opts := yaml.MapSlice{{Key: "path", Value: l.path}}
+		if l.host != "" {
+			opts = append(opts, yaml.MapItem{Key: "host", Value: l.host})
+		}
+		if l.mode != "" {
+			opts = append(opts, yaml.MapItem{Key: "mode", Value: l.mode})
+		}
+		proxy = append(proxy,
+			yaml.MapItem{Key: "network", Value: "xhttp"},
+			yaml.MapItem{Key: "xhttp-opts", Value: opts},
+		)
+	default:
+		return nil, common.NewError("transport", l.network, "is not supported by Clash:", l.name)
+	}
+	return proxy, nil
+}
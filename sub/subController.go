@@ -12,11 +12,15 @@ type SUBController struct {
 	subTitle       string
 	subPath        string
 	subJsonPath    string
+	subClashPath   string
+	subSingboxPath string
 	subEncrypt     bool
 	updateInterval string
 
-	subService     *SubService
-	subJsonService *SubJsonService
+	subService        *SubService
+	subJsonService    *SubJsonService
+	subClashService   *SubClashService
+	subSingboxService *SubSingboxService
 }
 
 func NewSUBController(
@@ -31,6 +35,9 @@ func NewSUBController(
 	jsonNoise string,
 	jsonMux string,
 	jsonRules string,
+	clashPath string,
+	clashRules string,
+	singboxPath string,
 	subTitle string,
 ) *SUBController {
 	sub := NewSubService(showInfo, rModel)
@@ -38,11 +45,15 @@ func NewSUBController(
 		subTitle:       subTitle,
 		subPath:        subPath,
 		subJsonPath:    jsonPath,
+		subClashPath:   clashPath,
+		subSingboxPath: singboxPath,
 		subEncrypt:     encrypt,
 		updateInterval: update,
 
-		subService:     sub,
-		subJsonService: NewSubJsonService(jsonFragment, jsonNoise, jsonMux, jsonRules, sub),
+		subService:        sub,
+		subJsonService:    NewSubJsonService(jsonFragment, jsonNoise, jsonMux, jsonRules, sub),
+		subClashService:   NewSubClashService(clashRules, sub),
+		subSingboxService: NewSubSingboxService(sub),
 	}
 	a.initRouter(g)
 	return a
@@ -51,10 +62,16 @@ func NewSUBController(
 func (a *SUBController) initRouter(g *gin.RouterGroup) {
 	gLink := g.Group(a.subPath)
 	gJson := g.Group(a.subJsonPath)
+	gClash := g.Group(a.subClashPath)
+	gSingbox := g.Group(a.subSingboxPath)
 
 	gLink.GET(":subid", a.subs)
 
 	gJson.GET(":subid", a.subJsons)
+
+	gClash.GET(":subid", a.subClash)
+
+	gSingbox.GET(":subid", a.subSingbox)
 }
 
 func (a *SUBController) subs(c *gin.Context) {
@@ -125,6 +142,66 @@ func (a *SUBController) subJsons(c *gin.Context) {
 	}
 }
 
+func (a *SUBController) subClash(c *gin.Context) {
+	subId := c.Param("subid")
+	var host string
+	if h, err := getHostFromXFH(c.GetHeader("X-Forwarded-Host")); err == nil {
+		host = h
+	}
+	if host == "" {
+		host = c.GetHeader("X-Real-IP")
+	}
+	if host == "" {
+		var err error
+		host, _, err = net.SplitHostPort(c.Request.Host)
+		if err != nil {
+			host = c.Request.Host
+		}
+	}
+	clashSub, header, err := a.subClashService.GetClash(subId, host)
+	if err != nil || len(clashSub) == 0 {
+		c.String(400, "Error!")
+	} else {
+
+		// Add headers
+		c.Writer.Header().Set("Subscription-Userinfo", header)
+		c.Writer.Header().Set("Profile-Update-Interval", a.updateInterval)
+		c.Writer.Header().Set("Profile-Title", "base64:"+base64.StdEncoding.EncodeToString([]byte(a.subTitle)))
+
+		c.Data(200, "text/yaml; charset=utf-8", []byte(clashSub))
+	}
+}
+
+func (a *SUBController) subSingbox(c *gin.Context) {
+	subId := c.Param("subid")
+	var host string
+	if h, err := getHostFromXFH(c.GetHeader("X-Forwarded-Host")); err == nil {
+		host = h
+	}
+	if host == "" {
+		host = c.GetHeader("X-Real-IP")
+	}
+	if host == "" {
+		var err error
+		host, _, err = net.SplitHostPort(c.Request.Host)
+		if err != nil {
+			host = c.Request.Host
+		}
+	}
+	singboxSub, header, err := a.subSingboxService.GetSingbox(subId, host)
+	if err != nil || len(singboxSub) == 0 {
+		c.String(400, "Error!")
+	} else {
+
+		// Add headers
+		c.Writer.Header().Set("Subscription-Userinfo", header)
+		c.Writer.Header().Set("Profile-Update-Interval", a.updateInterval)
+		c.Writer.Header().Set("Profile-Title", "base64:"+base64.StdEncoding.EncodeToString([]byte(a.subTitle)))
+
+		c.Data(200, "application/json; charset=utf-8", []byte(singboxSub))
+	}
+}
+
 func getHostFromXFH(s string) (string, error) {
 	if strings.Contains(s, ":") {
 		realHost, _, err := net.SplitHostPort(s)
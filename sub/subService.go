@@ -107,14 +107,10 @@ func (s *SubService) GetSubs(subId string, host string) ([]string, string, error
 func (s *SubService) getInboundsBySubId(subId string) ([]*model.Inbound, error) {
 	db := database.GetDB()
 	var inbounds []*model.Inbound
-	err := db.Model(model.Inbound{}).Preload("ClientStats").Where(`id in (
-		SELECT DISTINCT inbounds.id
-		FROM inbounds,
-			JSON_EACH(JSON_EXTRACT(inbounds.settings, '$.clients')) AS client 
-		WHERE
-			protocol in ('vmess','vless','trojan','shadowsocks')
-			AND JSON_EXTRACT(client.value, '$.subId') = ? AND enable = ?
-	)`, subId, true).Find(&inbounds).Error
+	err := db.Model(model.Inbound{}).Preload("ClientStats").
+		Where("protocol in ('vmess','vless','trojan','shadowsocks') AND enable = ?", true).
+		Where("id IN (?)", db.Model(model.InboundClient{}).Select("inbound_id").Where("sub_id = ?", subId)).
+		Find(&inbounds).Error
 	if err != nil {
 		return nil, err
 	}
@@ -921,6 +917,9 @@ func (s *SubService) genRemark(inbound *model.Inbound, email string, extra strin
 			if vol := stats.Total - (stats.Up + stats.Down); vol > 0 {
 				remark = append(remark, fmt.Sprintf("%s%s", common.FormatTraffic(vol), "📊"))
 			}
+			if speed := s.clientSpeed(inbound, email); !speed.IsZero() {
+				remark = append(remark, formatSpeed(speed))
+			}
 			now := time.Now().Unix()
 			switch exp := stats.ExpiryTime / 1000; {
 			case exp > 0:
@@ -960,6 +959,32 @@ func (s *SubService) genRemark(inbound *model.Inbound, email string, extra strin
 	return strings.Join(remark, separationChar)
 }
 
+// clientSpeed returns the speed limits of the client with the email in the inbound
+func (s *SubService) clientSpeed(inbound *model.Inbound, email string) model.SpeedProfile {
+	clients, _ := s.inboundService.GetClients(inbound)
+	for _, client := range clients {
+		if client.Email == email {
+			return client.SpeedProfile()
+		}
+	}
+	return model.SpeedProfile{}
+}
+
+// formatSpeed shows a speed limit in the remark, with the upload and download
+// limits apart when they differ
+func formatSpeed(speed model.SpeedProfile) string {
+	format := func(kb int) string {
+		if kb <= 0 {
+			return "∞"
+		}
+		return common.FormatTraffic(int64(kb)*1024) + "/s"
+	}
+	if speed.Up == speed.Down {
+		return format(speed.Up) + "🚀"
+	}
+	return format(speed.Up) + "⬆" + format(speed.Down) + "⬇"
+}
+
 func searchKey(data any, key string) (any, bool) {
 	switch val := data.(type) {
 	case map[string]any:
@@ -0,0 +1,249 @@
+package sub
+
+import (
+	"encoding/json"
+	"strings"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+)
+
+const (
+	// singboxSelectTag is the outbound the route sends traffic to, the user picks a proxy in it
+	singboxSelectTag = "proxy"
+	// singboxAutoTag picks the proxy with the lowest latency
+	singboxAutoTag = "auto"
+)
+
+// SubSingboxService renders a subscription as a sing-box profile. Like the Clash
+// subscription, the outbounds are read back from the share links of SubService.
+type SubSingboxService struct {
+	SubService *SubService
+}
+
+func NewSubSingboxService(subService *SubService) *SubSingboxService {
+	return &SubSingboxService{
+		SubService: subService,
+	}
+}
+
+// SingboxOutbound is a sing-box outbound. The fields of all the types used here are
+// in one struct, so the output keeps the field order of the struct.
+type SingboxOutbound struct {
+	Type       string            `json:"type"`
+	Tag        string            `json:"tag"`
+	Server     string            `json:"server,omitempty"`
+	ServerPort int               `json:"server_port,omitempty"`
+	UUID       string            `json:"uuid,omitempty"`
+	Password   string            `json:"password,omitempty"`
+	Method     string            `json:"method,omitempty"`
+	Security   string            `json:"security,omitempty"`
+	Flow       string            `json:"flow,omitempty"`
+	TLS        *SingboxTLS       `json:"tls,omitempty"`
+	Transport  *SingboxTransport `json:"transport,omitempty"`
+	Outbounds  []string          `json:"outbounds,omitempty"`
+	Default    string            `json:"default,omitempty"`
+	URL        string            `json:"url,omitempty"`
+	Interval   string            `json:"interval,omitempty"`
+}
+
+type SingboxTLS struct {
+	Enabled    bool            `json:"enabled"`
+	ServerName string          `json:"server_name,omitempty"`
+	Insecure   bool            `json:"insecure,omitempty"`
+	ALPN       []string        `json:"alpn,omitempty"`
+	UTLS       *SingboxUTLS    `json:"utls,omitempty"`
+	Reality    *SingboxReality `json:"reality,omitempty"`
+}
+
+type SingboxUTLS struct {
+	Enabled     bool   `json:"enabled"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type SingboxReality struct {
+	Enabled   bool   `json:"enabled"`
+	PublicKey string `json:"public_key"`
+	ShortID   string `json:"short_id"`
+}
+
+type SingboxTransport struct {
+	Type        string            `json:"type"`
+	Path        string            `json:"path,omitempty"`
+	Host        string            `json:"host,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	ServiceName string            `json:"service_name,omitempty"`
+}
+
+func (s *SubSingboxService) GetSingbox(subId string, host string) (string, string, error) {
+	links, header, err := s.SubService.GetSubs(subId, host)
+	if err != nil || len(links) == 0 {
+		return "", "", err
+	}
+
+	var proxies []*SingboxOutbound
+	var tags []string
+	used := make(map[string]int)
+	for _, link := range splitLinks(links) {
+		outbound, err := singboxOutbound(link)
+		if err != nil {
+			logger.Debug("SubSingboxService - skip link:", err)
+			continue
+		}
+		// sing-box needs unique outbound tags
+		outbound.Tag = uniqueName(used, outbound.Tag)
+		proxies = append(proxies, outbound)
+		tags = append(tags, outbound.Tag)
+	}
+	if len(proxies) == 0 {
+		return "", "", nil
+	}
+
+	result, err := json.MarshalIndent(singboxProfile(proxies, tags), "", "  ")
+	if err != nil {
+		return "", "", err
+	}
+	return string(result), header, nil
+}
+
+// singboxProfile puts the proxies into a profile with a tun and a local mixed inbound,
+// a selector and an urltest group, and a route that sends everything but private addresses
+// through the selector.
+func singboxProfile(proxies []*SingboxOutbound, tags []string) map[string]any {
+	outbounds := []*SingboxOutbound{
+		{
+			Type:      "selector",
+			Tag:       singboxSelectTag,
+			Outbounds: append([]string{singboxAutoTag}, append(tags, "direct")...),
+			Default:   singboxAutoTag,
+		},
+		{
+			Type:      "urltest",
+			Tag:       singboxAutoTag,
+			Outbounds: tags,
+			URL:       clashTestURL,
+			Interval:  "5m",
+		},
+	}
+	outbounds = append(outbounds, proxies...)
+	outbounds = append(outbounds, &SingboxOutbound{Type: "direct", Tag: "direct"})
+
+	return map[string]any{
+		"log": map[string]any{
+			"level":     "warn",
+			"timestamp": true,
+		},
+		"inbounds": []map[string]any{
+			{
+				"type":         "tun",
+				"tag":          "tun-in",
+				"address":      []string{"172.19.0.1/30", "fdfe:dcba:9876::1/126"},
+				"auto_route":   true,
+				"strict_route": true,
+			},
+			{
+				"type":        "mixed",
+				"tag":         "mixed-in",
+				"listen":      "127.0.0.1",
+				"listen_port": 2080,
+			},
+		},
+		"outbounds": outbounds,
+		"route": map[string]any{
+			"rules": []map[string]any{
+				{"action": "sniff"},
+				{"protocol": "dns", "action": "hijack-dns"},
+				{"ip_is_private": true, "outbound": "direct"},
+			},
+			"final":                 singboxSelectTag,
+			"auto_detect_interface": true,
+		},
+	}
+}
+
+// singboxOutbound converts a share link into a sing-box outbound. The result only
+// depends on the link, so the same link always gives the same outbound.
+func singboxOutbound(link string) (*SingboxOutbound, error) {
+	l, err := parseShareLink(link)
+	if err != nil {
+		return nil, err
+	}
+	outbound := &SingboxOutbound{
+		Tag:        l.name,
+		Server:     l.server,
+		ServerPort: l.port,
+	}
+	switch l.protocol {
+	case "vmess":
+		outbound.Type = "vmess"
+		outbound.UUID = l.uuid
+		outbound.Security = l.cipher
+	case "vless":
+		// sing-box has no VLESS encryption
+		if l.encryption != "" {
+			return nil, common.NewError("vless encryption is not supported by sing-box:", l.name)
+		}
+		outbound.Type = "vless"
+		outbound.UUID = l.uuid
+		outbound.Flow = l.flow
+	case "trojan":
+		outbound.Type = "trojan"
+		outbound.Password = l.password
+	case "ss":
+		if l.network != "tcp" || l.security != "none" {
+			return nil, common.NewError("shadowsocks over", l.network, l.security, "is not supported by sing-box:", l.name)
+		}
+		outbound.Type = "shadowsocks"
+		outbound.Method = l.cipher
+		outbound.Password = l.password
+		return outbound, nil
+	}
+
+	switch l.security {
+	case "tls", "reality":
+		tls := &SingboxTLS{
+			Enabled:    true,
+			ServerName: l.sni,
+			Insecure:   l.insecure,
+		}
+		if l.alpn != "" {
+			tls.ALPN = strings.Split(l.alpn, ",")
+		}
+		fp := l.fp
+		if l.security == "reality" && fp == "" {
+			// Reality needs a uTLS fingerprint
+			fp = "chrome"
+		}
+		if fp != "" {
+			tls.UTLS = &SingboxUTLS{Enabled: true, Fingerprint: fp}
+		}
+		if l.security == "reality" {
+			tls.Reality = &SingboxReality{Enabled: true, PublicKey: l.pbk, ShortID: l.sid}
+		}
+		outbound.TLS = tls
+	case "none":
+	default:
+		return nil, common.NewError("unsupported security:", l.security)
+	}
+
+	switch l.network {
+	case "tcp", "":
+		// The HTTP header obfuscation of Xray has no sing-box counterpart
+		if l.header == "http" {
+			return nil, common.NewError("tcp with http header is not supported by sing-box:", l.name)
+		}
+	case "ws":
+		outbound.Transport = &SingboxTransport{Type: "ws", Path: l.path}
+		if l.host != "" {
+			outbound.Transport.Headers = map[string]string{"Host": l.host}
+		}
+	case "httpupgrade":
+		outbound.Transport = &SingboxTransport{Type: "httpupgrade", Path: l.path, Host: l.host}
+	case "grpc":
+		outbound.Transport = &SingboxTransport{Type: "grpc", ServiceName: l.path}
+	default:
+		// xhttp and kcp have no sing-box transport
+		return nil, common.NewError("transport", l.network, "is not supported by sing-box:", l.name)
+	}
+	return outbound, nil
+}
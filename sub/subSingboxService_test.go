@@ -0,0 +1,134 @@
+package sub
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// vmessLink builds a vmess share link the way SubService.genVmessLink does
+func vmessLink(t *testing.T, obj map[string]any) string {
+	t.Helper()
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "vmess://" + base64.StdEncoding.EncodeToString(data)
+}
+
+func ssLink(method string, password string, rest string) string {
+	return "ss://" + base64.StdEncoding.EncodeToString([]byte(method+":"+password)) + rest
+}
+
+// assertGolden compares got with testdata/<name>, go test -update rewrites the file
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test ./sub -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file:\n got:\n%s\n want:\n%s", name, got, want)
+	}
+}
+
+func singboxLinks(t *testing.T) map[string]string {
+	return map[string]string{
+		"vmess_tcp": vmessLink(t, map[string]any{
+			"v": "2", "ps": "vmess-tcp", "add": "example.com", "port": 10001, "type": "none",
+			"id": "b831381d-6324-4d53-ad4f-8cda48b30811", "scy": "auto", "net": "tcp", "tls": "none",
+		}),
+		"vmess_ws_tls": vmessLink(t, map[string]any{
+			"v": "2", "ps": "vmess-ws", "add": "example.com", "port": 443,
+			"id": "b831381d-6324-4d53-ad4f-8cda48b30811", "scy": "aes-128-gcm", "net": "ws",
+			"path": "/vmess", "host": "cdn.example.com", "tls": "tls", "sni": "cdn.example.com",
+			"alpn": "h2,http/1.1", "fp": "firefox", "allowInsecure": true,
+		}),
+		"vless_ws": "vless://1b0f5a7e-7a4e-4ad6-9a2a-7f4c8d1e0b21@example.com:8080?type=ws&path=%2Fws&host=ws.example.com&encryption=none&security=none#vless-ws",
+		"vless_grpc": "vless://1b0f5a7e-7a4e-4ad6-9a2a-7f4c8d1e0b21@example.com:443?type=grpc&serviceName=grpc-svc&authority=&encryption=none" +
+			"&security=tls&sni=grpc.example.com&alpn=h2&fp=chrome#vless-grpc",
+		"vless_httpupgrade": "vless://1b0f5a7e-7a4e-4ad6-9a2a-7f4c8d1e0b21@example.com:2096?type=httpupgrade&path=%2Fup&host=up.example.com" +
+			"&encryption=none&security=tls&sni=up.example.com&allowInsecure=1#vless-httpupgrade",
+		"vless_reality": "vless://1b0f5a7e-7a4e-4ad6-9a2a-7f4c8d1e0b21@203.0.113.10:443?type=tcp&headerType=none&encryption=none&security=reality" +
+			"&sni=www.microsoft.com&pbk=Z84J2IelR9ch3k8VtlVhhs5ycBUlXA7wHBWcBrjqnAw&sid=6ba85179e30d4fc2&spx=%2Fabc" +
+			"&flow=xtls-rprx-vision#vless-reality",
+		"trojan_tls":  "trojan://trojan-pass@example.com:8443?type=tcp&headerType=none&security=tls&sni=trojan.example.com&fp=safari#trojan-tls",
+		"shadowsocks": ssLink("2022-blake3-aes-128-gcm", "c2VydmVyLWtleQ==:Y2xpZW50LWtleQ==", "@[2001:db8::10]:8388?type=tcp#ss-2022"),
+	}
+}
+
+func TestSingboxOutbound(t *testing.T) {
+	for name, link := range singboxLinks(t) {
+		t.Run(name, func(t *testing.T) {
+			outbound, err := singboxOutbound(link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.MarshalIndent(outbound, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, filepath.Join("singbox", name+".json"), append(got, '\n'))
+		})
+	}
+}
+
+func TestSingboxOutboundSkipped(t *testing.T) {
+	links := map[string]string{
+		"xhttp": "vless://1b0f5a7e-7a4e-4ad6-9a2a-7f4c8d1e0b21@example.com:443?type=xhttp&path=%2Fx&host=&mode=auto" +
+			"&encryption=none&security=tls&sni=example.com#vless-xhttp",
+		"kcp": vmessLink(t, map[string]any{
+			"v": "2", "ps": "vmess-kcp", "add": "example.com", "port": 10002, "type": "none",
+			"id": "b831381d-6324-4d53-ad4f-8cda48b30811", "scy": "auto", "net": "kcp", "path": "seed", "tls": "none",
+		}),
+		"tcp_http_header": "trojan://trojan-pass@example.com:80?type=tcp&path=%2F&host=example.com&headerType=http&security=none#trojan-http",
+		"vless_encryption": "vless://1b0f5a7e-7a4e-4ad6-9a2a-7f4c8d1e0b21@example.com:443?type=tcp&headerType=none" +
+			"&encryption=mlkem768x25519plus.native.0rtt.abc&security=none#vless-encryption",
+		"shadowsocks_ws": ssLink("aes-256-gcm", "secret", "@example.com:8388?type=ws&path=%2Fss#ss-ws"),
+	}
+	for name, link := range links {
+		t.Run(name, func(t *testing.T) {
+			if outbound, err := singboxOutbound(link); err == nil {
+				t.Fatalf("expected the link to be skipped, got %+v", outbound)
+			}
+		})
+	}
+}
+
+func TestSingboxProfile(t *testing.T) {
+	links := singboxLinks(t)
+	used := make(map[string]int)
+	var proxies []*SingboxOutbound
+	var tags []string
+	// Two links with the same remark get numbered tags
+	for _, name := range []string{"vmess_ws_tls", "vless_reality", "vless_reality", "shadowsocks"} {
+		outbound, err := singboxOutbound(links[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		outbound.Tag = uniqueName(used, outbound.Tag)
+		proxies = append(proxies, outbound)
+		tags = append(tags, outbound.Tag)
+	}
+	got, err := json.MarshalIndent(singboxProfile(proxies, tags), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, filepath.Join("singbox", "profile.json"), append(got, '\n'))
+}
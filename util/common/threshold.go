@@ -0,0 +1,27 @@
+package common
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ParseThresholds parses a comma separated list of positive integers such as "50,80,95".
+// Duplicates and zeros are dropped, so "0" yields an empty list.
+func ParseThresholds(list string) ([]int, error) {
+	thresholds := make([]int, 0)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		threshold, err := strconv.Atoi(item)
+		if err != nil || threshold < 0 {
+			return nil, NewError("invalid threshold:", item)
+		}
+		if threshold > 0 && !slices.Contains(thresholds, threshold) {
+			thresholds = append(thresholds, threshold)
+		}
+	}
+	return thresholds, nil
+}
@@ -0,0 +1,42 @@
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Command is one invocation of a firewall tool
+type Command struct {
+	Name  string
+	Args  []string
+	Stdin string // passed on standard input when not empty
+}
+
+func (c Command) String() string {
+	return strings.TrimSpace(c.Name + " " + strings.Join(c.Args, " "))
+}
+
+// Executor runs the firewall tools. CommandExecutor runs them for real, tests swap
+// in a recorder so the firewall can be exercised without root.
+type Executor interface {
+	Run(cmd Command) (string, error)
+}
+
+// CommandExecutor runs commands with os/exec
+type CommandExecutor struct{}
+
+func (CommandExecutor) Run(cmd Command) (string, error) {
+	c := exec.Command(cmd.Name, cmd.Args...)
+	if cmd.Stdin != "" {
+		c.Stdin = strings.NewReader(cmd.Stdin)
+	}
+	var out bytes.Buffer
+	c.Stdout = &out
+	c.Stderr = &out
+	if err := c.Run(); err != nil {
+		return out.String(), fmt.Errorf("%s: %w: %s", cmd, err, strings.TrimSpace(out.String()))
+	}
+	return out.String(), nil
+}
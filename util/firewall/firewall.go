@@ -0,0 +1,304 @@
+// Package firewall bans source IPs on a single port with nftables, or with iptables
+// where nft is not installed, without relying on Fail2Ban.
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"x-ui/logger"
+)
+
+// Kind is the packet filter the bans are written to
+type Kind string
+
+const (
+	KindNftables Kind = "nftables"
+	KindIptables Kind = "iptables"
+)
+
+const (
+	// nftTable holds the sets of banned address/port pairs and the chain dropping them
+	nftTable = "x-ui-limit"
+	// iptablesChain is jumped to from INPUT and holds one DROP rule per banned pair and protocol
+	iptablesChain = "X-UI-LIMIT"
+)
+
+// ErrUnavailable is returned by Init when neither nft nor iptables can be run
+var ErrUnavailable = errors.New("neither nft nor iptables is available")
+
+// Ban is a source IP that may not connect to a port until Expires
+type Ban struct {
+	IP      string    `json:"ip"`
+	Port    int       `json:"port"`
+	Expires time.Time `json:"expires"`
+}
+
+// Firewall keeps its own set of bans. nftables expires them in the kernel, for
+// iptables the rules are removed by Expire. Init clears the bans left by a previous
+// process. In dry-run mode the commands that change the firewall are only logged.
+type Firewall struct {
+	executor Executor
+	dryRun   bool
+	kind     Kind
+	ip6      bool // ip6tables is available, nftables always handles IPv6
+	bans     map[string]*Ban
+	mu       sync.Mutex
+}
+
+func New(executor Executor) *Firewall {
+	return &Firewall{
+		executor: executor,
+		bans:     make(map[string]*Ban),
+	}
+}
+
+// SetDryRun switches dry-run mode. The firewall is set up again by the next Init,
+// since the bans made in the other mode are either missing or never existed.
+func (f *Firewall) SetDryRun(dryRun bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.dryRun == dryRun {
+		return
+	}
+	f.dryRun = dryRun
+	f.kind = ""
+	f.bans = make(map[string]*Ban)
+}
+
+// Kind returns the packet filter in use, empty before a successful Init
+func (f *Firewall) Kind() Kind {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.kind
+}
+
+// Init detects the packet filter and creates an empty table or chain for the bans.
+// It does nothing once it has succeeded.
+func (f *Firewall) Init() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.kind != "" {
+		return nil
+	}
+
+	if _, err := f.executor.Run(Command{Name: "nft", Args: []string{"--version"}}); err == nil {
+		script := fmt.Sprintf(`table inet %[1]s
+delete table inet %[1]s
+table inet %[1]s {
+	set banned4 { type ipv4_addr . inet_service; flags timeout; }
+	set banned6 { type ipv6_addr . inet_service; flags timeout; }
+	chain input {
+		type filter hook input priority -1; policy accept;
+		ip saddr . tcp dport @banned4 drop
+		ip saddr . udp dport @banned4 drop
+		ip6 saddr . tcp dport @banned6 drop
+		ip6 saddr . udp dport @banned6 drop
+	}
+}
+`, nftTable)
+		if err := f.run(Command{Name: "nft", Args: []string{"-f", "-"}, Stdin: script}); err != nil {
+			return err
+		}
+		f.kind = KindNftables
+		f.bans = make(map[string]*Ban)
+		return nil
+	}
+
+	if _, err := f.executor.Run(Command{Name: "iptables", Args: []string{"--version"}}); err != nil {
+		return ErrUnavailable
+	}
+	_, err := f.executor.Run(Command{Name: "ip6tables", Args: []string{"--version"}})
+	f.ip6 = err == nil
+	for _, tool := range f.iptablesTools() {
+		// The chain may be left from a previous run, so creating it is allowed to fail
+		f.run(Command{Name: tool, Args: []string{"-w", "-N", iptablesChain}})
+		if err := f.run(Command{Name: tool, Args: []string{"-w", "-F", iptablesChain}}); err != nil {
+			return err
+		}
+		if f.run(Command{Name: tool, Args: []string{"-w", "-C", "INPUT", "-j", iptablesChain}}) != nil {
+			if err := f.run(Command{Name: tool, Args: []string{"-w", "-I", "INPUT", "-j", iptablesChain}}); err != nil {
+				return err
+			}
+		}
+	}
+	f.kind = KindIptables
+	f.bans = make(map[string]*Ban)
+	return nil
+}
+
+// Ban drops the traffic from ip to port for duration. An IP that is already
+// banned on the port keeps its current ban, an expired one is banned again.
+func (f *Firewall) Ban(ip string, port int, duration time.Duration) error {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return fmt.Errorf("invalid IP address: %s", ip)
+	}
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port: %d", port)
+	}
+	if err := f.Init(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ip = addr.String()
+	key := banKey(ip, port)
+	ban, banned := f.bans[key]
+	if banned && time.Now().Before(ban.Expires) {
+		return nil
+	}
+	// An expired iptables ban keeps its rules until Expire runs, so they are replaced
+	// rather than added a second time
+	if banned && f.kind == KindIptables {
+		if err := f.remove(addr, port); err != nil {
+			return err
+		}
+		delete(f.bans, key)
+	}
+
+	switch f.kind {
+	case KindNftables:
+		seconds := max(int(duration/time.Second), 1)
+		element := fmt.Sprintf("{ %s . %d timeout %ds }", ip, port, seconds)
+		if err := f.run(Command{Name: "nft", Args: []string{"add", "element", "inet", nftTable, nftSet(addr), element}}); err != nil {
+			return err
+		}
+	case KindIptables:
+		tool := iptablesTool(addr)
+		if tool == "ip6tables" && !f.ip6 {
+			return fmt.Errorf("ip6tables is not available to ban %s", ip)
+		}
+		for _, proto := range []string{"tcp", "udp"} {
+			if err := f.run(Command{Name: tool, Args: iptablesRule("-A", ip, port, proto)}); err != nil {
+				return err
+			}
+		}
+	}
+	f.bans[key] = &Ban{IP: ip, Port: port, Expires: time.Now().Add(duration)}
+	return nil
+}
+
+// Unban lifts the ban of ip on port before it expires
+func (f *Firewall) Unban(ip string, port int) error {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return fmt.Errorf("invalid IP address: %s", ip)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ip = addr.String()
+	key := banKey(ip, port)
+	if _, ok := f.bans[key]; !ok {
+		return nil
+	}
+	if err := f.remove(addr, port); err != nil {
+		return err
+	}
+	delete(f.bans, key)
+	return nil
+}
+
+// Expire removes the bans that ended before now. nftables has already dropped them
+// itself, iptables rules are deleted here.
+func (f *Firewall) Expire(now time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var errs []error
+	for key, ban := range f.bans {
+		if now.Before(ban.Expires) {
+			continue
+		}
+		if f.kind == KindIptables {
+			if err := f.remove(net.ParseIP(ban.IP), ban.Port); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		delete(f.bans, key)
+	}
+	return errors.Join(errs...)
+}
+
+// Bans returns the active bans ordered by expiry
+func (f *Firewall) Bans() []Ban {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bans := make([]Ban, 0, len(f.bans))
+	for _, ban := range f.bans {
+		bans = append(bans, *ban)
+	}
+	sort.Slice(bans, func(a, b int) bool {
+		if bans[a].Expires.Equal(bans[b].Expires) {
+			return banKey(bans[a].IP, bans[a].Port) < banKey(bans[b].IP, bans[b].Port)
+		}
+		return bans[a].Expires.Before(bans[b].Expires)
+	})
+	return bans
+}
+
+func (f *Firewall) remove(addr net.IP, port int) error {
+	ip := addr.String()
+	switch f.kind {
+	case KindNftables:
+		element := fmt.Sprintf("{ %s . %d }", ip, port)
+		return f.run(Command{Name: "nft", Args: []string{"delete", "element", "inet", nftTable, nftSet(addr), element}})
+	case KindIptables:
+		tool := iptablesTool(addr)
+		for _, proto := range []string{"tcp", "udp"} {
+			if err := f.run(Command{Name: tool, Args: iptablesRule("-D", ip, port, proto)}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// run executes a command that changes the firewall, or only logs it in dry-run mode
+func (f *Firewall) run(cmd Command) error {
+	if f.dryRun {
+		if cmd.Stdin != "" {
+			logger.Infof("firewall dry run: %s <<EOF\n%sEOF", cmd, cmd.Stdin)
+		} else {
+			logger.Info("firewall dry run:", cmd.String())
+		}
+		return nil
+	}
+	_, err := f.executor.Run(cmd)
+	return err
+}
+
+func (f *Firewall) iptablesTools() []string {
+	if f.ip6 {
+		return []string{"iptables", "ip6tables"}
+	}
+	return []string{"iptables"}
+}
+
+func banKey(ip string, port int) string {
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+func nftSet(addr net.IP) string {
+	if addr.To4() != nil {
+		return "banned4"
+	}
+	return "banned6"
+}
+
+func iptablesTool(addr net.IP) string {
+	if addr.To4() != nil {
+		return "iptables"
+	}
+	return "ip6tables"
+}
+
+func iptablesRule(op string, ip string, port int, proto string) []string {
+	return []string{"-w", op, iptablesChain, "-s", ip, "-p", proto, "--dport", strconv.Itoa(port), "-j", "DROP"}
+}
@@ -0,0 +1,375 @@
+package firewall
+
+import (
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeExecutor records the commands it is given instead of running them. A command
+// fails when its text starts with one of the prefixes in fail, and missing lists the
+// tools that are reported as not installed.
+type fakeExecutor struct {
+	fail    []string
+	missing []string
+
+	mu       sync.Mutex
+	commands []Command
+}
+
+func (f *fakeExecutor) Run(cmd Command) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, name := range f.missing {
+		if cmd.Name == name {
+			return "", fmt.Errorf("%s: %w", cmd, exec.ErrNotFound)
+		}
+	}
+	f.commands = append(f.commands, cmd)
+	for _, prefix := range f.fail {
+		if strings.HasPrefix(cmd.String(), prefix) {
+			return "", fmt.Errorf("%s: exit status 1", cmd)
+		}
+	}
+	return "", nil
+}
+
+// Commands returns the commands run so far, one line each
+func (f *fakeExecutor) Commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lines := make([]string, 0, len(f.commands))
+	for _, cmd := range f.commands {
+		line := cmd.String()
+		if cmd.Stdin != "" {
+			line += " <<< " + strings.Join(strings.Fields(cmd.Stdin), " ")
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Reset forgets the recorded commands
+func (f *fakeExecutor) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = nil
+}
+
+func assertCommands(t *testing.T, executor *fakeExecutor, want ...string) {
+	t.Helper()
+	got := executor.Commands()
+	if len(want) == 0 {
+		want = []string{}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("commands:\n got  %q\n want %q", got, want)
+	}
+	executor.Reset()
+}
+
+func TestInitNftables(t *testing.T) {
+	executor := &fakeExecutor{}
+	f := New(executor)
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Kind() != KindNftables {
+		t.Fatalf("kind = %q, want %q", f.Kind(), KindNftables)
+	}
+	got := executor.Commands()
+	if len(got) != 2 || got[0] != "nft --version" {
+		t.Fatalf("unexpected commands: %q", got)
+	}
+	for _, want := range []string{
+		"nft -f - <<< table inet x-ui-limit delete table inet x-ui-limit table inet x-ui-limit {",
+		"set banned4 { type ipv4_addr . inet_service; flags timeout; }",
+		"type filter hook input priority -1; policy accept;",
+		"ip6 saddr . udp dport @banned6 drop",
+	} {
+		if !strings.Contains(got[1], want) {
+			t.Errorf("nft script %q does not contain %q", got[1], want)
+		}
+	}
+
+	// A second Init keeps the table
+	executor.Reset()
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, executor)
+}
+
+func TestInitIptablesFallback(t *testing.T) {
+	executor := &fakeExecutor{missing: []string{"nft"}}
+	f := New(executor)
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Kind() != KindIptables {
+		t.Fatalf("kind = %q, want %q", f.Kind(), KindIptables)
+	}
+	assertCommands(t, executor,
+		"iptables --version",
+		"ip6tables --version",
+		"iptables -w -N X-UI-LIMIT",
+		"iptables -w -F X-UI-LIMIT",
+		"iptables -w -C INPUT -j X-UI-LIMIT",
+		"ip6tables -w -N X-UI-LIMIT",
+		"ip6tables -w -F X-UI-LIMIT",
+		"ip6tables -w -C INPUT -j X-UI-LIMIT",
+	)
+}
+
+func TestInitIptablesAddsJump(t *testing.T) {
+	executor := &fakeExecutor{
+		missing: []string{"nft", "ip6tables"},
+		fail:    []string{"iptables -w -C INPUT"},
+	}
+	f := New(executor)
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, executor,
+		"iptables --version",
+		"iptables -w -N X-UI-LIMIT",
+		"iptables -w -F X-UI-LIMIT",
+		"iptables -w -C INPUT -j X-UI-LIMIT",
+		"iptables -w -I INPUT -j X-UI-LIMIT",
+	)
+
+	// Without ip6tables IPv6 addresses cannot be banned
+	if err := f.Ban("2001:db8::1", 443, time.Minute); err == nil {
+		t.Fatal("expected an error banning an IPv6 address without ip6tables")
+	}
+}
+
+func TestInitUnavailable(t *testing.T) {
+	executor := &fakeExecutor{missing: []string{"nft", "iptables", "ip6tables"}}
+	f := New(executor)
+	if err := f.Init(); err != ErrUnavailable {
+		t.Fatalf("err = %v, want %v", err, ErrUnavailable)
+	}
+	if f.Kind() != "" {
+		t.Fatalf("kind = %q after a failed Init", f.Kind())
+	}
+}
+
+func TestBanNftables(t *testing.T) {
+	executor := &fakeExecutor{}
+	f := New(executor)
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	executor.Reset()
+
+	if err := f.Ban("203.0.113.7", 443, 30*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Ban("2001:db8::0001", 8443, 90*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, executor,
+		"nft add element inet x-ui-limit banned4 { 203.0.113.7 . 443 timeout 1800s }",
+		"nft add element inet x-ui-limit banned6 { 2001:db8::1 . 8443 timeout 90s }",
+	)
+
+	// An active ban is kept as it is
+	if err := f.Ban("203.0.113.7", 443, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, executor)
+
+	if err := f.Unban("203.0.113.7", 443); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, executor,
+		"nft delete element inet x-ui-limit banned4 { 203.0.113.7 . 443 }",
+	)
+	bans := f.Bans()
+	if len(bans) != 1 || bans[0].IP != "2001:db8::1" || bans[0].Port != 8443 {
+		t.Fatalf("unexpected bans: %+v", bans)
+	}
+
+	// The kernel expires nftables elements, Expire only forgets them
+	if err := f.Expire(time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, executor)
+	if bans := f.Bans(); len(bans) != 0 {
+		t.Fatalf("bans left after Expire: %+v", bans)
+	}
+}
+
+func TestBanIptables(t *testing.T) {
+	executor := &fakeExecutor{missing: []string{"nft"}}
+	f := New(executor)
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	executor.Reset()
+
+	if err := f.Ban("198.51.100.2", 2053, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Ban("2001:db8::2", 2053, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, executor,
+		"iptables -w -A X-UI-LIMIT -s 198.51.100.2 -p tcp --dport 2053 -j DROP",
+		"iptables -w -A X-UI-LIMIT -s 198.51.100.2 -p udp --dport 2053 -j DROP",
+		"ip6tables -w -A X-UI-LIMIT -s 2001:db8::2 -p tcp --dport 2053 -j DROP",
+		"ip6tables -w -A X-UI-LIMIT -s 2001:db8::2 -p udp --dport 2053 -j DROP",
+	)
+
+	// Only the ban that has ended is removed
+	if err := f.Expire(time.Now().Add(10 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, executor,
+		"iptables -w -D X-UI-LIMIT -s 198.51.100.2 -p tcp --dport 2053 -j DROP",
+		"iptables -w -D X-UI-LIMIT -s 198.51.100.2 -p udp --dport 2053 -j DROP",
+	)
+
+	if err := f.Unban("2001:db8::2", 2053); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, executor,
+		"ip6tables -w -D X-UI-LIMIT -s 2001:db8::2 -p tcp --dport 2053 -j DROP",
+		"ip6tables -w -D X-UI-LIMIT -s 2001:db8::2 -p udp --dport 2053 -j DROP",
+	)
+
+	// Unbanning an IP that is not banned does nothing
+	if err := f.Unban("198.51.100.2", 2053); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, executor)
+}
+
+func TestBanIptablesReplacesExpiredRule(t *testing.T) {
+	executor := &fakeExecutor{missing: []string{"nft", "ip6tables"}}
+	f := New(executor)
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	executor.Reset()
+
+	// A ban that has ended but has not been removed by Expire yet
+	if err := f.Ban("198.51.100.3", 443, -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	executor.Reset()
+
+	if err := f.Ban("198.51.100.3", 443, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, executor,
+		"iptables -w -D X-UI-LIMIT -s 198.51.100.3 -p tcp --dport 443 -j DROP",
+		"iptables -w -D X-UI-LIMIT -s 198.51.100.3 -p udp --dport 443 -j DROP",
+		"iptables -w -A X-UI-LIMIT -s 198.51.100.3 -p tcp --dport 443 -j DROP",
+		"iptables -w -A X-UI-LIMIT -s 198.51.100.3 -p udp --dport 443 -j DROP",
+	)
+	bans := f.Bans()
+	if len(bans) != 1 || !bans[0].Expires.After(time.Now()) {
+		t.Fatalf("unexpected bans: %+v", bans)
+	}
+}
+
+func TestBanInvalid(t *testing.T) {
+	executor := &fakeExecutor{}
+	f := New(executor)
+	if err := f.Ban("not-an-ip", 443, time.Minute); err == nil {
+		t.Error("expected an error for an invalid IP")
+	}
+	if err := f.Ban("203.0.113.7", 0, time.Minute); err == nil {
+		t.Error("expected an error for port 0")
+	}
+	if err := f.Ban("203.0.113.7", 65536, time.Minute); err == nil {
+		t.Error("expected an error for port 65536")
+	}
+	assertCommands(t, executor)
+}
+
+func TestDryRun(t *testing.T) {
+	for _, missing := range [][]string{nil, {"nft"}} {
+		executor := &fakeExecutor{missing: missing}
+		f := New(executor)
+		f.SetDryRun(true)
+		if err := f.Init(); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Ban("203.0.113.7", 443, -time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Ban("203.0.113.7", 443, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Unban("203.0.113.7", 443); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Ban("203.0.113.8", 443, -time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Expire(time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		// Only the read-only probes that detect the packet filter are run
+		for _, cmd := range executor.Commands() {
+			if !strings.HasSuffix(cmd, " --version") {
+				t.Errorf("dry run (%s) ran %q", f.Kind(), cmd)
+			}
+		}
+	}
+}
+
+func TestSetDryRunResetsInit(t *testing.T) {
+	executor := &fakeExecutor{}
+	f := New(executor)
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Ban("203.0.113.7", 443, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	f.SetDryRun(true)
+	if f.Kind() != "" || len(f.Bans()) != 0 {
+		t.Fatalf("switching to dry run kept kind %q and bans %+v", f.Kind(), f.Bans())
+	}
+}
+
+func TestFailingCommand(t *testing.T) {
+	executor := &fakeExecutor{fail: []string{"nft -f"}}
+	f := New(executor)
+	if err := f.Init(); err == nil {
+		t.Fatal("expected Init to fail")
+	}
+	if f.Kind() != "" {
+		t.Fatalf("kind = %q after a failed Init", f.Kind())
+	}
+
+	executor = &fakeExecutor{missing: []string{"nft"}, fail: []string{"iptables -w -A X-UI-LIMIT -s 198.51.100.4 -p udp"}}
+	f = New(executor)
+	if err := f.Ban("198.51.100.4", 443, time.Minute); err == nil {
+		t.Fatal("expected Ban to fail")
+	}
+	if bans := f.Bans(); len(bans) != 0 {
+		t.Fatalf("failed ban was recorded: %+v", bans)
+	}
+
+	executor = &fakeExecutor{missing: []string{"nft"}, fail: []string{"iptables -w -D"}}
+	f = New(executor)
+	if err := f.Ban("198.51.100.5", 443, -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Expire(time.Now()); err == nil {
+		t.Fatal("expected Expire to fail")
+	}
+	// The ban is kept so the next Expire tries again
+	if bans := f.Bans(); len(bans) != 1 {
+		t.Fatalf("bans = %+v, want the ban whose removal failed", bans)
+	}
+}
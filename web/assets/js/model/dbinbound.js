@@ -13,6 +13,14 @@ class DBInbound {
         
       // 新增：入站级设备限制（0 表示不限制）
         this.deviceLimit = 0;
+        // 超限后的处理策略，时间单位为秒
+        this.deviceAction = "block";
+        this.deviceTtl = 180;
+        this.deviceGrace = 0;
+        this.deviceCooldown = 0;
+        this.deviceThrottleSpeed = 0;
+        // 执行设备限制的方式：xray、fail2ban 或 firewall
+        this.limitBackend = "xray";
 
         this.listen = "";
         this.port = 0;
@@ -26,6 +34,10 @@ class DBInbound {
             return;
         }
         ObjectUtil.cloneProps(this, data);
+        // 旧数据没有策略，留空的处理方式和 TTL 在后端按默认值处理
+        if (!this.deviceAction) this.deviceAction = "block";
+        if (!this.deviceTtl) this.deviceTtl = 180;
+        if (!this.limitBackend) this.limitBackend = "xray";
     }
 
     get totalGB() {
@@ -1799,6 +1799,10 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
         email = RandomUtil.randomLowerAndNum(8),
         limitIp = 0,
         speedLimit = 0, // <--- 中文注释: 新增 speedLimit 属性
+        deviceLimit = 0, // 0 表示沿用入站的设备限制
+        uploadLimit = 0, // 单独的上传/下载限速，0 表示沿用 speedLimit
+        downloadLimit = 0,
+        burstLimit = 0,
         totalGB = 0,
         expiryTime = 0,
         enable = true,
@@ -1806,6 +1810,7 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
         subId = RandomUtil.randomLowerAndNum(16),
         comment = '',
         reset = 0,
+        group = '',
         created_at = undefined,
         updated_at = undefined
     ) {
@@ -1815,6 +1820,10 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
         this.email = email;
         this.limitIp = limitIp;
         this.speedLimit = speedLimit; // <--- 中文注释: 赋值
+        this.deviceLimit = deviceLimit;
+        this.uploadLimit = uploadLimit;
+        this.downloadLimit = downloadLimit;
+        this.burstLimit = burstLimit;
         this.totalGB = totalGB;
         this.expiryTime = expiryTime;
         this.enable = enable;
@@ -1822,6 +1831,7 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
         this.subId = subId;
         this.comment = comment;
         this.reset = reset;
+        this.group = group;
         this.created_at = created_at;
         this.updated_at = updated_at;
     }
@@ -1834,6 +1844,10 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
             json.email,
             json.limitIp,
             json.speedLimit ?? 0, // <--- 中文注释: 从 JSON 解析
+            json.deviceLimit ?? 0,
+            json.uploadLimit ?? 0,
+            json.downloadLimit ?? 0,
+            json.burstLimit ?? 0,
             json.totalGB,
             json.expiryTime,
             json.enable,
@@ -1841,6 +1855,7 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
             json.subId,
             json.comment,
             json.reset,
+            json.group ?? '',
             json.created_at,
             json.updated_at,
         );
@@ -1942,6 +1957,10 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
         email = RandomUtil.randomLowerAndNum(8),
         limitIp = 0,
         speedLimit = 0, // <--- 中文注释: 新增 speedLimit 属性
+        deviceLimit = 0, // 0 表示沿用入站的设备限制
+        uploadLimit = 0, // 单独的上传/下载限速，0 表示沿用 speedLimit
+        downloadLimit = 0,
+        burstLimit = 0,
         totalGB = 0,
         expiryTime = 0,
         enable = true,
@@ -1949,6 +1968,7 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
         subId = RandomUtil.randomLowerAndNum(16),
         comment = '',
         reset = 0,
+        group = '',
         created_at = undefined,
         updated_at = undefined
     ) {
@@ -1958,6 +1978,10 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
         this.email = email;
         this.limitIp = limitIp;
         this.speedLimit = speedLimit; // <--- 中文注释: 赋值
+        this.deviceLimit = deviceLimit;
+        this.uploadLimit = uploadLimit;
+        this.downloadLimit = downloadLimit;
+        this.burstLimit = burstLimit;
         this.totalGB = totalGB;
         this.expiryTime = expiryTime;
         this.enable = enable;
@@ -1965,6 +1989,7 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
         this.subId = subId;
         this.comment = comment;
         this.reset = reset;
+        this.group = group;
         this.created_at = created_at;
         this.updated_at = updated_at;
     }
@@ -1977,6 +2002,10 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
             json.email,
             json.limitIp,
             json.speedLimit ?? 0, // <--- 中文注释: 从 JSON 解析
+            json.deviceLimit ?? 0,
+            json.uploadLimit ?? 0,
+            json.downloadLimit ?? 0,
+            json.burstLimit ?? 0,
             json.totalGB,
             json.expiryTime,
             json.enable,
@@ -1984,6 +2013,7 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
             json.subId,
             json.comment,
             json.reset,
+            json.group ?? '',
             json.created_at,
             json.updated_at,
         );
@@ -2091,6 +2121,10 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
         email = RandomUtil.randomLowerAndNum(8),
         limitIp = 0,
         speedLimit = 0, // <--- 中文注释: 新增 speedLimit 属性
+        deviceLimit = 0, // 0 表示沿用入站的设备限制
+        uploadLimit = 0, // 单独的上传/下载限速，0 表示沿用 speedLimit
+        downloadLimit = 0,
+        burstLimit = 0,
         totalGB = 0,
         expiryTime = 0,
         enable = true,
@@ -2098,6 +2132,7 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
         subId = RandomUtil.randomLowerAndNum(16),
         comment = '',
         reset = 0,
+        group = '',
         created_at = undefined,
         updated_at = undefined
     ) {
@@ -2106,6 +2141,10 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
         this.email = email;
         this.limitIp = limitIp;
         this.speedLimit = speedLimit; // <--- 中文注释: 赋值
+        this.deviceLimit = deviceLimit;
+        this.uploadLimit = uploadLimit;
+        this.downloadLimit = downloadLimit;
+        this.burstLimit = burstLimit;
         this.totalGB = totalGB;
         this.expiryTime = expiryTime;
         this.enable = enable;
@@ -2113,6 +2152,7 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
         this.subId = subId;
         this.comment = comment;
         this.reset = reset;
+        this.group = group;
         this.created_at = created_at;
         this.updated_at = updated_at;
     }
@@ -2123,12 +2163,17 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
             email: this.email,
             limitIp: this.limitIp,
             speedLimit: this.speedLimit, // <--- 中文注释: 序列化到 JSON
+            deviceLimit: this.deviceLimit,
+            uploadLimit: this.uploadLimit,
+            downloadLimit: this.downloadLimit,
+            burstLimit: this.burstLimit,
             totalGB: this.totalGB,
             expiryTime: this.expiryTime,
             enable: this.enable,
             tgId: this.tgId,
             subId: this.subId,
             comment: this.comment,
+            group: this.group,
             reset: this.reset,
             created_at: this.created_at,
             updated_at: this.updated_at,
@@ -2141,6 +2186,10 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
             json.email,
             json.limitIp,
             json.speedLimit ?? 0, // <--- 中文注释: 从 JSON 解析
+            json.deviceLimit ?? 0,
+            json.uploadLimit ?? 0,
+            json.downloadLimit ?? 0,
+            json.burstLimit ?? 0,
             json.totalGB,
             json.expiryTime,
             json.enable,
@@ -2148,6 +2197,7 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
             json.subId,
             json.comment,
             json.reset,
+            json.group ?? '',
             json.created_at,
             json.updated_at,
         );
@@ -2264,6 +2314,10 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
         email = RandomUtil.randomLowerAndNum(8),
         limitIp = 0,
         speedLimit = 0, // <--- 中文注释: 新增 speedLimit 属性
+        deviceLimit = 0, // 0 表示沿用入站的设备限制
+        uploadLimit = 0, // 单独的上传/下载限速，0 表示沿用 speedLimit
+        downloadLimit = 0,
+        burstLimit = 0,
         totalGB = 0,
         expiryTime = 0,
         enable = true,
@@ -2271,6 +2325,7 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
         subId = RandomUtil.randomLowerAndNum(16),
         comment = '',
         reset = 0,
+        group = '',
         created_at = undefined,
         updated_at = undefined
     ) {
@@ -2280,6 +2335,10 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
         this.email = email;
         this.limitIp = limitIp;
         this.speedLimit = speedLimit; // <--- 中文注释: 赋值
+        this.deviceLimit = deviceLimit;
+        this.uploadLimit = uploadLimit;
+        this.downloadLimit = downloadLimit;
+        this.burstLimit = burstLimit;
         this.totalGB = totalGB;
         this.expiryTime = expiryTime;
         this.enable = enable;
@@ -2287,6 +2346,7 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
         this.subId = subId;
         this.comment = comment;
         this.reset = reset;
+        this.group = group;
         this.created_at = created_at;
         this.updated_at = updated_at;
     }
@@ -2298,12 +2358,17 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
             email: this.email,
             limitIp: this.limitIp,
             speedLimit: this.speedLimit, // <--- 中文注释: 序列化到 JSON
+            deviceLimit: this.deviceLimit,
+            uploadLimit: this.uploadLimit,
+            downloadLimit: this.downloadLimit,
+            burstLimit: this.burstLimit,
             totalGB: this.totalGB,
             expiryTime: this.expiryTime,
             enable: this.enable,
             tgId: this.tgId,
             subId: this.subId,
             comment: this.comment,
+            group: this.group,
             reset: this.reset,
             created_at: this.created_at,
             updated_at: this.updated_at,
@@ -2317,6 +2382,10 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
             json.email,
             json.limitIp,
             json.speedLimit ?? 0, // <--- 中文注释: 从 JSON 解析
+            json.deviceLimit ?? 0,
+            json.uploadLimit ?? 0,
+            json.downloadLimit ?? 0,
+            json.burstLimit ?? 0,
             json.totalGB,
             json.expiryTime,
             json.enable,
@@ -2324,6 +2393,7 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
             json.subId,
             json.comment,
             json.reset,
+            json.group ?? '',
             json.created_at,
             json.updated_at,
         );
@@ -23,8 +23,12 @@ class AllSetting {
         this.tgBotLoginNotify = true;
         this.tgCpu = 80;
         this.tgLang = "zh-CN";
-        this.twoFactorEnable = false;
-        this.twoFactorToken = "";
+        this.loginMaxAttempts = 5;
+        this.loginLockoutMinutes = 15;
+        this.panelAllowCIDRs = "";
+        this.panelDenyCIDRs = "";
+        this.trustedProxies = "127.0.0.1/8,::1";
+        this.loginHistoryRetention = 90;
         this.xrayTemplateConfig = "";
         this.subEnable = false;
         this.subTitle = "";
@@ -35,6 +39,21 @@ class AllSetting {
         this.subDomain = "";
         this.externalTrafficInformEnable = false;
         this.externalTrafficInformURI = "";
+        this.externalTrafficInformAuth = "none";
+        this.externalTrafficInformFormat = "legacy";
+        this.externalTrafficInformSecret = "";
+        this.externalTrafficInformCertFile = "";
+        this.externalTrafficInformKeyFile = "";
+        this.externalTrafficInformCAFile = "";
+        this.trafficMinuteRetention = 24;
+        this.trafficHourRetention = 30;
+        this.trafficDayRetention = 365;
+        this.metricsEnable = false;
+        this.firewallBanTime = 30;
+        this.firewallDryRun = false;
+        this.speedTiers = "";
+        this.clientAlertTrafficPercents = "";
+        this.clientAlertExpiryDays = "";
         this.subCertFile = "";
         this.subKeyFile = "";
         this.subUpdates = 12;
@@ -46,6 +65,11 @@ class AllSetting {
         this.subJsonNoises = "";
         this.subJsonMux = "";
         this.subJsonRules = "";
+        this.subClashPath = "/clash/";
+        this.subClashURI = "";
+        this.subClashRules = "";
+        this.subSingboxPath = "/singbox/";
+        this.subSingboxURI = "";
 
         this.timeLocation = "Local";
 
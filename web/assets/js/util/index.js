@@ -86,6 +86,83 @@ class HttpUtil {
         }
         return msg;
     }
+
+    // postJson sends a raw JSON body; axios would turn it into a form in its interceptor
+    static async postJson(url, data) {
+        try {
+            const base = axios.defaults.baseURL || '/';
+            const resp = await fetch(base.replace(/\/$/, '') + url, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', 'X-Requested-With': 'XMLHttpRequest' },
+                body: JSON.stringify(data),
+            });
+            const msg = this._respToMsg({ data: await resp.json() });
+            this._handleMsg(msg);
+            return msg;
+        } catch (error) {
+            console.error('POST request failed:', error);
+            const errorMsg = new Msg(false, error.message || 'Request failed');
+            this._handleMsg(errorMsg);
+            return errorMsg;
+        }
+    }
+}
+
+class PasskeyUtil {
+    static isSupported() {
+        return !!(window.PublicKeyCredential && navigator.credentials);
+    }
+
+    static decode(value) {
+        const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+        const binary = atob(base64 + '='.repeat((4 - base64.length % 4) % 4));
+        return Uint8Array.from(binary, c => c.charCodeAt(0)).buffer;
+    }
+
+    static encode(buffer) {
+        const binary = String.fromCharCode(...new Uint8Array(buffer));
+        return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    }
+
+    // create runs the registration ceremony for the options returned by the server
+    static async create(options) {
+        const publicKey = { ...options.publicKey };
+        publicKey.challenge = this.decode(publicKey.challenge);
+        publicKey.user = { ...publicKey.user, id: this.decode(publicKey.user.id) };
+        publicKey.excludeCredentials = (publicKey.excludeCredentials || []).map(c => ({ ...c, id: this.decode(c.id) }));
+        const credential = await navigator.credentials.create({ publicKey });
+        return {
+            id: credential.id,
+            rawId: this.encode(credential.rawId),
+            type: credential.type,
+            response: {
+                attestationObject: this.encode(credential.response.attestationObject),
+                clientDataJSON: this.encode(credential.response.clientDataJSON),
+                transports: credential.response.getTransports ? credential.response.getTransports() : [],
+            },
+            clientExtensionResults: credential.getClientExtensionResults(),
+        };
+    }
+
+    // get runs the login ceremony for the options returned by the server
+    static async get(options) {
+        const publicKey = { ...options.publicKey };
+        publicKey.challenge = this.decode(publicKey.challenge);
+        publicKey.allowCredentials = (publicKey.allowCredentials || []).map(c => ({ ...c, id: this.decode(c.id) }));
+        const credential = await navigator.credentials.get({ publicKey });
+        return {
+            id: credential.id,
+            rawId: this.encode(credential.rawId),
+            type: credential.type,
+            response: {
+                authenticatorData: this.encode(credential.response.authenticatorData),
+                clientDataJSON: this.encode(credential.response.clientDataJSON),
+                signature: this.encode(credential.response.signature),
+                userHandle: credential.response.userHandle ? this.encode(credential.response.userHandle) : null,
+            },
+            clientExtensionResults: credential.getClientExtensionResults(),
+        };
+    }
 }
 
 class PromiseUtil {
@@ -1,6 +1,9 @@
 package controller
 
 import (
+	"net/http"
+	"strings"
+
 	"x-ui/web/service"
 
 	"github.com/gin-gonic/gin"
@@ -10,8 +13,21 @@ type APIController struct {
 	BaseController
 	inboundController *InboundController
 	serverController  *ServerController
+	userController    *UserController
+	tokenController   *APITokenController
+	auditController   *AuditController
+	lockoutController *LoginLockoutController
+	loginsController  *LoginHistoryController
+	sessionController *SessionController
+	passkeyController *PasskeyController
+	trafficController *TrafficStatController
+	metricsController *MetricsController
+	alertController   *ClientAlertController
+	speedController   *SpeedRuleController
+	webhookController *WebhookController
 	Tgbot             service.Tgbot
 	serverService  service.ServerService
+	apiTokenService   service.APITokenService
 }
 
 func NewAPIController(g *gin.RouterGroup) *APIController {
@@ -23,7 +39,7 @@ func NewAPIController(g *gin.RouterGroup) *APIController {
 func (a *APIController) initRouter(g *gin.RouterGroup) {
 	// Main API group
 	api := g.Group("/panel/api")
-	api.Use(a.checkLogin)
+	api.Use(a.checkAPIAuth)
 
 	// Inbounds API
 	inbounds := api.Group("/inbounds")
@@ -33,8 +49,73 @@ func (a *APIController) initRouter(g *gin.RouterGroup) {
 	server := api.Group("/server")
 	a.serverController = NewServerController(server, a.serverService)
 
+	// Admin accounts API
+	users := api.Group("/users", requireRole(ownerRoles...))
+	a.userController = NewUserController(users)
+
+	// API tokens of the current account
+	tokens := api.Group("/tokens", requireRole(allRoles...))
+	a.tokenController = NewAPITokenController(tokens)
+
+	// Login sessions
+	sessionsGroup := api.Group("/sessions", requireRole(allRoles...))
+	a.sessionController = NewSessionController(sessionsGroup)
+
+	// Passkeys and recovery codes of the current account
+	passkeys := api.Group("/passkeys", requireRole(allRoles...))
+	a.passkeyController = NewPasskeyController(passkeys)
+
+	// Audit log
+	audit := api.Group("/audit", requireRole(auditRoles...))
+	a.auditController = NewAuditController(audit)
+
+	// Login lockouts
+	lockouts := api.Group("/lockouts", requireRole(ownerRoles...))
+	a.lockoutController = NewLoginLockoutController(lockouts)
+
+	// Login history
+	logins := api.Group("/logins", requireRole(auditRoles...))
+	a.loginsController = NewLoginHistoryController(logins)
+
+	// Traffic history
+	traffic := api.Group("/traffic", requireRole(allRoles...))
+	a.trafficController = NewTrafficStatController(traffic)
+
+	// Client quota and expiry alerts
+	alerts := api.Group("/alerts", requireRole(staffRoles...))
+	a.alertController = NewClientAlertController(alerts)
+
+	// Time-of-day and quota speed rules
+	speedRules := api.Group("/speedRules", requireRole(staffRoles...))
+	a.speedController = NewSpeedRuleController(speedRules)
+
+	// Outgoing webhooks and their delivery log
+	webhooks := api.Group("/webhooks", requireRole(ownerRoles...))
+	a.webhookController = NewWebhookController(webhooks)
+
+	// Prometheus metrics, scraped with a read token or a staff session
+	metrics := g.Group("/metrics", a.checkAPIAuth, requireRole(staffRoles...))
+	a.metricsController = NewMetricsController(metrics)
+
 	// Extra routes
-	api.GET("/backuptotgbot", a.BackuptoTgbot)
+	api.GET("/backuptotgbot", requireRole(ownerRoles...), a.BackuptoTgbot)
+}
+
+// checkAPIAuth accepts either an "Authorization: Bearer" API token or the usual login session.
+func (a *APIController) checkAPIAuth(c *gin.Context) {
+	auth := c.GetHeader("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		a.checkLogin(c)
+		return
+	}
+	token, err := a.apiTokenService.CheckToken(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+	if err != nil {
+		pureJsonMsg(c, http.StatusUnauthorized, false, err.Error())
+		c.Abort()
+		return
+	}
+	c.Set(apiTokenKey, token)
+	c.Next()
 }
 
 func (a *APIController) BackuptoTgbot(c *gin.Context) {
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"strconv"
+
+	"x-ui/database/model"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type apiTokenForm struct {
+	Name   string `json:"name" form:"name"`
+	Scopes string `json:"scopes" form:"scopes"`
+}
+
+// APITokenController lets an account manage its API tokens; owners see and revoke all of them.
+type APITokenController struct {
+	apiTokenService service.APITokenService
+}
+
+func NewAPITokenController(g *gin.RouterGroup) *APITokenController {
+	a := &APITokenController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *APITokenController) initRouter(g *gin.RouterGroup) {
+	g.Use(requireSession)
+
+	g.GET("/list", a.getTokens)
+	g.POST("/add", a.addToken)
+	g.POST("/del/:id", a.delToken)
+}
+
+// tokenOwnerFilter returns 0 for owners (all tokens) and the account id otherwise.
+func tokenOwnerFilter(user *model.User) int {
+	if user.Role == model.RoleOwner {
+		return 0
+	}
+	return user.Id
+}
+
+func (a *APITokenController) getTokens(c *gin.Context) {
+	tokens, err := a.apiTokenService.GetTokens(tokenOwnerFilter(getCurrentUser(c)))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getApiTokens"), err)
+		return
+	}
+	jsonObj(c, tokens, nil)
+}
+
+func (a *APITokenController) addToken(c *gin.Context) {
+	form := &apiTokenForm{}
+	err := c.ShouldBind(form)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.createApiToken"), err)
+		return
+	}
+	plain, token, err := a.apiTokenService.WithActor(auditActor(c)).CreateToken(getCurrentUser(c).Id, form.Name, form.Scopes)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.createApiToken"), err)
+		return
+	}
+	// The plain token is only returned here, it can not be recovered later
+	jsonMsgObj(c, I18nWeb(c, "pages.api.toasts.createApiToken"), gin.H{"token": plain, "info": token}, nil)
+}
+
+func (a *APITokenController) delToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.revokeApiToken"), err)
+		return
+	}
+	err = a.apiTokenService.WithActor(auditActor(c)).RevokeToken(id, tokenOwnerFilter(getCurrentUser(c)))
+	jsonMsg(c, I18nWeb(c, "pages.api.toasts.revokeApiToken"), err)
+}
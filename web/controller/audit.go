@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditController exposes the audit log of administrative changes.
+type AuditController struct {
+	auditService service.AuditService
+}
+
+func NewAuditController(g *gin.RouterGroup) *AuditController {
+	a := &AuditController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *AuditController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getLogs)
+	g.GET("/export", a.exportLogs)
+}
+
+func (a *AuditController) getLogs(c *gin.Context) {
+	filter := &service.AuditFilter{}
+	err := c.ShouldBindQuery(filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getAuditLog"), err)
+		return
+	}
+	logs, total, err := a.auditService.GetLogs(filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getAuditLog"), err)
+		return
+	}
+	jsonObj(c, gin.H{"logs": logs, "total": total, "page": filter.Page, "pageSize": filter.PageSize}, nil)
+}
+
+func (a *AuditController) exportLogs(c *gin.Context) {
+	filter := &service.AuditFilter{}
+	err := c.ShouldBindQuery(filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.exportAuditLog"), err)
+		return
+	}
+	filename := fmt.Sprintf("audit-%s.csv", time.Now().Format("20060102-150405"))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	err = a.auditService.ExportCSV(filter, c.Writer)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.exportAuditLog"), err)
+	}
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"strconv"
+
+	"x-ui/database/model"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientAlertController manages the quota and expiry alert thresholds and lists the sent alerts.
+type ClientAlertController struct {
+	clientAlertService service.ClientAlertService
+}
+
+func NewClientAlertController(g *gin.RouterGroup) *ClientAlertController {
+	a := &ClientAlertController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *ClientAlertController) initRouter(g *gin.RouterGroup) {
+	g.GET("/policies", a.getPolicies)
+	g.GET("/history", a.getHistory)
+
+	// Thresholds are changed by the accounts that manage all clients
+	write := g.Group("", requireRole(model.RoleOwner, model.RoleOperator))
+	write.POST("/policies/save", a.savePolicy)
+	write.POST("/policies/del/:id", a.delPolicy)
+}
+
+func (a *ClientAlertController) getPolicies(c *gin.Context) {
+	policies, err := a.clientAlertService.GetPolicies()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getAlertPolicies"), err)
+		return
+	}
+	jsonObj(c, policies, nil)
+}
+
+func (a *ClientAlertController) getHistory(c *gin.Context) {
+	filter := &service.ClientAlertFilter{}
+	err := c.ShouldBindQuery(filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getAlerts"), err)
+		return
+	}
+	alerts, total, err := a.clientAlertService.GetAlerts(filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getAlerts"), err)
+		return
+	}
+	jsonObj(c, gin.H{"alerts": alerts, "total": total, "page": filter.Page, "pageSize": filter.PageSize}, nil)
+}
+
+func (a *ClientAlertController) savePolicy(c *gin.Context) {
+	policy := &model.ClientAlertPolicy{}
+	err := c.ShouldBind(policy)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.saveAlertPolicy"), err)
+		return
+	}
+	err = a.clientAlertService.WithActor(auditActor(c)).SavePolicy(policy)
+	jsonMsgObj(c, I18nWeb(c, "pages.api.toasts.saveAlertPolicy"), policy, err)
+}
+
+func (a *ClientAlertController) delPolicy(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.deleteAlertPolicy"), err)
+		return
+	}
+	err = a.clientAlertService.WithActor(auditActor(c)).DelPolicy(id)
+	jsonMsg(c, I18nWeb(c, "pages.api.toasts.deleteAlertPolicy"), err)
+}
@@ -7,14 +7,14 @@ import (
 
 	"x-ui/database/model"
 	"x-ui/web/service"
-	"x-ui/web/session"
 
 	"github.com/gin-gonic/gin"
 )
 
 type InboundController struct {
-	inboundService service.InboundService
-	xrayService    service.XrayService
+	inboundService     service.InboundService
+	xrayService        service.XrayService
+	deviceLimitService service.DeviceLimitService
 }
 
 func NewInboundController(g *gin.RouterGroup) *InboundController {
@@ -24,32 +24,120 @@ func NewInboundController(g *gin.RouterGroup) *InboundController {
 }
 
 func (a *InboundController) initRouter(g *gin.RouterGroup) {
-	g.GET("/list", a.getInbounds)
-	g.GET("/get/:id", a.getInbound)
-	g.GET("/getClientTraffics/:email", a.getClientTraffics)
-	g.GET("/getClientTrafficsById/:id", a.getClientTrafficsById)
-
-	g.POST("/add", a.addInbound)
-	g.POST("/del/:id", a.delInbound)
-	g.POST("/update/:id", a.updateInbound)
-	g.POST("/clientIps/:email", a.getClientIps)
-	g.POST("/clearClientIps/:email", a.clearClientIps)
-	g.POST("/addClient", a.addInboundClient)
-	g.POST("/:id/delClient/:clientId", a.delInboundClient)
-	g.POST("/updateClient/:clientId", a.updateInboundClient)
-	g.POST("/:id/resetClientTraffic/:email", a.resetClientTraffic)
-	g.POST("/resetAllTraffics", a.resetAllTraffics)
-	g.POST("/resetAllClientTraffics/:id", a.resetAllClientTraffics)
-	g.POST("/delDepletedClients/:id", a.delDepletedClients)
-	g.POST("/import", a.importInbound)
-	g.POST("/onlines", a.onlines)
-	g.POST("/lastOnline", a.lastOnline)
-	g.POST("/updateClientTraffic/:email", a.updateClientTraffic)
+	// Read-only routes, available to every role
+	read := g.Group("", requireRole(allRoles...))
+	read.GET("/list", a.getInbounds)
+	read.GET("/get/:id", a.getInbound)
+	read.GET("/getClientTraffics/:email", a.getClientTraffics)
+	read.GET("/getClientTrafficsById/:id", a.getClientTrafficsById)
+	read.POST("/clientIps/:email", a.getClientIps)
+	read.POST("/onlines", a.onlines)
+	read.POST("/lastOnline", a.lastOnline)
+	read.GET("/clientGroups", a.getClientGroups)
+	read.GET("/devicePolicies", a.getDevicePolicies)
+	read.GET("/deviceStatus", a.getDeviceStatuses)
+	read.GET("/deviceStatus/:email", a.getDeviceStatus)
+
+	// Client management, available to owners, operators and resellers
+	clients := g.Group("", requireRole(managerRoles...))
+	clients.POST("/clearClientIps/:email", a.clearClientIps)
+	clients.POST("/addClient", a.addInboundClient)
+	clients.POST("/:id/delClient/:clientId", a.delInboundClient)
+	clients.POST("/updateClient/:clientId", a.updateInboundClient)
+	clients.POST("/:id/resetClientTraffic/:email", a.resetClientTraffic)
+	clients.POST("/resetAllClientTraffics/:id", a.resetAllClientTraffics)
+	clients.POST("/delDepletedClients/:id", a.delDepletedClients)
+	clients.POST("/updateClientTraffic/:email", a.updateClientTraffic)
+	clients.POST("/bulk", a.bulkUpdateClients)
+	clients.POST("/devicePolicy", a.saveDevicePolicy)
+	clients.POST("/delDevicePolicy/:email", a.delDevicePolicy)
+
+	// Inbound structure changes, owners and resellers (own inbounds only)
+	inbounds := g.Group("", requireRole(inboundRoles...))
+	inbounds.POST("/add", a.addInbound)
+	inbounds.POST("/del/:id", a.delInbound)
+	inbounds.POST("/update/:id", a.updateInbound)
+	inbounds.POST("/import", a.importInbound)
+
+	g.POST("/resetAllTraffics", requireRole(ownerRoles...), a.resetAllTraffics)
 }
 
-func (a *InboundController) getInbounds(c *gin.Context) {
-	user := session.GetLoginUser(c)
+// checkInboundOwner reports whether the current account may access the inbound.
+// Only resellers are restricted; a denied request has already been answered.
+func (a *InboundController) checkInboundOwner(c *gin.Context, inboundId int) bool {
+	user := getCurrentUser(c)
+	if user == nil || user.Role != model.RoleReseller {
+		return true
+	}
+	inbound, err := a.inboundService.GetInbound(inboundId)
+	if err != nil || inbound.UserId != user.Id {
+		denyAccess(c)
+		return false
+	}
+	return true
+}
+
+// checkClientOwner is checkInboundOwner for routes that address a client by email.
+func (a *InboundController) checkClientOwner(c *gin.Context, email string) bool {
+	user := getCurrentUser(c)
+	if user == nil || user.Role != model.RoleReseller {
+		return true
+	}
+	_, inbound, err := a.inboundService.GetClientInboundByEmail(email)
+	if err != nil || inbound == nil || inbound.UserId != user.Id {
+		denyAccess(c)
+		return false
+	}
+	return true
+}
+
+// resellerEmails returns the client emails a reseller may see, or nil for unrestricted roles.
+func (a *InboundController) resellerEmails(c *gin.Context) (map[string]bool, error) {
+	user := getCurrentUser(c)
+	if user == nil || user.Role != model.RoleReseller {
+		return nil, nil
+	}
+	emails, err := a.inboundService.GetUserClientEmails(user.Id)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make(map[string]bool, len(emails))
+	for _, email := range emails {
+		allowed[email] = true
+	}
+	return allowed, nil
+}
+
+// resellerInboundIds expands the "all inbounds" id (-1) into the reseller's own inbounds.
+func (a *InboundController) resellerInboundIds(c *gin.Context, id int) ([]int, bool) {
+	user := getCurrentUser(c)
+	if id >= 0 || user == nil || user.Role != model.RoleReseller {
+		return []int{id}, true
+	}
 	inbounds, err := a.inboundService.GetInbounds(user.Id)
+	if err != nil {
+		denyAccess(c)
+		return nil, false
+	}
+	ids := make([]int, 0, len(inbounds))
+	for _, inbound := range inbounds {
+		ids = append(ids, inbound.Id)
+	}
+	return ids, true
+}
+
+// resellerUserId returns the account id resellers are restricted to, 0 for other roles.
+func resellerUserId(c *gin.Context) int {
+	user := getCurrentUser(c)
+	if user == nil || user.Role != model.RoleReseller {
+		return 0
+	}
+	return user.Id
+}
+
+func (a *InboundController) getInbounds(c *gin.Context) {
+	// Resellers only see their own inbounds
+	inbounds, err := a.inboundService.GetInboundsForUser(getCurrentUser(c))
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
 		return
@@ -63,6 +151,9 @@ func (a *InboundController) getInbound(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "get"), err)
 		return
 	}
+	if !a.checkInboundOwner(c, id) {
+		return
+	}
 	inbound, err := a.inboundService.GetInbound(id)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
@@ -73,6 +164,9 @@ func (a *InboundController) getInbound(c *gin.Context) {
 
 func (a *InboundController) getClientTraffics(c *gin.Context) {
 	email := c.Param("email")
+	if !a.checkClientOwner(c, email) {
+		return
+	}
 	clientTraffics, err := a.inboundService.GetClientTrafficByEmail(email)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.trafficGetError"), err)
@@ -88,6 +182,20 @@ func (a *InboundController) getClientTrafficsById(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.trafficGetError"), err)
 		return
 	}
+	allowed, err := a.resellerEmails(c)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.trafficGetError"), err)
+		return
+	}
+	if allowed != nil {
+		owned := clientTraffics[:0]
+		for _, traffic := range clientTraffics {
+			if allowed[traffic.Email] {
+				owned = append(owned, traffic)
+			}
+		}
+		clientTraffics = owned
+	}
 	jsonObj(c, clientTraffics, nil)
 }
 
@@ -98,8 +206,7 @@ func (a *InboundController) addInbound(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundCreateSuccess"), err)
 		return
 	}
-	user := session.GetLoginUser(c)
-	inbound.UserId = user.Id
+	inbound.UserId = getCurrentUser(c).Id
 	if inbound.Listen == "" || inbound.Listen == "0.0.0.0" || inbound.Listen == "::" || inbound.Listen == "::0" {
 		inbound.Tag = fmt.Sprintf("inbound-%v", inbound.Port)
 	} else {
@@ -107,7 +214,7 @@ func (a *InboundController) addInbound(c *gin.Context) {
 	}
 
 	needRestart := false
-	inbound, needRestart, err = a.inboundService.AddInbound(inbound)
+	inbound, needRestart, err = a.inboundService.WithActor(auditActor(c)).AddInbound(inbound)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -124,8 +231,11 @@ func (a *InboundController) delInbound(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundDeleteSuccess"), err)
 		return
 	}
+	if !a.checkInboundOwner(c, id) {
+		return
+	}
 	needRestart := true
-	needRestart, err = a.inboundService.DelInbound(id)
+	needRestart, err = a.inboundService.WithActor(auditActor(c)).DelInbound(id)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -142,6 +252,9 @@ func (a *InboundController) updateInbound(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
 		return
 	}
+	if !a.checkInboundOwner(c, id) {
+		return
+	}
 	inbound := &model.Inbound{
 		Id: id,
 	}
@@ -151,7 +264,7 @@ func (a *InboundController) updateInbound(c *gin.Context) {
 		return
 	}
 	needRestart := true
-	inbound, needRestart, err = a.inboundService.UpdateInbound(inbound)
+	inbound, needRestart, err = a.inboundService.WithActor(auditActor(c)).UpdateInbound(inbound)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -164,6 +277,9 @@ func (a *InboundController) updateInbound(c *gin.Context) {
 
 func (a *InboundController) getClientIps(c *gin.Context) {
 	email := c.Param("email")
+	if !a.checkClientOwner(c, email) {
+		return
+	}
 
 	ips, err := a.inboundService.GetInboundClientIps(email)
 	if err != nil || ips == "" {
@@ -176,8 +292,11 @@ func (a *InboundController) getClientIps(c *gin.Context) {
 
 func (a *InboundController) clearClientIps(c *gin.Context) {
 	email := c.Param("email")
+	if !a.checkClientOwner(c, email) {
+		return
+	}
 
-	err := a.inboundService.ClearClientIps(email)
+	err := a.inboundService.WithActor(auditActor(c)).ClearClientIps(email)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.updateSuccess"), err)
 		return
@@ -192,15 +311,19 @@ func (a *InboundController) addInboundClient(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
 		return
 	}
+	if !a.checkInboundOwner(c, data.Id) {
+		return
+	}
 
 	needRestart := true
 
-	needRestart, err = a.inboundService.AddInboundClient(data)
+	needRestart, err = a.inboundService.WithActor(auditActor(c)).AddInboundClient(data)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
-	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientAddSuccess"), nil)
+	// needRestart tells the caller that the change takes effect once Xray has been restarted
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientAddSuccess"), gin.H{"needRestart": needRestart}, nil)
 	if needRestart {
 		a.xrayService.SetToNeedRestart()
 	}
@@ -213,10 +336,13 @@ func (a *InboundController) delInboundClient(c *gin.Context) {
 		return
 	}
 	clientId := c.Param("clientId")
+	if !a.checkInboundOwner(c, id) {
+		return
+	}
 
 	needRestart := true
 
-	needRestart, err = a.inboundService.DelInboundClient(id, clientId)
+	needRestart, err = a.inboundService.WithActor(auditActor(c)).DelInboundClient(id, clientId)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -236,15 +362,19 @@ func (a *InboundController) updateInboundClient(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
 		return
 	}
+	if !a.checkInboundOwner(c, inbound.Id) {
+		return
+	}
 
 	needRestart := true
 
-	needRestart, err = a.inboundService.UpdateInboundClient(inbound, clientId)
+	needRestart, err = a.inboundService.WithActor(auditActor(c)).UpdateInboundClient(inbound, clientId)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
-	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), nil)
+	// needRestart tells the caller that the change takes effect once Xray has been restarted
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), gin.H{"needRestart": needRestart}, nil)
 	if needRestart {
 		a.xrayService.SetToNeedRestart()
 	}
@@ -257,8 +387,11 @@ func (a *InboundController) resetClientTraffic(c *gin.Context) {
 		return
 	}
 	email := c.Param("email")
+	if !a.checkInboundOwner(c, id) {
+		return
+	}
 
-	needRestart, err := a.inboundService.ResetClientTraffic(id, email)
+	needRestart, err := a.inboundService.WithActor(auditActor(c)).ResetClientTraffic(id, email)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -270,7 +403,7 @@ func (a *InboundController) resetClientTraffic(c *gin.Context) {
 }
 
 func (a *InboundController) resetAllTraffics(c *gin.Context) {
-	err := a.inboundService.ResetAllTraffics()
+	err := a.inboundService.WithActor(auditActor(c)).ResetAllTraffics()
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -287,13 +420,22 @@ func (a *InboundController) resetAllClientTraffics(c *gin.Context) {
 		return
 	}
 
-	err = a.inboundService.ResetAllClientTraffics(id)
-	if err != nil {
-		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+	if id >= 0 && !a.checkInboundOwner(c, id) {
+		return
+	}
+	ids, ok := a.resellerInboundIds(c, id)
+	if !ok {
 		return
-	} else {
-		a.xrayService.SetToNeedRestart()
 	}
+
+	for _, inboundId := range ids {
+		err = a.inboundService.WithActor(auditActor(c)).ResetAllClientTraffics(inboundId)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+	}
+	a.xrayService.SetToNeedRestart()
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.resetAllClientTrafficSuccess"), nil)
 }
 
@@ -304,9 +446,8 @@ func (a *InboundController) importInbound(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
-	user := session.GetLoginUser(c)
 	inbound.Id = 0
-	inbound.UserId = user.Id
+	inbound.UserId = getCurrentUser(c).Id
 	if inbound.Listen == "" || inbound.Listen == "0.0.0.0" || inbound.Listen == "::" || inbound.Listen == "::0" {
 		inbound.Tag = fmt.Sprintf("inbound-%v", inbound.Port)
 	} else {
@@ -319,7 +460,7 @@ func (a *InboundController) importInbound(c *gin.Context) {
 	}
 
 	needRestart := false
-	inbound, needRestart, err = a.inboundService.AddInbound(inbound)
+	inbound, needRestart, err = a.inboundService.WithActor(auditActor(c)).AddInbound(inbound)
 	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundCreateSuccess"), inbound, err)
 	if err == nil && needRestart {
 		a.xrayService.SetToNeedRestart()
@@ -332,25 +473,68 @@ func (a *InboundController) delDepletedClients(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
 		return
 	}
-	err = a.inboundService.DelDepletedClients(id)
-	if err != nil {
-		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+	if id >= 0 && !a.checkInboundOwner(c, id) {
+		return
+	}
+	ids, ok := a.resellerInboundIds(c, id)
+	if !ok {
 		return
 	}
+	for _, inboundId := range ids {
+		err = a.inboundService.WithActor(auditActor(c)).DelDepletedClients(inboundId)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+	}
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.delDepletedClientsSuccess"), nil)
 }
 
 func (a *InboundController) onlines(c *gin.Context) {
-	jsonObj(c, a.inboundService.GetOnlineClients(), nil)
+	onlines := a.inboundService.GetOnlineClients()
+	allowed, err := a.resellerEmails(c)
+	if err != nil {
+		jsonObj(c, nil, err)
+		return
+	}
+	if allowed != nil {
+		owned := make([]string, 0, len(onlines))
+		for _, email := range onlines {
+			if allowed[email] {
+				owned = append(owned, email)
+			}
+		}
+		onlines = owned
+	}
+	jsonObj(c, onlines, nil)
 }
 
 func (a *InboundController) lastOnline(c *gin.Context) {
 	data, err := a.inboundService.GetClientsLastOnline()
-	jsonObj(c, data, err)
+	if err != nil {
+		jsonObj(c, data, err)
+		return
+	}
+	allowed, err := a.resellerEmails(c)
+	if err != nil {
+		jsonObj(c, nil, err)
+		return
+	}
+	if allowed != nil {
+		for email := range data {
+			if !allowed[email] {
+				delete(data, email)
+			}
+		}
+	}
+	jsonObj(c, data, nil)
 }
 
 func (a *InboundController) updateClientTraffic(c *gin.Context) {
 	email := c.Param("email")
+	if !a.checkClientOwner(c, email) {
+		return
+	}
 
 	// Define the request structure for traffic update
 	type TrafficUpdateRequest struct {
@@ -365,7 +549,7 @@ func (a *InboundController) updateClientTraffic(c *gin.Context) {
 		return
 	}
 
-	err = a.inboundService.UpdateClientTrafficByEmail(email, request.Upload, request.Download)
+	err = a.inboundService.WithActor(auditActor(c)).UpdateClientTrafficByEmail(email, request.Upload, request.Download)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -373,3 +557,129 @@ func (a *InboundController) updateClientTraffic(c *gin.Context) {
 
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), nil)
 }
+
+func (a *InboundController) getClientGroups(c *gin.Context) {
+	groups, err := a.inboundService.GetClientGroups(resellerUserId(c))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, groups, nil)
+}
+
+// bulkUpdateClients applies one operation to all clients of a group or matching a filter.
+func (a *InboundController) bulkUpdateClients(c *gin.Context) {
+	request := &service.BulkClientRequest{}
+	err := c.ShouldBindJSON(request)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), err)
+		return
+	}
+	// Resellers can only select and move clients within their own inbounds
+	request.Filter.UserId = resellerUserId(c)
+
+	count, needRestart, err := a.inboundService.WithActor(auditActor(c)).BulkUpdateClients(request)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), gin.H{"count": count, "needRestart": needRestart}, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// getDevicePolicies lists the clients whose device-limit policy overrides their inbound's
+func (a *InboundController) getDevicePolicies(c *gin.Context) {
+	policies, err := a.deviceLimitService.GetClientPolicies()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	allowed, err := a.resellerEmails(c)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	if allowed != nil {
+		owned := policies[:0]
+		for _, policy := range policies {
+			if allowed[policy.Email] {
+				owned = append(owned, policy)
+			}
+		}
+		policies = owned
+	}
+	jsonObj(c, policies, nil)
+}
+
+func (a *InboundController) saveDevicePolicy(c *gin.Context) {
+	policy := &model.ClientDevicePolicy{}
+	err := c.ShouldBind(policy)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), err)
+		return
+	}
+	if !a.checkClientOwner(c, policy.Email) {
+		return
+	}
+	needRestart, err := a.deviceLimitService.WithActor(auditActor(c)).SaveClientPolicy(policy)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), policy, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+func (a *InboundController) delDevicePolicy(c *gin.Context) {
+	email := c.Param("email")
+	if !a.checkClientOwner(c, email) {
+		return
+	}
+	err := a.deviceLimitService.WithActor(auditActor(c)).DelClientPolicy(email)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), nil)
+}
+
+// getDeviceStatuses lists the online IPs and device-limit state of every tracked client
+func (a *InboundController) getDeviceStatuses(c *gin.Context) {
+	statuses, err := a.deviceLimitService.GetDeviceStatuses("")
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	allowed, err := a.resellerEmails(c)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	if allowed != nil {
+		owned := statuses[:0]
+		for _, status := range statuses {
+			if allowed[status.Email] {
+				owned = append(owned, status)
+			}
+		}
+		statuses = owned
+	}
+	jsonObj(c, statuses, nil)
+}
+
+func (a *InboundController) getDeviceStatus(c *gin.Context) {
+	email := c.Param("email")
+	if !a.checkClientOwner(c, email) {
+		return
+	}
+	statuses, err := a.deviceLimitService.GetDeviceStatuses(email)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, statuses[0], nil)
+}
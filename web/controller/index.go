@@ -1,10 +1,12 @@
 package controller
 
 import (
+	"math"
 	"net/http"
+	"strconv"
 	"text/template"
-	"time"
 
+	"x-ui/database/model"
 	"x-ui/logger"
 	"x-ui/web/service"
 	"x-ui/web/session"
@@ -22,9 +24,12 @@ type LoginForm struct {
 type IndexController struct {
 	BaseController
 
-	settingService service.SettingService
-	userService    service.UserService
-	tgbot          service.Tgbot
+	settingService      service.SettingService
+	userService         service.UserService
+	loginLimitService   service.LoginLimitService
+	loginHistoryService service.LoginHistoryService
+	passkeyService      service.PasskeyService
+	tgbot               service.Tgbot
 }
 
 func NewIndexController(g *gin.RouterGroup) *IndexController {
@@ -36,6 +41,7 @@ func NewIndexController(g *gin.RouterGroup) *IndexController {
 func (a *IndexController) initRouter(g *gin.RouterGroup) {
 	g.GET("/", a.index)
 	g.POST("/login", a.login)
+	g.POST("/login/passkey", a.loginPasskey)
 	g.GET("/logout", a.logout)
 	g.POST("/getTwoFactorEnable", a.getTwoFactorEnable)
 }
@@ -64,20 +70,102 @@ func (a *IndexController) login(c *gin.Context) {
 		return
 	}
 
-	user := a.userService.CheckUser(form.Username, form.Password, form.TwoFactorCode)
-	timeStr := time.Now().Format("2006-01-02 15:04:05")
-	safeUser := template.HTMLEscapeString(form.Username)
-	safePass := template.HTMLEscapeString(form.Password)
+	remoteIp := getRemoteIp(c)
+	if a.checkLoginLimit(c, remoteIp, form.Username) {
+		return
+	}
+
+	user, result := a.userService.CheckUser(form.Username, form.Password, form.TwoFactorCode)
+	event := service.NewLoginEvent(form.Username, form.Password, remoteIp, c.Request.UserAgent(), result)
 
 	if user == nil {
-		logger.Warningf("wrong username: \"%s\", password: \"%s\", IP: \"%s\"", safeUser, safePass, getRemoteIp(c))
-		a.tgbot.UserLoginNotify(safeUser, safePass, getRemoteIp(c), timeStr, 0)
-		pureJsonMsg(c, http.StatusOK, false, I18nWeb(c, "pages.login.toasts.wrongUsernameOrPassword"))
+		a.loginFailed(c, event)
+		return
+	}
+
+	if result == model.LoginResultPasskeyRequired {
+		assertion, data, err := a.passkeyService.BeginLogin(user, webAuthnRP(c))
+		if err != nil {
+			logger.Warning("begin passkey login failed:", err)
+			pureJsonMsg(c, http.StatusOK, false, I18nWeb(c, "pages.login.toasts.wrongUsernameOrPassword"))
+			return
+		}
+		session.SetPasskeyChallenge(c, &session.PasskeyChallenge{Login: true, UserId: user.Id, Data: data})
+		if err := sessions.Default(c).Save(); err != nil {
+			logger.Warning("Unable to save session: ", err)
+			return
+		}
+		jsonObj(c, gin.H{"passkeyRequired": true, "options": assertion}, nil)
+		return
+	}
+
+	a.loginSucceeded(c, user, event)
+}
+
+// loginPasskey completes a login whose password was accepted but still needs a passkey.
+func (a *IndexController) loginPasskey(c *gin.Context) {
+	challenge := session.TakePasskeyChallenge(c, true)
+	// Saved right away so a failed attempt cannot replay the challenge
+	if err := sessions.Default(c).Save(); err != nil {
+		logger.Warning("Unable to save session: ", err)
+	}
+	if challenge == nil {
+		pureJsonMsg(c, http.StatusOK, false, I18nWeb(c, "pages.login.toasts.invalidFormData"))
+		return
+	}
+	user, err := a.userService.GetUserById(challenge.UserId)
+	if err != nil {
+		pureJsonMsg(c, http.StatusOK, false, I18nWeb(c, "pages.login.toasts.invalidFormData"))
+		return
+	}
+
+	remoteIp := getRemoteIp(c)
+	if a.checkLoginLimit(c, remoteIp, user.Username) {
+		return
+	}
+
+	err = a.passkeyService.FinishLogin(user, webAuthnRP(c), challenge.Data, c.Request)
+	if err != nil {
+		logger.Warning("passkey login failed:", err)
+		a.loginFailed(c, service.NewLoginEvent(user.Username, "", remoteIp, c.Request.UserAgent(), model.LoginResultWrongPasskey))
 		return
 	}
+	a.loginSucceeded(c, user, service.NewLoginEvent(user.Username, "", remoteIp, c.Request.UserAgent(), model.LoginResultSuccess))
+}
+
+// checkLoginLimit turns the attempt away before any credential is checked when the IP or
+// the username is backed off or locked. Unknown usernames are limited the same way, so the
+// reply does not tell whether an account exists. Owners lift a lockout of their own
+// username from another session, or by restarting the panel.
+func (a *IndexController) checkLoginLimit(c *gin.Context, ip string, username string) bool {
+	wait := max(a.loginLimitService.CheckAllowed(ip), a.loginLimitService.CheckUsername(username))
+	if wait <= 0 {
+		return false
+	}
+	// Limited attempts are not recorded, a flood of them would only fill the history
+	logger.Debugf("rate limited login of \"%s\" from IP \"%s\"", template.HTMLEscapeString(username), ip)
+	seconds := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+	pureJsonMsg(c, http.StatusOK, false, I18nWeb(c, "pages.login.toasts.tooManyAttempts", "Seconds=="+seconds))
+	return true
+}
+
+func (a *IndexController) loginFailed(c *gin.Context, event *model.LoginHistory) {
+	logger.Warningf("failed login of \"%s\" from IP \"%s\": %s", template.HTMLEscapeString(event.Username), event.IP, event.Reason)
+	if lockedUntil, locked := a.loginLimitService.RecordFailure(event.IP, event.Username); locked {
+		event.LockedUntil = lockedUntil.Unix()
+	}
+	a.loginHistoryService.Record(event)
+	a.tgbot.UserLoginNotify(event)
+	pureJsonMsg(c, http.StatusOK, false, I18nWeb(c, "pages.login.toasts.wrongUsernameOrPassword"))
+}
+
+func (a *IndexController) loginSucceeded(c *gin.Context, user *model.User, event *model.LoginHistory) {
+	safeUser := template.HTMLEscapeString(user.Username)
+	a.loginLimitService.RecordSuccess(event.IP, event.Username)
+	a.loginHistoryService.Record(event)
 
-	logger.Infof("%s logged in successfully, Ip Address: %s\n", safeUser, getRemoteIp(c))
-	a.tgbot.UserLoginNotify(safeUser, ``, getRemoteIp(c), timeStr, 1)
+	logger.Infof("%s logged in successfully, Ip Address: %s\n", safeUser, event.IP)
+	a.tgbot.UserLoginNotify(event)
 
 	sessionMaxAge, err := a.settingService.GetSessionMaxAge()
 	if err != nil {
@@ -108,7 +196,7 @@ func (a *IndexController) logout(c *gin.Context) {
 }
 
 func (a *IndexController) getTwoFactorEnable(c *gin.Context) {
-	status, err := a.settingService.GetTwoFactorEnable()
+	status, err := a.userService.HasTwoFactor()
 	if err == nil {
 		jsonObj(c, status, nil)
 	}
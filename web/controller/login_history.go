@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LoginHistoryController exposes the recorded panel login attempts.
+type LoginHistoryController struct {
+	loginHistoryService service.LoginHistoryService
+}
+
+func NewLoginHistoryController(g *gin.RouterGroup) *LoginHistoryController {
+	a := &LoginHistoryController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *LoginHistoryController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getHistory)
+}
+
+func (a *LoginHistoryController) getHistory(c *gin.Context) {
+	filter := &service.LoginHistoryFilter{}
+	err := c.ShouldBindQuery(filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getLoginHistory"), err)
+		return
+	}
+	history, total, err := a.loginHistoryService.GetHistory(filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getLoginHistory"), err)
+		return
+	}
+	jsonObj(c, gin.H{"history": history, "total": total, "page": filter.Page, "pageSize": filter.PageSize}, nil)
+}
@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LoginLockoutController lets owners inspect and lift login lockouts.
+type LoginLockoutController struct {
+	loginLimitService service.LoginLimitService
+	auditService      service.AuditService
+}
+
+func NewLoginLockoutController(g *gin.RouterGroup) *LoginLockoutController {
+	a := &LoginLockoutController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *LoginLockoutController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getLockouts)
+	g.POST("/clear", a.clearLockout)
+}
+
+func (a *LoginLockoutController) getLockouts(c *gin.Context) {
+	jsonObj(c, a.loginLimitService.GetLockouts(), nil)
+}
+
+// clearLockout lifts the lockout with the posted key, or every lockout when key is empty.
+func (a *LoginLockoutController) clearLockout(c *gin.Context) {
+	key := c.PostForm("key")
+	err := a.loginLimitService.ClearLockout(key)
+	if err == nil {
+		target := key
+		if target == "" {
+			target = "all"
+		}
+		a.auditService.Record(auditActor(c), "login.clearLockout", target, nil, nil)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.api.toasts.unlockLogin"), err)
+}
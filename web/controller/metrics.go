@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"x-ui/logger"
+	"x-ui/web/job"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsController serves the panel state in the Prometheus text format.
+type MetricsController struct {
+	serverService   service.ServerService
+	settingService  service.SettingService
+	inboundService  service.InboundService
+	outboundService service.OutboundService
+
+	statusLock sync.Mutex
+	lastStatus *service.Status
+}
+
+func NewMetricsController(g *gin.RouterGroup) *MetricsController {
+	a := &MetricsController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *MetricsController) initRouter(g *gin.RouterGroup) {
+	g.GET("", a.metrics)
+}
+
+// status refreshes the system status at most every few seconds,
+// so that frequent scrapes do not sample the CPU over a tiny window.
+func (a *MetricsController) status() *service.Status {
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+	if a.lastStatus == nil || time.Since(a.lastStatus.T) > 5*time.Second {
+		a.lastStatus = a.serverService.GetStatus(a.lastStatus)
+	}
+	return a.lastStatus
+}
+
+func (a *MetricsController) metrics(c *gin.Context) {
+	enable, err := a.settingService.GetMetricsEnable()
+	if err != nil || !enable {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	w := &metricsWriter{}
+	a.writeSystem(w, a.status())
+	if err := a.writeTraffic(w); err != nil {
+		logger.Warning("collect traffic metrics failed:", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	banned, activeIPs := job.GetDeviceLimitStats()
+	w.metric("xui_device_limit_banned_clients", "gauge", "Clients currently disabled for exceeding their device limit.")
+	w.sample("xui_device_limit_banned_clients", float64(banned))
+	w.metric("xui_device_limit_active_ips", "gauge", "Client IPs seen by the device limit check in its activity window.")
+	w.sample("xui_device_limit_active_ips", float64(activeIPs))
+
+	a.writeJobs(w)
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(w.String()))
+}
+
+func (a *MetricsController) writeSystem(w *metricsWriter, status *service.Status) {
+	w.metric("xui_cpu_usage_percent", "gauge", "CPU usage of the server.")
+	w.sample("xui_cpu_usage_percent", status.Cpu)
+	w.metric("xui_cpu_cores", "gauge", "Physical CPU cores of the server.")
+	w.sample("xui_cpu_cores", float64(status.CpuCores))
+	w.metric("xui_memory_used_bytes", "gauge", "Memory in use.")
+	w.sample("xui_memory_used_bytes", float64(status.Mem.Current))
+	w.metric("xui_memory_total_bytes", "gauge", "Total memory.")
+	w.sample("xui_memory_total_bytes", float64(status.Mem.Total))
+	w.metric("xui_swap_used_bytes", "gauge", "Swap in use.")
+	w.sample("xui_swap_used_bytes", float64(status.Swap.Current))
+	w.metric("xui_swap_total_bytes", "gauge", "Total swap.")
+	w.sample("xui_swap_total_bytes", float64(status.Swap.Total))
+	w.metric("xui_disk_used_bytes", "gauge", "Disk space in use.")
+	w.sample("xui_disk_used_bytes", float64(status.Disk.Current))
+	w.metric("xui_disk_total_bytes", "gauge", "Total disk space.")
+	w.sample("xui_disk_total_bytes", float64(status.Disk.Total))
+	w.metric("xui_load_average", "gauge", "System load average.")
+	for i, period := range []string{"1", "5", "15"} {
+		if i < len(status.Loads) {
+			w.sample("xui_load_average", status.Loads[i], "period", period)
+		}
+	}
+	w.metric("xui_connections", "gauge", "Open TCP and UDP connections.")
+	w.sample("xui_connections", float64(status.TcpCount), "protocol", "tcp")
+	w.sample("xui_connections", float64(status.UdpCount), "protocol", "udp")
+	w.metric("xui_network_speed_bytes_per_second", "gauge", "Network throughput since the previous sample.")
+	w.sample("xui_network_speed_bytes_per_second", float64(status.NetIO.Up), "direction", "up")
+	w.sample("xui_network_speed_bytes_per_second", float64(status.NetIO.Down), "direction", "down")
+	w.metric("xui_network_bytes_total", "counter", "Bytes sent and received by the server.")
+	w.sample("xui_network_bytes_total", float64(status.NetTraffic.Sent), "direction", "up")
+	w.sample("xui_network_bytes_total", float64(status.NetTraffic.Recv), "direction", "down")
+	w.metric("xui_uptime_seconds", "gauge", "Uptime of the server.")
+	w.sample("xui_uptime_seconds", float64(status.Uptime))
+
+	running := 0.0
+	if status.Xray.State == service.Running {
+		running = 1
+	}
+	w.metric("xui_xray_up", "gauge", "Whether Xray is running.")
+	w.sample("xui_xray_up", running)
+	w.metric("xui_xray_state", "gauge", "State of the Xray process.")
+	for _, state := range []service.ProcessState{service.Running, service.Stop, service.Error} {
+		value := 0.0
+		if status.Xray.State == state {
+			value = 1
+		}
+		w.sample("xui_xray_state", value, "state", string(state))
+	}
+	w.metric("xui_xray_uptime_seconds", "gauge", "Time since Xray was started.")
+	w.sample("xui_xray_uptime_seconds", float64(status.AppStats.Uptime))
+	w.metric("xui_xray_info", "gauge", "Version of the Xray core.")
+	w.sample("xui_xray_info", 1, "version", status.Xray.Version)
+
+	if export := status.TrafficExport; export != nil && export.Enabled {
+		w.metric("xui_traffic_export_backlog", "gauge", "Traffic records waiting to be sent to the external traffic URI.")
+		w.sample("xui_traffic_export_backlog", float64(export.Backlog))
+		w.metric("xui_traffic_export_last_success_timestamp_seconds", "gauge", "Time of the last accepted traffic export.")
+		w.sample("xui_traffic_export_last_success_timestamp_seconds", float64(export.LastSuccess))
+	}
+}
+
+func (a *MetricsController) writeTraffic(w *metricsWriter) error {
+	inbounds, err := a.inboundService.GetAllInbounds()
+	if err != nil {
+		return err
+	}
+	outbounds, err := a.outboundService.GetOutboundsTraffic()
+	if err != nil {
+		return err
+	}
+
+	w.metric("xui_inbound_traffic_bytes_total", "counter", "Traffic of each inbound since its last reset.")
+	for _, inbound := range inbounds {
+		port := strconv.Itoa(inbound.Port)
+		w.sample("xui_inbound_traffic_bytes_total", float64(inbound.Up), "inbound", inbound.Tag, "protocol", string(inbound.Protocol), "port", port, "direction", "up")
+		w.sample("xui_inbound_traffic_bytes_total", float64(inbound.Down), "inbound", inbound.Tag, "protocol", string(inbound.Protocol), "port", port, "direction", "down")
+	}
+	w.metric("xui_inbound_enabled", "gauge", "Whether each inbound is enabled.")
+	for _, inbound := range inbounds {
+		w.sample("xui_inbound_enabled", boolValue(inbound.Enable), "inbound", inbound.Tag)
+	}
+
+	w.metric("xui_client_traffic_bytes_total", "counter", "Traffic of each client since its last reset.")
+	for _, inbound := range inbounds {
+		for _, client := range inbound.ClientStats {
+			w.sample("xui_client_traffic_bytes_total", float64(client.Up), "email", client.Email, "inbound", inbound.Tag, "direction", "up")
+			w.sample("xui_client_traffic_bytes_total", float64(client.Down), "email", client.Email, "inbound", inbound.Tag, "direction", "down")
+		}
+	}
+	w.metric("xui_client_enabled", "gauge", "Whether each client is enabled.")
+	for _, inbound := range inbounds {
+		for _, client := range inbound.ClientStats {
+			w.sample("xui_client_enabled", boolValue(client.Enable), "email", client.Email, "inbound", inbound.Tag)
+		}
+	}
+
+	w.metric("xui_outbound_traffic_bytes_total", "counter", "Traffic of each outbound since its last reset.")
+	for _, outbound := range outbounds {
+		w.sample("xui_outbound_traffic_bytes_total", float64(outbound.Up), "outbound", outbound.Tag, "direction", "up")
+		w.sample("xui_outbound_traffic_bytes_total", float64(outbound.Down), "outbound", outbound.Tag, "direction", "down")
+	}
+
+	w.metric("xui_online_clients", "gauge", "Clients with traffic in the last collection.")
+	w.sample("xui_online_clients", float64(len(a.inboundService.GetOnlineClients())))
+	return nil
+}
+
+func (a *MetricsController) writeJobs(w *metricsWriter) {
+	stats := job.GetJobStats()
+	w.metric("xui_job_runs_total", "counter", "Runs of each scheduled job.")
+	for _, s := range stats {
+		w.sample("xui_job_runs_total", float64(s.Runs), "job", s.Name)
+	}
+	w.metric("xui_job_errors_total", "counter", "Failed runs of each scheduled job.")
+	for _, s := range stats {
+		w.sample("xui_job_errors_total", float64(s.Errors), "job", s.Name)
+	}
+	w.metric("xui_job_duration_seconds_total", "counter", "Time spent running each scheduled job.")
+	for _, s := range stats {
+		w.sample("xui_job_duration_seconds_total", s.TotalDuration.Seconds(), "job", s.Name)
+	}
+	w.metric("xui_job_last_duration_seconds", "gauge", "Duration of the last run of each scheduled job.")
+	for _, s := range stats {
+		w.sample("xui_job_last_duration_seconds", s.LastDuration.Seconds(), "job", s.Name)
+	}
+	w.metric("xui_job_last_run_timestamp_seconds", "gauge", "Start time of the last run of each scheduled job.")
+	for _, s := range stats {
+		if !s.LastRun.IsZero() {
+			w.sample("xui_job_last_run_timestamp_seconds", float64(s.LastRun.Unix()), "job", s.Name)
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// metricsWriter builds a response in the Prometheus text exposition format.
+type metricsWriter struct {
+	strings.Builder
+}
+
+func (w *metricsWriter) metric(name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one value; labels are given as name, value pairs
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(labels[i])
+			w.WriteString(`="`)
+			w.WriteString(labelEscaper.Replace(labels[i+1]))
+			w.WriteByte('"')
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	w.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+
+	"x-ui/web/service"
+	"x-ui/web/session"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// PasskeyController registers and removes the passkeys of the current account
+// and issues its recovery codes.
+type PasskeyController struct {
+	passkeyService service.PasskeyService
+}
+
+func NewPasskeyController(g *gin.RouterGroup) *PasskeyController {
+	a := &PasskeyController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *PasskeyController) initRouter(g *gin.RouterGroup) {
+	g.Use(requireSession)
+
+	g.GET("/list", a.getPasskeys)
+	g.POST("/register/begin", a.beginRegistration)
+	g.POST("/register/finish", a.finishRegistration)
+	g.POST("/del/:id", a.delPasskey)
+	g.POST("/recoveryCodes", a.generateRecoveryCodes)
+}
+
+func (a *PasskeyController) getPasskeys(c *gin.Context) {
+	user := getCurrentUser(c)
+	passkeys, err := a.passkeyService.GetPasskeys(user.Id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getPasskeys"), err)
+		return
+	}
+	remaining, err := a.passkeyService.CountRecoveryCodes(user.Id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getPasskeys"), err)
+		return
+	}
+	jsonObj(c, gin.H{"passkeys": passkeys, "recoveryCodes": remaining}, nil)
+}
+
+func (a *PasskeyController) beginRegistration(c *gin.Context) {
+	user := getCurrentUser(c)
+	creation, data, err := a.passkeyService.BeginRegistration(user, webAuthnRP(c))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.addPasskey"), err)
+		return
+	}
+	session.SetPasskeyChallenge(c, &session.PasskeyChallenge{UserId: user.Id, Data: data})
+	if err := sessions.Default(c).Save(); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.addPasskey"), err)
+		return
+	}
+	jsonObj(c, creation, nil)
+}
+
+// finishRegistration takes the authenticator response as the JSON body and the passkey name as query.
+func (a *PasskeyController) finishRegistration(c *gin.Context) {
+	user := getCurrentUser(c)
+	challenge := session.TakePasskeyChallenge(c, false)
+	sessions.Default(c).Save()
+	if challenge == nil || challenge.UserId != user.Id {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.addPasskey"), errors.New(I18nWeb(c, "pages.api.toasts.noPasskeyRegistration")))
+		return
+	}
+	passkey, err := a.passkeyService.WithActor(auditActor(c)).FinishRegistration(user, webAuthnRP(c), challenge.Data, c.Query("name"), c.Request)
+	jsonMsgObj(c, I18nWeb(c, "pages.api.toasts.addPasskey"), passkey, err)
+}
+
+func (a *PasskeyController) delPasskey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.deletePasskey"), err)
+		return
+	}
+	err = a.passkeyService.WithActor(auditActor(c)).DelPasskey(id, getCurrentUser(c).Id)
+	jsonMsg(c, I18nWeb(c, "pages.api.toasts.deletePasskey"), err)
+}
+
+// generateRecoveryCodes replaces the recovery codes; they are only shown in this response.
+func (a *PasskeyController) generateRecoveryCodes(c *gin.Context) {
+	codes, err := a.passkeyService.WithActor(auditActor(c)).GenerateRecoveryCodes(getCurrentUser(c).Id)
+	jsonMsgObj(c, I18nWeb(c, "pages.api.toasts.generateRecoveryCodes"), codes, err)
+}
@@ -41,29 +41,38 @@ func NewServerController(g *gin.RouterGroup, serverService service.ServerService
 }
 
 func (a *ServerController) initRouter(g *gin.RouterGroup) {
-	g.GET("/status", a.status)
-	g.GET("/getXrayVersion", a.getXrayVersion)
-	g.GET("/getConfigJson", a.getConfigJson)
-	g.GET("/getDb", a.getDb)
-	g.GET("/getNewUUID", a.getNewUUID)
-	g.GET("/getNewX25519Cert", a.getNewX25519Cert)
-	g.GET("/getNewmldsa65", a.getNewmldsa65)
-	g.GET("/getNewmlkem768", a.getNewmlkem768)
-	g.GET("/getNewVlessEnc", a.getNewVlessEnc)
-
-	g.POST("/stopXrayService", a.stopXrayService)
-	g.POST("/restartXrayService", a.restartXrayService)
-	g.POST("/installXray/:version", a.installXray)
-	g.POST("/updateGeofile", a.updateGeofile)
-	g.POST("/updateGeofile/:fileName", a.updateGeofile)
-	g.POST("/logs/:count", a.getLogs)
-	g.POST("/xraylogs/:count", a.getXrayLogs)
-	g.POST("/importDB", a.importDB)
-	g.POST("/getNewEchCert", a.getNewEchCert)
-	g.POST("/history/save", a.saveHistory)
-	g.GET("/history/load", a.loadHistory)
-	g.POST("/install/subconverter", a.installSubconverter)
-	g.POST("/openPort", a.openPort)
+	read := g.Group("", requireRole(allRoles...))
+	read.GET("/status", a.status)
+	read.GET("/getXrayVersion", a.getXrayVersion)
+	read.GET("/history/load", a.loadHistory)
+
+	// Logs cover every inbound, so resellers can not read them
+	logs := g.Group("", requireRole(staffRoles...))
+	logs.POST("/logs/:count", a.getLogs)
+	logs.POST("/xraylogs/:count", a.getXrayLogs)
+
+	// Key and ID generators used by the client/inbound forms
+	tools := g.Group("", requireRole(managerRoles...))
+	tools.GET("/getNewUUID", a.getNewUUID)
+	tools.GET("/getNewX25519Cert", a.getNewX25519Cert)
+	tools.GET("/getNewmldsa65", a.getNewmldsa65)
+	tools.GET("/getNewmlkem768", a.getNewmlkem768)
+	tools.GET("/getNewVlessEnc", a.getNewVlessEnc)
+	tools.POST("/getNewEchCert", a.getNewEchCert)
+	tools.POST("/history/save", a.saveHistory)
+
+	// Server control, owners only
+	control := g.Group("", requireRole(ownerRoles...))
+	control.GET("/getConfigJson", a.getConfigJson)
+	control.GET("/getDb", a.getDb)
+	control.POST("/stopXrayService", a.stopXrayService)
+	control.POST("/restartXrayService", a.restartXrayService)
+	control.POST("/installXray/:version", a.installXray)
+	control.POST("/updateGeofile", a.updateGeofile)
+	control.POST("/updateGeofile/:fileName", a.updateGeofile)
+	control.POST("/importDB", a.importDB)
+	control.POST("/install/subconverter", a.installSubconverter)
+	control.POST("/openPort", a.openPort)
 }
 
 func (a *ServerController) refreshStatus() {
@@ -240,7 +249,7 @@ func (a *ServerController) importDB(c *gin.Context) {
 		a.lastGetStatusTime = time.Now()
 	}()
 	// Import it
-	err = a.serverService.ImportDB(file)
+	err = a.serverService.WithActor(auditActor(c)).ImportDB(file)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.index.importDatabaseError"), err)
 		return
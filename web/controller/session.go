@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"strconv"
+
+	"x-ui/web/service"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// SessionController shows the active login sessions and revokes them.
+// Accounts manage their own sessions, owners those of every account.
+type SessionController struct {
+	sessionService service.SessionService
+}
+
+func NewSessionController(g *gin.RouterGroup) *SessionController {
+	a := &SessionController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *SessionController) initRouter(g *gin.RouterGroup) {
+	g.Use(requireSession)
+
+	g.GET("/list", a.getSessions)
+	g.POST("/revoke/:id", a.revokeSession)
+	g.POST("/revokeAll", a.revokeAllSessions)
+}
+
+func (a *SessionController) getSessions(c *gin.Context) {
+	list, err := a.sessionService.GetSessions(tokenOwnerFilter(getCurrentUser(c)))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getSessions"), err)
+		return
+	}
+	jsonObj(c, gin.H{"sessions": list, "current": sessions.Default(c).ID()}, nil)
+}
+
+func (a *SessionController) revokeSession(c *gin.Context) {
+	err := a.sessionService.WithActor(auditActor(c)).RevokeSession(c.Param("id"), tokenOwnerFilter(getCurrentUser(c)))
+	jsonMsg(c, I18nWeb(c, "pages.api.toasts.revokeSession"), err)
+}
+
+// revokeAllSessions logs out every other session of the caller. Owners may pass
+// userId to log out all sessions of another account.
+func (a *SessionController) revokeAllSessions(c *gin.Context) {
+	user := getCurrentUser(c)
+	userId := user.Id
+	exceptId := sessions.Default(c).ID()
+	if id := c.PostForm("userId"); id != "" && tokenOwnerFilter(user) == 0 {
+		var err error
+		userId, err = strconv.Atoi(id)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "pages.api.toasts.revokeSession"), err)
+			return
+		}
+		if userId != user.Id {
+			exceptId = ""
+		}
+	}
+	err := a.sessionService.WithActor(auditActor(c)).RevokeUserSessions(userId, exceptId)
+	jsonMsg(c, I18nWeb(c, "pages.api.toasts.revokeSession"), err)
+}
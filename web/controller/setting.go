@@ -17,6 +17,13 @@ type updateUserForm struct {
 	OldPassword string `json:"oldPassword" form:"oldPassword"`
 	NewUsername string `json:"newUsername" form:"newUsername"`
 	NewPassword string `json:"newPassword" form:"newPassword"`
+	// Required when the account uses TOTP
+	TwoFactorCode string `json:"twoFactorCode" form:"twoFactorCode"`
+}
+
+type twoFactorForm struct {
+	Token string `json:"token" form:"token"`
+	Code  string `json:"code" form:"code"`
 }
 
 type SettingController struct {
@@ -34,12 +41,19 @@ func NewSettingController(g *gin.RouterGroup) *SettingController {
 func (a *SettingController) initRouter(g *gin.RouterGroup) {
 	g = g.Group("/setting")
 
-	g.POST("/all", a.getAllSetting)
-	g.POST("/defaultSettings", a.getDefaultSettings)
-	g.POST("/update", a.updateSetting)
-	g.POST("/updateUser", a.updateUser)
-	g.POST("/restartPanel", a.restartPanel)
-	g.GET("/getDefaultJsonConfig", a.getDefaultXrayConfig)
+	// Every account may read the UI defaults and change its own credentials and TOTP
+	self := g.Group("", requireRole(allRoles...))
+	self.POST("/defaultSettings", a.getDefaultSettings)
+	self.POST("/updateUser", a.updateUser)
+	self.POST("/twoFactor", a.getTwoFactor)
+	self.POST("/twoFactor/enable", a.enableTwoFactor)
+	self.POST("/twoFactor/disable", a.disableTwoFactor)
+
+	owner := g.Group("", requireRole(ownerRoles...))
+	owner.POST("/all", a.getAllSetting)
+	owner.POST("/update", a.updateSetting)
+	owner.POST("/restartPanel", a.restartPanel)
+	owner.GET("/getDefaultJsonConfig", a.getDefaultXrayConfig)
 }
 
 func (a *SettingController) getAllSetting(c *gin.Context) {
@@ -67,7 +81,7 @@ func (a *SettingController) updateSetting(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
 		return
 	}
-	err = a.settingService.UpdateAllSetting(allSetting)
+	err = a.settingService.WithActor(auditActor(c)).UpdateAllSetting(allSetting)
 	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
 }
 
@@ -87,15 +101,54 @@ func (a *SettingController) updateUser(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifyUserError"), errors.New(I18nWeb(c, "pages.settings.toasts.userPassMustBeNotEmpty")))
 		return
 	}
-	err = a.userService.UpdateUser(user.Id, form.NewUsername, form.NewPassword)
-	if err == nil {
-		user.Username = form.NewUsername
-		user.Password, _ = crypto.HashPasswordAsBcrypt(form.NewPassword)
-		session.SetLoginUser(c, user)
+	valid, err := a.userService.CheckTwoFactor(user.Id, form.TwoFactorCode)
+	if err == nil && !valid {
+		err = errors.New(I18nWeb(c, "pages.settings.security.twoFactorModalError"))
 	}
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifyUserError"), err)
+		return
+	}
+	// UpdateUser revokes every session of the account, the page logs out afterwards
+	err = a.userService.WithActor(auditActor(c)).UpdateUser(user.Id, form.NewUsername, form.NewPassword)
 	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifyUser"), err)
 }
 
+func (a *SettingController) getTwoFactor(c *gin.Context) {
+	user, err := a.userService.GetUserById(session.GetLoginUser(c).Id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.getSettings"), err)
+		return
+	}
+	jsonObj(c, user.TwoFactorEnable, nil)
+}
+
+func (a *SettingController) enableTwoFactor(c *gin.Context) {
+	form := &twoFactorForm{}
+	err := c.ShouldBind(form)
+	if err == nil {
+		err = a.userService.WithActor(auditActor(c)).EnableTwoFactor(session.GetLoginUser(c).Id, form.Token, form.Code)
+	}
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.security.twoFactorModalError"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.security.twoFactorModalSetSuccess"), nil)
+}
+
+func (a *SettingController) disableTwoFactor(c *gin.Context) {
+	form := &twoFactorForm{}
+	err := c.ShouldBind(form)
+	if err == nil {
+		err = a.userService.WithActor(auditActor(c)).DisableTwoFactor(session.GetLoginUser(c).Id, form.Code)
+	}
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.security.twoFactorModalError"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.security.twoFactorModalDeleteSuccess"), nil)
+}
+
 func (a *SettingController) restartPanel(c *gin.Context) {
 	err := a.panelService.RestartPanel(time.Second * 3)
 	jsonMsg(c, I18nWeb(c, "pages.settings.restartPanelSuccess"), err)
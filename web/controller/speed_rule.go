@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"strconv"
+
+	"x-ui/database/model"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SpeedRuleController manages the rules that lower client speeds by time of day or used traffic.
+type SpeedRuleController struct {
+	speedRuleService service.SpeedRuleService
+	xrayService      service.XrayService
+}
+
+func NewSpeedRuleController(g *gin.RouterGroup) *SpeedRuleController {
+	a := &SpeedRuleController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *SpeedRuleController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getRules)
+
+	// Rules are changed by the accounts that manage all clients
+	write := g.Group("", requireRole(model.RoleOwner, model.RoleOperator))
+	write.POST("/save", a.saveRule)
+	write.POST("/del/:id", a.delRule)
+}
+
+func (a *SpeedRuleController) getRules(c *gin.Context) {
+	rules, err := a.speedRuleService.GetRules()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getSpeedRules"), err)
+		return
+	}
+	jsonObj(c, rules, nil)
+}
+
+func (a *SpeedRuleController) saveRule(c *gin.Context) {
+	rule := &model.SpeedRule{}
+	err := c.ShouldBind(rule)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.saveSpeedRule"), err)
+		return
+	}
+	needRestart, err := a.speedRuleService.WithActor(auditActor(c)).SaveRule(rule)
+	if err == nil && needRestart {
+		// Xray needs a policy level for the new speed before clients can be moved to it
+		a.xrayService.SetToNeedRestart()
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.api.toasts.saveSpeedRule"), rule, err)
+}
+
+func (a *SpeedRuleController) delRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.deleteSpeedRule"), err)
+		return
+	}
+	err = a.speedRuleService.WithActor(auditActor(c)).DelRule(id)
+	jsonMsg(c, I18nWeb(c, "pages.api.toasts.deleteSpeedRule"), err)
+}
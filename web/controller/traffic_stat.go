@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"x-ui/database/model"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrafficStatController exposes the traffic history of clients, inbounds and outbounds.
+type TrafficStatController struct {
+	trafficStatService service.TrafficStatService
+	inboundService     service.InboundService
+}
+
+func NewTrafficStatController(g *gin.RouterGroup) *TrafficStatController {
+	a := &TrafficStatController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *TrafficStatController) initRouter(g *gin.RouterGroup) {
+	g.GET("/history", a.getHistory)
+	g.GET("/top", a.getTop)
+}
+
+// bindQuery parses the query and limits resellers to the clients and inbounds they own.
+// It answers the request itself and returns nil when the query can not be served.
+func (a *TrafficStatController) bindQuery(c *gin.Context) *service.TrafficQuery {
+	query := &service.TrafficQuery{}
+	if err := c.ShouldBindQuery(query); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getTrafficHistory"), err)
+		return nil
+	}
+	userId := resellerUserId(c)
+	if userId == 0 {
+		return query
+	}
+	switch query.Kind {
+	case model.TrafficKindClient:
+		emails, err := a.inboundService.GetUserClientEmails(userId)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "pages.api.toasts.getTrafficHistory"), err)
+			return nil
+		}
+		query.Names = emails
+	case model.TrafficKindInbound:
+		inbounds, err := a.inboundService.GetInbounds(userId)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "pages.api.toasts.getTrafficHistory"), err)
+			return nil
+		}
+		query.Names = make([]string, 0, len(inbounds))
+		for _, inbound := range inbounds {
+			query.Names = append(query.Names, inbound.Tag)
+		}
+	default:
+		// Outbounds are shared by every account
+		denyAccess(c)
+		return nil
+	}
+	return query
+}
+
+func (a *TrafficStatController) getHistory(c *gin.Context) {
+	query := a.bindQuery(c)
+	if query == nil {
+		return
+	}
+	points, err := a.trafficStatService.GetHistory(query)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getTrafficHistory"), err)
+		return
+	}
+	jsonObj(c, gin.H{"points": points, "interval": query.Interval, "from": query.From, "to": query.To}, nil)
+}
+
+func (a *TrafficStatController) getTop(c *gin.Context) {
+	query := a.bindQuery(c)
+	if query == nil {
+		return
+	}
+	usages, err := a.trafficStatService.GetTop(query)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getTrafficHistory"), err)
+		return
+	}
+	jsonObj(c, gin.H{"top": usages, "from": query.From, "to": query.To}, nil)
+}
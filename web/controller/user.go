@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+
+	"x-ui/database/model"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type userForm struct {
+	Username string     `json:"username" form:"username"`
+	Password string     `json:"password" form:"password"`
+	Role     model.Role `json:"role" form:"role"`
+
+	// Reseller quotas, 0 means unlimited
+	MaxClients int `json:"maxClients" form:"maxClients"`
+	MaxTotalGB int `json:"maxTotalGB" form:"maxTotalGB"`
+}
+
+// UserController manages the admin accounts of the panel. Only owners may use it.
+type UserController struct {
+	userService service.UserService
+}
+
+func NewUserController(g *gin.RouterGroup) *UserController {
+	a := &UserController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *UserController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getUsers)
+	g.POST("/add", a.addUser)
+	g.POST("/update/:id", a.updateUser)
+	g.POST("/del/:id", a.delUser)
+}
+
+func (a *UserController) getUsers(c *gin.Context) {
+	users, err := a.userService.GetUsers()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getUsers"), err)
+		return
+	}
+	jsonObj(c, users, nil)
+}
+
+func (a *UserController) addUser(c *gin.Context) {
+	form := &userForm{}
+	err := c.ShouldBind(form)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.addUser"), err)
+		return
+	}
+	user, err := a.userService.WithActor(auditActor(c)).AddUser(form.Username, form.Password, form.Role, form.MaxClients, form.MaxTotalGB)
+	jsonMsgObj(c, I18nWeb(c, "pages.api.toasts.addUser"), user, err)
+}
+
+func (a *UserController) updateUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.updateUser"), err)
+		return
+	}
+	form := &userForm{}
+	err = c.ShouldBind(form)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.updateUser"), err)
+		return
+	}
+	err = a.userService.WithActor(auditActor(c)).UpdateUserAccount(id, form.Username, form.Password, form.Role, form.MaxClients, form.MaxTotalGB)
+	jsonMsg(c, I18nWeb(c, "pages.api.toasts.updateUser"), err)
+}
+
+func (a *UserController) delUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.deleteUser"), err)
+		return
+	}
+	if user := getCurrentUser(c); user != nil && user.Id == id {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.deleteUser"), errors.New(I18nWeb(c, "pages.api.toasts.deleteSelf")))
+		return
+	}
+	err = a.userService.WithActor(auditActor(c)).DelUser(id)
+	jsonMsg(c, I18nWeb(c, "pages.api.toasts.deleteUser"), err)
+}
@@ -3,28 +3,45 @@ package controller
 import (
 	"net"
 	"net/http"
-	"strings"
 
 	"x-ui/config"
 	"x-ui/logger"
 	"x-ui/web/entity"
+	"x-ui/web/service"
 
 	"github.com/gin-gonic/gin"
 )
 
+// getRemoteIp returns the client IP. X-Real-IP and X-Forwarded-For are only honoured
+// when the request comes from one of the trustedProxies, so they cannot be forged.
 func getRemoteIp(c *gin.Context) string {
-	value := c.GetHeader("X-Real-IP")
-	if value != "" {
-		return value
+	return c.ClientIP()
+}
+
+// webAuthnRP derives the WebAuthn relying party from the configured web domain, or from
+// the host the panel was reached on. The X-Forwarded-* headers are only read from trusted proxies.
+func webAuthnRP(c *gin.Context) service.WebAuthnRP {
+	trusted := c.GetBool("trusted_proxy")
+	host := c.Request.Host
+	if forwarded := c.GetHeader("X-Forwarded-Host"); forwarded != "" && trusted {
+		host = forwarded
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || (trusted && c.GetHeader("X-Forwarded-Proto") == "https") {
+		scheme = "https"
 	}
-	value = c.GetHeader("X-Forwarded-For")
-	if value != "" {
-		ips := strings.Split(value, ",")
-		return ips[0]
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname, port = host, ""
+	}
+	if domain := c.GetString("web_domain"); domain != "" {
+		hostname = domain
+		host = domain
+		if port != "" {
+			host = net.JoinHostPort(domain, port)
+		}
 	}
-	addr := c.Request.RemoteAddr
-	ip, _, _ := net.SplitHostPort(addr)
-	return ip
+	return service.WebAuthnRP{ID: hostname, Origin: scheme + "://" + host}
 }
 
 func jsonMsg(c *gin.Context, msg string, err error) {
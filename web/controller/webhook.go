@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"strconv"
+
+	"x-ui/database/model"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WebhookController manages the outgoing webhooks and shows their delivery log.
+type WebhookController struct {
+	webhookService service.WebhookService
+}
+
+func NewWebhookController(g *gin.RouterGroup) *WebhookController {
+	a := &WebhookController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *WebhookController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getWebhooks)
+	g.GET("/events", a.getEvents)
+	g.GET("/deliveries", a.getDeliveries)
+	g.POST("/add", a.addWebhook)
+	g.POST("/update/:id", a.updateWebhook)
+	g.POST("/del/:id", a.delWebhook)
+	g.POST("/test/:id", a.testWebhook)
+	g.POST("/deliveries/retry/:id", a.retryDelivery)
+}
+
+func (a *WebhookController) getWebhooks(c *gin.Context) {
+	webhooks, err := a.webhookService.GetWebhooks()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getWebhooks"), err)
+		return
+	}
+	jsonObj(c, webhooks, nil)
+}
+
+func (a *WebhookController) getEvents(c *gin.Context) {
+	jsonObj(c, model.WebhookEvents, nil)
+}
+
+func (a *WebhookController) getDeliveries(c *gin.Context) {
+	filter := &service.WebhookDeliveryFilter{}
+	err := c.ShouldBindQuery(filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getWebhookDeliveries"), err)
+		return
+	}
+	deliveries, total, err := a.webhookService.GetDeliveries(filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.getWebhookDeliveries"), err)
+		return
+	}
+	jsonObj(c, gin.H{"deliveries": deliveries, "total": total, "page": filter.Page, "pageSize": filter.PageSize}, nil)
+}
+
+func (a *WebhookController) addWebhook(c *gin.Context) {
+	webhook := &model.Webhook{}
+	err := c.ShouldBind(webhook)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.addWebhook"), err)
+		return
+	}
+	err = a.webhookService.WithActor(auditActor(c)).AddWebhook(webhook)
+	jsonMsgObj(c, I18nWeb(c, "pages.api.toasts.addWebhook"), webhook, err)
+}
+
+func (a *WebhookController) updateWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.updateWebhook"), err)
+		return
+	}
+	webhook := &model.Webhook{}
+	err = c.ShouldBind(webhook)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.updateWebhook"), err)
+		return
+	}
+	webhook.Id = id
+	err = a.webhookService.WithActor(auditActor(c)).UpdateWebhook(webhook)
+	jsonMsgObj(c, I18nWeb(c, "pages.api.toasts.updateWebhook"), webhook, err)
+}
+
+func (a *WebhookController) delWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.deleteWebhook"), err)
+		return
+	}
+	err = a.webhookService.WithActor(auditActor(c)).DelWebhook(id)
+	jsonMsg(c, I18nWeb(c, "pages.api.toasts.deleteWebhook"), err)
+}
+
+func (a *WebhookController) testWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.testWebhook"), err)
+		return
+	}
+	delivery, err := a.webhookService.TestWebhook(id)
+	jsonMsgObj(c, I18nWeb(c, "pages.api.toasts.testWebhook"), delivery, err)
+}
+
+func (a *WebhookController) retryDelivery(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.api.toasts.redeliverWebhook"), err)
+		return
+	}
+	err = a.webhookService.RetryDelivery(id)
+	jsonMsg(c, I18nWeb(c, "pages.api.toasts.redeliverWebhook"), err)
+}
@@ -22,7 +22,7 @@ func NewXraySettingController(g *gin.RouterGroup) *XraySettingController {
 }
 
 func (a *XraySettingController) initRouter(g *gin.RouterGroup) {
-	g = g.Group("/xray")
+	g = g.Group("/xray", requireRole(ownerRoles...))
 
 	g.POST("/", a.getXraySetting)
 	g.POST("/update", a.updateSetting)
@@ -4,9 +4,11 @@ import (
 	"crypto/tls"
 	"math"
 	"net"
+	"os"
 	"strings"
 	"time"
 
+	"x-ui/database/model"
 	"x-ui/util/common"
 )
 
@@ -17,51 +19,75 @@ type Msg struct {
 }
 
 type AllSetting struct {
-	WebListen                   string `json:"webListen" form:"webListen"`
-	WebDomain                   string `json:"webDomain" form:"webDomain"`
-	WebPort                     int    `json:"webPort" form:"webPort"`
-	WebCertFile                 string `json:"webCertFile" form:"webCertFile"`
-	WebKeyFile                  string `json:"webKeyFile" form:"webKeyFile"`
-	WebBasePath                 string `json:"webBasePath" form:"webBasePath"`
-	SessionMaxAge               int    `json:"sessionMaxAge" form:"sessionMaxAge"`
-	PageSize                    int    `json:"pageSize" form:"pageSize"`
-	ExpireDiff                  int    `json:"expireDiff" form:"expireDiff"`
-	TrafficDiff                 int    `json:"trafficDiff" form:"trafficDiff"`
-	RemarkModel                 string `json:"remarkModel" form:"remarkModel"`
-	TgBotEnable                 bool   `json:"tgBotEnable" form:"tgBotEnable"`
-	TgBotToken                  string `json:"tgBotToken" form:"tgBotToken"`
-	TgBotProxy                  string `json:"tgBotProxy" form:"tgBotProxy"`
-	TgBotAPIServer              string `json:"tgBotAPIServer" form:"tgBotAPIServer"`
-	TgBotChatId                 string `json:"tgBotChatId" form:"tgBotChatId"`
-	TgRunTime                   string `json:"tgRunTime" form:"tgRunTime"`
-	TgBotBackup                 bool   `json:"tgBotBackup" form:"tgBotBackup"`
-	TgBotLoginNotify            bool   `json:"tgBotLoginNotify" form:"tgBotLoginNotify"`
-	TgCpu                       int    `json:"tgCpu" form:"tgCpu"`
-	TgLang                      string `json:"tgLang" form:"tgLang"`
-	TimeLocation                string `json:"timeLocation" form:"timeLocation"`
-	TwoFactorEnable             bool   `json:"twoFactorEnable" form:"twoFactorEnable"`
-	TwoFactorToken              string `json:"twoFactorToken" form:"twoFactorToken"`
-	SubEnable                   bool   `json:"subEnable" form:"subEnable"`
-	SubTitle                    string `json:"subTitle" form:"subTitle"`
-	SubListen                   string `json:"subListen" form:"subListen"`
-	SubPort                     int    `json:"subPort" form:"subPort"`
-	SubPath                     string `json:"subPath" form:"subPath"`
-	SubDomain                   string `json:"subDomain" form:"subDomain"`
-	SubCertFile                 string `json:"subCertFile" form:"subCertFile"`
-	SubKeyFile                  string `json:"subKeyFile" form:"subKeyFile"`
-	SubUpdates                  int    `json:"subUpdates" form:"subUpdates"`
-	ExternalTrafficInformEnable bool   `json:"externalTrafficInformEnable" form:"externalTrafficInformEnable"`
-	ExternalTrafficInformURI    string `json:"externalTrafficInformURI" form:"externalTrafficInformURI"`
-	SubEncrypt                  bool   `json:"subEncrypt" form:"subEncrypt"`
-	SubShowInfo                 bool   `json:"subShowInfo" form:"subShowInfo"`
-	SubURI                      string `json:"subURI" form:"subURI"`
-	SubJsonPath                 string `json:"subJsonPath" form:"subJsonPath"`
-	SubJsonURI                  string `json:"subJsonURI" form:"subJsonURI"`
-	SubJsonFragment             string `json:"subJsonFragment" form:"subJsonFragment"`
-	SubJsonNoises               string `json:"subJsonNoises" form:"subJsonNoises"`
-	SubJsonMux                  string `json:"subJsonMux" form:"subJsonMux"`
-	SubJsonRules                string `json:"subJsonRules" form:"subJsonRules"`
-	Datepicker                  string `json:"datepicker" form:"datepicker"`
+	WebListen                     string `json:"webListen" form:"webListen"`
+	WebDomain                     string `json:"webDomain" form:"webDomain"`
+	WebPort                       int    `json:"webPort" form:"webPort"`
+	WebCertFile                   string `json:"webCertFile" form:"webCertFile"`
+	WebKeyFile                    string `json:"webKeyFile" form:"webKeyFile"`
+	WebBasePath                   string `json:"webBasePath" form:"webBasePath"`
+	SessionMaxAge                 int    `json:"sessionMaxAge" form:"sessionMaxAge"`
+	PageSize                      int    `json:"pageSize" form:"pageSize"`
+	ExpireDiff                    int    `json:"expireDiff" form:"expireDiff"`
+	TrafficDiff                   int    `json:"trafficDiff" form:"trafficDiff"`
+	RemarkModel                   string `json:"remarkModel" form:"remarkModel"`
+	TgBotEnable                   bool   `json:"tgBotEnable" form:"tgBotEnable"`
+	TgBotToken                    string `json:"tgBotToken" form:"tgBotToken"`
+	TgBotProxy                    string `json:"tgBotProxy" form:"tgBotProxy"`
+	TgBotAPIServer                string `json:"tgBotAPIServer" form:"tgBotAPIServer"`
+	TgBotChatId                   string `json:"tgBotChatId" form:"tgBotChatId"`
+	TgRunTime                     string `json:"tgRunTime" form:"tgRunTime"`
+	TgBotBackup                   bool   `json:"tgBotBackup" form:"tgBotBackup"`
+	TgBotLoginNotify              bool   `json:"tgBotLoginNotify" form:"tgBotLoginNotify"`
+	TgCpu                         int    `json:"tgCpu" form:"tgCpu"`
+	TgLang                        string `json:"tgLang" form:"tgLang"`
+	TimeLocation                  string `json:"timeLocation" form:"timeLocation"`
+	LoginMaxAttempts              int    `json:"loginMaxAttempts" form:"loginMaxAttempts"`
+	LoginLockoutMinutes           int    `json:"loginLockoutMinutes" form:"loginLockoutMinutes"`
+	PanelAllowCIDRs               string `json:"panelAllowCIDRs" form:"panelAllowCIDRs"`
+	PanelDenyCIDRs                string `json:"panelDenyCIDRs" form:"panelDenyCIDRs"`
+	TrustedProxies                string `json:"trustedProxies" form:"trustedProxies"`
+	LoginHistoryRetention         int    `json:"loginHistoryRetention" form:"loginHistoryRetention"`
+	SubEnable                     bool   `json:"subEnable" form:"subEnable"`
+	SubTitle                      string `json:"subTitle" form:"subTitle"`
+	SubListen                     string `json:"subListen" form:"subListen"`
+	SubPort                       int    `json:"subPort" form:"subPort"`
+	SubPath                       string `json:"subPath" form:"subPath"`
+	SubDomain                     string `json:"subDomain" form:"subDomain"`
+	SubCertFile                   string `json:"subCertFile" form:"subCertFile"`
+	SubKeyFile                    string `json:"subKeyFile" form:"subKeyFile"`
+	SubUpdates                    int    `json:"subUpdates" form:"subUpdates"`
+	ExternalTrafficInformEnable   bool   `json:"externalTrafficInformEnable" form:"externalTrafficInformEnable"`
+	ExternalTrafficInformURI      string `json:"externalTrafficInformURI" form:"externalTrafficInformURI"`
+	ExternalTrafficInformAuth     string `json:"externalTrafficInformAuth" form:"externalTrafficInformAuth"`
+	ExternalTrafficInformFormat   string `json:"externalTrafficInformFormat" form:"externalTrafficInformFormat"`
+	ExternalTrafficInformSecret   string `json:"externalTrafficInformSecret" form:"externalTrafficInformSecret"`
+	ExternalTrafficInformCertFile string `json:"externalTrafficInformCertFile" form:"externalTrafficInformCertFile"`
+	ExternalTrafficInformKeyFile  string `json:"externalTrafficInformKeyFile" form:"externalTrafficInformKeyFile"`
+	ExternalTrafficInformCAFile   string `json:"externalTrafficInformCAFile" form:"externalTrafficInformCAFile"`
+	TrafficMinuteRetention        int    `json:"trafficMinuteRetention" form:"trafficMinuteRetention"`
+	TrafficHourRetention          int    `json:"trafficHourRetention" form:"trafficHourRetention"`
+	TrafficDayRetention           int    `json:"trafficDayRetention" form:"trafficDayRetention"`
+	MetricsEnable                 bool   `json:"metricsEnable" form:"metricsEnable"`
+	FirewallBanTime               int    `json:"firewallBanTime" form:"firewallBanTime"`
+	FirewallDryRun                bool   `json:"firewallDryRun" form:"firewallDryRun"`
+	SpeedTiers                    string `json:"speedTiers" form:"speedTiers"`
+	ClientAlertTrafficPercents    string `json:"clientAlertTrafficPercents" form:"clientAlertTrafficPercents"`
+	ClientAlertExpiryDays         string `json:"clientAlertExpiryDays" form:"clientAlertExpiryDays"`
+	SubEncrypt                    bool   `json:"subEncrypt" form:"subEncrypt"`
+	SubShowInfo                   bool   `json:"subShowInfo" form:"subShowInfo"`
+	SubURI                        string `json:"subURI" form:"subURI"`
+	SubJsonPath                   string `json:"subJsonPath" form:"subJsonPath"`
+	SubJsonURI                    string `json:"subJsonURI" form:"subJsonURI"`
+	SubJsonFragment               string `json:"subJsonFragment" form:"subJsonFragment"`
+	SubJsonNoises                 string `json:"subJsonNoises" form:"subJsonNoises"`
+	SubJsonMux                    string `json:"subJsonMux" form:"subJsonMux"`
+	SubJsonRules                  string `json:"subJsonRules" form:"subJsonRules"`
+	SubClashPath                  string `json:"subClashPath" form:"subClashPath"`
+	SubClashURI                   string `json:"subClashURI" form:"subClashURI"`
+	SubClashRules                 string `json:"subClashRules" form:"subClashRules"`
+	SubSingboxPath                string `json:"subSingboxPath" form:"subSingboxPath"`
+	SubSingboxURI                 string `json:"subSingboxURI" form:"subSingboxURI"`
+	Datepicker                    string `json:"datepicker" form:"datepicker"`
 }
 
 func (s *AllSetting) CheckValid() error {
@@ -125,10 +151,99 @@ func (s *AllSetting) CheckValid() error {
 		s.SubJsonPath += "/"
 	}
 
+	if !strings.HasPrefix(s.SubClashPath, "/") {
+		s.SubClashPath = "/" + s.SubClashPath
+	}
+	if !strings.HasSuffix(s.SubClashPath, "/") {
+		s.SubClashPath += "/"
+	}
+
+	if !strings.HasPrefix(s.SubSingboxPath, "/") {
+		s.SubSingboxPath = "/" + s.SubSingboxPath
+	}
+	if !strings.HasSuffix(s.SubSingboxPath, "/") {
+		s.SubSingboxPath += "/"
+	}
+	if err := CheckSubPaths(s.SubPath, s.SubJsonPath, s.SubClashPath, s.SubSingboxPath); err != nil {
+		return err
+	}
+
 	_, err := time.LoadLocation(s.TimeLocation)
 	if err != nil {
 		return common.NewError("time location not exist:", s.TimeLocation)
 	}
 
+	if s.LoginMaxAttempts < 0 || s.LoginLockoutMinutes < 0 {
+		return common.NewError("login attempts and lockout minutes can not be negative")
+	}
+	if _, err := common.ParseCIDRs(s.PanelAllowCIDRs); err != nil {
+		return err
+	}
+	if _, err := common.ParseCIDRs(s.PanelDenyCIDRs); err != nil {
+		return err
+	}
+	if _, err := common.ParseCIDRs(s.TrustedProxies); err != nil {
+		return err
+	}
+	if s.LoginHistoryRetention < 0 {
+		return common.NewError("login history retention is not valid")
+	}
+
+	if s.ExternalTrafficInformFormat != "legacy" && s.ExternalTrafficInformFormat != "batch" {
+		return common.NewError("external traffic format is not valid:", s.ExternalTrafficInformFormat)
+	}
+	switch s.ExternalTrafficInformAuth {
+	case "none", "bearer", "hmac":
+		if s.ExternalTrafficInformAuth != "none" && s.ExternalTrafficInformSecret == "" {
+			return common.NewError("external traffic secret is required for", s.ExternalTrafficInformAuth, "authentication")
+		}
+	default:
+		return common.NewError("external traffic authentication is not valid:", s.ExternalTrafficInformAuth)
+	}
+	if s.ExternalTrafficInformCertFile != "" || s.ExternalTrafficInformKeyFile != "" {
+		_, err := tls.LoadX509KeyPair(s.ExternalTrafficInformCertFile, s.ExternalTrafficInformKeyFile)
+		if err != nil {
+			return common.NewErrorf("cert file <%v> or key file <%v> invalid: %v", s.ExternalTrafficInformCertFile, s.ExternalTrafficInformKeyFile, err)
+		}
+	}
+	if s.ExternalTrafficInformCAFile != "" {
+		if _, err := os.Stat(s.ExternalTrafficInformCAFile); err != nil {
+			return common.NewErrorf("CA file <%v> invalid: %v", s.ExternalTrafficInformCAFile, err)
+		}
+	}
+
+	if s.TrafficMinuteRetention < 1 || s.TrafficHourRetention < 1 || s.TrafficDayRetention < 0 {
+		return common.NewError("traffic history retention is not valid")
+	}
+	if s.FirewallBanTime < 1 {
+		return common.NewError("firewall ban time is not valid")
+	}
+	if tiers, err := common.ParseThresholds(s.SpeedTiers); err != nil {
+		return err
+	} else if len(tiers) > model.MaxSpeedTiers {
+		return common.NewErrorf("at most %d speed tiers are allowed", model.MaxSpeedTiers)
+	}
+	if _, err := common.ParseThresholds(s.ClientAlertTrafficPercents); err != nil {
+		return err
+	}
+	if _, err := common.ParseThresholds(s.ClientAlertExpiryDays); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CheckSubPaths rejects two subscription formats on the same path. Every format is a route
+// of its own on the subscription server and gin panics on duplicate routes.
+func CheckSubPaths(subPath string, jsonPath string, clashPath string, singboxPath string) error {
+	names := map[string]string{}
+	for _, path := range []struct{ name, value string }{
+		{"subPath", subPath}, {"subJsonPath", jsonPath}, {"subClashPath", clashPath}, {"subSingboxPath", singboxPath},
+	} {
+		if other, ok := names[path.value]; ok {
+			return common.NewErrorf("%s and %s can not be the same path: %s", other, path.name, path.value)
+		}
+		names[path.value] = path.name
+	}
 	return nil
 }
@@ -0,0 +1,179 @@
+package job
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"time"
+
+	"x-ui/logger"
+	"x-ui/web/service"
+	"x-ui/xray"
+)
+
+// AccessLogEntry is one accepted connection of a client read from the Xray access log
+type AccessLogEntry struct {
+	Email string
+	IP    string
+	Time  int64 // unix seconds when the line was read
+}
+
+var (
+	accessLogIPRegex    = regexp.MustCompile(`from (?:tcp:|udp:)?\[?([0-9a-fA-F\.:]+)\]?:\d+ accepted`)
+	accessLogEmailRegex = regexp.MustCompile(`email: (\S+)`)
+)
+
+// accessLogRotateInterval is how often the access log is moved to the persistent access log
+const accessLogRotateInterval = time.Hour
+
+// AccessLogJob tails the Xray access log and feeds every new connection once to the
+// client IP log (CheckClientIpJob) and to the device limit (CheckDeviceLimitJob).
+// The read position is saved in the settings, so a restart continues where it stopped.
+type AccessLogJob struct {
+	settingService service.SettingService
+	clientIps      *CheckClientIpJob
+	deviceLimit    *CheckDeviceLimitJob
+
+	loaded      bool
+	offset      int64
+	savedOffset int64
+	lastRotate  int64
+	lastErr     error
+}
+
+func NewAccessLogJob(xrayService *service.XrayService, telegramService service.TelegramService) *AccessLogJob {
+	return &AccessLogJob{
+		clientIps:   NewCheckClientIpJob(),
+		deviceLimit: NewCheckDeviceLimitJob(xrayService, telegramService),
+		lastRotate:  time.Now().Unix(),
+	}
+}
+
+func (j *AccessLogJob) Run() {
+	j.lastErr = nil
+	logPath, err := xray.GetAccessLogPath()
+	if err != nil || logPath == "none" || logPath == "" {
+		return
+	}
+	if !j.loaded {
+		offset, err := j.settingService.GetAccessLogOffset()
+		if err != nil {
+			j.lastErr = err
+			logger.Warning("load access log offset failed:", err)
+			return
+		}
+		j.offset, j.savedOffset = offset, offset
+		j.loaded = true
+	}
+
+	entries, err := j.read(logPath)
+	if err != nil {
+		j.lastErr = err
+		logger.Warning("read access log failed:", err)
+		return
+	}
+	if err := j.clientIps.record(entries); err != nil {
+		j.lastErr = err
+		logger.Warning("save client ips failed:", err)
+	}
+	j.deviceLimit.run(entries)
+	if err := j.deviceLimit.LastError(); err != nil {
+		j.lastErr = err
+	}
+
+	if time.Now().Unix()-j.lastRotate > int64(accessLogRotateInterval/time.Second) {
+		if err := j.rotate(logPath); err != nil {
+			j.lastErr = err
+			logger.Warning("rotate access log failed:", err)
+		}
+	}
+	if j.offset != j.savedOffset {
+		if err := j.settingService.SetAccessLogOffset(j.offset); err != nil {
+			j.lastErr = err
+			logger.Warning("save access log offset failed:", err)
+			return
+		}
+		j.savedOffset = j.offset
+	}
+}
+
+func (j *AccessLogJob) LastError() error {
+	return j.lastErr
+}
+
+// read returns the connections logged since the previous read. An incomplete last
+// line is left for the next read.
+func (j *AccessLogJob) read(logPath string) ([]AccessLogEntry, error) {
+	file, err := os.Open(logPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// The log was truncated by something else, start over
+	if info.Size() < j.offset {
+		j.offset = 0
+	}
+	if _, err = file.Seek(j.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var entries []AccessLogEntry
+	now := time.Now().Unix()
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return entries, err
+		}
+		j.offset += int64(len(line))
+
+		ipMatch := accessLogIPRegex.FindStringSubmatch(line)
+		if len(ipMatch) < 2 || ipMatch[1] == "127.0.0.1" || ipMatch[1] == "::1" {
+			continue
+		}
+		emailMatch := accessLogEmailRegex.FindStringSubmatch(line)
+		if len(emailMatch) < 2 {
+			continue
+		}
+		entries = append(entries, AccessLogEntry{Email: emailMatch[1], IP: ipMatch[1], Time: now})
+	}
+	return entries, nil
+}
+
+// rotate appends the access log to the persistent access log and truncates it,
+// which also starts a new window of the client IP log.
+func (j *AccessLogJob) rotate(logPath string) error {
+	j.lastRotate = time.Now().Unix()
+
+	persistent, err := os.OpenFile(xray.GetAccessPersistentLogPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer persistent.Close()
+	file, err := os.Open(logPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = io.Copy(persistent, file); err != nil {
+		return err
+	}
+	if err = os.Truncate(logPath, 0); err != nil {
+		return err
+	}
+	j.offset = 0
+	j.clientIps.reset()
+	return nil
+}
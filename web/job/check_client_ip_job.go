@@ -1,52 +1,67 @@
 package job
 
 import (
-	"bufio"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt" // 中文注释 (新增): 导入 fmt 包用于格式化消息
 	"log"
 	"os"
 	"os/exec"
-	"regexp"
 	"runtime"
 	"sort"
-	"time"
 	"sync"
-                 "crypto/rand"
-                 "encoding/hex"
-                 "fmt" // 中文注释 (新增): 导入 fmt 包用于格式化消息
+	"time"
 
 	"x-ui/database"
 	"x-ui/database/model"
 	"x-ui/logger"
+	"x-ui/util/firewall"
+	"x-ui/web/service"
 	"x-ui/xray"
-    "x-ui/web/service"
+
+	"gorm.io/gorm/clause"
 )
 
 // =================================================================
 // 中文注释: 以下是用于实现设备限制功能的核心代码
+// 状态在每次运行后写入数据库，面板重启后从数据库恢复，并与 Xray 重新对账
 // =================================================================
 
 // ActiveClientIPs 中文注释: 用于在内存中跟踪每个用户的活跃IP (TTL机制)
-// 结构: map[用户email] -> map[IP地址] -> 最后活跃时间
-var ActiveClientIPs = make(map[string]map[string]time.Time)
+// 结构: map[用户email] -> map[IP地址] -> 出现时间
+var ActiveClientIPs = make(map[string]map[string]*model.DeviceIPSighting)
 var activeClientsLock sync.RWMutex
 
-// ClientStatus 中文注释: 用于跟踪每个用户的状态（是否因为设备超限而被禁用）
-// 结构: map[用户email] -> 是否被禁用(true/false)
-var ClientStatus = make(map[string]bool)
+// ClientStatus 中文注释: 用于跟踪超限的用户，包括宽限期/冷却期的计时和正在执行的处理方式
+// 结构: map[用户email] -> 跟踪状态 (kick 是一次性的，不会记录为处理方式)
+var ClientStatus = make(map[string]*model.DeviceLimitState)
 var clientStatusLock sync.RWMutex
 
+// DeviceFirewall 中文注释: firewall 方式使用的防火墙，第一次封禁时才检测 nftables/iptables 并建表
+var DeviceFirewall = firewall.New(firewall.CommandExecutor{})
+
 // CheckDeviceLimitJob 中文注释: 这是我们的设备限制任务的结构体
 type CheckDeviceLimitJob struct {
-	inboundService service.InboundService
-	xrayService    *service.XrayService
+	deviceLimitService service.DeviceLimitService
+	settingService     service.SettingService
+	xrayService        *service.XrayService
 	// 中文注释: 新增 xrayApi 字段，用于持有 Xray API 客户端实例
 	xrayApi xray.XrayAPI
-	// lastPosition 中文注释: 用于记录上次读取 access.log 的位置，避免重复读取
-	lastPosition int64
-                 // 〔中文注释〕: 注入 Telegram 服务用于发送通知，确保此行存在。
-	telegramService   service.TelegramService
+	// 〔中文注释〕: 注入 Telegram 服务用于发送通知，确保此行存在。
+	telegramService service.TelegramService
+	webhookService  service.WebhookService
+
+	// 中文注释: 以下字段用于持久化与对账
+	loaded      bool                              // 是否已从数据库恢复状态
+	reconciled  bool                              // 是否已与当前的 Xray 进程对账
+	xrayUptime  uint64                            // 上次运行时 Xray 的运行时间，变小说明 Xray 重启过
+	savedStates map[string]model.DeviceLimitState // 数据库中的状态，用于只写入有变化的行
+	expiredIPs  []*model.DeviceIPSighting         // 本次运行中下线、被踢掉或被封禁的IP
+	f2bWarned   bool                              // 是否已提示过未安装 Fail2Ban
+	fwWarned    bool                              // 是否已提示过防火墙不可用
+	lastErr     error
 }
 
 // RandomUUID 中文注释: 新增一个辅助函数，用于生成一个随机的 UUID
@@ -65,115 +80,183 @@ func NewCheckDeviceLimitJob(xrayService *service.XrayService, telegramService se
 		xrayService: xrayService,
 		// 中文注释: 初始化 xrayApi 字段
 		xrayApi: xray.XrayAPI{},
-                                 // 〔中文注释〕: 将传入的 telegramService 赋值给结构体实例。
+		// 〔中文注释〕: 将传入的 telegramService 赋值给结构体实例。
 		telegramService: telegramService,
+		savedStates:     make(map[string]model.DeviceLimitState),
 	}
 }
 
-// Run 中文注释: 定时任务的主函数，每次定时器触发时执行
-func (j *CheckDeviceLimitJob) Run() {
+// run 中文注释: 由 AccessLogJob 在每次读取访问日志后调用，entries 是本次新读到的连接记录
+func (j *CheckDeviceLimitJob) run(entries []AccessLogEntry) {
+	j.lastErr = nil
 	// 中文注释: 检查 xray 是否正在运行，如果xray没运行，则无需执行此任务
 	if !j.xrayService.IsXrayRunning() {
 		return
 	}
 
+	// 中文注释: 第一次运行时从数据库恢复上次的状态和日志读取位置
+	if !j.loaded {
+		if err := j.loadState(); err != nil {
+			j.lastErr = err
+			logger.Warning("恢复设备限制状态失败:", err)
+			return
+		}
+	}
+
+	// 中文注释: 读取所有受设备限制的用户及其策略（入站策略，或客户端单独的覆盖策略）
+	targets, err := j.deviceLimitService.GetTargets()
+	if err != nil {
+		j.lastErr = err
+		logger.Warning("读取设备限制策略失败:", err)
+		return
+	}
+
+	// 中文注释: Xray 启动时使用数据库中的原始用户配置，面板刚启动或 Xray 重启过都需要重新对账
+	uptime := j.xrayService.GetXrayUptime()
+	if uptime < j.xrayUptime {
+		j.reconciled = false
+	}
+	j.xrayUptime = uptime
+
+	runStart := time.Now().Unix()
+
+	// 中文注释: 移除到期的防火墙封禁，并应用设置中的演练模式
+	j.expireFirewallBans()
+
 	// 1. 清理过期的IP
-	j.cleanupExpiredIPs()
+	j.cleanupExpiredIPs(targets)
 
-	// 2. 解析新的日志并更新IP列表
-	j.parseAccessLog()
+	// 2. 用新的连接记录更新IP列表
+	j.addSightings(entries)
 
 	// 3. 检查所有用户的设备限制状态
-	j.checkAllClientsLimit()
+	j.checkAllClientsLimit(targets)
+
+	// 4. 保存本次运行的变化
+	j.saveState(runStart)
 }
 
-// cleanupExpiredIPs 中文注释: 清理长时间不活跃的IP
-func (j *CheckDeviceLimitJob) cleanupExpiredIPs() {
+func (j *CheckDeviceLimitJob) LastError() error {
+	return j.lastErr
+}
+
+// loadState 中文注释: 从数据库恢复活跃IP和跟踪状态
+func (j *CheckDeviceLimitJob) loadState() error {
+	states, sightings, err := j.deviceLimitService.LoadState()
+	if err != nil {
+		return err
+	}
+
 	activeClientsLock.Lock()
-	defer activeClientsLock.Unlock()
+	for _, sighting := range sightings {
+		if _, ok := ActiveClientIPs[sighting.Email]; !ok {
+			ActiveClientIPs[sighting.Email] = make(map[string]*model.DeviceIPSighting)
+		}
+		ActiveClientIPs[sighting.Email][sighting.IP] = sighting
+	}
+	activeClientsLock.Unlock()
 
-	now := time.Now()
-	// 中文注释: 活跃判断窗口(TTL): 近3分钟内出现过就算“活跃”
-	const activeTTL = 3 * time.Minute
-	for email, ips := range ActiveClientIPs {
-		for ip, lastSeen := range ips {
-			// 中文注释: 如果一个IP超过3分钟没有新的连接日志，我们就认为它已经下线
-			if now.Sub(lastSeen) > activeTTL {
-				delete(ActiveClientIPs[email], ip)
+	clientStatusLock.Lock()
+	for _, state := range states {
+		ClientStatus[state.Email] = state
+		j.savedStates[state.Email] = *state
+	}
+	clientStatusLock.Unlock()
+
+	j.loaded = true
+	return nil
+}
+
+// saveState 中文注释: 把本次运行中变化的IP和状态写入数据库
+func (j *CheckDeviceLimitJob) saveState(runStart int64) {
+	changes := &service.DeviceLimitChanges{ExpiredIPs: j.expiredIPs}
+
+	activeClientsLock.RLock()
+	for _, ips := range ActiveClientIPs {
+		for _, sighting := range ips {
+			if sighting.LastSeen >= runStart {
+				seen := *sighting
+				seen.Id = 0
+				changes.Sightings = append(changes.Sightings, &seen)
 			}
 		}
-		// 中文注释: 如果一个用户的所有IP都下线了，就从大Map中移除这个用户，节省内存
-		if len(ActiveClientIPs[email]) == 0 {
-			delete(ActiveClientIPs, email)
-		}
 	}
-}
+	activeClientsLock.RUnlock()
 
-// parseAccessLog 中文注释: 解析 xray access log 来获取最新的用户IP信息
-func (j *CheckDeviceLimitJob) parseAccessLog() {
-	logPath, err := xray.GetAccessLogPath()
-	if err != nil || logPath == "none" || logPath == "" {
-		return
+	current := make(map[string]model.DeviceLimitState)
+	clientStatusLock.RLock()
+	for email, state := range ClientStatus {
+		current[email] = *state
+	}
+	clientStatusLock.RUnlock()
+	for email, state := range current {
+		if saved, ok := j.savedStates[email]; !ok || saved != state {
+			changes.States = append(changes.States, &state)
+		}
+	}
+	for email := range j.savedStates {
+		if _, ok := current[email]; !ok {
+			changes.ClearedStates = append(changes.ClearedStates, email)
+		}
 	}
 
-	file, err := os.Open(logPath)
-	if err != nil {
+	if err := j.deviceLimitService.SaveChanges(changes); err != nil {
+		j.lastErr = err
+		logger.Warning("保存设备限制状态失败:", err)
 		return
 	}
-	defer file.Close()
-
-	// 中文注释: 移动到上次读取结束的位置，实现增量读取
-	file.Seek(j.lastPosition, 0)
-
-	scanner := bufio.NewScanner(file)
-
-	// 中文注释: 使用正则表达式从日志行中提取 email 和 IP
-	emailRegex := regexp.MustCompile(`email: ([^ ]+)`)
-	ipRegex := regexp.MustCompile(`from (?:tcp:|udp:)?\[?([0-9a-fA-F\.:]+)\]?:\d+ accepted`)
+	j.savedStates = current
+	j.expiredIPs = nil
+}
 
+// cleanupExpiredIPs 中文注释: 清理长时间不活跃的IP，活跃判断窗口(TTL)由用户的策略决定
+func (j *CheckDeviceLimitJob) cleanupExpiredIPs(targets map[string]*service.DeviceLimitTarget) {
 	activeClientsLock.Lock()
 	defer activeClientsLock.Unlock()
 
 	now := time.Now()
-	for scanner.Scan() {
-		line := scanner.Text()
-		
-		emailMatch := emailRegex.FindStringSubmatch(line)
-		ipMatch := ipRegex.FindStringSubmatch(line)
-
-		if len(emailMatch) > 1 && len(ipMatch) > 1 {
-			email := emailMatch[1]
-			ip := ipMatch[1]
-
-			if ip == "127.0.0.1" || ip == "::1" {
-				continue
-			}
-
-			if _, ok := ActiveClientIPs[email]; !ok {
-				ActiveClientIPs[email] = make(map[string]time.Time)
+	for email, ips := range ActiveClientIPs {
+		activeTTL := model.DefaultDeviceTTL
+		if target, ok := targets[email]; ok {
+			activeTTL = target.Policy.ActiveTTL()
+		}
+		for ip, sighting := range ips {
+			// 中文注释: 如果一个IP超过 TTL 没有新的连接日志，我们就认为它已经下线
+			if now.Sub(time.Unix(sighting.LastSeen, 0)) > activeTTL {
+				delete(ips, ip)
+				j.expiredIPs = append(j.expiredIPs, sighting)
 			}
-			ActiveClientIPs[email][ip] = now
+		}
+		// 中文注释: 如果一个用户的所有IP都下线了，就从大Map中移除这个用户，节省内存
+		if len(ips) == 0 {
+			delete(ActiveClientIPs, email)
 		}
 	}
+}
+
+// addSightings 中文注释: 把新的连接记录加入活跃IP列表
+func (j *CheckDeviceLimitJob) addSightings(entries []AccessLogEntry) {
+	activeClientsLock.Lock()
+	defer activeClientsLock.Unlock()
 
-	currentPosition, err := file.Seek(0, os.SEEK_END)
-	if err == nil {
-		if currentPosition < j.lastPosition {
-			j.lastPosition = 0
+	for _, entry := range entries {
+		if _, ok := ActiveClientIPs[entry.Email]; !ok {
+			ActiveClientIPs[entry.Email] = make(map[string]*model.DeviceIPSighting)
+		}
+		if sighting, ok := ActiveClientIPs[entry.Email][entry.IP]; ok {
+			sighting.LastSeen = entry.Time
 		} else {
-			j.lastPosition = currentPosition
+			ActiveClientIPs[entry.Email][entry.IP] = &model.DeviceIPSighting{Email: entry.Email, IP: entry.IP, FirstSeen: entry.Time, LastSeen: entry.Time}
 		}
 	}
 }
 
-// checkAllClientsLimit 中文注释: 核心功能，检查所有用户，对超限的执行封禁，对恢复的执行解封
-func (j *CheckDeviceLimitJob) checkAllClientsLimit() {
-	db := database.GetDB()
-	var inbounds []*model.Inbound
-	// 中文注释: 这里仅查询启用了设备限制(device_limit > 0)并且自身是开启状态的入站规则
-	db.Where("device_limit > 0 AND enable = ?", true).Find(&inbounds)
-
-	if len(inbounds) == 0 {
+// checkAllClientsLimit 中文注释: 核心功能，检查所有用户，对持续超限的按策略处理，对恢复的在冷却期后解除
+func (j *CheckDeviceLimitJob) checkAllClientsLimit(targets map[string]*service.DeviceLimitTarget) {
+	clientStatusLock.Lock()
+	defer clientStatusLock.Unlock()
+	if len(targets) == 0 && len(ClientStatus) == 0 {
+		j.reconciled = true
 		return
 	}
 
@@ -186,482 +269,432 @@ func (j *CheckDeviceLimitJob) checkAllClientsLimit() {
 	j.xrayApi.Init(apiPort)
 	defer j.xrayApi.Close()
 
-	// 中文注释: 优化 - 在一次循环中同时获取 tag 和 protocol
-	inboundInfoMap := make(map[int]struct {
-		Limit    int
-		Tag      string
-		Protocol model.Protocol
-	})
-	for _, inbound := range inbounds {
-		inboundInfoMap[inbound.Id] = struct {
-			Limit    int
-			Tag      string
-			Protocol model.Protocol
-		}{Limit: inbound.DeviceLimit, Tag: inbound.Tag, Protocol: inbound.Protocol}
+	// 中文注释: kick 会从活跃列表中移除IP，所以这里需要写锁
+	activeClientsLock.Lock()
+	defer activeClientsLock.Unlock()
+
+	if !j.reconciled {
+		j.reconcile(targets)
+		j.reconciled = true
 	}
 
-	activeClientsLock.RLock()
-	clientStatusLock.Lock()
-	defer activeClientsLock.RUnlock()
-	defer clientStatusLock.Unlock()
+	now := time.Now().Unix()
 
 	// 第一步: 处理当前在线的用户
 	for email, ips := range ActiveClientIPs {
-		traffic, err := j.inboundService.GetClientTrafficByEmail(email)
-		if err != nil || traffic == nil {
-			continue
-		}
-
-		info, ok := inboundInfoMap[traffic.InboundId]
-		if !ok || info.Limit <= 0 {
+		target, ok := targets[email]
+		if !ok || target.Limit <= 0 {
 			continue
 		}
-
-
-		isBanned := ClientStatus[email]
+		state := ClientStatus[email]
 		activeIPCount := len(ips)
 
-                                  // 调用封禁函数
-		if activeIPCount > info.Limit && !isBanned {
-			// 中文注释: 调用封禁函数时，传入当前的IP数用于记录日志
-			j.banUser(email, activeIPCount, &info)
-		}
-
-                                  // 调用解封函数
-		if activeIPCount <= info.Limit && isBanned {
-			// 中文注释: 调用解封函数时，传入当前的IP数用于记录日志
-			j.unbanUser(email, activeIPCount, &info)
+		if activeIPCount > target.Limit {
+			if state == nil {
+				state = &model.DeviceLimitState{Email: email}
+				ClientStatus[email] = state
+			}
+			state.WithinLimitSince = 0
+			if state.Action != "" {
+				continue
+			}
+			// 中文注释: 宽限期内只记录开始超限的时间，持续超限才处理
+			if state.OverLimitSince == 0 {
+				state.OverLimitSince = now
+			}
+			if now-state.OverLimitSince < int64(target.Policy.Grace) {
+				continue
+			}
+			j.enforce(target, ips, state, now)
+		} else if state != nil {
+			state.OverLimitSince = 0
+			if state.Action != "" {
+				j.release(target, activeIPCount, state, now)
+			}
 		}
 	}
 
-	// 第二步: 专门处理那些“已被封禁”但“已不在线”的用户，为他们解封
-	for email, isBanned := range ClientStatus {
-		if !isBanned {
+	// 第二步: 专门处理那些“已被处理”但“已不在线”的用户，以及入站已取消设备限制的用户
+	for email, state := range ClientStatus {
+		target, limited := targets[email]
+		if _, online := ActiveClientIPs[email]; online && limited {
+			continue
+		}
+		// 中文注释: 已下线的用户重新计算宽限期
+		state.OverLimitSince = 0
+		if state.Action == "" {
 			continue
 		}
-		if _, online := ActiveClientIPs[email]; !online {
-			traffic, err := j.inboundService.GetClientTrafficByEmail(email)
-			if err != nil || traffic == nil {
+		if !limited {
+			var err error
+			target, err = j.deviceLimitService.GetTarget(email)
+			if err != nil {
+				// 中文注释: 用户已被删除，无需恢复
+				delete(ClientStatus, email)
 				continue
 			}
-			info, ok := inboundInfoMap[traffic.InboundId]
-			if !ok {
-				continue
+			// 中文注释: 不再受限的用户立即恢复，且无论结果如何都不再跟踪
+			target.Policy.Cooldown = 0
+			if !j.release(target, 0, state, now) {
+				delete(ClientStatus, email)
 			}
-			logger.Infof("已封禁用户 %s 已完全下线，执行解封操作。", email)
+			continue
+		}
+		j.release(target, 0, state, now)
+	}
 
-			// 调用解封函数，这种情况下：活跃IP数为0，我们直接传入0用于记录日志
-			j.unbanUser(email, 0, &info)
+	// 第三步: 清理不再需要跟踪的用户
+	for email, state := range ClientStatus {
+		if state.Action == "" && state.OverLimitSince == 0 {
+			delete(ClientStatus, email)
 		}
 	}
 }
 
-// banUser 中文注释: 封装的封禁用户函数；IP数量超限，且用户当前未被封禁 -> 执行封禁 (UUID 替换)
-func (j *CheckDeviceLimitJob) banUser(email string, activeIPCount int, info *struct {
-	Limit    int
-	Tag      string
-	Protocol model.Protocol
-}) {
-    // =================================================================
-    // 这一行代码是整个解封逻辑的灵魂！
-    // GetClientByEmail 函数会去查询您的数据库 (x-ui.db)，
-    // 找到 `inbounds` 表，解析其中的 `settings` 字段，并从中去，
-    // 读取出您最初设置的、最原始、最正确的用户信息（包括最原始的UUID），
-    // 然后把它赋值给 `client` 这个变量；此时，`client` 变量就持有了那个“老链接”的正确原始 UUID。
-    // =================================================================
-	_, client, err := j.inboundService.GetClientByEmail(email)
-	if err != nil || client == nil {
-		return
-	}
-	logger.Infof("〔设备限制〕超限：用户 %s. 限制: %d, 当前活跃: %d. 执行封禁掐网。", email, info.Limit, activeIPCount)
-	
-	// 〔中文注释〕: 以下是发送 Telegram 通知的核心代码，
-	// 它会调用我们注入的 telegramService 的 SendMessage 方法。
-	go func() {
-		// 〔中文注释〕: 在调用前，先判断服务实例是否为 nil，增加代码健壮性。
-		if j.telegramService == nil {
-			return
+// reconcile 中文注释: 让 Xray 中的用户与记录的处理状态一致。仍然超限的用户重新执行处理，
+// 其余的恢复原始凭据和限速等级。调用方需持有两把锁。
+func (j *CheckDeviceLimitJob) reconcile(targets map[string]*service.DeviceLimitTarget) {
+	for email, state := range ClientStatus {
+		if state.Action != model.DeviceLimitBlock && state.Action != model.DeviceLimitThrottle {
+			continue
 		}
-		tgMessage := fmt.Sprintf(
-			"<b>〔X-Panel面板〕设备超限提醒</b>\n\n"+
-				"  ------------------------------------\n"+
-				"  👤 用户 Email：%s\n"+
-				"  🖥️ 设备限制数量：%d\n"+
-				"  🌐 当前在线IP数：%d\n"+
-				"  ------------------------------------\n\n"+
-				"<b><i>⚠ 该用户已被自动掐网封禁！</i></b>",
-			email, info.Limit, activeIPCount,
-		)
-		// 〔中文注释〕: 调用接口方法发送消息。
-		err := j.telegramService.SendMessage(tgMessage)
-		if err != nil {
-			logger.Warningf("发送 Telegram 封禁通知失败: %v", err)
+		target, limited := targets[email]
+		if limited && len(ActiveClientIPs[email]) > target.Limit {
+			if err := j.apply(target, state.Action); err != nil {
+				j.lastErr = err
+				logger.Warningf("〔设备限制〕对账时重新处理用户 %s 失败: %v", email, err)
+			}
+			continue
 		}
-	}()
-
-
-	// 中文注释: 步骤一：先从 Xray-Core 中删除该用户。
-	j.xrayApi.RemoveUser(info.Tag, email)
-    
-    // =================================================================
-	// 中文注释: 增加 5000 毫秒延时，解决竞态条件问题
-	time.Sleep(5000 * time.Millisecond)
-    // =================================================================
-
-	// 中文注释: 创建一个带有随机UUID/Password的临时客户端配置用于“封禁”
-	tempClient := *client
-
-                 // 适用于 VMess/VLESS
-	if tempClient.ID != "" { tempClient.ID = RandomUUID() }
-
-                 // 适用于 Trojan/Shadowsocks/Socks
-	if tempClient.Password != "" { tempClient.Password = RandomUUID() }
-
-	var clientMap map[string]interface{}
-	clientJson, _ := json.Marshal(tempClient)
-	json.Unmarshal(clientJson, &clientMap)
-
-                 // 中文注释: 步骤二：将这个带有错误UUID/Password的临时用户添加回去。
-                 // 客户端持有的还是旧的UUID，自然就无法通过验证，从而达到了“封禁”的效果。
-	err = j.xrayApi.AddUser(string(info.Protocol), info.Tag, clientMap)
-	if err != nil {
-		logger.Warningf("通过API封禁用户 %s 失败: %v", email, err)
-	} else {
-	                 // 中文注释: 封禁成功后，在内存中标记该用户为“已封禁”状态。
-		ClientStatus[email] = true
+		if !limited {
+			var err error
+			if target, err = j.deviceLimitService.GetTarget(email); err != nil {
+				delete(ClientStatus, email)
+				continue
+			}
+		}
+		if err := j.restore(target); err != nil {
+			j.lastErr = err
+			logger.Warningf("〔设备限制〕对账时恢复用户 %s 失败: %v", email, err)
+			continue
+		}
+		delete(ClientStatus, email)
+		j.released(target, len(ActiveClientIPs[email]), state.Action)
 	}
 }
 
-// unbanUser 中文注释: 封装的解封用户函数；IP数量已恢复正常，但用户处于封禁状态 -> 执行解封 (恢复原始 UUID)
-func (j *CheckDeviceLimitJob) unbanUser(email string, activeIPCount int, info *struct {
-	Limit    int
-	Tag      string
-	Protocol model.Protocol
-}) {
-	_, client, err := j.inboundService.GetClientByEmail(email)
-	if err != nil || client == nil {
+// enforce 中文注释: 按策略处理一个超限的用户
+func (j *CheckDeviceLimitJob) enforce(target *service.DeviceLimitTarget, ips map[string]*model.DeviceIPSighting, state *model.DeviceLimitState, now int64) {
+	email := target.Email
+	activeIPCount := len(ips)
+	if target.Backend == model.LimitBackendFail2Ban || target.Backend == model.LimitBackendFirewall {
+		j.ban(target, ips)
+		state.OverLimitSince = 0
 		return
 	}
-	logger.Infof("〔设备数量〕已恢复：用户 %s. 限制: %d, 当前活跃: %d. 执行解封/恢复用户。", email, info.Limit, activeIPCount)	
-
-                 // 中文注释: 步骤一：先从 Xray-Core 中删除用于“封禁”的那个临时用户。
-	j.xrayApi.RemoveUser(info.Tag, email)
-    
-    // =================================================================
-	// 中文注释: 同样增加 5000 毫秒延时，确保解封操作的稳定性
-	time.Sleep(5000 * time.Millisecond)
-    // =================================================================
+	action := target.Policy.GetAction()
 
-	var clientMap map[string]interface{}
-	clientJson, _ := json.Marshal(client)
-	json.Unmarshal(clientJson, &clientMap)
-
-                 // 中文注释: 步骤二：将数据库中原始的、正确的用户信息重新添加回 Xray-Core，从而实现“解封”。
-	err = j.xrayApi.AddUser(string(info.Protocol), info.Tag, clientMap)
-	if err != nil {
-		logger.Warningf("通过API恢复用户 %s 失败: %v", email, err)
-	} else {
-                                  // 中文注释: 解封成功后，从内存中移除该用户的“已封禁”状态标记。
-		delete(ClientStatus, email)
+	if err := j.apply(target, action); err != nil {
+		j.lastErr = err
+		logger.Warningf("〔设备限制〕通过API处理用户 %s 失败: %v", email, err)
+		return
 	}
+	state.OverLimitSince = 0
+	if action == model.DeviceLimitKick {
+		// 中文注释: 忘记最新出现的IP，最早的设备重连后占回名额，新设备若继续连接会在宽限期后再次被踢
+		j.forgetNewestIPs(ips, activeIPCount-target.Limit)
+	} else {
+		// 中文注释: 处理成功后，记录该用户正在执行的处理方式
+		state.Action = action
+		state.ActedAt = now
+	}
+	logger.Infof("〔设备限制〕超限：用户 %s. 限制: %d, 当前活跃: %d. 执行处理: %s。", email, target.Limit, activeIPCount, action)
+	j.notify(target, activeIPCount, action)
+	j.webhookService.Emit(nil, model.WebhookEventDeviceBanned, map[string]any{
+		"email": email, "limit": target.Limit, "activeIps": activeIPCount, "action": action,
+	})
 }
 
-type CheckClientIpJob struct {
-	lastClear     int64
-	disAllowedIps []string
-}
-
-var job *CheckClientIpJob
-
-func NewCheckClientIpJob() *CheckClientIpJob {
-	job = new(CheckClientIpJob)
-	return job
-}
-
-func (j *CheckClientIpJob) Run() {
-	if j.lastClear == 0 {
-		j.lastClear = time.Now().Unix()
-	}
-
-	shouldClearAccessLog := false
-	iplimitActive := j.hasLimitIp()
-	f2bInstalled := j.checkFail2BanInstalled()
-	isAccessLogAvailable := j.checkAccessLogAvailable(iplimitActive)
-
-	if isAccessLogAvailable {
-		if runtime.GOOS == "windows" {
-			if iplimitActive {
-				shouldClearAccessLog = j.processLogFile()
-			}
-		} else {
-			if iplimitActive {
-				if f2bInstalled {
-					shouldClearAccessLog = j.processLogFile()
-				} else {
-					if !f2bInstalled {
-						logger.Warning("[LimitIP] Fail2Ban is not installed, Please install Fail2Ban from the x-ui bash menu.")
-					}
-				}
-			}
-		}
+// ban 中文注释: 封禁超出限制的最新IP。Fail2Ban 方式把它们写入 IP 限制日志，由 Fail2Ban 用 iptables 封禁；
+// firewall 方式由面板自己在入站端口上封禁。这些IP同时从活跃列表中移除，封禁期间没有新的连接记录，
+// 解封后若继续超限会再次被封禁。
+func (j *CheckDeviceLimitJob) ban(target *service.DeviceLimitTarget, ips map[string]*model.DeviceIPSighting) {
+	activeIPCount := len(ips)
+	var err error
+	if target.Backend == model.LimitBackendFirewall {
+		err = j.firewallBan(target, ips)
+	} else {
+		err = j.fail2BanBan(target, ips)
 	}
-
-	if shouldClearAccessLog || (isAccessLogAvailable && time.Now().Unix()-j.lastClear > 3600) {
-		j.clearAccessLog()
+	if err != nil {
+		j.lastErr = err
+		return
 	}
-}
-
-func (j *CheckClientIpJob) clearAccessLog() {
-	logAccessP, err := os.OpenFile(xray.GetAccessPersistentLogPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
-	j.checkError(err)
-	defer logAccessP.Close()
 
-	accessLogPath, err := xray.GetAccessLogPath()
-	j.checkError(err)
-
-	file, err := os.Open(accessLogPath)
-	j.checkError(err)
-	defer file.Close()
-
-	_, err = io.Copy(logAccessP, file)
-	j.checkError(err)
-
-	err = os.Truncate(accessLogPath, 0)
-	j.checkError(err)
-
-	j.lastClear = time.Now().Unix()
+	logger.Infof("〔设备限制〕超限：用户 %s. 限制: %d, 当前活跃: %d. 使用 %s 封禁最新的IP。", target.Email, target.Limit, activeIPCount, target.Backend)
+	j.notify(target, activeIPCount, "")
+	j.webhookService.Emit(nil, model.WebhookEventDeviceBanned, map[string]any{
+		"email": target.Email, "limit": target.Limit, "activeIps": activeIPCount, "action": target.Backend,
+	})
 }
 
-func (j *CheckClientIpJob) hasLimitIp() bool {
-	db := database.GetDB()
-	var inbounds []*model.Inbound
+// fail2BanBan 中文注释: 把最新的IP写入 IP 限制日志，交给 Fail2Ban
+func (j *CheckDeviceLimitJob) fail2BanBan(target *service.DeviceLimitTarget, ips map[string]*model.DeviceIPSighting) error {
+	if runtime.GOOS != "windows" && !j.f2bWarned && !fail2BanInstalled() {
+		logger.Warning("[LimitIP] Fail2Ban is not installed, Please install Fail2Ban from the x-ui bash menu.")
+		j.f2bWarned = true
+	}
 
-	err := db.Model(model.Inbound{}).Find(&inbounds).Error
+	logIpFile, err := os.OpenFile(xray.GetIPLimitLogPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
-		return false
+		logger.Errorf("failed to open IP limit log file: %s", err)
+		return err
 	}
-
-	for _, inbound := range inbounds {
-		if inbound.Settings == "" {
-			continue
-		}
-
-		settings := map[string][]model.Client{}
-		json.Unmarshal([]byte(inbound.Settings), &settings)
-		clients := settings["clients"]
-
-		for _, client := range clients {
-			limitIp := client.LimitIP
-			if limitIp > 0 {
-				return true
-			}
-		}
+	defer logIpFile.Close()
+	ipLog := log.New(logIpFile, "", log.LstdFlags)
+	for _, sighting := range j.forgetNewestIPs(ips, len(ips)-target.Limit) {
+		ipLog.Printf("[LIMIT_IP] Email = %s || SRC = %s", target.Email, sighting.IP)
 	}
-
-	return false
+	return nil
 }
 
-func (j *CheckClientIpJob) processLogFile() bool {
-
-	ipRegex := regexp.MustCompile(`from (?:tcp:|udp:)?\[?([0-9a-fA-F\.:]+)\]?:\d+ accepted`)
-	emailRegex := regexp.MustCompile(`email: (.+)$`)
-
-	accessLogPath, _ := xray.GetAccessLogPath()
-	file, _ := os.Open(accessLogPath)
-	defer file.Close()
-
-	inboundClientIps := make(map[string]map[string]struct{}, 100)
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		ipMatches := ipRegex.FindStringSubmatch(line)
-		if len(ipMatches) < 2 {
-			continue
-		}
-
-		ip := ipMatches[1]
-
-		if ip == "127.0.0.1" || ip == "::1" {
-			continue
+// firewallBan 中文注释: 用面板自己的防火墙在入站端口上封禁最新的IP，时长取自设置 firewallBanTime
+func (j *CheckDeviceLimitJob) firewallBan(target *service.DeviceLimitTarget, ips map[string]*model.DeviceIPSighting) error {
+	if err := DeviceFirewall.Init(); err != nil {
+		if !j.fwWarned {
+			logger.Warning("〔设备限制〕防火墙不可用，无法封禁超限的IP:", err)
+			j.fwWarned = true
 		}
+		return err
+	}
+	j.fwWarned = false
+	minutes, err := j.settingService.GetFirewallBanTime()
+	if err != nil || minutes < 1 {
+		minutes = 30
+	}
 
-		emailMatches := emailRegex.FindStringSubmatch(line)
-		if len(emailMatches) < 2 {
-			continue
-		}
-		email := emailMatches[1]
-
-		if _, exists := inboundClientIps[email]; !exists {
-			inboundClientIps[email] = make(map[string]struct{})
+	var errs []error
+	for _, sighting := range j.forgetNewestIPs(ips, len(ips)-target.Limit) {
+		if err := DeviceFirewall.Ban(sighting.IP, target.Port, time.Duration(minutes)*time.Minute); err != nil {
+			logger.Warningf("〔设备限制〕封禁用户 %s 的IP %s 失败: %v", target.Email, sighting.IP, err)
+			errs = append(errs, err)
 		}
-		inboundClientIps[email][ip] = struct{}{}
 	}
+	return errors.Join(errs...)
+}
 
-	shouldCleanLog := false
-	for email, uniqueIps := range inboundClientIps {
+// expireFirewallBans 中文注释: 应用演练模式的设置，并移除到期的防火墙封禁 (iptables 需要手动删除规则)
+func (j *CheckDeviceLimitJob) expireFirewallBans() {
+	if dryRun, err := j.settingService.GetFirewallDryRun(); err == nil {
+		DeviceFirewall.SetDryRun(dryRun)
+	}
+	if err := DeviceFirewall.Expire(time.Now()); err != nil {
+		j.lastErr = err
+		logger.Warning("〔设备限制〕移除到期的防火墙封禁失败:", err)
+	}
+}
 
-		ips := make([]string, 0, len(uniqueIps))
-		for ip := range uniqueIps {
-			ips = append(ips, ip)
+// apply 中文注释: 通过 Xray API 执行处理方式，notify 不需要改动 Xray
+func (j *CheckDeviceLimitJob) apply(target *service.DeviceLimitTarget, action model.DeviceLimitAction) error {
+	client := target.Client
+	switch action {
+	case model.DeviceLimitBlock:
+		// 中文注释: 用随机的 UUID/Password 替换，客户端持有的还是旧的凭据，自然就无法通过验证，从而达到“封禁”的效果
+		id, password := client.ID, client.Password
+		if id != "" {
+			id = RandomUUID() // 适用于 VMess/VLESS
 		}
-		sort.Strings(ips)
-
-		clientIpsRecord, err := j.getInboundClientIps(email)
-		if err != nil {
-			j.addInboundClientIps(email, ips)
-			continue
+		if password != "" {
+			password = RandomUUID() // 适用于 Trojan/Shadowsocks
 		}
-
-		shouldCleanLog = j.updateInboundClientIps(clientIpsRecord, email, ips) || shouldCleanLog
+		return j.replaceUser(target, target.XrayUser(id, password, clientLevel(target)))
+	case model.DeviceLimitThrottle:
+		return j.replaceUser(target, target.XrayUser(client.ID, client.Password, target.Policy.ThrottleSpeed))
+	case model.DeviceLimitKick:
+		// 中文注释: 重新添加用户会断开它的所有连接
+		return j.replaceUser(target, target.XrayUser(client.ID, client.Password, clientLevel(target)))
 	}
-
-	return shouldCleanLog
+	return nil
 }
 
-func (j *CheckClientIpJob) checkFail2BanInstalled() bool {
-	cmd := "fail2ban-client"
-	args := []string{"-h"}
-	err := exec.Command(cmd, args...).Run()
-	return err == nil
+// restore 中文注释: 将数据库中原始的、正确的用户信息重新添加回 Xray-Core，从而实现“解封”
+func (j *CheckDeviceLimitJob) restore(target *service.DeviceLimitTarget) error {
+	client := target.Client
+	return j.replaceUser(target, target.XrayUser(client.ID, client.Password, clientLevel(target)))
 }
 
-func (j *CheckClientIpJob) checkAccessLogAvailable(iplimitActive bool) bool {
-	accessLogPath, err := xray.GetAccessLogPath()
-	if err != nil {
+// release 中文注释: 冷却期结束后解除对用户的处理 (恢复原始 UUID 和限速等级)，返回是否已解除
+func (j *CheckDeviceLimitJob) release(target *service.DeviceLimitTarget, activeIPCount int, state *model.DeviceLimitState, now int64) bool {
+	if state.WithinLimitSince == 0 {
+		state.WithinLimitSince = now
+	}
+	if now-state.WithinLimitSince < int64(target.Policy.Cooldown) {
 		return false
 	}
 
-	if accessLogPath == "none" || accessLogPath == "" {
-		if iplimitActive {
-			logger.Warning("[LimitIP] Access log path is not set, Please configure the access log path in Xray configs.")
+	if state.Action == model.DeviceLimitBlock || state.Action == model.DeviceLimitThrottle {
+		if err := j.restore(target); err != nil {
+			j.lastErr = err
+			logger.Warningf("通过API恢复用户 %s 失败: %v", target.Email, err)
+			return false
 		}
-		return false
 	}
 
+	// 中文注释: 解除成功后，移除该用户的处理状态
+	delete(ClientStatus, target.Email)
+	j.released(target, activeIPCount, state.Action)
 	return true
 }
 
-func (j *CheckClientIpJob) checkError(e error) {
-	if e != nil {
-		logger.Warning("client ip job err:", e)
-	}
+// released 中文注释: 记录日志并发送解除处理的 Webhook
+func (j *CheckDeviceLimitJob) released(target *service.DeviceLimitTarget, activeIPCount int, action model.DeviceLimitAction) {
+	logger.Infof("〔设备数量〕已恢复：用户 %s. 限制: %d, 当前活跃: %d. 解除处理: %s。", target.Email, target.Limit, activeIPCount, action)
+	j.webhookService.Emit(nil, model.WebhookEventDeviceUnbanned, map[string]any{
+		"email": target.Email, "limit": target.Limit, "activeIps": activeIPCount, "action": action,
+	})
 }
 
-func (j *CheckClientIpJob) getInboundClientIps(clientEmail string) (*model.InboundClientIps, error) {
-	db := database.GetDB()
-	InboundClientIps := &model.InboundClientIps{}
-	err := db.Model(model.InboundClientIps{}).Where("client_email = ?", clientEmail).First(InboundClientIps).Error
-	if err != nil {
-		return nil, err
-	}
-	return InboundClientIps, nil
+// replaceUser 中文注释: 先从 Xray-Core 中删除用户，再以新的凭据/等级添加回去
+func (j *CheckDeviceLimitJob) replaceUser(target *service.DeviceLimitTarget, user map[string]any) error {
+	return replaceXrayUser(&j.xrayApi, target.Protocol, target.Tag, target.Email, user)
 }
 
-func (j *CheckClientIpJob) addInboundClientIps(clientEmail string, ips []string) error {
-	inboundClientIps := &model.InboundClientIps{}
-	jsonIps, err := json.Marshal(ips)
-	j.checkError(err)
-
-	inboundClientIps.ClientEmail = clientEmail
-	inboundClientIps.Ips = string(jsonIps)
-
-	db := database.GetDB()
-	tx := db.Begin()
-
-	defer func() {
-		if err == nil {
-			tx.Commit()
-		} else {
-			tx.Rollback()
-		}
-	}()
-
-	err = tx.Save(inboundClientIps).Error
-	if err != nil {
-		return err
+// clientLevel 中文注释: 用户的限速等级，自动限速规则正在生效时使用规则的等级
+func clientLevel(target *service.DeviceLimitTarget) int {
+	if level, ok := speedRuleLevel(target.Email); ok {
+		return level
 	}
-	return nil
+	level, _ := service.SpeedLevel(target.Client.SpeedProfile())
+	return level
 }
 
-func (j *CheckClientIpJob) updateInboundClientIps(inboundClientIps *model.InboundClientIps, clientEmail string, ips []string) bool {
-	jsonIps, err := json.Marshal(ips)
-	if err != nil {
-		logger.Error("failed to marshal IPs to JSON:", err)
-		return false
+// forgetNewestIPs 中文注释: 从活跃列表中移除最新出现的 count 个IP，并返回它们
+func (j *CheckDeviceLimitJob) forgetNewestIPs(ips map[string]*model.DeviceIPSighting, count int) []*model.DeviceIPSighting {
+	newest := make([]*model.DeviceIPSighting, 0, len(ips))
+	for _, sighting := range ips {
+		newest = append(newest, sighting)
 	}
-
-	inboundClientIps.ClientEmail = clientEmail
-	inboundClientIps.Ips = string(jsonIps)
-
-	inbound, err := j.getInboundByEmail(clientEmail)
-	if err != nil {
-		logger.Errorf("failed to fetch inbound settings for email %s: %s", clientEmail, err)
-		return false
+	sort.Slice(newest, func(a, b int) bool { return newest[a].FirstSeen > newest[b].FirstSeen })
+	newest = newest[:min(count, len(newest))]
+	for _, sighting := range newest {
+		delete(ips, sighting.IP)
+		j.expiredIPs = append(j.expiredIPs, sighting)
 	}
+	return newest
+}
 
-	if inbound.Settings == "" {
-		logger.Debug("wrong data:", inbound)
-		return false
+// notify 中文注释: 发送 Telegram 超限通知
+func (j *CheckDeviceLimitJob) notify(target *service.DeviceLimitTarget, activeIPCount int, action model.DeviceLimitAction) {
+	// 〔中文注释〕: 在调用前，先判断服务实例是否为 nil，增加代码健壮性。
+	if j.telegramService == nil {
+		return
 	}
+	var result string
+	switch action {
+	case model.DeviceLimitBlock:
+		result = "⚠ 该用户已被自动掐网封禁！"
+	case model.DeviceLimitKick:
+		result = "⚠ 已断开该用户最新设备的连接！"
+	case model.DeviceLimitThrottle:
+		result = fmt.Sprintf("⚠ 该用户已被限速至 %d KB/s！", target.Policy.ThrottleSpeed)
+	default:
+		result = "ℹ 仅通知，未做任何限制。"
+	}
+	// 中文注释: Fail2Ban 和防火墙只封禁最新设备的IP，不使用处理方式
+	switch target.Backend {
+	case model.LimitBackendFail2Ban:
+		result = "⚠ 已交给 Fail2Ban 封禁该用户最新设备的IP！"
+	case model.LimitBackendFirewall:
+		result = fmt.Sprintf("⚠ 已用防火墙在端口 %d 上封禁该用户最新设备的IP！", target.Port)
+	}
+	tgMessage := fmt.Sprintf(
+		"<b>〔X-Panel面板〕设备超限提醒</b>\n\n"+
+			"  ------------------------------------\n"+
+			"  👤 用户 Email：%s\n"+
+			"  🖥️ 设备限制数量：%d\n"+
+			"  🌐 当前在线IP数：%d\n"+
+			"  ------------------------------------\n\n"+
+			"<b><i>%s</i></b>",
+		target.Email, target.Limit, activeIPCount, result,
+	)
+	go func() {
+		// 〔中文注释〕: 调用接口方法发送消息。
+		if err := j.telegramService.SendMessage(tgMessage); err != nil {
+			logger.Warningf("发送 Telegram 设备超限通知失败: %v", err)
+		}
+	}()
+}
 
-	settings := map[string][]model.Client{}
-	json.Unmarshal([]byte(inbound.Settings), &settings)
-	clients := settings["clients"]
-	shouldCleanLog := false
-	j.disAllowedIps = []string{}
+// CheckClientIpJob records the IPs each client connected from since the access log was
+// last rotated, which the panel shows as the client's IP log. The IP limit itself is
+// enforced by CheckDeviceLimitJob with the backend chosen for the inbound.
+type CheckClientIpJob struct {
+	loaded    bool
+	windowIPs map[string]map[string]struct{}
+}
 
-	logIpFile, err := os.OpenFile(xray.GetIPLimitLogPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		logger.Errorf("failed to open IP limit log file: %s", err)
-		return false
-	}
-	defer logIpFile.Close()
-	log.SetOutput(logIpFile)
-	log.SetFlags(log.LstdFlags)
-
-	for _, client := range clients {
-		if client.Email == clientEmail {
-			limitIp := client.LimitIP
-
-			if limitIp > 0 && inbound.Enable {
-				shouldCleanLog = true
-
-				if limitIp < len(ips) {
-					j.disAllowedIps = append(j.disAllowedIps, ips[limitIp:]...)
-					for i := limitIp; i < len(ips); i++ {
-						log.Printf("[LIMIT_IP] Email = %s || SRC = %s", clientEmail, ips[i])
-					}
-				}
+func NewCheckClientIpJob() *CheckClientIpJob {
+	return &CheckClientIpJob{windowIPs: make(map[string]map[string]struct{})}
+}
+
+// record adds the IPs of new access log entries and saves the clients whose IP list changed
+func (j *CheckClientIpJob) record(entries []AccessLogEntry) error {
+	db := database.GetDB()
+	if !j.loaded {
+		// The log was not rotated since the lists were saved, so they are still the current window
+		var rows []*model.InboundClientIps
+		if err := db.Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			var ips []string
+			json.Unmarshal([]byte(row.Ips), &ips)
+			j.windowIPs[row.ClientEmail] = make(map[string]struct{}, len(ips))
+			for _, ip := range ips {
+				j.windowIPs[row.ClientEmail][ip] = struct{}{}
 			}
 		}
+		j.loaded = true
 	}
 
-	sort.Strings(j.disAllowedIps)
-
-	if len(j.disAllowedIps) > 0 {
-		logger.Debug("disAllowedIps:", j.disAllowedIps)
+	changed := make(map[string]bool)
+	for _, entry := range entries {
+		if _, ok := j.windowIPs[entry.Email]; !ok {
+			j.windowIPs[entry.Email] = make(map[string]struct{})
+		}
+		if _, ok := j.windowIPs[entry.Email][entry.IP]; !ok {
+			j.windowIPs[entry.Email][entry.IP] = struct{}{}
+			changed[entry.Email] = true
+		}
 	}
 
-	db := database.GetDB()
-	err = db.Save(inboundClientIps).Error
-	if err != nil {
-		logger.Error("failed to save inboundClientIps:", err)
-		return false
+	for email := range changed {
+		ips := make([]string, 0, len(j.windowIPs[email]))
+		for ip := range j.windowIPs[email] {
+			ips = append(ips, ip)
+		}
+		sort.Strings(ips)
+		jsonIps, err := json.Marshal(ips)
+		if err != nil {
+			return err
+		}
+		err = db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "client_email"}},
+			DoUpdates: clause.AssignmentColumns([]string{"ips"}),
+		}).Create(&model.InboundClientIps{ClientEmail: email, Ips: string(jsonIps)}).Error
+		if err != nil {
+			return err
+		}
 	}
-
-	return shouldCleanLog
+	return nil
 }
 
-func (j *CheckClientIpJob) getInboundByEmail(clientEmail string) (*model.Inbound, error) {
-	db := database.GetDB()
-	inbound := &model.Inbound{}
-
-	err := db.Model(&model.Inbound{}).Where("settings LIKE ?", "%"+clientEmail+"%").First(inbound).Error
-	if err != nil {
-		return nil, err
-	}
+// reset starts a new window after the access log was rotated. The saved lists are
+// kept until the client connects again.
+func (j *CheckClientIpJob) reset() {
+	j.windowIPs = make(map[string]map[string]struct{})
+}
 
-	return inbound, nil
+func fail2BanInstalled() bool {
+	return exec.Command("fail2ban-client", "-h").Run() == nil
 }
@@ -1,14 +1,18 @@
 package job
 
 import (
+	"x-ui/database/model"
 	"x-ui/logger"
 	"x-ui/web/service"
 )
 
 type CheckXrayRunningJob struct {
-	xrayService service.XrayService
+	xrayService    service.XrayService
+	webhookService service.WebhookService
 
 	checkTime int
+	// crashed is set once the crash was reported, so a failing restart is not reported every time
+	crashed bool
 }
 
 func NewCheckXrayRunningJob() *CheckXrayRunningJob {
@@ -18,10 +22,19 @@ func NewCheckXrayRunningJob() *CheckXrayRunningJob {
 func (j *CheckXrayRunningJob) Run() {
 	if !j.xrayService.DidXrayCrash() {
 		j.checkTime = 0
+		// Xray is either running again or was stopped by hand meanwhile
+		if j.crashed && j.xrayService.IsXrayRunning() {
+			j.webhookService.Emit(nil, model.WebhookEventXrayRestarted, map[string]any{"reason": "crash"})
+		}
+		j.crashed = false
 	} else {
 		j.checkTime++
 		// only restart if it's down 2 times in a row
 		if j.checkTime > 1 {
+			if !j.crashed {
+				j.crashed = true
+				j.webhookService.Emit(nil, model.WebhookEventXrayCrashed, map[string]any{"result": j.xrayService.GetXrayResult()})
+			}
 			err := j.xrayService.RestartXray(false)
 			j.checkTime = 0
 			if err != nil {
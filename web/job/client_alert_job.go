@@ -0,0 +1,28 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+// ClientAlertJob sends the per-client quota and expiry alerts.
+type ClientAlertJob struct {
+	clientAlertService service.ClientAlertService
+	lastErr            error
+}
+
+func NewClientAlertJob() *ClientAlertJob {
+	return new(ClientAlertJob)
+}
+
+func (j *ClientAlertJob) Run() {
+	j.lastErr = j.clientAlertService.CheckAlerts()
+	if j.lastErr != nil {
+		logger.Warning("check client alerts failed:", j.lastErr)
+	}
+}
+
+// LastError returns the error of the last run, if any
+func (j *ClientAlertJob) LastError() error {
+	return j.lastErr
+}
@@ -0,0 +1,113 @@
+package job
+
+import (
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"x-ui/database/model"
+	"x-ui/logger"
+
+	"github.com/robfig/cron/v3"
+)
+
+// JobStats describes the runs of one scheduled job, for the metrics endpoint.
+type JobStats struct {
+	Name          string
+	Runs          uint64
+	Errors        uint64
+	LastRun       time.Time
+	LastDuration  time.Duration
+	TotalDuration time.Duration
+}
+
+// errorJob is implemented by jobs that can tell whether their last run failed.
+// Jobs without it only count a panic as an error.
+type errorJob interface {
+	LastError() error
+}
+
+var (
+	jobStats     = make(map[string]*JobStats)
+	jobStatsLock sync.Mutex
+)
+
+type instrumentedJob struct {
+	name string
+	job  cron.Job
+}
+
+// Instrument wraps a job so that its runs, durations and errors are recorded under name.
+// A panic in the job is recovered and counted as an error instead of stopping the panel.
+func Instrument(name string, job cron.Job) cron.Job {
+	jobStatsLock.Lock()
+	if _, ok := jobStats[name]; !ok {
+		jobStats[name] = &JobStats{Name: name}
+	}
+	jobStatsLock.Unlock()
+	return &instrumentedJob{name: name, job: job}
+}
+
+// InstrumentFunc is Instrument for plain functions
+func InstrumentFunc(name string, fn func()) cron.Job {
+	return Instrument(name, cron.FuncJob(fn))
+}
+
+func (j *instrumentedJob) Run() {
+	start := time.Now()
+	failed := true
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("job %s panic: %v\n%s", j.name, r, debug.Stack())
+		}
+		duration := time.Since(start)
+		jobStatsLock.Lock()
+		defer jobStatsLock.Unlock()
+		stats := jobStats[j.name]
+		stats.Runs++
+		if failed {
+			stats.Errors++
+		}
+		stats.LastRun = start
+		stats.LastDuration = duration
+		stats.TotalDuration += duration
+	}()
+	j.job.Run()
+	if reporter, ok := j.job.(errorJob); ok {
+		failed = reporter.LastError() != nil
+	} else {
+		failed = false
+	}
+}
+
+// GetJobStats returns a copy of the statistics of every instrumented job, sorted by name.
+func GetJobStats() []JobStats {
+	jobStatsLock.Lock()
+	defer jobStatsLock.Unlock()
+	result := make([]JobStats, 0, len(jobStats))
+	for _, stats := range jobStats {
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(i, k int) bool { return result[i].Name < result[k].Name })
+	return result
+}
+
+// GetDeviceLimitStats returns the number of clients currently blocked or throttled by CheckDeviceLimitJob
+// and the number of IPs it tracks as active.
+func GetDeviceLimitStats() (banned int, activeIPs int) {
+	clientStatusLock.RLock()
+	for _, state := range ClientStatus {
+		if state.Action == model.DeviceLimitBlock || state.Action == model.DeviceLimitThrottle {
+			banned++
+		}
+	}
+	clientStatusLock.RUnlock()
+
+	activeClientsLock.RLock()
+	for _, ips := range ActiveClientIPs {
+		activeIPs += len(ips)
+	}
+	activeClientsLock.RUnlock()
+	return banned, activeIPs
+}
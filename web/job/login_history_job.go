@@ -0,0 +1,28 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+// LoginHistoryJob drops login attempts past their retention.
+type LoginHistoryJob struct {
+	loginHistoryService service.LoginHistoryService
+	lastErr             error
+}
+
+func NewLoginHistoryJob() *LoginHistoryJob {
+	return new(LoginHistoryJob)
+}
+
+func (j *LoginHistoryJob) Run() {
+	j.lastErr = j.loginHistoryService.Prune()
+	if j.lastErr != nil {
+		logger.Warning("prune login history failed:", j.lastErr)
+	}
+}
+
+// LastError returns the error of the last run, if any
+func (j *LoginHistoryJob) LastError() error {
+	return j.lastErr
+}
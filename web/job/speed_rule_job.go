@@ -0,0 +1,158 @@
+package job
+
+import (
+	"maps"
+	"sync"
+	"time"
+
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/web/service"
+	"x-ui/xray"
+)
+
+// speedRuleLevels holds the policy level a speed rule moved each client to, by email.
+// The device-limit job restores these clients to this level instead of their own.
+// It takes this lock while holding clientStatusLock, so never take them the other way round.
+var (
+	speedRuleLevels     = make(map[string]int)
+	speedRuleLevelsLock sync.Mutex
+)
+
+// speedRuleLevel returns the level a speed rule keeps the client on, if any
+func speedRuleLevel(email string) (int, bool) {
+	speedRuleLevelsLock.Lock()
+	defer speedRuleLevelsLock.Unlock()
+	level, ok := speedRuleLevels[email]
+	return level, ok
+}
+
+// setSpeedRuleLevel records the level a rule moved the client to, or forgets it when moved is false
+func setSpeedRuleLevel(email string, level int, moved bool) {
+	speedRuleLevelsLock.Lock()
+	defer speedRuleLevelsLock.Unlock()
+	if moved {
+		speedRuleLevels[email] = level
+	} else {
+		delete(speedRuleLevels, email)
+	}
+}
+
+// SpeedRuleJob moves clients to the speed of a matching speed rule, or to full speed while
+// they have burst allowance left, through the Xray API without restarting Xray, and back
+// to their own speed when neither applies any more.
+type SpeedRuleJob struct {
+	speedRuleService service.SpeedRuleService
+	xrayService      service.XrayService
+	xrayApi          xray.XrayAPI
+	xrayUptime       uint64
+	lastErr          error
+}
+
+func NewSpeedRuleJob() *SpeedRuleJob {
+	return new(SpeedRuleJob)
+}
+
+func (j *SpeedRuleJob) Run() {
+	j.lastErr = nil
+	if !j.xrayService.IsXrayRunning() {
+		return
+	}
+
+	// The levels are copied so that the lock is not held while the device-limit lock is taken
+	speedRuleLevelsLock.Lock()
+	// A restarted Xray has every client on its own level again
+	uptime := j.xrayService.GetXrayUptime()
+	if uptime < j.xrayUptime {
+		clear(speedRuleLevels)
+	}
+	j.xrayUptime = uptime
+	levels := maps.Clone(speedRuleLevels)
+	speedRuleLevelsLock.Unlock()
+
+	targets, err := j.speedRuleService.GetTargets(time.Now())
+	if err != nil {
+		j.lastErr = err
+		logger.Warning("evaluate speed rules failed:", err)
+		return
+	}
+	// Deleted or disabled clients are no longer in Xray
+	for email := range levels {
+		if _, ok := targets[email]; !ok {
+			setSpeedRuleLevel(email, 0, false)
+		}
+	}
+	if len(levels) == 0 && !hasMovedTarget(targets) {
+		return
+	}
+	apiPort := j.xrayService.GetApiPort()
+	if apiPort == 0 {
+		return
+	}
+	j.xrayApi.Init(apiPort)
+	defer j.xrayApi.Close()
+
+	for email, target := range targets {
+		current, moved := levels[email]
+		if !target.Moved() && !moved {
+			continue
+		}
+		level := target.Level()
+		if moved && current == level {
+			continue
+		}
+		// The device limit decides the level while it blocks or throttles the client
+		if deviceLimitActing(email) {
+			continue
+		}
+		if err := replaceXrayUser(&j.xrayApi, target.Protocol, target.Tag, email, target.XrayUser(level)); err != nil {
+			j.lastErr = err
+			logger.Warningf("apply speed rule to %s failed: %v", email, err)
+			continue
+		}
+		setSpeedRuleLevel(email, level, target.Moved())
+		if target.Rule != nil {
+			logger.Infof("speed rule %q limits %s to %d KB/s", target.Rule.Name, email, target.Rule.Speed)
+		} else if target.Bursting {
+			logger.Infof("burst allowance lifts the speed limit of %s", email)
+		} else {
+			logger.Infof("speed rule or burst no longer applies to %s, restored its own speed", email)
+		}
+	}
+}
+
+// LastError returns the error of the last run, if any
+func (j *SpeedRuleJob) LastError() error {
+	return j.lastErr
+}
+
+func hasMovedTarget(targets map[string]*service.SpeedRuleTarget) bool {
+	for _, target := range targets {
+		if target.Moved() {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceLimitActing reports whether the device-limit job currently blocks or throttles the client
+func deviceLimitActing(email string) bool {
+	clientStatusLock.RLock()
+	defer clientStatusLock.RUnlock()
+	state, ok := ClientStatus[email]
+	return ok && (state.Action == model.DeviceLimitBlock || state.Action == model.DeviceLimitThrottle)
+}
+
+// replaceXrayUser removes a user from Xray and adds it back with new credentials or a new level.
+// Adding fails while the removal has not taken effect, so it is retried for a few seconds.
+func replaceXrayUser(api *xray.XrayAPI, protocol model.Protocol, tag string, email string, user map[string]any) error {
+	api.RemoveUser(tag, email)
+	var err error
+	for range 10 {
+		if err = api.AddUser(string(protocol), tag, user); err == nil {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+// TrafficExportJob sends the buffered traffic to the external traffic URI.
+type TrafficExportJob struct {
+	trafficExportService service.TrafficExportService
+	lastErr              error
+}
+
+func NewTrafficExportJob() *TrafficExportJob {
+	return new(TrafficExportJob)
+}
+
+func (j *TrafficExportJob) Run() {
+	j.lastErr = j.trafficExportService.Flush()
+	if j.lastErr != nil {
+		logger.Warning("export traffic failed:", j.lastErr)
+	}
+}
+
+// LastError returns the error of the last run, if any
+func (j *TrafficExportJob) LastError() error {
+	return j.lastErr
+}
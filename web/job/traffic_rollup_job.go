@@ -0,0 +1,28 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+// TrafficRollupJob compacts the traffic history and drops buckets past their retention.
+type TrafficRollupJob struct {
+	trafficStatService service.TrafficStatService
+	lastErr            error
+}
+
+func NewTrafficRollupJob() *TrafficRollupJob {
+	return new(TrafficRollupJob)
+}
+
+func (j *TrafficRollupJob) Run() {
+	j.lastErr = j.trafficStatService.Compact()
+	if j.lastErr != nil {
+		logger.Warning("compact traffic history failed:", j.lastErr)
+	}
+}
+
+// LastError returns the error of the last run, if any
+func (j *TrafficRollupJob) LastError() error {
+	return j.lastErr
+}
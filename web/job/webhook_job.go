@@ -0,0 +1,28 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+// WebhookJob sends the queued webhook deliveries that are due.
+type WebhookJob struct {
+	webhookService service.WebhookService
+	lastErr        error
+}
+
+func NewWebhookJob() *WebhookJob {
+	return new(WebhookJob)
+}
+
+func (j *WebhookJob) Run() {
+	j.lastErr = j.webhookService.DeliverPending()
+	if j.lastErr != nil {
+		logger.Warning("deliver webhooks failed:", j.lastErr)
+	}
+}
+
+// LastError returns the error of the last run, if any
+func (j *WebhookJob) LastError() error {
+	return j.lastErr
+}
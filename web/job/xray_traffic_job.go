@@ -1,19 +1,16 @@
 package job
 
 import (
-	"encoding/json"
 	"x-ui/logger"
 	"x-ui/web/service"
-	"x-ui/xray"
-
-	"github.com/valyala/fasthttp"
 )
 
 type XrayTrafficJob struct {
-	settingService  service.SettingService
-	xrayService     service.XrayService
-	inboundService  service.InboundService
-	outboundService service.OutboundService
+	xrayService          service.XrayService
+	inboundService       service.InboundService
+	outboundService      service.OutboundService
+	trafficExportService service.TrafficExportService
+	lastErr              error
 }
 
 func NewXrayTrafficJob() *XrayTrafficJob {
@@ -21,51 +18,35 @@ func NewXrayTrafficJob() *XrayTrafficJob {
 }
 
 func (j *XrayTrafficJob) Run() {
+	j.lastErr = nil
 	if !j.xrayService.IsXrayRunning() {
 		return
 	}
 	traffics, clientTraffics, err := j.xrayService.GetXrayTraffic()
 	if err != nil {
+		j.lastErr = err
 		return
 	}
 	err, needRestart0 := j.inboundService.AddTraffic(traffics, clientTraffics)
 	if err != nil {
+		j.lastErr = err
 		logger.Warning("add inbound traffic failed:", err)
 	}
 	err, needRestart1 := j.outboundService.AddTraffic(traffics, clientTraffics)
 	if err != nil {
+		j.lastErr = err
 		logger.Warning("add outbound traffic failed:", err)
 	}
-	if ExternalTrafficInformEnable, err := j.settingService.GetExternalTrafficInformEnable(); ExternalTrafficInformEnable {
-		j.informTrafficToExternalAPI(traffics, clientTraffics)
-	} else if err != nil {
-		logger.Warning("get ExternalTrafficInformEnable failed:", err)
+	// Buffered for the external traffic URI, TrafficExportJob sends it
+	if err := j.trafficExportService.Enqueue(traffics, clientTraffics); err != nil {
+		logger.Warning("queue traffic for export failed:", err)
 	}
 	if needRestart0 || needRestart1 {
 		j.xrayService.SetToNeedRestart()
 	}
 }
 
-func (j *XrayTrafficJob) informTrafficToExternalAPI(inboundTraffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) {
-	informURL, err := j.settingService.GetExternalTrafficInformURI()
-	if err != nil {
-		logger.Warning("get ExternalTrafficInformURI failed:", err)
-		return
-	}
-	requestBody, err := json.Marshal(map[string]any{"clientTraffics": clientTraffics, "inboundTraffics": inboundTraffics})
-	if err != nil {
-		logger.Warning("parse client/inbound traffic failed:", err)
-		return
-	}
-	request := fasthttp.AcquireRequest()
-	defer fasthttp.ReleaseRequest(request)
-	request.Header.SetMethod("POST")
-	request.Header.SetContentType("application/json; charset=UTF-8")
-	request.SetBody([]byte(requestBody))
-	request.SetRequestURI(informURL)
-	response := fasthttp.AcquireResponse()
-	defer fasthttp.ReleaseResponse(response)
-	if err := fasthttp.Do(request, response); err != nil {
-		logger.Warning("POST ExternalTrafficInformURI failed:", err)
-	}
+// LastError returns the error of the last run, if any
+func (j *XrayTrafficJob) LastError() error {
+	return j.lastErr
 }
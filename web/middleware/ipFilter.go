@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"x-ui/util/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IPFilterMiddleware rejects clients in the deny list and, when an allow list is set,
+// every client outside of it. The deny list wins when both match. The client IP only
+// comes from forwarding headers when the engine trusts the proxy that sent them.
+func IPFilterMiddleware(allow []*net.IPNet, deny []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+		if common.ContainsIP(deny, ip) || (len(allow) > 0 && !common.ContainsIP(allow, ip)) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
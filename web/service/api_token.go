@@ -0,0 +1,130 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/util/common"
+	"x-ui/util/crypto"
+
+	"gorm.io/gorm"
+)
+
+// apiTokenPrefix marks panel API tokens so they are easy to recognise in scripts and leaks.
+const apiTokenPrefix = "xp_"
+
+// APITokenService manages the long-lived bearer tokens used for headless API access.
+type APITokenService struct {
+	auditService AuditService
+	actor        *model.AuditActor
+}
+
+// WithActor returns a copy of the service whose changes are audited as made by actor.
+func (s *APITokenService) WithActor(actor *model.AuditActor) *APITokenService {
+	scoped := *s
+	scoped.actor = actor
+	return &scoped
+}
+
+// ParseScopes turns a comma separated scope list into its canonical form.
+func (s *APITokenService) ParseScopes(scopes string) (string, error) {
+	var result []string
+	for _, part := range strings.Split(scopes, ",") {
+		scope := model.APIScope(strings.TrimSpace(part))
+		if scope == "" {
+			continue
+		}
+		if !scope.IsValid() {
+			return "", common.NewError("invalid scope:", scope)
+		}
+		result = append(result, string(scope))
+	}
+	if len(result) == 0 {
+		return "", errors.New("at least one scope is required")
+	}
+	return strings.Join(result, ","), nil
+}
+
+// CreateToken stores a new token for userId and returns the plain token, which is only shown once.
+func (s *APITokenService) CreateToken(userId int, name string, scopes string) (string, *model.APIToken, error) {
+	if name == "" {
+		return "", nil, errors.New("token name can not be empty")
+	}
+	scopes, err := s.ParseScopes(scopes)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := crypto.RandomHex(24)
+	if err != nil {
+		return "", nil, err
+	}
+	plain := apiTokenPrefix + secret
+
+	token := &model.APIToken{
+		UserId:    userId,
+		Name:      name,
+		TokenHash: crypto.HashToken(plain),
+		Prefix:    plain[:len(apiTokenPrefix)+6],
+		Scopes:    scopes,
+		CreatedAt: time.Now().Unix(),
+	}
+	err = database.GetDB().Create(token).Error
+	if err != nil {
+		return "", nil, err
+	}
+	s.auditService.Record(s.actor, "token.create", name, nil, token)
+	return plain, token, nil
+}
+
+// GetTokens lists the tokens of userId, or of every account when userId is 0.
+func (s *APITokenService) GetTokens(userId int) ([]*model.APIToken, error) {
+	db := database.GetDB().Model(model.APIToken{})
+	if userId > 0 {
+		db = db.Where("user_id = ?", userId)
+	}
+	var tokens []*model.APIToken
+	err := db.Order("id asc").Find(&tokens).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RevokeToken deletes a token. A userId other than 0 restricts the deletion to that account's tokens.
+func (s *APITokenService) RevokeToken(id int, userId int) error {
+	db := database.GetDB().Where("id = ?", id)
+	if userId > 0 {
+		db = db.Where("user_id = ?", userId)
+	}
+	result := db.Delete(&model.APIToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("token not found:", id)
+	}
+	s.auditService.Record(s.actor, "token.revoke", strconv.Itoa(id), nil, nil)
+	return nil
+}
+
+// CheckToken resolves a bearer token to its record and records the time it was used.
+func (s *APITokenService) CheckToken(plain string) (*model.APIToken, error) {
+	if !strings.HasPrefix(plain, apiTokenPrefix) {
+		return nil, errors.New("invalid token")
+	}
+	db := database.GetDB()
+	token := &model.APIToken{}
+	err := db.Model(model.APIToken{}).Where("token_hash = ?", crypto.HashToken(plain)).First(token).Error
+	if database.IsNotFound(err) {
+		return nil, errors.New("invalid token")
+	} else if err != nil {
+		return nil, err
+	}
+	token.LastUsedAt = time.Now().Unix()
+	db.Model(token).Update("last_used_at", token.LastUsedAt)
+	return token, nil
+}
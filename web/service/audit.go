@@ -0,0 +1,271 @@
+package service
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+
+	"gorm.io/gorm"
+)
+
+// AuditFilter narrows down the audit log listing. Empty fields match everything.
+type AuditFilter struct {
+	Page      int    `json:"page" form:"page"`
+	PageSize  int    `json:"pageSize" form:"pageSize"`
+	ActorType string `json:"actorType" form:"actorType"`
+	Actor     string `json:"actor" form:"actor"`
+	Action    string `json:"action" form:"action"`
+	Target    string `json:"target" form:"target"`
+	From      int64  `json:"from" form:"from"` // unix seconds, inclusive
+	To        int64  `json:"to" form:"to"`     // unix seconds, inclusive
+}
+
+// auditSecretKeys are masked in before/after snapshots so the log never stores credentials.
+// Keys containing one of them are masked at any depth.
+var auditSecretKeys = []string{"password", "token", "secret", "privatekey"}
+
+// auditSecretNames are masked only when the key is exactly one of them: the "id" of a client
+// is its UUID and "auth" its Hysteria password.
+var auditSecretNames = []string{"id", "auth"}
+
+// AuditService writes and queries the audit log of administrative changes.
+type AuditService struct{}
+
+// Record stores one audit entry. before and after may be nil or any JSON-serialisable value.
+// Failures are only logged, auditing never makes the audited operation fail.
+func (s *AuditService) Record(actor *model.AuditActor, action string, target string, before any, after any) {
+	s.RecordTx(database.GetDB(), actor, action, target, before, after)
+}
+
+// RecordTx stores an audit entry within tx. Changes made inside a transaction must be
+// recorded with it: SQLite has a single writer, so a second connection would wait for
+// the transaction's lock, and the entry is rolled back together with the change.
+func (s *AuditService) RecordTx(tx *gorm.DB, actor *model.AuditActor, action string, target string, before any, after any) {
+	if actor == nil {
+		actor = &model.AuditActor{Type: model.ActorSystem}
+	}
+	beforeMap := auditSnapshot(before)
+	afterMap := auditSnapshot(after)
+	diff := auditDiff(beforeMap, afterMap)
+	for key, values := range diff {
+		if isAuditSecret(key, values[0]) || isAuditSecret(key, values[1]) {
+			diff[key] = [2]any{"******", "******"}
+		} else {
+			diff[key] = [2]any{maskAuditSecrets(values[0]), maskAuditSecrets(values[1])}
+		}
+	}
+	maskAuditSecrets(beforeMap)
+	maskAuditSecrets(afterMap)
+	entry := &model.AuditLog{
+		CreatedAt: time.Now().Unix(),
+		ActorType: actor.Type,
+		Actor:     actor.Name,
+		IP:        actor.IP,
+		Action:    action,
+		Target:    target,
+		Before:    auditJSON(beforeMap),
+		After:     auditJSON(afterMap),
+		Diff:      auditJSON(diff),
+	}
+
+	if err := tx.Create(entry).Error; err != nil {
+		logger.Warning("write audit log failed:", action, target, err)
+	}
+}
+
+// GetLogs returns one page of audit entries, newest first, and the total number of matches.
+func (s *AuditService) GetLogs(filter *AuditFilter) ([]*model.AuditLog, int64, error) {
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PageSize < 1 || filter.PageSize > 500 {
+		filter.PageSize = 50
+	}
+	query := s.filterQuery(filter)
+
+	var total int64
+	err := query.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	var logs []*model.AuditLog
+	err = query.Order("id desc").
+		Offset((filter.Page - 1) * filter.PageSize).
+		Limit(filter.PageSize).
+		Find(&logs).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return logs, total, nil
+}
+
+// ExportCSV writes every entry matching the filter (ignoring paging) as CSV.
+func (s *AuditService) ExportCSV(filter *AuditFilter, w io.Writer) error {
+	var logs []*model.AuditLog
+	err := s.filterQuery(filter).Order("id asc").Find(&logs).Error
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "time", "actor_type", "actor", "ip", "action", "target", "diff", "before", "after"})
+	for _, entry := range logs {
+		writer.Write([]string{
+			strconv.Itoa(entry.Id),
+			time.Unix(entry.CreatedAt, 0).Format(time.RFC3339),
+			entry.ActorType,
+			entry.Actor,
+			entry.IP,
+			entry.Action,
+			entry.Target,
+			entry.Diff,
+			entry.Before,
+			entry.After,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func (s *AuditService) filterQuery(filter *AuditFilter) *gorm.DB {
+	query := database.GetDB().Model(model.AuditLog{})
+	if filter.ActorType != "" {
+		query = query.Where("actor_type = ?", filter.ActorType)
+	}
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		// "client." matches every client action
+		if strings.HasSuffix(filter.Action, ".") {
+			query = query.Where("action LIKE ?", filter.Action+"%")
+		} else {
+			query = query.Where("action = ?", filter.Action)
+		}
+	}
+	if filter.Target != "" {
+		query = query.Where("target LIKE ?", "%"+filter.Target+"%")
+	}
+	if filter.From > 0 {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if filter.To > 0 {
+		query = query.Where("created_at <= ?", filter.To)
+	}
+	return query
+}
+
+// auditSnapshot converts a value into a JSON object.
+// Non-object values are stored under the "value" key.
+func auditSnapshot(v any) map[string]any {
+	if v == nil {
+		return nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var snapshot map[string]any
+	if json.Unmarshal(data, &snapshot) != nil {
+		var value any
+		json.Unmarshal(data, &value)
+		return map[string]any{"value": value}
+	}
+	return snapshot
+}
+
+// isAuditSecret reports whether the value stored under key is a credential. Only string
+// ids are secret, the numeric ids of inbounds, users and rules are kept.
+func isAuditSecret(key string, value any) bool {
+	if value == nil || value == "" {
+		return false
+	}
+	lower := strings.ToLower(key)
+	if slices.Contains(auditSecretNames, lower) {
+		_, isString := value.(string)
+		return isString || lower != "id"
+	}
+	for _, secret := range auditSecretKeys {
+		if strings.Contains(lower, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskAuditSecrets replaces the credentials at any depth of a snapshot value, including
+// JSON documents stored as strings such as inbound settings. Maps and slices are masked
+// in place, the masked value is returned for strings.
+func maskAuditSecrets(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if isAuditSecret(key, item) {
+				v[key] = "******"
+			} else {
+				v[key] = maskAuditSecrets(item)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = maskAuditSecrets(item)
+		}
+	case string:
+		trimmed := strings.TrimSpace(v)
+		if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+			return v
+		}
+		var decoded any
+		if json.Unmarshal([]byte(trimmed), &decoded) != nil {
+			return v
+		}
+		data, err := json.Marshal(maskAuditSecrets(decoded))
+		if err != nil {
+			return v
+		}
+		return string(data)
+	}
+	return value
+}
+
+// auditDiff returns {key: [before, after]} for every top-level key whose value changed.
+func auditDiff(before map[string]any, after map[string]any) map[string][2]any {
+	diff := map[string][2]any{}
+	for key, oldValue := range before {
+		newValue, ok := after[key]
+		if !ok || !reflect.DeepEqual(oldValue, newValue) {
+			diff[key] = [2]any{oldValue, newValue}
+		}
+	}
+	for key, newValue := range after {
+		if _, ok := before[key]; !ok {
+			diff[key] = [2]any{nil, newValue}
+		}
+	}
+	if len(diff) == 0 {
+		return nil
+	}
+	return diff
+}
+
+func auditJSON[T map[string]any | map[string][2]any](v T) string {
+	if v == nil {
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,317 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+
+	"gorm.io/gorm"
+)
+
+// ClientAlertFilter narrows down the sent alert listing. Empty fields match everything.
+type ClientAlertFilter struct {
+	Page     int    `json:"page" form:"page"`
+	PageSize int    `json:"pageSize" form:"pageSize"`
+	Email    string `json:"email" form:"email"`
+	Kind     string `json:"kind" form:"kind"`
+}
+
+// ClientAlertService sends quota and expiry alerts for clients. Thresholds come from the
+// client's own policy, then its group's policy, then the global settings.
+type ClientAlertService struct {
+	settingService SettingService
+	tgbot          Tgbot
+	auditService   AuditService
+	actor          *model.AuditActor
+}
+
+// WithActor returns a copy of the service whose changes are audited as made by actor.
+func (s *ClientAlertService) WithActor(actor *model.AuditActor) *ClientAlertService {
+	scoped := *s
+	scoped.actor = actor
+	return &scoped
+}
+
+// alertCandidate is one enabled client with the data its alerts are computed from
+type alertCandidate struct {
+	Email      string
+	Group      string
+	TgID       int64
+	Up         int64
+	Down       int64
+	Total      int64
+	ExpiryTime int64
+}
+
+func (s *ClientAlertService) GetPolicies() ([]*model.ClientAlertPolicy, error) {
+	var policies []*model.ClientAlertPolicy
+	err := database.GetDB().Model(model.ClientAlertPolicy{}).Order("scope asc, name asc").Find(&policies).Error
+	return policies, err
+}
+
+// SavePolicy creates or replaces the policy of a client or group
+func (s *ClientAlertService) SavePolicy(policy *model.ClientAlertPolicy) error {
+	if policy.Scope != model.ClientAlertScopeClient && policy.Scope != model.ClientAlertScopeGroup {
+		return common.NewError("invalid alert scope:", policy.Scope)
+	}
+	policy.Name = strings.TrimSpace(policy.Name)
+	if policy.Name == "" {
+		return errors.New("alert policy name can not be empty")
+	}
+	if _, err := common.ParseThresholds(policy.TrafficPercents); err != nil {
+		return err
+	}
+	if _, err := common.ParseThresholds(policy.ExpiryDays); err != nil {
+		return err
+	}
+
+	db := database.GetDB()
+	existing := &model.ClientAlertPolicy{}
+	err := db.Where("scope = ? AND name = ?", policy.Scope, policy.Name).First(existing).Error
+	if database.IsNotFound(err) {
+		policy.Id = 0
+		err = db.Create(policy).Error
+		existing = nil
+	} else if err == nil {
+		policy.Id = existing.Id
+		err = db.Save(policy).Error
+	}
+	if err == nil {
+		s.auditService.Record(s.actor, "alertPolicy.save", string(policy.Scope)+":"+policy.Name, existing, policy)
+	}
+	return err
+}
+
+func (s *ClientAlertService) DelPolicy(id int) error {
+	db := database.GetDB()
+	policy := &model.ClientAlertPolicy{}
+	if err := db.First(policy, id).Error; err != nil {
+		return err
+	}
+	err := db.Delete(model.ClientAlertPolicy{}, id).Error
+	if err == nil {
+		s.auditService.Record(s.actor, "alertPolicy.delete", string(policy.Scope)+":"+policy.Name, policy, nil)
+	}
+	return err
+}
+
+// GetAlerts returns one page of sent alerts, newest first, and the total number of matches.
+func (s *ClientAlertService) GetAlerts(filter *ClientAlertFilter) ([]*model.ClientAlert, int64, error) {
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PageSize < 1 || filter.PageSize > 500 {
+		filter.PageSize = 50
+	}
+	query := database.GetDB().Model(model.ClientAlert{})
+	if filter.Email != "" {
+		query = query.Where("email = ?", filter.Email)
+	}
+	if filter.Kind != "" {
+		query = query.Where("kind = ?", filter.Kind)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var alerts []*model.ClientAlert
+	err := query.Order("id desc").
+		Offset((filter.Page - 1) * filter.PageSize).
+		Limit(filter.PageSize).
+		Find(&alerts).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return alerts, total, nil
+}
+
+// resetClientAlerts starts a new billing cycle for the given clients,
+// so that each of their thresholds can fire again.
+func resetClientAlerts(tx *gorm.DB, emails []string) error {
+	if len(emails) == 0 {
+		return nil
+	}
+	return tx.Model(model.ClientAlert{}).
+		Where("email IN ? AND reset_at = 0", emails).
+		Update("reset_at", time.Now().Unix()).Error
+}
+
+// thresholds resolves the traffic and expiry thresholds of every client
+func (s *ClientAlertService) thresholds() (func(email string, group string) ([]int, []int), error) {
+	defaultPercents, err := s.settingService.GetClientAlertTrafficPercents()
+	if err != nil {
+		return nil, err
+	}
+	defaultDays, err := s.settingService.GetClientAlertExpiryDays()
+	if err != nil {
+		return nil, err
+	}
+	policies, err := s.GetPolicies()
+	if err != nil {
+		return nil, err
+	}
+	clientPolicies := make(map[string]*model.ClientAlertPolicy)
+	groupPolicies := make(map[string]*model.ClientAlertPolicy)
+	for _, policy := range policies {
+		if policy.Scope == model.ClientAlertScopeClient {
+			clientPolicies[policy.Name] = policy
+		} else {
+			groupPolicies[policy.Name] = policy
+		}
+	}
+
+	resolve := func(email string, group string) ([]int, []int) {
+		percents, days := defaultPercents, defaultDays
+		if policy, ok := groupPolicies[group]; ok && group != "" {
+			if policy.TrafficPercents != "" {
+				percents = policy.TrafficPercents
+			}
+			if policy.ExpiryDays != "" {
+				days = policy.ExpiryDays
+			}
+		}
+		if policy, ok := clientPolicies[email]; ok {
+			if policy.TrafficPercents != "" {
+				percents = policy.TrafficPercents
+			}
+			if policy.ExpiryDays != "" {
+				days = policy.ExpiryDays
+			}
+		}
+		// Invalid values are rejected when saved, a broken setting just disables the alert
+		percentList, _ := common.ParseThresholds(percents)
+		dayList, _ := common.ParseThresholds(days)
+		return percentList, dayList
+	}
+	return resolve, nil
+}
+
+// CheckAlerts sends the alerts whose thresholds were crossed since the last check.
+// Alerts are only evaluated while the Telegram bot runs, so none is recorded as sent without being delivered.
+func (s *ClientAlertService) CheckAlerts() error {
+	if !s.tgbot.IsRunning() {
+		return nil
+	}
+	resolve, err := s.thresholds()
+	if err != nil {
+		return err
+	}
+
+	db := database.GetDB()
+	var candidates []*alertCandidate
+	err = db.Table("clients").
+		Select("clients.email, clients.`group`, clients.tg_id, client_traffics.up, client_traffics.down, client_traffics.total, client_traffics.expiry_time").
+		Joins("JOIN client_traffics ON client_traffics.email = clients.email").
+		Where("client_traffics.enable = ? AND clients.email != ''", true).
+		Scan(&candidates).Error
+	if err != nil {
+		return err
+	}
+
+	var sent []*model.ClientAlert
+	err = db.Where("reset_at = 0").Find(&sent).Error
+	if err != nil {
+		return err
+	}
+	type sentKey struct {
+		email     string
+		kind      model.ClientAlertKind
+		threshold int
+		target    int64
+	}
+	sentKeys := make(map[sentKey]bool, len(sent))
+	for _, alert := range sent {
+		sentKeys[sentKey{alert.Email, alert.Kind, alert.Threshold, alert.Target}] = true
+	}
+	isSent := func(email string, kind model.ClientAlertKind, threshold int, target int64) bool {
+		return sentKeys[sentKey{email, kind, threshold, target}]
+	}
+
+	now := time.Now().UnixMilli()
+	for _, client := range candidates {
+		percents, days := resolve(client.Email, client.Group)
+		used := client.Up + client.Down
+
+		if client.Total > 0 {
+			var crossed []int
+			for _, percent := range percents {
+				if used*100 >= client.Total*int64(percent) && !isSent(client.Email, model.ClientAlertTraffic, percent, client.Total) {
+					crossed = append(crossed, percent)
+				}
+			}
+			if len(crossed) > 0 {
+				// Only the highest threshold is announced, the lower ones are marked as passed
+				percent := slices.Max(crossed)
+				msg := s.tgbot.I18nBot("tgbot.messages.quotaAlert",
+					"Email=="+client.Email,
+					"Percent=="+strconv.Itoa(percent),
+					"Used=="+common.FormatTraffic(used),
+					"Total=="+common.FormatTraffic(client.Total))
+				s.deliver(client, model.ClientAlertTraffic, crossed, client.Total, used, msg)
+			}
+		}
+
+		if client.ExpiryTime > now {
+			remaining := client.ExpiryTime - now
+			var crossed []int
+			for _, day := range days {
+				if remaining <= int64(day)*86400000 && !isSent(client.Email, model.ClientAlertExpiry, day, client.ExpiryTime) {
+					crossed = append(crossed, day)
+				}
+			}
+			if len(crossed) > 0 {
+				day := slices.Min(crossed)
+				msg := s.tgbot.I18nBot("tgbot.messages.expiryAlert",
+					"Email=="+client.Email,
+					"Days=="+strconv.Itoa(day),
+					"Time=="+time.UnixMilli(client.ExpiryTime).Format("2006-01-02 15:04:05"))
+				s.deliver(client, model.ClientAlertExpiry, crossed, client.ExpiryTime, remaining, msg)
+			}
+		}
+	}
+	return nil
+}
+
+// deliver sends one alert to the admins and the client's own chat and records every crossed threshold.
+// Nothing is recorded when no chat got the alert, so that the next check retries it.
+func (s *ClientAlertService) deliver(client *alertCandidate, kind model.ClientAlertKind, thresholds []int, target int64, value int64, msg string) {
+	recipients := slices.Clone(adminIds)
+	if client.TgID != 0 && !checkAdmin(client.TgID) {
+		recipients = append(recipients, client.TgID)
+	}
+	ids := make([]string, 0, len(recipients))
+	for _, id := range recipients {
+		if err := s.tgbot.sendMsg(id, msg); err != nil {
+			logger.Warningf("send %s alert of %s to %d failed: %v", kind, client.Email, id, err)
+			continue
+		}
+		ids = append(ids, strconv.FormatInt(id, 10))
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	now := time.Now().Unix()
+	alerts := make([]*model.ClientAlert, 0, len(thresholds))
+	for _, threshold := range thresholds {
+		alerts = append(alerts, &model.ClientAlert{
+			CreatedAt:  now,
+			Email:      client.Email,
+			Kind:       kind,
+			Threshold:  threshold,
+			Target:     target,
+			Value:      value,
+			Recipients: strings.Join(ids, ","),
+		})
+	}
+	if err := database.GetDB().Create(&alerts).Error; err != nil {
+		logger.Warning("save client alert failed:", err)
+	}
+}
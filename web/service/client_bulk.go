@@ -0,0 +1,481 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+
+	"gorm.io/gorm"
+)
+
+// BulkAction names an operation applied to every client matched by a ClientFilter.
+type BulkAction string
+
+const (
+	BulkExtend      BulkAction = "extend"      // Value: days, negative shortens
+	BulkAddTraffic  BulkAction = "addTraffic"  // Value: GB, negative lowers the limit
+	BulkEnable      BulkAction = "enable"      // no value
+	BulkDisable     BulkAction = "disable"     // no value
+	BulkSpeedLimit  BulkAction = "speedLimit"  // Value: KB/s in both directions, 0 removes the limit
+	BulkDeviceLimit BulkAction = "deviceLimit" // Value: device count, 0 falls back to the inbound's limit
+	BulkSetGroup    BulkAction = "setGroup"    // Group: new group, empty ungroups
+	BulkMove        BulkAction = "move"        // InboundId: target inbound
+)
+
+// ClientFilter selects clients across inbounds. Set fields are combined with AND.
+type ClientFilter struct {
+	Group      string   `json:"group" form:"group"`
+	InboundIds []int    `json:"inboundIds" form:"inboundIds"`
+	Emails     []string `json:"emails" form:"emails"`
+	Search     string   `json:"search" form:"search"` // substring of email or comment
+	// UserId restricts the filter to the inbounds of one account; set for resellers only
+	UserId int `json:"-" form:"-"`
+}
+
+// BulkClientRequest is one bulk operation and the clients it applies to.
+type BulkClientRequest struct {
+	Filter    ClientFilter `json:"filter"`
+	Action    BulkAction   `json:"action"`
+	Value     int64        `json:"value"`
+	Group     string       `json:"group"`
+	InboundId int          `json:"inboundId"`
+}
+
+// ClientGroup is a group name with the number of clients tagged with it.
+type ClientGroup struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// bulkClient is a matched client: its raw settings entry and the values before the change.
+type bulkClient struct {
+	inbound *model.Inbound
+	entry   map[string]any
+	before  model.Client
+	after   model.Client
+}
+
+func (f *ClientFilter) isEmpty() bool {
+	return f.Group == "" && len(f.InboundIds) == 0 && len(f.Emails) == 0 && f.Search == ""
+}
+
+func (f *ClientFilter) matchInbound(inbound *model.Inbound) bool {
+	if f.UserId > 0 && inbound.UserId != f.UserId {
+		return false
+	}
+	if len(f.InboundIds) == 0 {
+		return true
+	}
+	for _, id := range f.InboundIds {
+		if id == inbound.Id {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *ClientFilter) matchClient(client *model.Client) bool {
+	if client.Email == "" {
+		return false
+	}
+	if f.Group != "" && !strings.EqualFold(client.Group, f.Group) {
+		return false
+	}
+	if len(f.Emails) > 0 {
+		found := false
+		for _, email := range f.Emails {
+			if email == client.Email {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Search != "" {
+		search := strings.ToLower(f.Search)
+		if !strings.Contains(strings.ToLower(client.Email), search) &&
+			!strings.Contains(strings.ToLower(client.Comment), search) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseBulkClient decodes a raw settings entry, keeping fields the model does not know.
+func parseBulkClient(raw any) (map[string]any, model.Client, bool) {
+	entry, ok := raw.(map[string]any)
+	if !ok {
+		return nil, model.Client{}, false
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, model.Client{}, false
+	}
+	client := model.Client{}
+	if err = json.Unmarshal(data, &client); err != nil {
+		return nil, model.Client{}, false
+	}
+	return entry, client, true
+}
+
+// GetClientGroups lists the client groups in use with their sizes. A userId above 0
+// only counts the clients of that account's inbounds.
+func (s *InboundService) GetClientGroups(userId int) ([]ClientGroup, error) {
+	var inbounds []*model.Inbound
+	var err error
+	if userId > 0 {
+		inbounds, err = s.GetInbounds(userId)
+	} else {
+		inbounds, err = s.GetAllInbounds()
+	}
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, inbound := range inbounds {
+		clients, err := s.GetClients(inbound)
+		if err != nil {
+			return nil, err
+		}
+		for _, client := range clients {
+			if client.Group != "" {
+				counts[client.Group]++
+			}
+		}
+	}
+	groups := make([]ClientGroup, 0, len(counts))
+	for name, count := range counts {
+		groups = append(groups, ClientGroup{Name: name, Count: count})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+// BulkUpdateClients applies one operation to every matched client in a single
+// transaction and then syncs the running Xray instance once. It returns the number
+// of changed clients and whether Xray has to be restarted to pick up the change.
+func (s *InboundService) BulkUpdateClients(req *BulkClientRequest) (int, bool, error) {
+	if req.Filter.isEmpty() {
+		return 0, false, common.NewError("a group, inbound, email or search filter is required")
+	}
+	switch req.Action {
+	case BulkExtend, BulkAddTraffic, BulkEnable, BulkDisable, BulkSetGroup, BulkMove:
+	case BulkSpeedLimit, BulkDeviceLimit:
+		if req.Value < 0 {
+			return 0, false, common.NewError("limit can not be negative:", req.Value)
+		}
+	default:
+		return 0, false, common.NewError("unknown bulk action:", req.Action)
+	}
+	if req.Action == BulkSpeedLimit {
+		tiers, err := s.settingService.GetSpeedTiers()
+		if err != nil {
+			return 0, false, err
+		}
+		req.Value = int64(model.SnapSpeed(tiers, int(req.Value)))
+	}
+
+	db := database.GetDB()
+	var inbounds []*model.Inbound
+	err := db.Model(model.Inbound{}).Find(&inbounds).Error
+	if err != nil {
+		return 0, false, err
+	}
+
+	var target *model.Inbound
+	var targetSettings map[string]any
+	if req.Action == BulkMove {
+		for _, inbound := range inbounds {
+			if inbound.Id == req.InboundId {
+				target = inbound
+			}
+		}
+		if target == nil || (req.Filter.UserId > 0 && target.UserId != req.Filter.UserId) {
+			return 0, false, common.NewError("target inbound not found:", req.InboundId)
+		}
+		if err = json.Unmarshal([]byte(target.Settings), &targetSettings); err != nil {
+			return 0, false, err
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	settingsById := map[int]map[string]any{}
+	var matched []*bulkClient
+	for _, inbound := range inbounds {
+		if !req.Filter.matchInbound(inbound) || (target != nil && inbound.Id == target.Id) {
+			continue
+		}
+		var settings map[string]any
+		if err = json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+			continue
+		}
+		rawClients, ok := settings["clients"].([]any)
+		if !ok {
+			continue
+		}
+		kept := make([]any, 0, len(rawClients))
+		for _, raw := range rawClients {
+			entry, client, ok := parseBulkClient(raw)
+			if !ok || !req.Filter.matchClient(&client) {
+				kept = append(kept, raw)
+				continue
+			}
+			if target != nil && target.Protocol != inbound.Protocol {
+				return 0, false, common.NewErrorf("can not move %s from %s to %s inbound", client.Email, inbound.Protocol, target.Protocol)
+			}
+			matched = append(matched, &bulkClient{inbound: inbound, entry: entry, before: client})
+			if target == nil {
+				kept = append(kept, entry)
+			}
+		}
+		settings["clients"] = kept
+		settingsById[inbound.Id] = settings
+	}
+	if len(matched) == 0 {
+		return 0, false, nil
+	}
+
+	if err = s.checkBulkQuota(req, matched, target); err != nil {
+		return 0, false, err
+	}
+
+	for _, client := range matched {
+		applyBulkAction(req, client, now)
+		client.entry["updated_at"] = now
+		if target != nil {
+			targetClients, _ := targetSettings["clients"].([]any)
+			targetSettings["clients"] = append(targetClients, client.entry)
+		}
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, inbound := range inbounds {
+			settings, ok := settingsById[inbound.Id]
+			if target != nil && inbound.Id == target.Id {
+				settings, ok = targetSettings, true
+			}
+			if !ok {
+				continue
+			}
+			data, err := json.Marshal(settings)
+			if err != nil {
+				return err
+			}
+			// Only the changed client rows are written, the inbound itself is untouched
+			_, clients, _, err := model.SplitClients(string(data))
+			if err != nil {
+				return err
+			}
+			err = model.SyncClients(tx, inbound.Id, clients)
+			if err != nil {
+				return err
+			}
+		}
+		for _, client := range matched {
+			updates := bulkTrafficUpdates(req, client, target)
+			if len(updates) == 0 {
+				continue
+			}
+			err := tx.Model(xray.ClientTraffic{}).Where("email = ?", client.before.Email).Updates(updates).Error
+			if err != nil {
+				return err
+			}
+		}
+		if req.Action == BulkDisable {
+			for _, client := range matched {
+				if client.before.Enable {
+					after := client.before
+					after.Enable = false
+					s.webhookService.Emit(tx, model.WebhookEventClientDisabled, webhookClientData(client.inbound.Id, &after))
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	needRestart := s.syncBulkClients(req, matched, target)
+
+	emails := make([]string, 0, len(matched))
+	for _, client := range matched {
+		emails = append(emails, client.before.Email)
+	}
+	s.audit("client.bulk", string(req.Action), map[string]any{"filter": req.Filter}, map[string]any{
+		"value":     req.Value,
+		"group":     req.Group,
+		"inboundId": req.InboundId,
+		"clients":   emails,
+	})
+	return len(matched), needRestart, nil
+}
+
+// applyBulkAction changes the raw settings entry and records the new values in client.after.
+func applyBulkAction(req *BulkClientRequest, client *bulkClient, now int64) {
+	after := client.before
+	switch req.Action {
+	case BulkExtend:
+		// Unlimited clients stay unlimited; "start after first use" (negative) keeps its sign
+		delta := req.Value * 24 * 60 * 60 * 1000
+		switch {
+		case after.ExpiryTime > 0:
+			after.ExpiryTime = max(after.ExpiryTime, now) + delta
+			if after.ExpiryTime < now {
+				after.ExpiryTime = now
+			}
+		case after.ExpiryTime < 0:
+			after.ExpiryTime = min(after.ExpiryTime-delta, -1)
+		}
+		client.entry["expiryTime"] = after.ExpiryTime
+	case BulkAddTraffic:
+		// Unlimited clients stay unlimited
+		if after.TotalGB > 0 {
+			after.TotalGB = max(after.TotalGB+req.Value*1024*1024*1024, 1)
+		}
+		client.entry["totalGB"] = after.TotalGB
+	case BulkEnable, BulkDisable:
+		after.Enable = req.Action == BulkEnable
+		client.entry["enable"] = after.Enable
+	case BulkSpeedLimit:
+		// The separate upload/download limits are cleared so the new limit applies to both
+		after.SpeedLimit = int(req.Value)
+		after.UploadLimit, after.DownloadLimit = 0, 0
+		client.entry["speedLimit"] = after.SpeedLimit
+		client.entry["uploadLimit"] = 0
+		client.entry["downloadLimit"] = 0
+	case BulkDeviceLimit:
+		after.DeviceLimit = int(req.Value)
+		client.entry["deviceLimit"] = after.DeviceLimit
+	case BulkSetGroup:
+		after.Group = strings.TrimSpace(req.Group)
+		client.entry["group"] = after.Group
+	}
+	client.after = after
+}
+
+// bulkTrafficUpdates returns the client_traffics columns changed by the action.
+// Like a single client edit, a new limit re-enables a client disabled for depletion.
+func bulkTrafficUpdates(req *BulkClientRequest, client *bulkClient, target *model.Inbound) map[string]any {
+	switch req.Action {
+	case BulkExtend:
+		return map[string]any{"expiry_time": client.after.ExpiryTime, "enable": client.after.Enable}
+	case BulkAddTraffic:
+		return map[string]any{"total": client.after.TotalGB, "enable": client.after.Enable}
+	case BulkEnable, BulkDisable:
+		return map[string]any{"enable": client.after.Enable}
+	case BulkMove:
+		return map[string]any{"inbound_id": target.Id}
+	}
+	return nil
+}
+
+// checkBulkQuota keeps resellers within their traffic quota when limits are raised
+// and within their client quota when clients are moved into their inbounds.
+func (s *InboundService) checkBulkQuota(req *BulkClientRequest, matched []*bulkClient, target *model.Inbound) error {
+	switch req.Action {
+	case BulkAddTraffic:
+		if req.Value <= 0 {
+			return nil
+		}
+		extra := map[int]int64{}
+		for _, client := range matched {
+			if client.before.TotalGB > 0 {
+				extra[client.inbound.UserId] += req.Value * 1024 * 1024 * 1024
+			}
+		}
+		for userId, bytes := range extra {
+			if err := s.checkResellerQuota(userId, nil, nil, bytes); err != nil {
+				return err
+			}
+		}
+	case BulkMove:
+		var moved []model.Client
+		for _, client := range matched {
+			if client.inbound.UserId != target.UserId {
+				moved = append(moved, client.before)
+			}
+		}
+		if len(moved) > 0 {
+			return s.checkResellerQuota(target.UserId, nil, moved, 0)
+		}
+	}
+	return nil
+}
+
+// syncBulkClients re-adds the changed clients to the running Xray over one API
+// connection. Only changes Xray enforces itself need a sync.
+func (s *InboundService) syncBulkClients(req *BulkClientRequest, matched []*bulkClient, target *model.Inbound) bool {
+	if req.Action == BulkDeviceLimit || req.Action == BulkSetGroup {
+		return false
+	}
+	if p == nil || !p.IsRunning() {
+		return false
+	}
+
+	var clientTraffics []*xray.ClientTraffic
+	emails := make([]string, 0, len(matched))
+	for _, client := range matched {
+		emails = append(emails, client.before.Email)
+	}
+	// Depleted clients are disabled in the traffic table only
+	database.GetDB().Model(xray.ClientTraffic{}).Where("email IN ?", emails).Find(&clientTraffics)
+	active := make(map[string]bool, len(clientTraffics))
+	for _, traffic := range clientTraffics {
+		active[traffic.Email] = traffic.Enable
+	}
+
+	needRestart := false
+	s.xrayApi.Init(p.GetAPIPort())
+	defer s.xrayApi.Close()
+	for _, client := range matched {
+		inbound := client.inbound
+		if client.before.Enable {
+			err := s.xrayApi.RemoveUser(inbound.Tag, client.before.Email)
+			if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("User %s not found.", client.before.Email)) {
+				logger.Debug("Error in deleting client by api:", err)
+				needRestart = true
+			}
+		}
+		if !client.after.Enable || !active[client.before.Email] {
+			continue
+		}
+		if target != nil {
+			inbound = target
+		}
+		cipher := ""
+		if inbound.Protocol == model.Shadowsocks {
+			var settings map[string]any
+			json.Unmarshal([]byte(inbound.Settings), &settings)
+			cipher, _ = settings["method"].(string)
+		}
+		level, provisioned := SpeedLevel(client.after.SpeedProfile())
+		err := s.xrayApi.AddUser(string(inbound.Protocol), inbound.Tag, map[string]any{
+			"email":    client.after.Email,
+			"id":       client.after.ID,
+			"security": client.after.Security,
+			"flow":     client.after.Flow,
+			"password": client.after.Password,
+			"cipher":   cipher,
+			"level":    level,
+		})
+		if err != nil {
+			logger.Debug("Error in adding client by api:", err)
+			needRestart = true
+		} else if !provisioned {
+			// The client is limited only once Xray is restarted with its policy level
+			needRestart = true
+		}
+	}
+	return needRestart
+}
@@ -0,0 +1,406 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/util/common"
+	"x-ui/xray"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DeviceLimitTarget is one client with a device limit, its own or its inbound's, together with
+// the policy that applies to it and what is needed to re-add it through the Xray API.
+type DeviceLimitTarget struct {
+	Email     string
+	InboundId int
+	Limit     int
+	Backend   model.LimitBackend
+	Tag       string
+	Port      int // the firewall backend bans on this port only
+	Protocol  model.Protocol
+	Method    string // cipher of a Shadowsocks inbound
+	Client    model.Client
+	Policy    model.DeviceLimitPolicy
+}
+
+// DeviceLimitService manages the device-limit policies of inbounds and their per-client overrides.
+type DeviceLimitService struct {
+	settingService SettingService
+	auditService   AuditService
+	actor          *model.AuditActor
+}
+
+// DeviceLimitChanges is what one run of the device-limit job changed in its state
+type DeviceLimitChanges struct {
+	States        []*model.DeviceLimitState // created or updated
+	ClearedStates []string                  // emails that are no longer tracked
+	Sightings     []*model.DeviceIPSighting // new or seen again
+	ExpiredIPs    []*model.DeviceIPSighting // offline or forgotten
+}
+
+// ClientDeviceStatus is the device-limit view of one client: its online IPs and whether it is being acted on
+type ClientDeviceStatus struct {
+	Email     string                    `json:"email"`
+	Limit     int                       `json:"limit"` // 0 if neither the client nor its inbound has a device limit
+	Policy    *model.DeviceLimitPolicy  `json:"policy"`
+	State     *model.DeviceLimitState   `json:"state"` // nil while the client is within its limit
+	ActiveIPs []*model.DeviceIPSighting `json:"activeIps"`
+}
+
+// WithActor returns a copy of the service whose changes are audited as made by actor.
+func (s *DeviceLimitService) WithActor(actor *model.AuditActor) *DeviceLimitService {
+	scoped := *s
+	scoped.actor = actor
+	return &scoped
+}
+
+// CheckInbound validates the device-limit backend and policy of an inbound before it is saved
+func (s *DeviceLimitService) CheckInbound(inbound *model.Inbound) error {
+	switch inbound.LimitBackend {
+	case "", model.LimitBackendXray, model.LimitBackendFail2Ban, model.LimitBackendFirewall:
+	default:
+		return common.NewError("invalid device limit backend:", inbound.LimitBackend)
+	}
+	return s.CheckPolicy(&inbound.DeviceLimitPolicy)
+}
+
+// CheckPolicy validates a policy before it is saved with an inbound or as an override
+func (s *DeviceLimitService) CheckPolicy(policy *model.DeviceLimitPolicy) error {
+	switch policy.Action {
+	case "", model.DeviceLimitBlock, model.DeviceLimitKick, model.DeviceLimitThrottle, model.DeviceLimitNotify:
+	default:
+		return common.NewError("invalid device limit action:", policy.Action)
+	}
+	if policy.TTL != 0 && (policy.TTL < 30 || policy.TTL > 86400) {
+		return errors.New("device TTL must be between 30 and 86400 seconds")
+	}
+	if policy.Grace < 0 || policy.Grace > 86400 {
+		return errors.New("device grace period must be between 0 and 86400 seconds")
+	}
+	if policy.Cooldown < 0 || policy.Cooldown > 86400 {
+		return errors.New("device cooldown must be between 0 and 86400 seconds")
+	}
+	if policy.ThrottleSpeed < 0 {
+		return errors.New("device throttle speed can not be negative")
+	}
+	if policy.Action == model.DeviceLimitThrottle && policy.ThrottleSpeed == 0 {
+		return errors.New("the throttle action needs a throttle speed")
+	}
+	return nil
+}
+
+func (s *DeviceLimitService) GetClientPolicies() ([]*model.ClientDevicePolicy, error) {
+	var policies []*model.ClientDevicePolicy
+	err := database.GetDB().Model(model.ClientDevicePolicy{}).Order("email asc").Find(&policies).Error
+	return policies, err
+}
+
+// SaveClientPolicy creates or replaces the override of one client. It reports whether
+// Xray must be restarted to create the policy level of a new throttle speed.
+func (s *DeviceLimitService) SaveClientPolicy(policy *model.ClientDevicePolicy) (bool, error) {
+	policy.Email = strings.TrimSpace(policy.Email)
+	if policy.Email == "" {
+		return false, errors.New("client email can not be empty")
+	}
+	if err := s.CheckPolicy(&policy.DeviceLimitPolicy); err != nil {
+		return false, err
+	}
+
+	db := database.GetDB()
+	existing := &model.ClientDevicePolicy{}
+	err := db.Where("email = ?", policy.Email).First(existing).Error
+	if database.IsNotFound(err) {
+		policy.Id = 0
+		err = db.Create(policy).Error
+		existing = nil
+	} else if err == nil {
+		policy.Id = existing.Id
+		err = db.Save(policy).Error
+	}
+	if err != nil {
+		return false, err
+	}
+	s.auditService.Record(s.actor, "devicePolicy.save", policy.Email, existing, policy)
+	needRestart := policy.GetAction() == model.DeviceLimitThrottle &&
+		(existing == nil || existing.GetAction() != model.DeviceLimitThrottle || existing.ThrottleSpeed != policy.ThrottleSpeed)
+	return needRestart, nil
+}
+
+func (s *DeviceLimitService) DelClientPolicy(email string) error {
+	db := database.GetDB()
+	policy := &model.ClientDevicePolicy{}
+	if err := db.Where("email = ?", email).First(policy).Error; err != nil {
+		return err
+	}
+	err := db.Delete(model.ClientDevicePolicy{}, policy.Id).Error
+	if err == nil {
+		s.auditService.Record(s.actor, "devicePolicy.delete", email, policy, nil)
+	}
+	return err
+}
+
+// ThrottleSpeeds returns the speeds the throttle action may switch clients to,
+// so that Xray has a policy level for each of them.
+func (s *DeviceLimitService) ThrottleSpeeds() ([]int, error) {
+	db := database.GetDB()
+	var speeds []int
+	err := db.Model(model.Inbound{}).Where(deviceLimitedInbounds).
+		Where("device_action = ? AND device_throttle_speed > 0", model.DeviceLimitThrottle).
+		Distinct().Pluck("device_throttle_speed", &speeds).Error
+	if err != nil {
+		return nil, err
+	}
+	var clientSpeeds []int
+	err = db.Model(model.ClientDevicePolicy{}).
+		Where("device_action = ? AND device_throttle_speed > 0", model.DeviceLimitThrottle).
+		Distinct().Pluck("device_throttle_speed", &clientSpeeds).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, speed := range clientSpeeds {
+		if !slices.Contains(speeds, speed) {
+			speeds = append(speeds, speed)
+		}
+	}
+	return speeds, nil
+}
+
+// deviceLimitedInbounds matches the inbounds that have a device limit or a client with its own
+const deviceLimitedInbounds = "(device_limit > 0 OR id IN (SELECT inbound_id FROM clients WHERE device_limit > 0 OR limit_ip > 0))"
+
+// GetTargets returns every enabled client of an enabled inbound that has a device limit,
+// either its own or the inbound's, keyed by email. A client's limitIp, the IP limit of
+// 3x-ui, counts as its own device limit when deviceLimit is not set.
+func (s *DeviceLimitService) GetTargets() (map[string]*DeviceLimitTarget, error) {
+	db := database.GetDB()
+	var inbounds []*model.Inbound
+	err := db.Model(model.Inbound{}).Where(deviceLimitedInbounds).Where("enable = ?", true).Find(&inbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	targets := make(map[string]*DeviceLimitTarget)
+	if len(inbounds) == 0 {
+		return targets, nil
+	}
+
+	overrides := make(map[string]model.DeviceLimitPolicy)
+	policies, err := s.GetClientPolicies()
+	if err != nil {
+		return nil, err
+	}
+	for _, policy := range policies {
+		overrides[policy.Email] = policy.DeviceLimitPolicy
+	}
+
+	// Disabled clients are not in Xray, re-adding them would enable them again
+	var disabledEmails []string
+	err = db.Model(xray.ClientTraffic{}).Where("enable = ?", false).Pluck("email", &disabledEmails).Error
+	if err != nil {
+		return nil, err
+	}
+	disabled := make(map[string]bool, len(disabledEmails))
+	for _, email := range disabledEmails {
+		disabled[email] = true
+	}
+
+	for _, inbound := range inbounds {
+		clients, err := model.LoadClients(db, inbound.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, client := range clients {
+			if client.Email == "" || !client.Enable || disabled[client.Email] {
+				continue
+			}
+			target := newDeviceLimitTarget(inbound, client)
+			if target.Limit <= 0 {
+				continue
+			}
+			if policy, ok := overrides[client.Email]; ok {
+				target.Policy = policy
+			}
+			targets[client.Email] = target
+		}
+	}
+	return targets, nil
+}
+
+// GetTarget returns the target of one client regardless of its inbound's device limit,
+// which is needed to restore a client after the limit was removed.
+func (s *DeviceLimitService) GetTarget(email string) (*DeviceLimitTarget, error) {
+	client := &model.InboundClient{}
+	db := database.GetDB()
+	if err := db.Where("email = ?", email).First(client).Error; err != nil {
+		return nil, err
+	}
+	inbound := &model.Inbound{}
+	if err := db.Model(model.Inbound{}).First(inbound, client.InboundId).Error; err != nil {
+		return nil, err
+	}
+	target := newDeviceLimitTarget(inbound, client)
+	override := &model.ClientDevicePolicy{}
+	err := db.Where("email = ?", email).First(override).Error
+	if err == nil {
+		target.Policy = override.DeviceLimitPolicy
+	} else if !database.IsNotFound(err) {
+		return nil, err
+	}
+	return target, nil
+}
+
+func newDeviceLimitTarget(inbound *model.Inbound, client *model.InboundClient) *DeviceLimitTarget {
+	settings := map[string]any{}
+	json.Unmarshal([]byte(inbound.Settings), &settings)
+	method, _ := settings["method"].(string)
+	limit := inbound.DeviceLimit
+	if client.DeviceLimit > 0 {
+		limit = client.DeviceLimit
+	} else if limit == 0 && client.LimitIP > 0 {
+		// limitIp only stands in when the inbound has no device limit, so clients of
+		// limited inbounds keep the limit they had before limitIp was counted
+		limit = client.LimitIP
+	}
+	backend := inbound.LimitBackend
+	if backend == "" {
+		backend = model.LimitBackendXray
+	}
+	return &DeviceLimitTarget{
+		Email:     client.Email,
+		InboundId: inbound.Id,
+		Limit:     limit,
+		Backend:   backend,
+		Tag:       inbound.Tag,
+		Port:      inbound.Port,
+		Protocol:  inbound.Protocol,
+		Method:    method,
+		Client:    client.ToClient(),
+		Policy:    inbound.DeviceLimitPolicy,
+	}
+}
+
+// XrayUser builds the user map passed to XrayAPI.AddUser. id and password are the
+// credentials to add, which differ from the client's while it is blocked; level selects
+// the speed-limit policy level.
+func (t *DeviceLimitTarget) XrayUser(id string, password string, level int) map[string]any {
+	return map[string]any{
+		"email":    t.Email,
+		"id":       id,
+		"security": t.Client.Security,
+		"flow":     t.Client.Flow,
+		"password": password,
+		"cipher":   t.Method,
+		"level":    level,
+	}
+}
+
+// LoadState returns the persisted state of the device-limit job
+func (s *DeviceLimitService) LoadState() ([]*model.DeviceLimitState, []*model.DeviceIPSighting, error) {
+	db := database.GetDB()
+	var states []*model.DeviceLimitState
+	if err := db.Find(&states).Error; err != nil {
+		return nil, nil, err
+	}
+	var sightings []*model.DeviceIPSighting
+	if err := db.Find(&sightings).Error; err != nil {
+		return nil, nil, err
+	}
+	return states, sightings, nil
+}
+
+// SaveChanges persists the state changed by one run of the device-limit job
+func (s *DeviceLimitService) SaveChanges(changes *DeviceLimitChanges) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		for _, state := range changes.States {
+			if err := tx.Save(state).Error; err != nil {
+				return err
+			}
+		}
+		if len(changes.ClearedStates) > 0 {
+			if err := tx.Where("email IN ?", changes.ClearedStates).Delete(model.DeviceLimitState{}).Error; err != nil {
+				return err
+			}
+		}
+		// Expired IPs are deleted first, an IP that expired and came back in the same run is in both lists
+		for _, sighting := range changes.ExpiredIPs {
+			err := tx.Where("email = ? AND ip = ?", sighting.Email, sighting.IP).Delete(model.DeviceIPSighting{}).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(changes.Sightings) > 0 {
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "email"}, {Name: "ip"}},
+				DoUpdates: clause.AssignmentColumns([]string{"first_seen", "last_seen"}),
+			}).CreateInBatches(changes.Sightings, 100).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetDeviceStatuses returns the clients that have online IPs or are tracked by the
+// device-limit job, or only the given client if email is not empty.
+// The state is saved by the job on every run, so it lags behind by at most one run.
+func (s *DeviceLimitService) GetDeviceStatuses(email string) ([]*ClientDeviceStatus, error) {
+	db := database.GetDB()
+	stateQuery := db.Model(model.DeviceLimitState{})
+	sightingQuery := db.Model(model.DeviceIPSighting{}).Order("first_seen asc")
+	if email != "" {
+		stateQuery = stateQuery.Where("email = ?", email)
+		sightingQuery = sightingQuery.Where("email = ?", email)
+	}
+	var states []*model.DeviceLimitState
+	if err := stateQuery.Find(&states).Error; err != nil {
+		return nil, err
+	}
+	var sightings []*model.DeviceIPSighting
+	if err := sightingQuery.Find(&sightings).Error; err != nil {
+		return nil, err
+	}
+	targets, err := s.GetTargets()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]*ClientDeviceStatus)
+	statusOf := func(email string) *ClientDeviceStatus {
+		status, ok := statuses[email]
+		if !ok {
+			status = &ClientDeviceStatus{Email: email, ActiveIPs: []*model.DeviceIPSighting{}}
+			if target, ok := targets[email]; ok {
+				status.Limit = target.Limit
+				status.Policy = &target.Policy
+			}
+			statuses[email] = status
+		}
+		return status
+	}
+	for _, sighting := range sightings {
+		status := statusOf(sighting.Email)
+		status.ActiveIPs = append(status.ActiveIPs, sighting)
+	}
+	for _, state := range states {
+		if state.Action != "" {
+			statusOf(state.Email).State = state
+		}
+	}
+	if email != "" {
+		statusOf(email)
+	}
+
+	result := make([]*ClientDeviceStatus, 0, len(statuses))
+	for _, status := range statuses {
+		result = append(result, status)
+	}
+	slices.SortFunc(result, func(a, b *ClientDeviceStatus) int { return strings.Compare(a.Email, b.Email) })
+	return result, nil
+}
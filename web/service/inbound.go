@@ -20,6 +20,28 @@ import (
 type InboundService struct {
 	xrayApi xray.XrayAPI
 	tgService TelegramService
+	auditService AuditService
+	trafficStatService TrafficStatService
+	webhookService WebhookService
+	deviceLimitService DeviceLimitService
+	settingService SettingService
+	actor        *model.AuditActor
+}
+
+// WithActor returns a copy of the service whose changes are audited as made by actor.
+func (s *InboundService) WithActor(actor *model.AuditActor) *InboundService {
+	scoped := *s
+	scoped.actor = actor
+	return &scoped
+}
+
+func (s *InboundService) audit(action string, target string, before any, after any) {
+	s.auditService.Record(s.actor, action, target, before, after)
+}
+
+// auditTx records a change made inside the transaction tx
+func (s *InboundService) auditTx(tx *gorm.DB, action string, target string, before any, after any) {
+	s.auditService.RecordTx(tx, s.actor, action, target, before, after)
 }
 
 // 【新增方法】: 用于从外部注入 XrayAPI 实例
@@ -52,6 +74,102 @@ func (s *InboundService) GetAllInbounds() ([]*model.Inbound, error) {
 	return inbounds, nil
 }
 
+// GetInboundsForUser lists the inbounds visible to the given account:
+// resellers only see their own, every other role sees all of them.
+func (s *InboundService) GetInboundsForUser(user *model.User) ([]*model.Inbound, error) {
+	if user != nil && user.Role == model.RoleReseller {
+		return s.GetInbounds(user.Id)
+	}
+	return s.GetAllInbounds()
+}
+
+// GetUserClientEmails returns the emails of all clients in the inbounds owned by userId.
+func (s *InboundService) GetUserClientEmails(userId int) ([]string, error) {
+	db := database.GetDB()
+	var emails []string
+	err := db.Model(xray.ClientTraffic{}).
+		Where("inbound_id IN (?)", db.Model(model.Inbound{}).Select("id").Where("user_id = ?", userId)).
+		Pluck("email", &emails).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return emails, nil
+}
+
+// checkResellerQuota verifies that replacing oldClients with newClients and raising
+// existing limits by extraBytes keeps a reseller within their client count and total
+// traffic quotas. Only growth is rejected, so a reseller over a lowered quota can still
+// edit or shrink their clients. Other roles are not limited.
+func (s *InboundService) checkResellerQuota(userId int, oldClients []model.Client, newClients []model.Client, extraBytes int64) error {
+	db := database.GetDB()
+	user := &model.User{}
+	err := db.Model(model.User{}).Where("id = ?", userId).First(user).Error
+	if database.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if user.Role != model.RoleReseller || (user.MaxClients <= 0 && user.MaxTotalGB <= 0) {
+		return nil
+	}
+
+	addedClients := len(newClients) - len(oldClients)
+	addedBytes := extraBytes
+	oldLimits := make(map[string]int64, len(oldClients))
+	for _, client := range oldClients {
+		oldLimits[clientQuotaKey(client)] = client.TotalGB
+		addedBytes -= client.TotalGB
+	}
+	for _, client := range newClients {
+		addedBytes += client.TotalGB
+	}
+
+	inbounds, err := s.GetInbounds(userId)
+	if err != nil {
+		return err
+	}
+	clientCount := addedClients
+	totalBytes := addedBytes
+	for _, inbound := range inbounds {
+		clients, err := s.GetClients(inbound)
+		if err != nil {
+			return err
+		}
+		clientCount += len(clients)
+		for _, client := range clients {
+			totalBytes += client.TotalGB
+		}
+	}
+
+	if user.MaxClients > 0 && addedClients > 0 && clientCount > user.MaxClients {
+		return common.NewErrorf("client quota exceeded: %d/%d", clientCount, user.MaxClients)
+	}
+	if user.MaxTotalGB > 0 {
+		for _, client := range newClients {
+			// An unlimited client would make the traffic quota meaningless
+			if oldLimit, ok := oldLimits[clientQuotaKey(client)]; client.TotalGB <= 0 && (!ok || oldLimit > 0) {
+				return common.NewError("traffic quota requires a traffic limit for client:", client.Email)
+			}
+		}
+		maxBytes := int64(user.MaxTotalGB) * 1024 * 1024 * 1024
+		if addedBytes > 0 && totalBytes > maxBytes {
+			return common.NewErrorf("traffic quota exceeded: %.2f GB of %d GB", float64(totalBytes)/1024/1024/1024, user.MaxTotalGB)
+		}
+	}
+	return nil
+}
+
+// clientQuotaKey identifies a client across an edit, its UUID or password stays when the email is renamed
+func clientQuotaKey(client model.Client) string {
+	if client.ID != "" {
+		return "id:" + client.ID
+	}
+	if client.Password != "" {
+		return "password:" + client.Password
+	}
+	return "email:" + client.Email
+}
+
 func (s *InboundService) checkPortExist(listen string, port int, ignoreId int) (bool, error) {
 	db := database.GetDB()
 	if listen == "" || listen == "0.0.0.0" || listen == "::" || listen == "::0" {
@@ -99,11 +217,7 @@ func (s *InboundService) GetClients(inbound *model.Inbound) ([]model.Client, err
 func (s *InboundService) getAllEmails() ([]string, error) {
 	db := database.GetDB()
 	var emails []string
-	err := db.Raw(`
-		SELECT JSON_EXTRACT(client.value, '$.email')
-		FROM inbounds,
-			JSON_EACH(JSON_EXTRACT(inbounds.settings, '$.clients')) AS client
-		`).Scan(&emails).Error
+	err := db.Model(model.InboundClient{}).Pluck("email", &emails).Error
 	if err != nil {
 		return nil, err
 	}
@@ -184,11 +298,24 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 		return inbound, false, common.NewError("Duplicate email:", existEmail)
 	}
 
+	// 中文注释：检查设备限制策略
+	if err = s.deviceLimitService.CheckInbound(inbound); err != nil {
+		return inbound, false, err
+	}
+
 	// 中文注释：获取入站规则中的客户端信息
 	clients, err := s.GetClients(inbound)
 	if err != nil {
 		return inbound, false, err
 	}
+	// 中文注释：设置了限速档位时，把客户端的限速对齐到档位，下面写回 settings
+	if err = s.snapSpeeds(clients); err != nil {
+		return inbound, false, err
+	}
+	// 中文注释：代理商新建或导入的入站同样受客户端数量和流量配额限制
+	if err = s.checkResellerQuota(inbound.UserId, nil, clients, 0); err != nil {
+		return inbound, false, err
+	}
 
 	// 中文注释：确保客户端设置中包含创建和更新时间戳
 	if len(clients) > 0 {
@@ -307,7 +434,8 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 	}()
 
 	// 中文注释：保存入站信息到数据库 (此时 inbound 对象已包含我们手动设置的 ID)
-	err = tx.Save(inbound).Error
+	// 中文注释：必须使用 Create，Save 对不存在的 ID 会跳过钩子插入，客户端就不会拆分到 clients 表
+	err = tx.Create(inbound).Error
 	if err == nil {
 		if len(inbound.ClientStats) == 0 {
 			for _, client := range clients {
@@ -330,6 +458,10 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 		err1 = s.xrayApi.AddInbound(inboundJson)
 		if err1 == nil {
 			logger.Debug("New inbound added by api:", inbound.Tag)
+			// 中文注释：入站配置不带客户端的 level，有限速的客户端通过 API 带上 level 重新添加
+			if s.addSpeedLevels(inbound, clients) {
+				needRestart = true
+			}
 		} else {
 			// 中文注释：如果 API 调用失败，则标记需要重启面板以应用更改
 			logger.Debug("Unable to add inbound by api:", err1)
@@ -337,6 +469,17 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 		}
 		s.xrayApi.Close()
 	}
+	// 中文注释：throttle 使用的限速等级需要重启 Xray 才会写入 policy
+	if inbound.DeviceLimit > 0 && inbound.GetAction() == model.DeviceLimitThrottle {
+		needRestart = true
+	}
+
+	s.auditTx(tx, "inbound.add", inbound.Tag, nil, inbound)
+	if err == nil {
+		for i := range clients {
+			s.webhookService.Emit(tx, model.WebhookEventClientCreated, webhookClientData(inbound.Id, &clients[i]))
+		}
+	}
 
 	// 中文注释：返回创建好的入站对象、是否需要重启以及错误信息
 	return inbound, needRestart, err
@@ -362,11 +505,6 @@ func (s *InboundService) DelInbound(id int) (bool, error) {
 		logger.Debug("No enabled inbound founded to removing by api", tag)
 	}
 
-	// Delete client traffics of inbounds
-	err := db.Where("inbound_id = ?", id).Delete(xray.ClientTraffic{}).Error
-	if err != nil {
-		return false, err
-	}
 	inbound, err := s.GetInbound(id)
 	if err != nil {
 		return false, err
@@ -375,14 +513,33 @@ func (s *InboundService) DelInbound(id int) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	for _, client := range clients {
-		err := s.DelClientIPs(db, client.Email)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Delete client traffics of inbounds
+		err := tx.Where("inbound_id = ?", id).Delete(xray.ClientTraffic{}).Error
 		if err != nil {
-			return false, err
+			return err
 		}
-	}
-
-	return needRestart, db.Delete(model.Inbound{}, id).Error
+		for _, client := range clients {
+			err := s.DelClientIPs(tx, client.Email)
+			if err != nil {
+				return err
+			}
+		}
+		err = tx.Where("inbound_id = ?", id).Delete(model.InboundClient{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Delete(model.Inbound{}, id).Error
+		if err != nil {
+			return err
+		}
+		s.auditTx(tx, "inbound.delete", inbound.Tag, inbound, nil)
+		for i := range clients {
+			s.webhookService.Emit(tx, model.WebhookEventClientDeleted, webhookClientData(id, &clients[i]))
+		}
+		return nil
+	})
+	return needRestart, err
 }
 
 func (s *InboundService) GetInbound(id int) (*model.Inbound, error) {
@@ -404,12 +561,30 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 		return inbound, false, common.NewError("Port already exists:", inbound.Port)
 	}
 
+	if err = s.deviceLimitService.CheckInbound(inbound); err != nil {
+		return inbound, false, err
+	}
+
 	oldInbound, err := s.GetInbound(inbound.Id)
 	if err != nil {
 		return inbound, false, err
 	}
 
+	// 中文注释：替换整个客户端列表时，按新旧列表的差值检查代理商配额
+	oldClients, err := s.GetClients(oldInbound)
+	if err != nil {
+		return inbound, false, err
+	}
+	newClients, err := s.GetClients(inbound)
+	if err != nil {
+		return inbound, false, err
+	}
+	if err = s.checkResellerQuota(oldInbound.UserId, oldClients, newClients, 0); err != nil {
+		return inbound, false, err
+	}
+
 	tag := oldInbound.Tag
+	before := *oldInbound
 
 	db := database.GetDB()
 	tx := db.Begin()
@@ -455,6 +630,10 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 				}
 			}
 		}
+		speedTiers, err2 := s.settingService.GetSpeedTiers()
+		if err2 != nil {
+			return inbound, false, err2
+		}
 		var newSettings map[string]any
 		if err2 := json.Unmarshal([]byte(inbound.Settings), &newSettings); err2 == nil && newSettings != nil {
 			now := time.Now().Unix() * 1000
@@ -462,6 +641,12 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 				for i := range nSlice {
 					if m, ok2 := nSlice[i].(map[string]any); ok2 {
 						email, _ := m["email"].(string)
+						// Speed limits are aligned to the speed tiers, if any
+						for _, key := range []string{"speedLimit", "uploadLimit", "downloadLimit"} {
+							if speed, ok3 := m[key].(float64); ok3 {
+								m[key] = model.SnapSpeed(speedTiers, int(speed))
+							}
+						}
 						if _, ok3 := m["created_at"]; !ok3 {
 							if v, ok4 := emailToCreated[email]; ok4 && v > 0 {
 								m["created_at"] = v
@@ -494,6 +679,8 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 	oldInbound.ExpiryTime = inbound.ExpiryTime
                  // 中文注释：确保在更新数据时，将前端传来的 deviceLimit 值赋给从数据库中读出的旧对象。
 	oldInbound.DeviceLimit = inbound.DeviceLimit
+	oldInbound.DeviceLimitPolicy = inbound.DeviceLimitPolicy
+	oldInbound.LimitBackend = inbound.LimitBackend
 	oldInbound.Listen = inbound.Listen
 	oldInbound.Port = inbound.Port
 	oldInbound.Protocol = inbound.Protocol
@@ -520,6 +707,10 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 			err2 = s.xrayApi.AddInbound(inboundJson)
 			if err2 == nil {
 				logger.Debug("Updated inbound added by api:", oldInbound.Tag)
+				// 中文注释：入站配置不带客户端的 level，有限速的客户端通过 API 带上 level 重新添加
+				if clients, err3 := s.GetClients(oldInbound); err3 != nil || s.addSpeedLevels(oldInbound, clients) {
+					needRestart = true
+				}
 			} else {
 				logger.Debug("Unable to update inbound by api:", err2)
 				needRestart = true
@@ -527,8 +718,17 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 		}
 	}
 	s.xrayApi.Close()
+	// 中文注释：throttle 的限速等级变化后需要重启 Xray 才会写入 policy
+	if oldInbound.DeviceLimit > 0 && oldInbound.GetAction() == model.DeviceLimitThrottle &&
+		(before.DeviceLimit == 0 || before.GetAction() != model.DeviceLimitThrottle || before.ThrottleSpeed != oldInbound.ThrottleSpeed) {
+		needRestart = true
+	}
 
-	return inbound, needRestart, tx.Save(oldInbound).Error
+	err = tx.Save(oldInbound).Error
+	if err == nil {
+		s.auditTx(tx, "inbound.update", oldInbound.Tag, &before, oldInbound)
+	}
+	return inbound, needRestart, err
 }
 
 func (s *InboundService) updateClientTraffics(tx *gorm.DB, oldInbound *model.Inbound, newInbound *model.Inbound) error {
@@ -588,6 +788,11 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 		return false, err
 	}
 
+	// Speed limits are aligned to the speed tiers before they are written back below
+	if err = s.snapSpeeds(clients); err != nil {
+		return false, err
+	}
+
 	interfaceClients := settings["clients"].([]any)
 	// Add timestamps for new clients being appended
 	nowTs := time.Now().Unix() * 1000
@@ -602,6 +807,9 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
             // clients[i] 和 interfaceClients[i] 是一一对应的
             // 我们从强类型的 clients[i] 对象中取出 SpeedLimit，赋值给弱类型的 map
 			cm["speedLimit"] = clients[i].SpeedLimit // 中文注释: 确保批量添加时，speedLimit 的值也被写入数据库。
+			cm["uploadLimit"] = clients[i].UploadLimit
+			cm["downloadLimit"] = clients[i].DownloadLimit
+			cm["burstLimit"] = clients[i].BurstLimit
 			
 			interfaceClients[i] = cm
 		}
@@ -619,6 +827,11 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 		return false, err
 	}
 
+	err = s.checkResellerQuota(oldInbound.UserId, nil, clients, 0)
+	if err != nil {
+		return false, err
+	}
+
 	// Secure client ID
 	for _, client := range clients {
 		switch oldInbound.Protocol {
@@ -643,17 +856,8 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 		return false, err
 	}
 
-	oldClients := oldSettings["clients"].([]any)
-	oldClients = append(oldClients, interfaceClients...)
-
-	oldSettings["clients"] = oldClients
-
-	newSettings, err := json.MarshalIndent(oldSettings, "", "  ")
-	if err != nil {
-		return false, err
-	}
-
-	oldInbound.Settings = string(newSettings)
+	// The new clients are appended after the existing ones
+	position := len(oldSettings["clients"].([]any))
 
 	db := database.GetDB()
 	tx := db.Begin()
@@ -678,6 +882,7 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 				}
 
 				// 中文注释: 在这里为 API 调用添加 speedLimit 参数。
+				level, provisioned := SpeedLevel(client.SpeedProfile())
 					clientMap := map[string]any{
 					"email":    client.Email,
 					"id":       client.ID,
@@ -687,12 +892,16 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 					"cipher":   cipher,
 					
 					// Xray-core 会将这个值作为 level，然后去 policy 中寻找对应的限速策略。
-					"level":    client.SpeedLimit,
+					"level":    level,
 				}
 				err1 := s.xrayApi.AddUser(string(oldInbound.Protocol), oldInbound.Tag, clientMap)
 				
 				if err1 == nil {
 					logger.Debug("Client added by api:", client.Email)
+					// 中文注释: 运行中的 Xray 没有这个 level 时，客户端要重启后才会限速
+					if !provisioned {
+						needRestart = true
+					}
 				} else {
 					logger.Debug("Error in adding client by api:", err1)
 					needRestart = true
@@ -704,7 +913,18 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 	}
 	s.xrayApi.Close()
 
-	return needRestart, tx.Save(oldInbound).Error
+	for i := range interfaceClients {
+		entry, _ := interfaceClients[i].(map[string]any)
+		err = model.SaveClient(tx, oldInbound.Id, position+i, entry)
+		if err != nil {
+			return needRestart, err
+		}
+	}
+	for i := range clients {
+		s.auditTx(tx, "client.add", clients[i].Email, nil, &clients[i])
+		s.webhookService.Emit(tx, model.WebhookEventClientCreated, webhookClientData(data.Id, &clients[i]))
+	}
+	return needRestart, nil
 }
 
 func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool, error) {
@@ -730,11 +950,15 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 
 	interfaceClients := settings["clients"].([]any)
 	var newClients []any
+	var deletedClient map[string]any
+	position := -1
 	needApiDel := false
-	for _, client := range interfaceClients {
+	for index, client := range interfaceClients {
 		c := client.(map[string]any)
 		c_id := c[client_key].(string)
 		if c_id == clientId {
+			deletedClient = c
+			position = index
 			email, _ = c["email"].(string)
 			needApiDel, _ = c["enable"].(bool)
 		} else {
@@ -746,21 +970,7 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 		return false, common.NewError("no client remained in Inbound")
 	}
 
-	settings["clients"] = newClients
-	newSettings, err := json.MarshalIndent(settings, "", "  ")
-	if err != nil {
-		return false, err
-	}
-
-	oldInbound.Settings = string(newSettings)
-
 	db := database.GetDB()
-
-	err = s.DelClientIPs(db, email)
-	if err != nil {
-		logger.Error("Error in delete client IPs")
-		return false, err
-	}
 	needRestart := false
 
 	if len(email) > 0 {
@@ -770,11 +980,6 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 			logger.Error("Get stats error")
 			return false, err
 		}
-		err = s.DelClientStat(db, email)
-		if err != nil {
-			logger.Error("Delete stats Data Error")
-			return false, err
-		}
 		if needApiDel && notDepleted {
 			s.xrayApi.Init(p.GetAPIPort())
 			err1 := s.xrayApi.RemoveUser(oldInbound.Tag, email)
@@ -792,7 +997,30 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 			s.xrayApi.Close()
 		}
 	}
-	return needRestart, db.Save(oldInbound).Error
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := s.DelClientIPs(tx, email)
+		if err != nil {
+			logger.Error("Error in delete client IPs")
+			return err
+		}
+		if len(email) > 0 {
+			err = s.DelClientStat(tx, email)
+			if err != nil {
+				logger.Error("Delete stats Data Error")
+				return err
+			}
+		}
+		if position >= 0 {
+			err = model.DeleteClient(tx, oldInbound.Id, position)
+			if err != nil {
+				return err
+			}
+		}
+		s.auditTx(tx, "client.delete", email, deletedClient, nil)
+		s.webhookService.Emit(tx, model.WebhookEventClientDeleted, map[string]any{"email": email, "inboundId": inboundId})
+		return nil
+	})
+	return needRestart, err
 }
 
 func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId string) (bool, error) {
@@ -800,6 +1028,10 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 	if err != nil {
 		return false, err
 	}
+	// Speed limits are aligned to the speed tiers before they are written back below
+	if err = s.snapSpeeds(clients); err != nil {
+		return false, err
+	}
 
 	var settings map[string]any
 	err = json.Unmarshal([]byte(data.Settings), &settings)
@@ -847,6 +1079,12 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 		return false, common.NewError("empty client ID")
 	}
 
+	// Raising the traffic limit or making the client unlimited counts against a reseller's quota
+	err = s.checkResellerQuota(oldInbound.UserId, oldClients[clientIndex:clientIndex+1], clients[:1], 0)
+	if err != nil {
+		return false, err
+	}
+
 	if len(clients[0].Email) > 0 && clients[0].Email != oldEmail {
 		existEmail, err := s.checkEmailsExistForClients(clients)
 		if err != nil {
@@ -884,19 +1122,13 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
             // clients[0] 是从请求中解码出来的强类型对象，它的 SpeedLimit 字段是有值的。
             // 我们把它手动赋值给即将用于保存的 newMap。
 			newMap["speedLimit"] = clients[0].SpeedLimit // 中文注释：确保将 speedLimit 的值写入将要保存到数据库的 map 中。
+			newMap["uploadLimit"] = clients[0].UploadLimit
+			newMap["downloadLimit"] = clients[0].DownloadLimit
+			newMap["burstLimit"] = clients[0].BurstLimit
 			
 			interfaceClients[0] = newMap
 		}
 	}
-	settingsClients[clientIndex] = interfaceClients[0]
-	oldSettings["clients"] = settingsClients
-
-	newSettings, err := json.MarshalIndent(oldSettings, "", "  ")
-	if err != nil {
-		return false, err
-	}
-
-	oldInbound.Settings = string(newSettings)
 	db := database.GetDB()
 	tx := db.Begin()
 
@@ -954,6 +1186,7 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 			}
 
 			// 中文注释: 同样，在更新用户时，也必须把新的 speedLimit 值通过 API 传给 Xray-core。
+			level, provisioned := SpeedLevel(clients[0].SpeedProfile())
 			clientMap := map[string]any{
 				"email":    clients[0].Email,
 				"id":       clients[0].ID,
@@ -962,12 +1195,16 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 				"password": clients[0].Password,
 				"cipher":   cipher,
 				
-				"level":    clients[0].SpeedLimit,
+				"level":    level,
 			}
 			err1 := s.xrayApi.AddUser(string(oldInbound.Protocol), oldInbound.Tag, clientMap)
 			
 			if err1 == nil {
 				logger.Debug("Client edited by api:", clients[0].Email)
+				// 中文注释: 运行中的 Xray 没有这个 level 时，客户端要重启后才会限速
+				if !provisioned {
+					needRestart = true
+				}
 			} else {
 				logger.Debug("Error in adding client by api:", err1)
 				needRestart = true
@@ -978,7 +1215,15 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 		logger.Debug("Client old email not found")
 		needRestart = true
 	}
-	return needRestart, tx.Save(oldInbound).Error
+	entry, _ := interfaceClients[0].(map[string]any)
+	err = model.SaveClient(tx, oldInbound.Id, clientIndex, entry)
+	if err == nil {
+		s.auditTx(tx, "client.update", clients[0].Email, &oldClients[clientIndex], &clients[0])
+		if oldClients[clientIndex].Enable && !clients[0].Enable {
+			s.webhookService.Emit(tx, model.WebhookEventClientDisabled, webhookClientData(data.Id, &clients[0]))
+		}
+	}
+	return needRestart, err
 }
 
 func (s *InboundService) AddTraffic(inboundTraffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) (error, bool) {
@@ -1001,6 +1246,7 @@ func (s *InboundService) AddTraffic(inboundTraffics []*xray.Traffic, clientTraff
 	if err != nil {
 		return err, false
 	}
+	s.trafficStatService.recordInboundTraffic(tx, inboundTraffics, clientTraffics)
 
 	needRestart0, count, err := s.autoRenewClients(tx)
 	if err != nil {
@@ -1247,6 +1493,15 @@ func (s *InboundService) autoRenewClients(tx *gorm.DB) (bool, int64, error) {
 	if err != nil {
 		return false, 0, err
 	}
+	// A renewal starts a new billing cycle for the quota and expiry alerts
+	renewed := make([]string, 0, len(traffics))
+	for _, traffic := range traffics {
+		renewed = append(renewed, traffic.Email)
+	}
+	err = resetClientAlerts(tx, renewed)
+	if err != nil {
+		return false, 0, err
+	}
 	if p != nil {
 		err1 = s.xrayApi.Init(p.GetAPIPort())
 		if err1 != nil {
@@ -1289,7 +1544,7 @@ func (s *InboundService) disableInvalidInbounds(tx *gorm.DB) (bool, int64, error
 		s.xrayApi.Close()
 	}
 
-	result := tx.Model(model.Inbound{}).
+	result := tx.Model(&model.Inbound{}).
 		Where("((total > 0 and up + down >= total) or (expiry_time > 0 and expiry_time <= ?)) and enable = ?", now, true).
 		Update("enable", false)
 	err := result.Error
@@ -1301,20 +1556,25 @@ func (s *InboundService) disableInvalidClients(tx *gorm.DB) (bool, int64, error)
 	now := time.Now().Unix() * 1000
 	needRestart := false
 
-	if p != nil {
-		var results []struct {
-			Tag   string
-			Email string
-		}
+	var results []struct {
+		Tag        string
+		InboundId  int
+		Email      string
+		Up         int64
+		Down       int64
+		Total      int64
+		ExpiryTime int64
+	}
+	err := tx.Table("inbounds").
+		Select("inbounds.tag, client_traffics.inbound_id, client_traffics.email, client_traffics.up, client_traffics.down, client_traffics.total, client_traffics.expiry_time").
+		Joins("JOIN client_traffics ON inbounds.id = client_traffics.inbound_id").
+		Where("((client_traffics.total > 0 AND client_traffics.up + client_traffics.down >= client_traffics.total) OR (client_traffics.expiry_time > 0 AND client_traffics.expiry_time <= ?)) AND client_traffics.enable = ?", now, true).
+		Scan(&results).Error
+	if err != nil {
+		return false, 0, err
+	}
 
-		err := tx.Table("inbounds").
-			Select("inbounds.tag, client_traffics.email").
-			Joins("JOIN client_traffics ON inbounds.id = client_traffics.inbound_id").
-			Where("((client_traffics.total > 0 AND client_traffics.up + client_traffics.down >= client_traffics.total) OR (client_traffics.expiry_time > 0 AND client_traffics.expiry_time <= ?)) AND client_traffics.enable = ?", now, true).
-			Scan(&results).Error
-		if err != nil {
-			return false, 0, err
-		}
+	if p != nil && len(results) > 0 {
 		s.xrayApi.Init(p.GetAPIPort())
 		for _, result := range results {
 			err1 := s.xrayApi.RemoveUser(result.Tag, result.Email)
@@ -1324,12 +1584,8 @@ func (s *InboundService) disableInvalidClients(tx *gorm.DB) (bool, int64, error)
 				if strings.Contains(err1.Error(), fmt.Sprintf("User %s not found.", result.Email)) {
 					logger.Debug("User is already disabled. Nothing to do more...")
 				} else {
-					if strings.Contains(err1.Error(), fmt.Sprintf("User %s not found.", result.Email)) {
-						logger.Debug("User is already disabled. Nothing to do more...")
-					} else {
-						logger.Debug("Error in disabling client by api:", err1)
-						needRestart = true
-					}
+					logger.Debug("Error in disabling client by api:", err1)
+					needRestart = true
 				}
 			}
 		}
@@ -1338,8 +1594,24 @@ func (s *InboundService) disableInvalidClients(tx *gorm.DB) (bool, int64, error)
 	result := tx.Model(xray.ClientTraffic{}).
 		Where("((total > 0 and up + down >= total) or (expiry_time > 0 and expiry_time <= ?)) and enable = ?", now, true).
 		Update("enable", false)
-	err := result.Error
+	err = result.Error
 	count := result.RowsAffected
+	if err == nil {
+		for _, client := range results {
+			event := model.WebhookEventClientDepleted
+			if client.ExpiryTime > 0 && client.ExpiryTime <= now {
+				event = model.WebhookEventClientExpired
+			}
+			s.webhookService.Emit(tx, event, map[string]any{
+				"email":      client.Email,
+				"inboundId":  client.InboundId,
+				"up":         client.Up,
+				"down":       client.Down,
+				"total":      client.Total,
+				"expiryTime": client.ExpiryTime,
+			})
+		}
+	}
 	return needRestart, count, err
 }
 
@@ -1358,11 +1630,7 @@ func (s *InboundService) MigrationRemoveOrphanedTraffics() {
 	db := database.GetDB()
 	db.Exec(`
 		DELETE FROM client_traffics
-		WHERE email NOT IN (
-			SELECT JSON_EXTRACT(client.value, '$.email')
-			FROM inbounds,
-				JSON_EACH(JSON_EXTRACT(inbounds.settings, '$.clients')) AS client
-		)
+		WHERE email NOT IN (SELECT email FROM clients)
 	`)
 }
 
@@ -1804,6 +2072,7 @@ func (s *InboundService) ResetClientTrafficByEmail(clientEmail string) error {
 	if err != nil {
 		return err
 	}
+	s.audit("client.resetTraffic", clientEmail, nil, nil)
 	return nil
 }
 
@@ -1836,6 +2105,7 @@ func (s *InboundService) ResetClientTraffic(id int, clientEmail string) (bool, e
 					}
 					cipher = oldSettings["method"].(string)
 				}
+				level, provisioned := SpeedLevel(client.SpeedProfile())
 				err1 := s.xrayApi.AddUser(string(inbound.Protocol), inbound.Tag, map[string]any{
 					"email":    client.Email,
 					"id":       client.ID,
@@ -1843,9 +2113,13 @@ func (s *InboundService) ResetClientTraffic(id int, clientEmail string) (bool, e
 					"flow":     client.Flow,
 					"password": client.Password,
 					"cipher":   cipher,
+					"level":    level,
 				})
 				if err1 == nil {
 					logger.Debug("Client enabled due to reset traffic:", clientEmail)
+					if !provisioned {
+						needRestart = true
+					}
 				} else {
 					logger.Debug("Error in enabling client by api:", err1)
 					needRestart = true
@@ -1856,6 +2130,7 @@ func (s *InboundService) ResetClientTraffic(id int, clientEmail string) (bool, e
 		}
 	}
 
+	before := *traffic
 	traffic.Up = 0
 	traffic.Down = 0
 	traffic.Enable = true
@@ -1865,6 +2140,7 @@ func (s *InboundService) ResetClientTraffic(id int, clientEmail string) (bool, e
 	if err != nil {
 		return false, err
 	}
+	s.audit("client.resetTraffic", clientEmail, &before, traffic)
 
 	return needRestart, nil
 }
@@ -1884,17 +2160,23 @@ func (s *InboundService) ResetAllClientTraffics(id int) error {
 		Updates(map[string]any{"enable": true, "up": 0, "down": 0})
 
 	err := result.Error
+	if err == nil {
+		s.audit("inbound.resetClientTraffics", strconv.Itoa(id), nil, map[string]any{"clients": result.RowsAffected})
+	}
 	return err
 }
 
 func (s *InboundService) ResetAllTraffics() error {
 	db := database.GetDB()
 
-	result := db.Model(model.Inbound{}).
+	result := db.Model(&model.Inbound{}).
 		Where("user_id > ?", 0).
 		Updates(map[string]any{"up": 0, "down": 0})
 
 	err := result.Error
+	if err == nil {
+		s.audit("inbound.resetAllTraffics", "*", nil, map[string]any{"inbounds": result.RowsAffected})
+	}
 	return err
 }
 
@@ -1973,32 +2255,22 @@ func (s *InboundService) DelDepletedClients(id int) (err error) {
 		return err
 	}
 
+	for _, depletedClient := range depletedClients {
+		s.auditTx(tx, "client.deleteDepleted", strconv.Itoa(depletedClient.InboundId), map[string]any{"emails": depletedClient.Email}, nil)
+		for _, email := range strings.Split(depletedClient.Email, ",") {
+			s.webhookService.Emit(tx, model.WebhookEventClientDeleted, map[string]any{"email": email, "inboundId": depletedClient.InboundId})
+		}
+	}
 	return nil
 }
 
 func (s *InboundService) GetClientTrafficTgBot(tgId int64) ([]*xray.ClientTraffic, error) {
 	db := database.GetDB()
-	var inbounds []*model.Inbound
-
-	// Retrieve inbounds where settings contain the given tgId
-	err := db.Model(model.Inbound{}).Where("settings LIKE ?", fmt.Sprintf(`%%"tgId": %d%%`, tgId)).Find(&inbounds).Error
-	if err != nil && err != gorm.ErrRecordNotFound {
-		logger.Errorf("Error retrieving inbounds with tgId %d: %v", tgId, err)
-		return nil, err
-	}
-
 	var emails []string
-	for _, inbound := range inbounds {
-		clients, err := s.GetClients(inbound)
-		if err != nil {
-			logger.Errorf("Error retrieving clients for inbound %d: %v", inbound.Id, err)
-			continue
-		}
-		for _, client := range clients {
-			if client.TgID == tgId {
-				emails = append(emails, client.Email)
-			}
-		}
+	err := db.Model(model.InboundClient{}).Where("tg_id = ? AND email != ''", tgId).Pluck("email", &emails).Error
+	if err != nil {
+		logger.Errorf("Error retrieving clients with tgId %d: %v", tgId, err)
+		return nil, err
 	}
 
 	var traffics []*xray.ClientTraffic
@@ -2043,6 +2315,7 @@ func (s *InboundService) UpdateClientTrafficByEmail(email string, upload int64,
 		logger.Warningf("Error updating ClientTraffic with email %s: %v", email, err)
 		return err
 	}
+	s.audit("client.updateTraffic", email, nil, map[string]any{"up": upload, "down": download})
 	return nil
 }
 
@@ -2050,13 +2323,9 @@ func (s *InboundService) GetClientTrafficByID(id string) ([]xray.ClientTraffic,
 	db := database.GetDB()
 	var traffics []xray.ClientTraffic
 
-	err := db.Model(xray.ClientTraffic{}).Where(`email IN(
-		SELECT JSON_EXTRACT(client.value, '$.email') as email
-		FROM inbounds,
-	  	JSON_EACH(JSON_EXTRACT(inbounds.settings, '$.clients')) AS client
-		WHERE
-	  	JSON_EXTRACT(client.value, '$.id') in (?)
-		)`, id).Find(&traffics).Error
+	err := db.Model(xray.ClientTraffic{}).
+		Where("email IN (?)", db.Model(model.InboundClient{}).Select("email").Where("client_id = ?", id)).
+		Find(&traffics).Error
 
 	if err != nil {
 		logger.Debug(err)
@@ -2067,41 +2336,24 @@ func (s *InboundService) GetClientTrafficByID(id string) ([]xray.ClientTraffic,
 
 func (s *InboundService) SearchClientTraffic(query string) (traffic *xray.ClientTraffic, err error) {
 	db := database.GetDB()
-	inbound := &model.Inbound{}
+	client := &model.InboundClient{}
 	traffic = &xray.ClientTraffic{}
 
-	// Search for inbound settings that contain the query
-	err = db.Model(model.Inbound{}).Where("settings LIKE ?", "%\""+query+"\"%").First(inbound).Error
+	// Search for the client whose ID or password is the query
+	err = db.Model(model.InboundClient{}).
+		Where("(client_id = ? OR password = ?) AND email != ''", query, query).
+		First(client).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			logger.Warningf("Inbound settings containing query %s not found: %v", query, err)
+			logger.Warningf("Client with ID or password %s not found: %v", query, err)
 			return nil, err
 		}
-		logger.Errorf("Error searching for inbound settings with query %s: %v", query, err)
-		return nil, err
-	}
-
-	traffic.InboundId = inbound.Id
-
-	// Unmarshal settings to get clients
-	settings := map[string][]model.Client{}
-	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
-		logger.Errorf("Error unmarshalling inbound settings for inbound ID %d: %v", inbound.Id, err)
+		logger.Errorf("Error searching for client with query %s: %v", query, err)
 		return nil, err
 	}
 
-	clients := settings["clients"]
-	for _, client := range clients {
-		if (client.ID == query || client.Password == query) && client.Email != "" {
-			traffic.Email = client.Email
-			break
-		}
-	}
-
-	if traffic.Email == "" {
-		logger.Warningf("No client found with query %s in inbound ID %d", query, inbound.Id)
-		return nil, gorm.ErrRecordNotFound
-	}
+	traffic.InboundId = client.InboundId
+	traffic.Email = client.Email
 
 	// Retrieve ClientTraffic based on the found email
 	err = db.Model(xray.ClientTraffic{}).Where("email = ?", traffic.Email).First(traffic).Error
@@ -2137,6 +2389,7 @@ func (s *InboundService) ClearClientIps(clientEmail string) error {
 	if err != nil {
 		return err
 	}
+	s.audit("client.clearIps", clientEmail, nil, nil)
 	return nil
 }
 
@@ -2298,7 +2551,7 @@ func (s *InboundService) MigrationRequirements() {
 		}
 		stream["externalProxy"] = reverses
 		newStream, _ := json.MarshalIndent(stream, " ", "  ")
-		tx.Model(model.Inbound{}).Where("id = ?", ep.Id).Update("stream_settings", newStream)
+		tx.Model(&model.Inbound{}).Where("id = ?", ep.Id).Update("stream_settings", newStream)
 	}
 
 	err = tx.Raw(`UPDATE inbounds
@@ -2365,3 +2618,59 @@ func (s *InboundService) FilterAndSortClientEmails(emails []string) ([]string, [
 
 	return validEmails, extraEmails, nil
 }
+
+// snapSpeeds aligns the speed limits of the clients to the speed tiers. Every tier is a
+// policy level of the running Xray, so a snapped client can be updated through the API.
+func (s *InboundService) snapSpeeds(clients []model.Client) error {
+	tiers, err := s.settingService.GetSpeedTiers()
+	if err != nil {
+		return err
+	}
+	for i := range clients {
+		clients[i].SnapSpeeds(tiers)
+	}
+	return nil
+}
+
+// addSpeedLevels re-adds the enabled speed limited clients of an inbound that was just added
+// through the API, whose config carries no client levels, with their policy levels. It reports
+// whether Xray must be restarted for a client to get its speed limit. The API must be initialized.
+func (s *InboundService) addSpeedLevels(inbound *model.Inbound, clients []model.Client) bool {
+	cipher := ""
+	if inbound.Protocol == model.Shadowsocks {
+		var settings map[string]any
+		json.Unmarshal([]byte(inbound.Settings), &settings)
+		cipher, _ = settings["method"].(string)
+	}
+	needRestart := false
+	for _, client := range clients {
+		profile := client.SpeedProfile()
+		if !client.Enable || client.Email == "" || profile.IsZero() {
+			continue
+		}
+		level, provisioned := SpeedLevel(profile)
+		if !provisioned {
+			needRestart = true
+			continue
+		}
+		if err := s.xrayApi.RemoveUser(inbound.Tag, client.Email); err != nil {
+			logger.Debug("Unable to remove client by api:", err)
+			needRestart = true
+			continue
+		}
+		err := s.xrayApi.AddUser(string(inbound.Protocol), inbound.Tag, map[string]any{
+			"email":    client.Email,
+			"id":       client.ID,
+			"security": client.Security,
+			"flow":     client.Flow,
+			"password": client.Password,
+			"cipher":   cipher,
+			"level":    level,
+		})
+		if err != nil {
+			logger.Debug("Unable to add client with its level by api:", err)
+			needRestart = true
+		}
+	}
+	return needRestart
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+)
+
+// LoginHistoryFilter narrows down the login history listing. Empty fields match everything.
+type LoginHistoryFilter struct {
+	Page     int    `json:"page" form:"page"`
+	PageSize int    `json:"pageSize" form:"pageSize"`
+	Username string `json:"username" form:"username"`
+	IP       string `json:"ip" form:"ip"`
+	Success  string `json:"success" form:"success"` // "true", "false" or empty
+	Reason   string `json:"reason" form:"reason"`
+	From     int64  `json:"from" form:"from"` // unix seconds, inclusive
+	To       int64  `json:"to" form:"to"`     // unix seconds, inclusive
+}
+
+// loginHistoryMaxRows bounds the login history whatever its retention is
+const loginHistoryMaxRows = 100000
+
+// LoginHistoryService stores and queries panel login attempts.
+type LoginHistoryService struct {
+	settingService SettingService
+	webhookService WebhookService
+}
+
+// NewLoginEvent builds a login attempt event. The password itself is never kept,
+// only a mask revealing its length on failed attempts.
+func NewLoginEvent(username string, password string, ip string, userAgent string, reason model.LoginResult) *model.LoginHistory {
+	event := &model.LoginHistory{
+		CreatedAt: time.Now().Unix(),
+		Username:  truncateRunes(username, 128),
+		IP:        ip,
+		UserAgent: truncateRunes(userAgent, 256),
+		Success:   reason == model.LoginResultSuccess,
+		Reason:    reason,
+	}
+	if !event.Success {
+		event.PasswordHint = maskPassword(password)
+	}
+	return event
+}
+
+func maskPassword(password string) string {
+	if password == "" {
+		return ""
+	}
+	return "******(" + strconv.Itoa(len([]rune(password))) + ")"
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
+
+// Record saves a login attempt. Failures are only logged, they never block the login.
+func (s *LoginHistoryService) Record(event *model.LoginHistory) {
+	if err := database.GetDB().Create(event).Error; err != nil {
+		logger.Warning("save login history failed:", err)
+	}
+	webhookEvent := model.WebhookEventLoginFailure
+	if event.Success {
+		webhookEvent = model.WebhookEventLoginSuccess
+	}
+	s.webhookService.Emit(nil, webhookEvent, map[string]any{
+		"username":    event.Username,
+		"ip":          event.IP,
+		"userAgent":   event.UserAgent,
+		"reason":      event.Reason,
+		"lockedUntil": event.LockedUntil,
+	})
+}
+
+// Prune drops the login attempts older than the configured retention, then all but the
+// newest loginHistoryMaxRows of them.
+func (s *LoginHistoryService) Prune() error {
+	days, err := s.settingService.GetLoginHistoryRetention()
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	if days > 0 {
+		cutoff := time.Now().AddDate(0, 0, -days).Unix()
+		if err := db.Where("created_at < ?", cutoff).Delete(&model.LoginHistory{}).Error; err != nil {
+			return err
+		}
+	}
+	var last model.LoginHistory
+	err = db.Select("id").Order("id desc").Offset(loginHistoryMaxRows).Limit(1).Take(&last).Error
+	if database.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return db.Where("id <= ?", last.Id).Delete(&model.LoginHistory{}).Error
+}
+
+// GetHistory returns one page of login attempts, newest first, and the total number of matches.
+func (s *LoginHistoryService) GetHistory(filter *LoginHistoryFilter) ([]*model.LoginHistory, int64, error) {
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PageSize < 1 || filter.PageSize > 500 {
+		filter.PageSize = 50
+	}
+	query := database.GetDB().Model(model.LoginHistory{})
+	if filter.Username != "" {
+		query = query.Where("username = ?", filter.Username)
+	}
+	if filter.IP != "" {
+		query = query.Where("ip = ?", filter.IP)
+	}
+	if filter.Success != "" {
+		success, err := strconv.ParseBool(filter.Success)
+		if err != nil {
+			return nil, 0, err
+		}
+		query = query.Where("success = ?", success)
+	}
+	if filter.Reason != "" {
+		query = query.Where("reason = ?", filter.Reason)
+	}
+	if filter.From > 0 {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if filter.To > 0 {
+		query = query.Where("created_at <= ?", filter.To)
+	}
+
+	var total int64
+	err := query.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	var history []*model.LoginHistory
+	err = query.Order("id desc").
+		Offset((filter.Page - 1) * filter.PageSize).
+		Limit(filter.PageSize).
+		Find(&history).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return history, total, nil
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+)
+
+const (
+	// maxLoginBackoff caps the delay enforced between two failed attempts
+	maxLoginBackoff = 30 * time.Second
+	// maxLoginLockout caps the exponentially growing lockout duration
+	maxLoginLockout = 24 * time.Hour
+	// loginRecordTTL is how long an idle, unlocked record is kept
+	loginRecordTTL = 24 * time.Hour
+)
+
+// loginRecord tracks failed logins of one IP or one username.
+type loginRecord struct {
+	failures    int
+	lockouts    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// LoginLockout is an active lockout as shown to admins.
+type LoginLockout struct {
+	Key         string `json:"key"`
+	Kind        string `json:"kind"` // "ip" or "username"
+	Value       string `json:"value"`
+	Lockouts    int    `json:"lockouts"`
+	LockedUntil int64  `json:"lockedUntil"`
+}
+
+var (
+	loginRecords   = map[string]*loginRecord{}
+	loginRecordsMu sync.Mutex
+)
+
+// LoginLimitService slows down and locks out repeated failed panel logins,
+// tracked both per source IP and per attempted username.
+type LoginLimitService struct {
+	settingService SettingService
+}
+
+func loginKeys(ip string, username string) []string {
+	return []string{"ip:" + ip, "username:" + username}
+}
+
+// CheckAllowed returns how long the IP has to wait before the next attempt, or 0.
+func (s *LoginLimitService) CheckAllowed(ip string) time.Duration {
+	return s.wait("ip:" + ip)
+}
+
+// CheckUsername returns how long attempts on the username are refused, or 0. Like the
+// IP it is checked before the password, a lockout is lifted with ClearLockout.
+func (s *LoginLimitService) CheckUsername(username string) time.Duration {
+	return s.wait("username:" + username)
+}
+
+func (s *LoginLimitService) wait(key string) time.Duration {
+	loginRecordsMu.Lock()
+	defer loginRecordsMu.Unlock()
+
+	record, ok := loginRecords[key]
+	if !ok {
+		return 0
+	}
+	now := time.Now()
+	if record.lockedUntil.After(now) {
+		return record.lockedUntil.Sub(now)
+	}
+	if record.failures > 0 {
+		// Exponential back-off: 1s, 2s, 4s ... between consecutive failures
+		backoff := min(time.Second<<(record.failures-1), maxLoginBackoff)
+		if next := record.lastFailure.Add(backoff); next.After(now) {
+			return next.Sub(now)
+		}
+	}
+	return 0
+}
+
+// RecordFailure registers a failed attempt. When it triggers a new lockout,
+// the returned time is when the lockout ends.
+func (s *LoginLimitService) RecordFailure(ip string, username string) (time.Time, bool) {
+	maxAttempts, err := s.settingService.GetLoginMaxAttempts()
+	if err != nil {
+		logger.Warning("get loginMaxAttempts failed:", err)
+		maxAttempts = 5
+	}
+	lockoutMinutes, err := s.settingService.GetLoginLockoutMinutes()
+	if err != nil {
+		logger.Warning("get loginLockoutMinutes failed:", err)
+		lockoutMinutes = 15
+	}
+
+	loginRecordsMu.Lock()
+	defer loginRecordsMu.Unlock()
+
+	now := time.Now()
+	s.cleanup(now)
+
+	var lockedUntil time.Time
+	for _, key := range loginKeys(ip, username) {
+		record, ok := loginRecords[key]
+		if !ok {
+			record = &loginRecord{}
+			loginRecords[key] = record
+		}
+		record.failures++
+		record.lastFailure = now
+		// 0 attempts or 0 minutes disables lockouts, only the back-off remains
+		if maxAttempts > 0 && lockoutMinutes > 0 && record.failures >= maxAttempts {
+			duration := time.Duration(lockoutMinutes) * time.Minute << min(record.lockouts, 10)
+			record.lockedUntil = now.Add(min(duration, maxLoginLockout))
+			record.lockouts++
+			record.failures = 0
+			if record.lockedUntil.After(lockedUntil) {
+				lockedUntil = record.lockedUntil
+			}
+			logger.Warningf("login locked for %s until %s", key, record.lockedUntil.Format("2006-01-02 15:04:05"))
+		}
+	}
+	return lockedUntil, !lockedUntil.IsZero()
+}
+
+// RecordSuccess forgets the failures and lockout history of the IP and username.
+func (s *LoginLimitService) RecordSuccess(ip string, username string) {
+	loginRecordsMu.Lock()
+	defer loginRecordsMu.Unlock()
+	for _, key := range loginKeys(ip, username) {
+		delete(loginRecords, key)
+	}
+}
+
+// GetLockouts lists the lockouts that are currently active.
+func (s *LoginLimitService) GetLockouts() []LoginLockout {
+	loginRecordsMu.Lock()
+	defer loginRecordsMu.Unlock()
+
+	now := time.Now()
+	lockouts := []LoginLockout{}
+	for key, record := range loginRecords {
+		if !record.lockedUntil.After(now) {
+			continue
+		}
+		kind, value, _ := strings.Cut(key, ":")
+		lockouts = append(lockouts, LoginLockout{
+			Key:         key,
+			Kind:        kind,
+			Value:       value,
+			Lockouts:    record.lockouts,
+			LockedUntil: record.lockedUntil.Unix(),
+		})
+	}
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].LockedUntil > lockouts[j].LockedUntil
+	})
+	return lockouts
+}
+
+// ClearLockout removes the record with the given key, or every record when key is empty.
+func (s *LoginLimitService) ClearLockout(key string) error {
+	loginRecordsMu.Lock()
+	defer loginRecordsMu.Unlock()
+	if key == "" {
+		loginRecords = map[string]*loginRecord{}
+		return nil
+	}
+	if _, ok := loginRecords[key]; !ok {
+		return common.NewError("lockout not found:", key)
+	}
+	delete(loginRecords, key)
+	return nil
+}
+
+// cleanup drops idle records; the caller must hold loginRecordsMu.
+func (s *LoginLimitService) cleanup(now time.Time) {
+	for key, record := range loginRecords {
+		if record.lockedUntil.Before(now) && now.Sub(record.lastFailure) > loginRecordTTL {
+			delete(loginRecords, key)
+		}
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"gorm.io/gorm"
 )
 
-type OutboundService struct{}
+type OutboundService struct {
+	trafficStatService TrafficStatService
+}
 
 func (s *OutboundService) AddTraffic(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) (error, bool) {
 	var err error
@@ -28,6 +30,7 @@ func (s *OutboundService) AddTraffic(traffics []*xray.Traffic, clientTraffics []
 	if err != nil {
 		return err, false
 	}
+	s.trafficStatService.recordOutboundTraffic(tx, traffics)
 
 	return nil, false
 }
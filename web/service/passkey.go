@@ -0,0 +1,321 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/util/common"
+	"x-ui/util/crypto"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"gorm.io/gorm"
+)
+
+// recoveryCodeCount is the number of recovery codes generated at once
+const recoveryCodeCount = 10
+
+// WebAuthnRP identifies the relying party, i.e. the host the panel is reached on.
+type WebAuthnRP struct {
+	ID     string // host name without port
+	Origin string // scheme://host[:port]
+}
+
+// passkeyUser adapts a panel account to the webauthn.User interface.
+type passkeyUser struct {
+	user        *model.User
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	return []byte("x-ui-user-" + strconv.Itoa(u.user.Id))
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.user.Username
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	return u.user.Username
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+// PasskeyService manages the WebAuthn credentials and recovery codes of the accounts.
+type PasskeyService struct {
+	auditService AuditService
+	actor        *model.AuditActor
+}
+
+// WithActor returns a copy of the service whose changes are audited as made by actor.
+func (s *PasskeyService) WithActor(actor *model.AuditActor) *PasskeyService {
+	scoped := *s
+	scoped.actor = actor
+	return &scoped
+}
+
+func (s *PasskeyService) newWebAuthn(rp WebAuthnRP) (*webauthn.WebAuthn, error) {
+	return webauthn.New(&webauthn.Config{
+		RPID:          rp.ID,
+		RPDisplayName: "X-Panel",
+		RPOrigins:     []string{rp.Origin},
+	})
+}
+
+// GetPasskeys lists the passkeys of an account.
+func (s *PasskeyService) GetPasskeys(userId int) ([]*model.Passkey, error) {
+	var passkeys []*model.Passkey
+	err := database.GetDB().Where("user_id = ?", userId).Order("id asc").Find(&passkeys).Error
+	return passkeys, err
+}
+
+// HasPasskeys reports whether the account has registered at least one passkey.
+func (s *PasskeyService) HasPasskeys(userId int) (bool, error) {
+	var count int64
+	err := database.GetDB().Model(model.Passkey{}).Where("user_id = ?", userId).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *PasskeyService) loadUser(user *model.User) (*passkeyUser, []*model.Passkey, error) {
+	passkeys, err := s.GetPasskeys(user.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &passkeyUser{user: user}
+	for _, passkey := range passkeys {
+		var credential webauthn.Credential
+		if err := json.Unmarshal([]byte(passkey.Credential), &credential); err != nil {
+			return nil, nil, err
+		}
+		result.credentials = append(result.credentials, credential)
+	}
+	return result, passkeys, nil
+}
+
+// BeginRegistration starts adding a passkey. The returned session data must be
+// kept server-side and handed to FinishRegistration.
+func (s *PasskeyService) BeginRegistration(user *model.User, rp WebAuthnRP) (*protocol.CredentialCreation, string, error) {
+	w, err := s.newWebAuthn(rp)
+	if err != nil {
+		return nil, "", err
+	}
+	pkUser, _, err := s.loadUser(user)
+	if err != nil {
+		return nil, "", err
+	}
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(pkUser.credentials))
+	for _, credential := range pkUser.credentials {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+	creation, session, err := w.BeginRegistration(pkUser,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred))
+	if err != nil {
+		return nil, "", err
+	}
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		return nil, "", err
+	}
+	return creation, string(sessionData), nil
+}
+
+// FinishRegistration verifies the authenticator response and stores the new passkey.
+func (s *PasskeyService) FinishRegistration(user *model.User, rp WebAuthnRP, sessionData string, name string, r *http.Request) (*model.Passkey, error) {
+	w, err := s.newWebAuthn(rp)
+	if err != nil {
+		return nil, err
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(sessionData), &session); err != nil {
+		return nil, errors.New("no passkey registration in progress")
+	}
+	pkUser, _, err := s.loadUser(user)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := w.FinishRegistration(pkUser, session, r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return nil, err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey " + time.Now().Format("2006-01-02")
+	}
+	passkey := &model.Passkey{
+		UserId:       user.Id,
+		Name:         name,
+		CredentialId: base64.RawURLEncoding.EncodeToString(credential.ID),
+		Credential:   string(data),
+		CreatedAt:    time.Now().Unix(),
+	}
+	err = database.GetDB().Create(passkey).Error
+	if err != nil {
+		return nil, err
+	}
+	s.auditService.Record(s.actor, "passkey.add", user.Username, nil, passkey)
+	return passkey, nil
+}
+
+// BeginLogin creates the assertion challenge for the passkeys of an account.
+func (s *PasskeyService) BeginLogin(user *model.User, rp WebAuthnRP) (*protocol.CredentialAssertion, string, error) {
+	w, err := s.newWebAuthn(rp)
+	if err != nil {
+		return nil, "", err
+	}
+	pkUser, _, err := s.loadUser(user)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(pkUser.credentials) == 0 {
+		return nil, "", errors.New("no passkey registered")
+	}
+	assertion, session, err := w.BeginLogin(pkUser)
+	if err != nil {
+		return nil, "", err
+	}
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, string(sessionData), nil
+}
+
+// FinishLogin verifies the assertion and updates the signature counter of the used passkey.
+func (s *PasskeyService) FinishLogin(user *model.User, rp WebAuthnRP, sessionData string, r *http.Request) error {
+	w, err := s.newWebAuthn(rp)
+	if err != nil {
+		return err
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(sessionData), &session); err != nil {
+		return errors.New("no passkey login in progress")
+	}
+	pkUser, passkeys, err := s.loadUser(user)
+	if err != nil {
+		return err
+	}
+	credential, err := w.FinishLogin(pkUser, session, r)
+	if err != nil {
+		return err
+	}
+	if credential.Authenticator.CloneWarning {
+		return errors.New("passkey signature counter went backwards, the authenticator may be cloned")
+	}
+	credentialId := base64.RawURLEncoding.EncodeToString(credential.ID)
+	for _, passkey := range passkeys {
+		if passkey.CredentialId != credentialId {
+			continue
+		}
+		data, err := json.Marshal(credential)
+		if err != nil {
+			return err
+		}
+		return database.GetDB().Model(passkey).Updates(map[string]any{
+			"credential":   string(data),
+			"last_used_at": time.Now().Unix(),
+		}).Error
+	}
+	return nil
+}
+
+// DelPasskey removes a passkey. A non-zero userId restricts it to that account's passkeys.
+func (s *PasskeyService) DelPasskey(id int, userId int) error {
+	query := database.GetDB().Where("id = ?", id)
+	if userId > 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	passkey := &model.Passkey{}
+	err := query.First(passkey).Error
+	if database.IsNotFound(err) {
+		return common.NewError("passkey not found:", id)
+	} else if err != nil {
+		return err
+	}
+	err = database.GetDB().Delete(passkey).Error
+	if err == nil {
+		s.auditService.Record(s.actor, "passkey.delete", passkey.Name, passkey, nil)
+	}
+	return err
+}
+
+// GenerateRecoveryCodes replaces the recovery codes of an account. The plain codes
+// are only returned here, the database keeps their hashes.
+func (s *PasskeyService) GenerateRecoveryCodes(userId int) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]*model.RecoveryCode, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		code, err := crypto.RandomHex(5)
+		if err != nil {
+			return nil, err
+		}
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		records = append(records, &model.RecoveryCode{UserId: userId, CodeHash: crypto.HashToken(normalizeRecoveryCode(code))})
+	}
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userId).Delete(model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&records).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.auditService.Record(s.actor, "recoveryCodes.generate", "user:"+strconv.Itoa(userId), nil, nil)
+	return codes, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes an account has left.
+func (s *PasskeyService) CountRecoveryCodes(userId int) (int64, error) {
+	var count int64
+	err := database.GetDB().Model(model.RecoveryCode{}).
+		Where("user_id = ? AND used_at = 0", userId).
+		Count(&count).Error
+	return count, err
+}
+
+// UseRecoveryCode consumes a matching unused recovery code.
+func (s *PasskeyService) UseRecoveryCode(userId int, code string) bool {
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return false
+	}
+	result := database.GetDB().Model(model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at = 0", userId, crypto.HashToken(code)).
+		Update("used_at", time.Now().Unix())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
+
+// ResetFactors removes every passkey and recovery code of an account,
+// e.g. when its owner lost access to them.
+func (s *PasskeyService) ResetFactors(userId int) error {
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userId).Delete(model.Passkey{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userId).Delete(model.RecoveryCode{}).Error
+	})
+	if err == nil {
+		s.auditService.Record(s.actor, "passkey.reset", "user:"+strconv.Itoa(userId), nil, nil)
+	}
+	return err
+}
@@ -21,6 +21,7 @@ import (
 
 	"x-ui/config"
 	"x-ui/database"
+	"x-ui/database/model"
 	"x-ui/logger"
 	"x-ui/util/common"
 	"x-ui/util/sys"
@@ -87,6 +88,7 @@ type Status struct {
 		Mem     uint64 `json:"mem"`
 		Uptime  uint64 `json:"uptime"`
 	} `json:"appStats"`
+	TrafficExport *TrafficExportStatus `json:"trafficExport"`
 }
 
 type Release struct {
@@ -100,6 +102,17 @@ type ServerService struct {
 	cachedIPv4     string
 	cachedIPv6     string
 	noIPv6         bool
+	auditService   AuditService
+	webhookService WebhookService
+	trafficExport  TrafficExportService
+	actor          *model.AuditActor
+}
+
+// WithActor returns a copy of the service whose changes are audited as made by actor.
+func (s *ServerService) WithActor(actor *model.AuditActor) *ServerService {
+	scoped := *s
+	scoped.actor = actor
+	return &scoped
 }
 
 // 【新增方法】: 用于从外部注入 TelegramService 实例
@@ -311,6 +324,8 @@ func (s *ServerService) GetStatus(lastStatus *Status) *Status {
 		status.AppStats.Uptime = 0
 	}
 
+	status.TrafficExport = s.trafficExport.GetStatus()
+
 	return status
 }
 
@@ -374,6 +389,7 @@ func (s *ServerService) RestartXrayService() error {
 		logger.Error("start xray failed:", err)
 		return err
 	}
+	s.webhookService.Emit(nil, model.WebhookEventXrayRestarted, map[string]any{"reason": "manual"})
 	return nil
 }
 
@@ -736,6 +752,9 @@ func (s *ServerService) ImportDB(file multipart.File) error {
 
 	s.inboundService.MigrateDB()
 
+	// Recorded after the swap, otherwise the entry would be lost with the old database
+	s.auditService.Record(s.actor, "server.importDB", config.GetDBPath(), nil, nil)
+
 	// Start Xray
 	if err = s.RestartXrayService(); err != nil {
 		return common.NewErrorf("Imported DB but failed to start Xray: %v", err)
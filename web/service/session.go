@@ -0,0 +1,69 @@
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/util/common"
+)
+
+// SessionService lists and revokes the server-side login sessions.
+type SessionService struct {
+	auditService AuditService
+	actor        *model.AuditActor
+}
+
+// WithActor returns a copy of the service whose changes are audited as made by actor.
+func (s *SessionService) WithActor(actor *model.AuditActor) *SessionService {
+	scoped := *s
+	scoped.actor = actor
+	return &scoped
+}
+
+// GetSessions returns the active sessions of an account, or of every account when userId is 0.
+func (s *SessionService) GetSessions(userId int) ([]*model.LoginSession, error) {
+	query := database.GetDB().Model(model.LoginSession{}).
+		Where("user_id > 0 AND expires_at > ?", time.Now().Unix())
+	if userId > 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	var sessions []*model.LoginSession
+	err := query.Order("last_seen_at desc").Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession ends one session. A non-zero userId restricts it to that account's sessions.
+func (s *SessionService) RevokeSession(id string, userId int) error {
+	query := database.GetDB().Where("id = ?", id)
+	if userId > 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	result := query.Delete(model.LoginSession{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("session not found:", id)
+	}
+	s.auditService.Record(s.actor, "session.revoke", id, nil, nil)
+	return nil
+}
+
+// RevokeUserSessions ends every session of an account except exceptId, which may be empty.
+func (s *SessionService) RevokeUserSessions(userId int, exceptId string) error {
+	err := revokeUserSessions(userId, exceptId)
+	if err == nil {
+		s.auditService.Record(s.actor, "session.revokeAll", "user:"+strconv.Itoa(userId), nil, nil)
+	}
+	return err
+}
+
+func revokeUserSessions(userId int, exceptId string) error {
+	query := database.GetDB().Where("user_id = ?", userId)
+	if exceptId != "" {
+		query = query.Where("id <> ?", exceptId)
+	}
+	return query.Delete(model.LoginSession{}).Error
+}
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -24,57 +26,94 @@ import (
 var xrayTemplateConfig string
 
 var defaultValueMap = map[string]string{
-	"xrayTemplateConfig":          xrayTemplateConfig,
-	"webListen":                   "",
-	"webDomain":                   "",
-	"webPort":                     "13688",
-	"webCertFile":                 "",
-	"webKeyFile":                  "",
-	"secret":                      random.Seq(32),
-	"webBasePath":                 "/",
-	"sessionMaxAge":               "360",
-	"pageSize":                    "50",
-	"expireDiff":                  "0",
-	"trafficDiff":                 "0",
-	"remarkModel":                 "-ieo",
-	"timeLocation":                "Local",
-	"tgBotEnable":                 "false",
-	"tgBotToken":                  "",
-	"tgBotProxy":                  "",
-	"tgBotAPIServer":              "",
-	"tgBotChatId":                 "",
-	"tgRunTime":                   "@daily",
-	"tgBotBackup":                 "false",
-	"tgBotLoginNotify":            "true",
-	"tgCpu":                       "80",
-	"tgLang":                      "zh-CN",
-	"twoFactorEnable":             "false",
-	"twoFactorToken":              "",
-	"subEnable":                   "false",
-	"subTitle":                    "",
-	"subListen":                   "",
-	"subPort":                     "13788",
-	"subPath":                     "/sub/",
-	"subDomain":                   "",
-	"subCertFile":                 "",
-	"subKeyFile":                  "",
-	"subUpdates":                  "12",
-	"subEncrypt":                  "true",
-	"subShowInfo":                 "true",
-	"subURI":                      "",
-	"subJsonPath":                 "/json/",
-	"subJsonURI":                  "",
-	"subJsonFragment":             "",
-	"subJsonNoises":               "",
-	"subJsonMux":                  "",
-	"subJsonRules":                "",
-	"datepicker":                  "gregorian",
-	"warp":                        "",
-	"externalTrafficInformEnable": "false",
-	"externalTrafficInformURI":    "",
-}
-
-type SettingService struct{}
+	"xrayTemplateConfig":            xrayTemplateConfig,
+	"webListen":                     "",
+	"webDomain":                     "",
+	"webPort":                       "13688",
+	"webCertFile":                   "",
+	"webKeyFile":                    "",
+	"secret":                        random.Seq(32),
+	"webBasePath":                   "/",
+	"sessionMaxAge":                 "360",
+	"pageSize":                      "50",
+	"expireDiff":                    "0",
+	"trafficDiff":                   "0",
+	"remarkModel":                   "-ieo",
+	"timeLocation":                  "Local",
+	"tgBotEnable":                   "false",
+	"tgBotToken":                    "",
+	"tgBotProxy":                    "",
+	"tgBotAPIServer":                "",
+	"tgBotChatId":                   "",
+	"tgRunTime":                     "@daily",
+	"tgBotBackup":                   "false",
+	"tgBotLoginNotify":              "true",
+	"tgCpu":                         "80",
+	"tgLang":                        "zh-CN",
+	"loginMaxAttempts":              "5",
+	"loginLockoutMinutes":           "15",
+	"panelAllowCIDRs":               "",
+	"panelDenyCIDRs":                "",
+	"trustedProxies":                "127.0.0.1/8,::1",
+	"loginHistoryRetention":         "90",
+	"subEnable":                     "false",
+	"subTitle":                      "",
+	"subListen":                     "",
+	"subPort":                       "13788",
+	"subPath":                       "/sub/",
+	"subDomain":                     "",
+	"subCertFile":                   "",
+	"subKeyFile":                    "",
+	"subUpdates":                    "12",
+	"subEncrypt":                    "true",
+	"subShowInfo":                   "true",
+	"subURI":                        "",
+	"subJsonPath":                   "/json/",
+	"subJsonURI":                    "",
+	"subJsonFragment":               "",
+	"subJsonNoises":                 "",
+	"subJsonMux":                    "",
+	"subJsonRules":                  "",
+	"subClashPath":                  "/clash/",
+	"subClashURI":                   "",
+	"subClashRules":                 "",
+	"subSingboxPath":                "/singbox/",
+	"subSingboxURI":                 "",
+	"datepicker":                    "gregorian",
+	"warp":                          "",
+	"externalTrafficInformEnable":   "false",
+	"externalTrafficInformURI":      "",
+	"externalTrafficInformAuth":     "none",
+	"externalTrafficInformFormat":   "legacy",
+	"externalTrafficInformSecret":   "",
+	"externalTrafficInformCertFile": "",
+	"externalTrafficInformKeyFile":  "",
+	"externalTrafficInformCAFile":   "",
+	"trafficMinuteRetention":        "24",
+	"trafficHourRetention":          "30",
+	"trafficDayRetention":           "365",
+	"metricsEnable":                 "false",
+	"firewallBanTime":               "30",
+	"firewallDryRun":                "false",
+	"speedTiers":                    "",
+	"clientAlertTrafficPercents":    "",
+	"clientAlertExpiryDays":         "",
+	// Read position of the access log job in the Xray access log, not shown in the settings page
+	"accessLogOffset": "0",
+}
+
+type SettingService struct {
+	auditService   AuditService
+	webhookService WebhookService
+	actor          *model.AuditActor
+}
+
+// WithActor returns a copy of the service whose changes are audited as made by actor.
+func (s *SettingService) WithActor(actor *model.AuditActor) *SettingService {
+	scoped := *s
+	scoped.actor = actor
+	return &scoped
+}
 
 func (s *SettingService) GetDefaultJsonConfig() (any, error) {
 	var jsonData any
@@ -318,22 +357,6 @@ func (s *SettingService) GetTgLang() (string, error) {
 	return s.getString("tgLang")
 }
 
-func (s *SettingService) GetTwoFactorEnable() (bool, error) {
-	return s.getBool("twoFactorEnable")
-}
-
-func (s *SettingService) SetTwoFactorEnable(value bool) error {
-	return s.setBool("twoFactorEnable", value)
-}
-
-func (s *SettingService) GetTwoFactorToken() (string, error) {
-	return s.getString("twoFactorToken")
-}
-
-func (s *SettingService) SetTwoFactorToken(value string) error {
-	return s.setString("twoFactorToken", value)
-}
-
 func (s *SettingService) GetPort() (int, error) {
 	return s.getInt("webPort")
 }
@@ -370,6 +393,77 @@ func (s *SettingService) GetSessionMaxAge() (int, error) {
 	return s.getInt("sessionMaxAge")
 }
 
+func (s *SettingService) GetLoginMaxAttempts() (int, error) {
+	return s.getInt("loginMaxAttempts")
+}
+
+func (s *SettingService) GetLoginLockoutMinutes() (int, error) {
+	return s.getInt("loginLockoutMinutes")
+}
+
+func (s *SettingService) GetPanelAllowCIDRs() (string, error) {
+	return s.getString("panelAllowCIDRs")
+}
+
+func (s *SettingService) GetPanelDenyCIDRs() (string, error) {
+	return s.getString("panelDenyCIDRs")
+}
+
+func (s *SettingService) GetTrustedProxies() (string, error) {
+	return s.getString("trustedProxies")
+}
+
+func (s *SettingService) GetLoginHistoryRetention() (int, error) {
+	return s.getInt("loginHistoryRetention")
+}
+
+func (s *SettingService) GetTrafficMinuteRetention() (int, error) {
+	return s.getInt("trafficMinuteRetention")
+}
+
+func (s *SettingService) GetTrafficHourRetention() (int, error) {
+	return s.getInt("trafficHourRetention")
+}
+
+func (s *SettingService) GetTrafficDayRetention() (int, error) {
+	return s.getInt("trafficDayRetention")
+}
+
+func (s *SettingService) GetMetricsEnable() (bool, error) {
+	return s.getBool("metricsEnable")
+}
+
+// GetFirewallBanTime returns how many minutes the firewall device-limit backend bans an IP
+func (s *SettingService) GetFirewallBanTime() (int, error) {
+	return s.getInt("firewallBanTime")
+}
+
+func (s *SettingService) GetFirewallDryRun() (bool, error) {
+	return s.getBool("firewallDryRun")
+}
+
+// GetSpeedTiers returns the speed tiers in KB/s from low to high, empty when client limits are not snapped
+func (s *SettingService) GetSpeedTiers() ([]int, error) {
+	value, err := s.getString("speedTiers")
+	if err != nil {
+		return nil, err
+	}
+	tiers, err := common.ParseThresholds(value)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(tiers)
+	return tiers, nil
+}
+
+func (s *SettingService) GetClientAlertTrafficPercents() (string, error) {
+	return s.getString("clientAlertTrafficPercents")
+}
+
+func (s *SettingService) GetClientAlertExpiryDays() (string, error) {
+	return s.getString("clientAlertExpiryDays")
+}
+
 func (s *SettingService) GetRemarkModel() (string, error) {
 	return s.getString("remarkModel")
 }
@@ -499,6 +593,27 @@ func (s *SettingService) GetSubJsonRules() (string, error) {
 	return s.getString("subJsonRules")
 }
 
+func (s *SettingService) GetSubClashPath() (string, error) {
+	return s.getString("subClashPath")
+}
+
+func (s *SettingService) GetSubClashURI() (string, error) {
+	return s.getString("subClashURI")
+}
+
+// GetSubClashRules returns the rules of the Clash subscription, one Clash rule per line
+func (s *SettingService) GetSubClashRules() (string, error) {
+	return s.getString("subClashRules")
+}
+
+func (s *SettingService) GetSubSingboxPath() (string, error) {
+	return s.getString("subSingboxPath")
+}
+
+func (s *SettingService) GetSubSingboxURI() (string, error) {
+	return s.getString("subSingboxURI")
+}
+
 func (s *SettingService) GetDatepicker() (string, error) {
 	return s.getString("datepicker")
 }
@@ -527,6 +642,42 @@ func (s *SettingService) SetExternalTrafficInformURI(InformURI string) error {
 	return s.setString("externalTrafficInformURI", InformURI)
 }
 
+func (s *SettingService) GetExternalTrafficInformAuth() (string, error) {
+	return s.getString("externalTrafficInformAuth")
+}
+
+func (s *SettingService) GetExternalTrafficInformFormat() (string, error) {
+	return s.getString("externalTrafficInformFormat")
+}
+
+func (s *SettingService) GetExternalTrafficInformSecret() (string, error) {
+	return s.getString("externalTrafficInformSecret")
+}
+
+func (s *SettingService) GetExternalTrafficInformCertFile() (string, error) {
+	return s.getString("externalTrafficInformCertFile")
+}
+
+func (s *SettingService) GetExternalTrafficInformKeyFile() (string, error) {
+	return s.getString("externalTrafficInformKeyFile")
+}
+
+func (s *SettingService) GetExternalTrafficInformCAFile() (string, error) {
+	return s.getString("externalTrafficInformCAFile")
+}
+
+func (s *SettingService) GetAccessLogOffset() (int64, error) {
+	str, err := s.getString("accessLogOffset")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(str, 10, 64)
+}
+
+func (s *SettingService) SetAccessLogOffset(offset int64) error {
+	return s.setString("accessLogOffset", strconv.FormatInt(offset, 10))
+}
+
 func (s *SettingService) GetIpLimitEnable() (bool, error) {
 	accessLogPath, err := xray.GetAccessLogPath()
 	if err != nil {
@@ -539,6 +690,10 @@ func (s *SettingService) UpdateAllSetting(allSetting *entity.AllSetting) error {
 	if err := allSetting.CheckValid(); err != nil {
 		return err
 	}
+	before, err := s.GetAllSetting()
+	if err != nil {
+		return err
+	}
 
 	v := reflect.ValueOf(allSetting).Elem()
 	t := reflect.TypeOf(allSetting).Elem()
@@ -553,9 +708,34 @@ func (s *SettingService) UpdateAllSetting(allSetting *entity.AllSetting) error {
 			errs = append(errs, err)
 		}
 	}
+	s.auditService.Record(s.actor, "setting.update", "all", before, allSetting)
+
+	changed := make([]string, 0)
+	for key := range auditDiff(auditSnapshot(before), auditSnapshot(allSetting)) {
+		changed = append(changed, key)
+	}
+	s.emitSettingChanged(changed)
+	// Every speed tier is a policy level, which Xray only reads when it starts
+	if slices.Contains(changed, "speedTiers") {
+		isNeedXrayRestart.Store(true)
+	}
 	return common.Combine(errs...)
 }
 
+// emitSettingChanged raises the settings.changed webhook event. Only the names of the
+// changed settings are sent, their values may contain credentials.
+func (s *SettingService) emitSettingChanged(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+	data := map[string]any{"keys": keys}
+	if s.actor != nil {
+		data["actor"] = s.actor.Name
+	}
+	s.webhookService.Emit(nil, model.WebhookEventSettingChanged, data)
+}
+
 func (s *SettingService) GetDefaultXrayConfig() (any, error) {
 	var jsonData any
 	err := json.Unmarshal([]byte(xrayTemplateConfig), &jsonData)
@@ -578,6 +758,8 @@ func (s *SettingService) GetDefaultSettings(host string) (any, error) {
 		"subTitle":      func() (any, error) { return s.GetSubTitle() },
 		"subURI":        func() (any, error) { return s.GetSubURI() },
 		"subJsonURI":    func() (any, error) { return s.GetSubJsonURI() },
+		"subClashURI":   func() (any, error) { return s.GetSubClashURI() },
+		"subSingboxURI": func() (any, error) { return s.GetSubSingboxURI() },
 		"remarkModel":   func() (any, error) { return s.GetRemarkModel() },
 		"datepicker":    func() (any, error) { return s.GetDatepicker() },
 		"ipLimitEnable": func() (any, error) { return s.GetIpLimitEnable() },
@@ -593,12 +775,14 @@ func (s *SettingService) GetDefaultSettings(host string) (any, error) {
 		result[key] = value
 	}
 
-	if result["subEnable"].(bool) && (result["subURI"].(string) == "" || result["subJsonURI"].(string) == "") {
+	if result["subEnable"].(bool) && (result["subURI"].(string) == "" || result["subJsonURI"].(string) == "" || result["subClashURI"].(string) == "" || result["subSingboxURI"].(string) == "") {
 		subURI := ""
 		subTitle, _ := s.GetSubTitle()
 		subPort, _ := s.GetSubPort()
 		subPath, _ := s.GetSubPath()
 		subJsonPath, _ := s.GetSubJsonPath()
+		subClashPath, _ := s.GetSubClashPath()
+		subSingboxPath, _ := s.GetSubSingboxPath()
 		subDomain, _ := s.GetSubDomain()
 		subKeyFile, _ := s.GetSubKeyFile()
 		subCertFile, _ := s.GetSubCertFile()
@@ -628,6 +812,12 @@ func (s *SettingService) GetDefaultSettings(host string) (any, error) {
 		if result["subJsonURI"].(string) == "" {
 			result["subJsonURI"] = subURI + subJsonPath
 		}
+		if result["subClashURI"].(string) == "" {
+			result["subClashURI"] = subURI + subClashPath
+		}
+		if result["subSingboxURI"].(string) == "" {
+			result["subSingboxURI"] = subURI + subSingboxPath
+		}
 	}
 
 	return result, nil
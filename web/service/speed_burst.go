@@ -0,0 +1,70 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"x-ui/database/model"
+	"x-ui/xray"
+)
+
+// burstBucket is the unused burst allowance of one client, a token bucket in bytes
+// that the client spends above its speed limits and the unused part of the limits refills.
+type burstBucket struct {
+	tokens   int64
+	up       int64 // traffic counters at the last update
+	down     int64
+	at       time.Time
+	bursting bool
+}
+
+var (
+	burstBuckets     = make(map[string]*burstBucket)
+	burstBucketsLock sync.Mutex
+)
+
+// updateBurst charges the traffic of the client since the last update to its allowance and
+// reports whether it may run at full speed now. Once the allowance is spent the client stays
+// limited until half of it is refilled, so that it does not switch levels on every run.
+func updateBurst(client *model.Client, traffic *xray.ClientTraffic, now time.Time) bool {
+	profile := client.SpeedProfile()
+	capacity := int64(client.BurstLimit) * 1024
+
+	burstBucketsLock.Lock()
+	defer burstBucketsLock.Unlock()
+	bucket, ok := burstBuckets[client.Email]
+	if !ok {
+		burstBuckets[client.Email] = &burstBucket{tokens: capacity, up: traffic.Up, down: traffic.Down, at: now, bursting: true}
+		return true
+	}
+	seconds := now.Sub(bucket.at).Seconds()
+	// A traffic reset starts the counters from zero again
+	used := func(last int64, current int64) int64 {
+		return max(current-last, 0)
+	}
+	if profile.Up > 0 {
+		bucket.tokens += int64(float64(profile.Up)*1024*seconds) - used(bucket.up, traffic.Up)
+	}
+	if profile.Down > 0 {
+		bucket.tokens += int64(float64(profile.Down)*1024*seconds) - used(bucket.down, traffic.Down)
+	}
+	bucket.tokens = min(max(bucket.tokens, 0), capacity)
+	bucket.up, bucket.down, bucket.at = traffic.Up, traffic.Down, now
+	if bucket.bursting {
+		bucket.bursting = bucket.tokens > 0
+	} else {
+		bucket.bursting = bucket.tokens >= capacity/2
+	}
+	return bucket.bursting
+}
+
+// forgetBursts drops the allowance of clients that no longer have one
+func forgetBursts(keep map[string]bool) {
+	burstBucketsLock.Lock()
+	defer burstBucketsLock.Unlock()
+	for email := range burstBuckets {
+		if !keep[email] {
+			delete(burstBuckets, email)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/xray"
+)
+
+// runningLevels caches the speed limits of the policy levels in the running Xray config
+var runningLevels struct {
+	sync.Mutex
+	config *xray.Config
+	levels map[string]int // SpeedProfile.Key -> policy level
+}
+
+// assignSpeedLevels gives every speed profile its preferred policy level. Hashed levels of
+// different profiles can collide, then the profile whose key sorts later probes to the
+// next free level, so that no client ends up with the limits of another profile.
+func assignSpeedLevels(profiles map[string]model.SpeedProfile) map[string]int {
+	keys := make([]string, 0, len(profiles))
+	for key := range profiles {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	levels := make(map[string]int, len(keys))
+	owners := make(map[int]string, len(keys))
+	for _, key := range keys {
+		level := profiles[key].Level()
+		for {
+			owner, taken := owners[level]
+			if !taken {
+				break
+			}
+			next := model.NextLevel(level)
+			logger.Warningf("speed profile %s collides with %s on policy level %d, using level %d", key, owner, level, next)
+			level = next
+		}
+		owners[level] = key
+		levels[key] = level
+	}
+	return levels
+}
+
+// SpeedLevel returns the policy level of the speed profile in the running Xray config.
+// ok is false when the running config has no level with these limits, the client then
+// gets its speed limit only once Xray is restarted with a new config.
+func SpeedLevel(profile model.SpeedProfile) (level int, ok bool) {
+	if profile.IsZero() {
+		return 0, true
+	}
+	if p == nil || !p.IsRunning() {
+		return profile.Level(), false
+	}
+	config := p.GetConfig()
+
+	runningLevels.Lock()
+	defer runningLevels.Unlock()
+	if runningLevels.config != config {
+		runningLevels.config = config
+		runningLevels.levels = policySpeedLevels(config.Policy)
+	}
+	if level, ok := runningLevels.levels[profile.Key()]; ok {
+		return level, true
+	}
+	return profile.Level(), false
+}
+
+// policySpeedLevels maps the speed limits of every policy level to the lowest level having them
+func policySpeedLevels(policy []byte) map[string]int {
+	levels := make(map[string]int)
+	var parsed struct {
+		Levels map[string]struct {
+			UplinkOnly   int `json:"uplinkOnly"`
+			DownlinkOnly int `json:"downlinkOnly"`
+		} `json:"levels"`
+	}
+	if err := json.Unmarshal(policy, &parsed); err != nil {
+		return levels
+	}
+	for name, limits := range parsed.Levels {
+		level, err := strconv.Atoi(name)
+		if err != nil || level == 0 {
+			continue
+		}
+		key := model.SpeedProfile{Up: limits.UplinkOnly, Down: limits.DownlinkOnly}.Key()
+		if current, ok := levels[key]; !ok || level < current {
+			levels[key] = level
+		}
+	}
+	return levels
+}
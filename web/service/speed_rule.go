@@ -0,0 +1,308 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// SpeedRuleTarget is one enabled client that speed rules may apply to, with what is
+// needed to re-add it through the Xray API. Rule is the matching rule with the lowest
+// speed, nil while no rule lowers the client's own speed. A rule caps both directions
+// of the client's own limits at its speed. Bursting is set while the client still has
+// burst allowance left, it then runs without limits unless a rule matches.
+type SpeedRuleTarget struct {
+	Email    string
+	Tag      string
+	Protocol model.Protocol
+	Method   string // cipher of a Shadowsocks inbound
+	Client   model.Client
+	Rule     *model.SpeedRule
+	Bursting bool
+}
+
+// SpeedRuleService manages the rules that lower client speeds by time of day or used traffic.
+type SpeedRuleService struct {
+	settingService SettingService
+	auditService   AuditService
+	actor          *model.AuditActor
+}
+
+// WithActor returns a copy of the service whose changes are audited as made by actor.
+func (s *SpeedRuleService) WithActor(actor *model.AuditActor) *SpeedRuleService {
+	scoped := *s
+	scoped.actor = actor
+	return &scoped
+}
+
+func (s *SpeedRuleService) GetRules() ([]*model.SpeedRule, error) {
+	var rules []*model.SpeedRule
+	err := database.GetDB().Model(model.SpeedRule{}).Order("id asc").Find(&rules).Error
+	return rules, err
+}
+
+// CheckRule validates a rule before it is saved
+func (s *SpeedRuleService) CheckRule(rule *model.SpeedRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	rule.Group = strings.TrimSpace(rule.Group)
+	rule.StartTime = strings.TrimSpace(rule.StartTime)
+	rule.EndTime = strings.TrimSpace(rule.EndTime)
+	if rule.Name == "" {
+		return errors.New("speed rule name can not be empty")
+	}
+	if rule.Speed <= 0 {
+		return errors.New("speed rule speed must be positive")
+	}
+	tiers, err := s.settingService.GetSpeedTiers()
+	if err != nil {
+		return err
+	}
+	// A tier speed caps unlimited clients to a level of the tier ladder, which Xray always has
+	rule.Speed = model.SnapSpeed(tiers, rule.Speed)
+	if rule.TrafficPercent < 0 || rule.TrafficPercent > 100 {
+		return common.NewError("invalid speed rule traffic percent:", rule.TrafficPercent)
+	}
+	if (rule.StartTime == "") != (rule.EndTime == "") {
+		return errors.New("speed rule needs both a start and an end time")
+	}
+	if rule.StartTime != "" {
+		start, err := parseClock(rule.StartTime)
+		if err != nil {
+			return err
+		}
+		end, err := parseClock(rule.EndTime)
+		if err != nil {
+			return err
+		}
+		if start == end {
+			return errors.New("speed rule start and end time can not be equal")
+		}
+	}
+	if rule.TrafficPercent == 0 && rule.StartTime == "" {
+		return errors.New("speed rule needs a traffic percent or a time range")
+	}
+	return nil
+}
+
+// SaveRule creates a rule, or replaces the one with the same id. It reports whether
+// Xray must be restarted to create the policy level of a new speed, which is never
+// the case with speed tiers.
+func (s *SpeedRuleService) SaveRule(rule *model.SpeedRule) (bool, error) {
+	if err := s.CheckRule(rule); err != nil {
+		return false, err
+	}
+	var err error
+	db := database.GetDB()
+	var existing *model.SpeedRule
+	if rule.Id > 0 {
+		existing = &model.SpeedRule{}
+		if err := db.First(existing, rule.Id).Error; err != nil {
+			return false, err
+		}
+	}
+	if existing == nil {
+		err = db.Create(rule).Error
+	} else {
+		err = db.Save(rule).Error
+	}
+	if err != nil {
+		return false, err
+	}
+	s.auditService.Record(s.actor, "speedRule.save", rule.Name, existing, rule)
+	if !rule.Enable || (existing != nil && existing.Enable && existing.Speed == rule.Speed) {
+		return false, nil
+	}
+	return s.missingLevels(rule.Speed)
+}
+
+// missingLevels reports whether the running Xray lacks the policy level of a client capped to the speed
+func (s *SpeedRuleService) missingLevels(speed int) (bool, error) {
+	var rows []*model.InboundClient
+	err := database.GetDB().Select("speed_limit", "upload_limit", "download_limit").Find(&rows).Error
+	if err != nil {
+		return false, err
+	}
+	for _, row := range rows {
+		client := row.ToClient()
+		if _, ok := SpeedLevel(client.SpeedProfile().Cap(speed)); !ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *SpeedRuleService) DelRule(id int) error {
+	db := database.GetDB()
+	rule := &model.SpeedRule{}
+	if err := db.First(rule, id).Error; err != nil {
+		return err
+	}
+	err := db.Delete(model.SpeedRule{}, id).Error
+	if err == nil {
+		s.auditService.Record(s.actor, "speedRule.delete", rule.Name, rule, nil)
+	}
+	return err
+}
+
+// RuleSpeeds returns the speeds of the enabled rules, so that Xray has a policy level for each of them.
+func (s *SpeedRuleService) RuleSpeeds() ([]int, error) {
+	var speeds []int
+	err := database.GetDB().Model(model.SpeedRule{}).Where("enable = ? AND speed > 0", true).
+		Distinct().Pluck("speed", &speeds).Error
+	return speeds, err
+}
+
+// GetTargets evaluates the enabled rules and the burst allowances at now for every enabled
+// client of an enabled inbound. Each call charges the traffic since the last one to the
+// allowances, so it is only called by the speed rule job.
+func (s *SpeedRuleService) GetTargets(now time.Time) (map[string]*SpeedRuleTarget, error) {
+	db := database.GetDB()
+	var rules []*model.SpeedRule
+	if err := db.Where("enable = ?", true).Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	var bursts int64
+	if err := db.Model(model.InboundClient{}).Where("burst_limit > 0").Count(&bursts).Error; err != nil {
+		return nil, err
+	}
+	targets := make(map[string]*SpeedRuleTarget)
+	withBurst := make(map[string]bool)
+	if len(rules) == 0 && bursts == 0 {
+		forgetBursts(withBurst)
+		return targets, nil
+	}
+	location, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		return nil, err
+	}
+	clock := now.In(location)
+	minute := clock.Hour()*60 + clock.Minute()
+
+	// Disabled clients are not in Xray, re-adding them would enable them again
+	var traffics []*xray.ClientTraffic
+	if err := db.Model(xray.ClientTraffic{}).Find(&traffics).Error; err != nil {
+		return nil, err
+	}
+	trafficByEmail := make(map[string]*xray.ClientTraffic, len(traffics))
+	for _, traffic := range traffics {
+		trafficByEmail[traffic.Email] = traffic
+	}
+
+	var inbounds []*model.Inbound
+	err = db.Model(model.Inbound{}).Where("enable = ?", true).
+		Where("protocol IN ?", []model.Protocol{model.VMESS, model.VLESS, model.Trojan, model.Shadowsocks}).
+		Find(&inbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, inbound := range inbounds {
+		clients, err := model.LoadClients(db, inbound.Id)
+		if err != nil {
+			return nil, err
+		}
+		settings := map[string]any{}
+		json.Unmarshal([]byte(inbound.Settings), &settings)
+		method, _ := settings["method"].(string)
+		for _, row := range clients {
+			traffic := trafficByEmail[row.Email]
+			if row.Email == "" || !row.Enable || traffic == nil || !traffic.Enable {
+				continue
+			}
+			target := &SpeedRuleTarget{
+				Email:    row.Email,
+				Tag:      inbound.Tag,
+				Protocol: inbound.Protocol,
+				Method:   method,
+				Client:   row.ToClient(),
+			}
+			own := target.Client.SpeedProfile()
+			for _, rule := range rules {
+				if !ruleMatches(rule, inbound.Id, row.Group, traffic, minute) || own.Cap(rule.Speed) == own {
+					continue
+				}
+				if target.Rule == nil || rule.Speed < target.Rule.Speed {
+					target.Rule = rule
+				}
+			}
+			if target.Client.BurstLimit > 0 && !own.IsZero() {
+				withBurst[row.Email] = true
+				target.Bursting = updateBurst(&target.Client, traffic, now)
+			}
+			targets[row.Email] = target
+		}
+	}
+	forgetBursts(withBurst)
+	return targets, nil
+}
+
+// XrayUser builds the user map passed to XrayAPI.AddUser with the given policy level
+func (t *SpeedRuleTarget) XrayUser(level int) map[string]any {
+	return map[string]any{
+		"email":    t.Email,
+		"id":       t.Client.ID,
+		"security": t.Client.Security,
+		"flow":     t.Client.Flow,
+		"password": t.Client.Password,
+		"cipher":   t.Method,
+		"level":    level,
+	}
+}
+
+// Moved reports whether a rule or the burst allowance keeps the client off its own level
+func (t *SpeedRuleTarget) Moved() bool {
+	return t.Rule != nil || t.Bursting
+}
+
+// Level returns the policy level the client should be on now
+func (t *SpeedRuleTarget) Level() int {
+	profile := t.Client.SpeedProfile()
+	if t.Rule != nil {
+		profile = profile.Cap(t.Rule.Speed)
+	} else if t.Bursting {
+		return 0
+	}
+	level, _ := SpeedLevel(profile)
+	return level
+}
+
+func ruleMatches(rule *model.SpeedRule, inboundId int, group string, traffic *xray.ClientTraffic, minute int) bool {
+	if rule.InboundId > 0 && rule.InboundId != inboundId {
+		return false
+	}
+	if rule.Group != "" && rule.Group != group {
+		return false
+	}
+	if rule.TrafficPercent > 0 {
+		if traffic.Total <= 0 || (traffic.Up+traffic.Down)*100 < traffic.Total*int64(rule.TrafficPercent) {
+			return false
+		}
+	}
+	if rule.StartTime != "" {
+		// Rules are validated when saved, a broken one never matches
+		start, err1 := parseClock(rule.StartTime)
+		end, err2 := parseClock(rule.EndTime)
+		if err1 != nil || err2 != nil {
+			return false
+		}
+		if start < end {
+			return minute >= start && minute < end
+		}
+		return minute >= start || minute < end
+	}
+	return true
+}
+
+// parseClock parses "HH:MM" into minutes after midnight
+func parseClock(value string) (int, error) {
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, common.NewError("invalid time of day:", value)
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"html"
 	"math/big"
 	"net"
 	"net/url"
@@ -74,6 +75,7 @@ var (
 	client_Flow         string
 	client_Email        string
 	client_LimitIP      int
+	client_DeviceLimit  int
 	client_TotalGB      int64
 	client_ExpiryTime   int64
 	client_Enable       bool
@@ -482,7 +484,7 @@ func (t *Tgbot) OnReceive() {
 				if checkAdmin(message.From.ID) {
 					for _, sharedUser := range message.UsersShared.Users {
 						userID := sharedUser.UserID
-						needRestart, err := t.inboundService.SetClientTelegramUserID(message.UsersShared.RequestID, userID)
+						needRestart, err := t.inboundService.WithActor(tgActor(message.Chat.ID)).SetClientTelegramUserID(message.UsersShared.RequestID, userID)
 						if needRestart {
 							t.xrayService.SetToNeedRestart()
 						}
@@ -691,7 +693,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 				)
 				t.editMessageCallbackTgBot(chatId, callbackQuery.Message.GetMessageID(), inlineKeyboard)
 			case "reset_traffic_c":
-				err := t.inboundService.ResetClientTrafficByEmail(email)
+				err := t.inboundService.WithActor(tgActor(chatId)).ResetClientTrafficByEmail(email)
 				if err == nil {
 					t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.resetTrafficSuccess", "Email=="+email))
 					t.searchClient(chatId, email, callbackQuery.Message.GetMessageID())
@@ -733,7 +735,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 				if len(dataArray) == 3 {
 					limitTraffic, err := strconv.Atoi(dataArray[2])
 					if err == nil {
-						needRestart, err := t.inboundService.ResetClientTrafficLimitByEmail(email, limitTraffic)
+						needRestart, err := t.inboundService.WithActor(tgActor(chatId)).ResetClientTrafficLimitByEmail(email, limitTraffic)
 						if needRestart {
 							t.xrayService.SetToNeedRestart()
 						}
@@ -942,7 +944,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 							}
 
 						}
-						needRestart, err := t.inboundService.ResetClientExpiryTimeByEmail(email, date)
+						needRestart, err := t.inboundService.WithActor(tgActor(chatId)).ResetClientExpiryTimeByEmail(email, date)
 						if needRestart {
 							t.xrayService.SetToNeedRestart()
 						}
@@ -1139,7 +1141,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 				if len(dataArray) == 3 {
 					count, err := strconv.Atoi(dataArray[2])
 					if err == nil {
-						needRestart, err := t.inboundService.ResetClientIpLimitByEmail(email, count)
+						needRestart, err := t.inboundService.WithActor(tgActor(chatId)).ResetClientIpLimitByEmail(email, count)
 						if needRestart {
 							t.xrayService.SetToNeedRestart()
 						}
@@ -1294,6 +1296,86 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 						return
 					}
 				}
+			case "add_client_device_limit_c":
+				if len(dataArray) == 2 {
+					count, _ := strconv.Atoi(dataArray[1])
+					client_DeviceLimit = count
+				}
+
+				messageId := callbackQuery.Message.GetMessageID()
+				inbound, err := t.inboundService.GetInbound(receiver_inbound_ID)
+				if err != nil {
+					t.sendCallbackAnswerTgBot(callbackQuery.ID, err.Error())
+					return
+				}
+				message_text, err := t.BuildInboundClientDataMessage(inbound.Remark, inbound.Protocol)
+				if err != nil {
+					t.sendCallbackAnswerTgBot(callbackQuery.ID, err.Error())
+					return
+				}
+
+				t.addClient(callbackQuery.Message.GetChat().ID, message_text, messageId)
+				t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.successfulOperation"))
+			case "add_client_device_limit_in":
+				if len(dataArray) >= 2 {
+					oldInputNumber, err := strconv.Atoi(dataArray[1])
+					inputNumber := oldInputNumber
+					if err == nil {
+						if len(dataArray) == 3 {
+							num, err := strconv.Atoi(dataArray[2])
+							if err == nil {
+								switch num {
+								case -2:
+									inputNumber = 0
+								case -1:
+									if inputNumber > 0 {
+										inputNumber = (inputNumber / 10)
+									}
+								default:
+									inputNumber = (inputNumber * 10) + num
+								}
+							}
+							if inputNumber == oldInputNumber {
+								t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.successfulOperation"))
+								return
+							}
+							if inputNumber >= 999999 {
+								t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
+								return
+							}
+						}
+						inlineKeyboard := tu.InlineKeyboard(
+							tu.InlineKeyboardRow(
+								tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.cancel")).WithCallbackData(t.encodeQuery("add_client_default_device_limit")),
+							),
+							tu.InlineKeyboardRow(
+								tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.confirmNumber", "Num=="+strconv.Itoa(inputNumber))).WithCallbackData(t.encodeQuery("add_client_device_limit_c "+strconv.Itoa(inputNumber))),
+							),
+							tu.InlineKeyboardRow(
+								tu.InlineKeyboardButton("1").WithCallbackData(t.encodeQuery("add_client_device_limit_in "+strconv.Itoa(inputNumber)+" 1")),
+								tu.InlineKeyboardButton("2").WithCallbackData(t.encodeQuery("add_client_device_limit_in "+strconv.Itoa(inputNumber)+" 2")),
+								tu.InlineKeyboardButton("3").WithCallbackData(t.encodeQuery("add_client_device_limit_in "+strconv.Itoa(inputNumber)+" 3")),
+							),
+							tu.InlineKeyboardRow(
+								tu.InlineKeyboardButton("4").WithCallbackData(t.encodeQuery("add_client_device_limit_in "+strconv.Itoa(inputNumber)+" 4")),
+								tu.InlineKeyboardButton("5").WithCallbackData(t.encodeQuery("add_client_device_limit_in "+strconv.Itoa(inputNumber)+" 5")),
+								tu.InlineKeyboardButton("6").WithCallbackData(t.encodeQuery("add_client_device_limit_in "+strconv.Itoa(inputNumber)+" 6")),
+							),
+							tu.InlineKeyboardRow(
+								tu.InlineKeyboardButton("7").WithCallbackData(t.encodeQuery("add_client_device_limit_in "+strconv.Itoa(inputNumber)+" 7")),
+								tu.InlineKeyboardButton("8").WithCallbackData(t.encodeQuery("add_client_device_limit_in "+strconv.Itoa(inputNumber)+" 8")),
+								tu.InlineKeyboardButton("9").WithCallbackData(t.encodeQuery("add_client_device_limit_in "+strconv.Itoa(inputNumber)+" 9")),
+							),
+							tu.InlineKeyboardRow(
+								tu.InlineKeyboardButton("🔄").WithCallbackData(t.encodeQuery("add_client_device_limit_in "+strconv.Itoa(inputNumber)+" -2")),
+								tu.InlineKeyboardButton("0").WithCallbackData(t.encodeQuery("add_client_device_limit_in "+strconv.Itoa(inputNumber)+" 0")),
+								tu.InlineKeyboardButton("⬅️").WithCallbackData(t.encodeQuery("add_client_device_limit_in "+strconv.Itoa(inputNumber)+" -1")),
+							),
+						)
+						t.editMessageCallbackTgBot(chatId, callbackQuery.Message.GetMessageID(), inlineKeyboard)
+						return
+					}
+				}
 			case "clear_ips":
 				inlineKeyboard := tu.InlineKeyboard(
 					tu.InlineKeyboardRow(
@@ -1305,7 +1387,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 				)
 				t.editMessageCallbackTgBot(chatId, callbackQuery.Message.GetMessageID(), inlineKeyboard)
 			case "clear_ips_c":
-				err := t.inboundService.ClearClientIps(email)
+				err := t.inboundService.WithActor(tgActor(chatId)).ClearClientIps(email)
 				if err == nil {
 					t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.clearIpSuccess", "Email=="+email))
 					t.searchClientIps(chatId, email, callbackQuery.Message.GetMessageID())
@@ -1334,7 +1416,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 					t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
 					return
 				}
-				needRestart, err := t.inboundService.SetClientTelegramUserID(traffic.Id, EmptyTelegramUserID)
+				needRestart, err := t.inboundService.WithActor(tgActor(chatId)).SetClientTelegramUserID(traffic.Id, EmptyTelegramUserID)
 				if needRestart {
 					t.xrayService.SetToNeedRestart()
 				}
@@ -1355,7 +1437,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 				)
 				t.editMessageCallbackTgBot(chatId, callbackQuery.Message.GetMessageID(), inlineKeyboard)
 			case "toggle_enable_c":
-				enabled, needRestart, err := t.inboundService.ToggleClientEnableByEmail(email)
+				enabled, needRestart, err := t.inboundService.WithActor(tgActor(chatId)).ToggleClientEnableByEmail(email)
 				if needRestart {
 					t.xrayService.SetToNeedRestart()
 				}
@@ -1393,6 +1475,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 				client_Flow = ""
 				client_Email = t.randomLowerAndNum(8)
 				client_LimitIP = 0
+				client_DeviceLimit = 0
 				client_TotalGB = 0
 				client_ExpiryTime = 0
 				client_Enable = true
@@ -1484,6 +1567,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 		client_Flow = ""
 		client_Email = t.randomLowerAndNum(8)
 		client_LimitIP = 0
+		client_DeviceLimit = 0
 		client_TotalGB = 0
 		client_ExpiryTime = 0
 		client_Enable = true
@@ -1640,6 +1724,35 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 			),
 		)
 		t.editMessageCallbackTgBot(chatId, callbackQuery.Message.GetMessageID(), inlineKeyboard)
+	case "add_client_ch_default_device_limit":
+		inlineKeyboard := tu.InlineKeyboard(
+			tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.cancel")).WithCallbackData(t.encodeQuery("add_client_default_device_limit")),
+			),
+			tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton(t.I18nBot("tgbot.inheritDeviceLimit")).WithCallbackData(t.encodeQuery("add_client_device_limit_c 0")),
+				tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.custom")).WithCallbackData(t.encodeQuery("add_client_device_limit_in 0")),
+			),
+			tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton("1").WithCallbackData(t.encodeQuery("add_client_device_limit_c 1")),
+				tu.InlineKeyboardButton("2").WithCallbackData(t.encodeQuery("add_client_device_limit_c 2")),
+			),
+			tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton("3").WithCallbackData(t.encodeQuery("add_client_device_limit_c 3")),
+				tu.InlineKeyboardButton("4").WithCallbackData(t.encodeQuery("add_client_device_limit_c 4")),
+			),
+			tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton("5").WithCallbackData(t.encodeQuery("add_client_device_limit_c 5")),
+				tu.InlineKeyboardButton("6").WithCallbackData(t.encodeQuery("add_client_device_limit_c 6")),
+				tu.InlineKeyboardButton("7").WithCallbackData(t.encodeQuery("add_client_device_limit_c 7")),
+			),
+			tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton("8").WithCallbackData(t.encodeQuery("add_client_device_limit_c 8")),
+				tu.InlineKeyboardButton("9").WithCallbackData(t.encodeQuery("add_client_device_limit_c 9")),
+				tu.InlineKeyboardButton("10").WithCallbackData(t.encodeQuery("add_client_device_limit_c 10")),
+			),
+		)
+		t.editMessageCallbackTgBot(chatId, callbackQuery.Message.GetMessageID(), inlineKeyboard)
 	case "add_client_default_info":
 		t.deleteMessageTgBot(chatId, callbackQuery.Message.GetMessageID())
 		t.SendMsgToTgbotDeleteAfter(chatId, t.I18nBot("tgbot.messages.using_default_value"), 3, tu.ReplyKeyboardRemove())
@@ -1665,7 +1778,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 		}
 		t.addClient(chatId, message_text, messageId)
 		t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.canceled", "Email=="+client_Email))
-	case "add_client_default_ip_limit":
+	case "add_client_default_ip_limit", "add_client_default_device_limit":
 		messageId := callbackQuery.Message.GetMessageID()
 		inbound, err := t.inboundService.GetInbound(receiver_inbound_ID)
 		if err != nil {
@@ -1681,7 +1794,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 		t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.canceled", "Email=="+client_Email))
 	case "add_client_submit_disable":
 		client_Enable = false
-		_, err := t.SubmitAddClient()
+		_, err := t.SubmitAddClient(chatId)
 		if err != nil {
 			errorMessage := fmt.Sprintf("%v", err)
 			t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.error_add_client", "error=="+errorMessage), tu.ReplyKeyboardRemove())
@@ -1691,7 +1804,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 		}
 	case "add_client_submit_enable":
 		client_Enable = true
-		_, err := t.SubmitAddClient()
+		_, err := t.SubmitAddClient(chatId)
 		if err != nil {
 			errorMessage := fmt.Sprintf("%v", err)
 			t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.error_add_client", "error=="+errorMessage), tu.ReplyKeyboardRemove())
@@ -1721,7 +1834,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 		}
 
 		for _, email := range emails {
-			err := t.inboundService.ResetClientTrafficByEmail(email)
+			err := t.inboundService.WithActor(tgActor(chatId)).ResetClientTrafficByEmail(email)
 			if err == nil {
 				msg := t.I18nBot("tgbot.messages.SuccessResetTraffic", "ClientEmail=="+email)
 				t.SendMsgToTgbot(chatId, msg, tu.ReplyKeyboardRemove())
@@ -2154,15 +2267,22 @@ func (t *Tgbot) BuildInboundClientDataMessage(inbound_remark string, protocol mo
 		ip_limit = fmt.Sprint(client_LimitIP)
 	}
 
+	device_limit := ""
+	if client_DeviceLimit == 0 {
+		device_limit = t.I18nBot("tgbot.inheritDeviceLimit")
+	} else {
+		device_limit = fmt.Sprint(client_DeviceLimit)
+	}
+
 	switch protocol {
 	case model.VMESS, model.VLESS:
-		message = t.I18nBot("tgbot.messages.inbound_client_data_id", "InboundRemark=="+inbound_remark, "ClientId=="+client_Id, "ClientEmail=="+client_Email, "ClientTraffic=="+traffic_value, "ClientExp=="+expiryTime, "IpLimit=="+ip_limit, "ClientComment=="+client_Comment)
+		message = t.I18nBot("tgbot.messages.inbound_client_data_id", "InboundRemark=="+inbound_remark, "ClientId=="+client_Id, "ClientEmail=="+client_Email, "ClientTraffic=="+traffic_value, "ClientExp=="+expiryTime, "IpLimit=="+ip_limit, "DeviceLimit=="+device_limit, "ClientComment=="+client_Comment)
 
 	case model.Trojan:
-		message = t.I18nBot("tgbot.messages.inbound_client_data_pass", "InboundRemark=="+inbound_remark, "ClientPass=="+client_TrPassword, "ClientEmail=="+client_Email, "ClientTraffic=="+traffic_value, "ClientExp=="+expiryTime, "IpLimit=="+ip_limit, "ClientComment=="+client_Comment)
+		message = t.I18nBot("tgbot.messages.inbound_client_data_pass", "InboundRemark=="+inbound_remark, "ClientPass=="+client_TrPassword, "ClientEmail=="+client_Email, "ClientTraffic=="+traffic_value, "ClientExp=="+expiryTime, "IpLimit=="+ip_limit, "DeviceLimit=="+device_limit, "ClientComment=="+client_Comment)
 
 	case model.Shadowsocks:
-		message = t.I18nBot("tgbot.messages.inbound_client_data_pass", "InboundRemark=="+inbound_remark, "ClientPass=="+client_ShPassword, "ClientEmail=="+client_Email, "ClientTraffic=="+traffic_value, "ClientExp=="+expiryTime, "IpLimit=="+ip_limit, "ClientComment=="+client_Comment)
+		message = t.I18nBot("tgbot.messages.inbound_client_data_pass", "InboundRemark=="+inbound_remark, "ClientPass=="+client_ShPassword, "ClientEmail=="+client_Email, "ClientTraffic=="+traffic_value, "ClientExp=="+expiryTime, "IpLimit=="+ip_limit, "DeviceLimit=="+device_limit, "ClientComment=="+client_Comment)
 
 	default:
 		return "", errors.New("unknown protocol")
@@ -2182,6 +2302,7 @@ func (t *Tgbot) BuildJSONForProtocol(protocol model.Protocol) (string, error) {
                 "security": "%s",
                 "email": "%s",
                 "limitIp": %d,
+                "deviceLimit": %d,
                 "totalGB": %d,
                 "expiryTime": %d,
                 "enable": %t,
@@ -2190,7 +2311,7 @@ func (t *Tgbot) BuildJSONForProtocol(protocol model.Protocol) (string, error) {
                 "comment": "%s",
                 "reset": %d
             }]
-        }`, client_Id, client_Security, client_Email, client_LimitIP, client_TotalGB, client_ExpiryTime, client_Enable, client_TgID, client_SubID, client_Comment, client_Reset)
+        }`, client_Id, client_Security, client_Email, client_LimitIP, client_DeviceLimit, client_TotalGB, client_ExpiryTime, client_Enable, client_TgID, client_SubID, client_Comment, client_Reset)
 
 	case model.VLESS:
 		jsonString = fmt.Sprintf(`{
@@ -2199,6 +2320,7 @@ func (t *Tgbot) BuildJSONForProtocol(protocol model.Protocol) (string, error) {
                 "flow": "%s",
                 "email": "%s",
                 "limitIp": %d,
+                "deviceLimit": %d,
                 "totalGB": %d,
                 "expiryTime": %d,
                 "enable": %t,
@@ -2207,7 +2329,7 @@ func (t *Tgbot) BuildJSONForProtocol(protocol model.Protocol) (string, error) {
                 "comment": "%s",
                 "reset": %d
             }]
-        }`, client_Id, client_Flow, client_Email, client_LimitIP, client_TotalGB, client_ExpiryTime, client_Enable, client_TgID, client_SubID, client_Comment, client_Reset)
+        }`, client_Id, client_Flow, client_Email, client_LimitIP, client_DeviceLimit, client_TotalGB, client_ExpiryTime, client_Enable, client_TgID, client_SubID, client_Comment, client_Reset)
 
 	case model.Trojan:
 		jsonString = fmt.Sprintf(`{
@@ -2215,6 +2337,7 @@ func (t *Tgbot) BuildJSONForProtocol(protocol model.Protocol) (string, error) {
                 "password": "%s",
                 "email": "%s",
                 "limitIp": %d,
+                "deviceLimit": %d,
                 "totalGB": %d,
                 "expiryTime": %d,
                 "enable": %t,
@@ -2223,7 +2346,7 @@ func (t *Tgbot) BuildJSONForProtocol(protocol model.Protocol) (string, error) {
                 "comment": "%s",
                 "reset": %d
             }]
-        }`, client_TrPassword, client_Email, client_LimitIP, client_TotalGB, client_ExpiryTime, client_Enable, client_TgID, client_SubID, client_Comment, client_Reset)
+        }`, client_TrPassword, client_Email, client_LimitIP, client_DeviceLimit, client_TotalGB, client_ExpiryTime, client_Enable, client_TgID, client_SubID, client_Comment, client_Reset)
 
 	case model.Shadowsocks:
 		jsonString = fmt.Sprintf(`{
@@ -2232,6 +2355,7 @@ func (t *Tgbot) BuildJSONForProtocol(protocol model.Protocol) (string, error) {
                 "password": "%s",
                 "email": "%s",
                 "limitIp": %d,
+                "deviceLimit": %d,
                 "totalGB": %d,
                 "expiryTime": %d,
                 "enable": %t,
@@ -2240,7 +2364,7 @@ func (t *Tgbot) BuildJSONForProtocol(protocol model.Protocol) (string, error) {
                 "comment": "%s",
                 "reset": %d
             }]
-        }`, client_Method, client_ShPassword, client_Email, client_LimitIP, client_TotalGB, client_ExpiryTime, client_Enable, client_TgID, client_SubID, client_Comment, client_Reset)
+        }`, client_Method, client_ShPassword, client_Email, client_LimitIP, client_DeviceLimit, client_TotalGB, client_ExpiryTime, client_Enable, client_TgID, client_SubID, client_Comment, client_Reset)
 
 	default:
 		return "", errors.New("unknown protocol")
@@ -2249,7 +2373,7 @@ func (t *Tgbot) BuildJSONForProtocol(protocol model.Protocol) (string, error) {
 	return jsonString, nil
 }
 
-func (t *Tgbot) SubmitAddClient() (bool, error) {
+func (t *Tgbot) SubmitAddClient(chatId int64) (bool, error) {
 
 	inbound, err := t.inboundService.GetInbound(receiver_inbound_ID)
 	if err != nil {
@@ -2268,7 +2392,12 @@ func (t *Tgbot) SubmitAddClient() (bool, error) {
 		Settings: jsonString,
 	}
 
-	return t.inboundService.AddInboundClient(newInbound)
+	return t.inboundService.WithActor(tgActor(chatId)).AddInboundClient(newInbound)
+}
+
+// tgActor identifies a Telegram admin chat in the audit log.
+func tgActor(chatId int64) *model.AuditActor {
+	return &model.AuditActor{Type: model.ActorTgBot, Name: strconv.FormatInt(chatId, 10)}
 }
 
 func checkAdmin(tgId int64) bool {
@@ -2335,13 +2464,18 @@ func (t *Tgbot) SendAnswer(chatId int64, msg string, isAdmin bool) {
 }
 
 func (t *Tgbot) SendMsgToTgbot(chatId int64, msg string, replyMarkup ...telego.ReplyMarkup) {
+	t.sendMsg(chatId, msg, replyMarkup...)
+}
+
+// sendMsg sends like SendMsgToTgbot and returns the first error, if any page of the message failed
+func (t *Tgbot) sendMsg(chatId int64, msg string, replyMarkup ...telego.ReplyMarkup) error {
 	if !isRunning {
-		return
+		return errors.New("Telegram bot is not running")
 	}
 
 	if msg == "" {
 		logger.Info("[tgbot] message is empty!")
-		return
+		return nil
 	}
 
 	var allMessages []string
@@ -2366,6 +2500,7 @@ func (t *Tgbot) SendMsgToTgbot(chatId int64, msg string, replyMarkup ...telego.R
 	} else {
 		allMessages = append(allMessages, msg)
 	}
+	var sendErr error
 	for n, message := range allMessages {
 		params := telego.SendMessageParams{
 			ChatID:    tu.ID(chatId),
@@ -2379,9 +2514,13 @@ func (t *Tgbot) SendMsgToTgbot(chatId int64, msg string, replyMarkup ...telego.R
 		_, err := bot.SendMessage(context.Background(), &params)
 		if err != nil {
 			logger.Warning("Error sending telegram message :", err)
+			if sendErr == nil {
+				sendErr = err
+			}
 		}
 		time.Sleep(500 * time.Millisecond)
 	}
+	return sendErr
 }
 
 func (t *Tgbot) SendMsgToTgbotAdmins(msg string, replyMarkup ...telego.ReplyMarkup) {
@@ -2634,12 +2773,14 @@ func (t *Tgbot) prepareServerUsageInfo() string {
 	return info
 }
 
-func (t *Tgbot) UserLoginNotify(username string, password string, ip string, time string, status LoginStatus) {
+// UserLoginNotify reports a panel login attempt. Credentials are never forwarded,
+// the event only carries the masked password hint.
+func (t *Tgbot) UserLoginNotify(event *model.LoginHistory) {
 	if !t.IsRunning() {
 		return
 	}
 
-	if username == "" || ip == "" || time == "" {
+	if event == nil || event.Username == "" || event.IP == "" {
 		logger.Warning("UserLoginNotify failed, invalid info!")
 		return
 	}
@@ -2650,18 +2791,27 @@ func (t *Tgbot) UserLoginNotify(username string, password string, ip string, tim
 	}
 
 	msg := ""
-	switch status {
-	case LoginSuccess:
+	if event.Success {
 		msg += t.I18nBot("tgbot.messages.loginSuccess")
 		msg += t.I18nBot("tgbot.messages.hostname", "Hostname=="+hostname)
-	case LoginFail:
-		msg += t.I18nBot("tgbot.messages.loginFailed")
+	} else {
+		if event.LockedUntil > 0 {
+			msg += t.I18nBot("tgbot.messages.loginLocked")
+		} else {
+			msg += t.I18nBot("tgbot.messages.loginFailed")
+		}
 		msg += t.I18nBot("tgbot.messages.hostname", "Hostname=="+hostname)
-		msg += t.I18nBot("tgbot.messages.password", "Password=="+password)
+		msg += t.I18nBot("tgbot.messages.loginReason", "Reason=="+string(event.Reason))
+		if event.PasswordHint != "" {
+			msg += t.I18nBot("tgbot.messages.passwordHint", "Hint=="+event.PasswordHint)
+		}
+	}
+	msg += t.I18nBot("tgbot.messages.username", "Username=="+html.EscapeString(event.Username))
+	msg += t.I18nBot("tgbot.messages.ip", "IP=="+event.IP)
+	msg += t.I18nBot("tgbot.messages.time", "Time=="+time.Unix(event.CreatedAt, 0).Format("2006-01-02 15:04:05"))
+	if event.LockedUntil > 0 {
+		msg += t.I18nBot("tgbot.messages.lockedUntil", "Time=="+time.Unix(event.LockedUntil, 0).Format("2006-01-02 15:04:05"))
 	}
-	msg += t.I18nBot("tgbot.messages.username", "Username=="+username)
-	msg += t.I18nBot("tgbot.messages.ip", "IP=="+ip)
-	msg += t.I18nBot("tgbot.messages.time", "Time=="+time)
 	t.SendMsgToTgbotAdmins(msg)
 }
 
@@ -3093,6 +3243,9 @@ func (t *Tgbot) addClient(chatId int64, msg string, messageID ...int) {
 				tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.change_comment")).WithCallbackData("add_client_ch_default_comment"),
 				tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.ipLimit")).WithCallbackData("add_client_ch_default_ip_limit"),
 			),
+			tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.deviceLimit")).WithCallbackData("add_client_ch_default_device_limit"),
+			),
 			tu.InlineKeyboardRow(
 				tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.submitDisable")).WithCallbackData("add_client_submit_disable"),
 				tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.submitEnable")).WithCallbackData("add_client_submit_enable"),
@@ -3120,6 +3273,9 @@ func (t *Tgbot) addClient(chatId int64, msg string, messageID ...int) {
 				tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.change_comment")).WithCallbackData("add_client_ch_default_comment"),
 				tu.InlineKeyboardButton("ip limit").WithCallbackData("add_client_ch_default_ip_limit"),
 			),
+			tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.deviceLimit")).WithCallbackData("add_client_ch_default_device_limit"),
+			),
 			tu.InlineKeyboardRow(
 				tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.submitDisable")).WithCallbackData("add_client_submit_disable"),
 				tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.submitEnable")).WithCallbackData("add_client_submit_enable"),
@@ -3147,6 +3303,9 @@ func (t *Tgbot) addClient(chatId int64, msg string, messageID ...int) {
 				tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.change_comment")).WithCallbackData("add_client_ch_default_comment"),
 				tu.InlineKeyboardButton("ip limit").WithCallbackData("add_client_ch_default_ip_limit"),
 			),
+			tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.deviceLimit")).WithCallbackData("add_client_ch_default_device_limit"),
+			),
 			tu.InlineKeyboardRow(
 				tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.submitDisable")).WithCallbackData("add_client_submit_disable"),
 				tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.submitEnable")).WithCallbackData("add_client_submit_enable"),
@@ -3673,7 +3832,7 @@ func (t *Tgbot) remoteCreateOneClickInbound(configType string, chatId int64) {
 	inboundService := InboundService{}
 	inboundService.SetTelegramService(t) // 将当前的 bot 实例注入
 
-	createdInbound, _, err := inboundService.AddInbound(newInbound)
+	createdInbound, _, err := inboundService.WithActor(tgActor(chatId)).AddInbound(newInbound)
 	
 	if err != nil {
 		t.SendMsgToTgbot(chatId, fmt.Sprintf("❌ 远程创建失败: 保存入站时出错: %v", err))
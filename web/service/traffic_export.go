@@ -0,0 +1,360 @@
+package service
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// Authentication modes of the external traffic exporter
+const (
+	TrafficExportAuthNone   = "none"
+	TrafficExportAuthBearer = "bearer" // Authorization: Bearer <secret>
+	TrafficExportAuthHMAC   = "hmac"   // X-Traffic-Signature, signed like webhooks
+)
+
+// Body formats of the external traffic exporter
+const (
+	// TrafficExportFormatLegacy posts one collection per request as
+	// {"clientTraffics": [...], "inboundTraffics": [...]}, the body sent before the exporter
+	// buffered its records. inboundTraffics holds the inbound and outbound traffic.
+	TrafficExportFormatLegacy = "legacy"
+	// TrafficExportFormatBatch posts up to trafficExportBatchSize collections per request
+	// as a TrafficExportBatch
+	TrafficExportFormatBatch = "batch"
+)
+
+const (
+	// trafficExportBatchSize is the number of records sent in one request
+	trafficExportBatchSize = 100
+	// trafficExportMaxBatches caps the requests of one flush, so a long backlog drains over a few runs
+	trafficExportMaxBatches = 10
+	// trafficExportMaxBacklog bounds the buffer; at one record per collection it holds about 11 days
+	trafficExportMaxBacklog = 100000
+)
+
+// TrafficExportAmount is the traffic of one inbound, outbound or client since the previous record.
+type TrafficExportAmount struct {
+	Tag   string `json:"tag,omitempty"`
+	Email string `json:"email,omitempty"`
+	Up    int64  `json:"up"`
+	Down  int64  `json:"down"`
+}
+
+// TrafficExportRecord is one traffic collection as sent to the receiver
+type TrafficExportRecord struct {
+	Seq       int64                  `json:"seq"`
+	Time      int64                  `json:"time"`
+	Inbounds  []*TrafficExportAmount `json:"inbounds,omitempty"`
+	Outbounds []*TrafficExportAmount `json:"outbounds,omitempty"`
+	Clients   []*TrafficExportAmount `json:"clients,omitempty"`
+}
+
+// TrafficExportBatch is the JSON body of one request in the batch format. Records are sent in sequence
+// order and each is sent until it is acknowledged, so the receiver must ignore sequence
+// numbers it has already seen.
+type TrafficExportBatch struct {
+	FirstSeq int64                  `json:"firstSeq"`
+	LastSeq  int64                  `json:"lastSeq"`
+	Records  []*TrafficExportRecord `json:"records"`
+}
+
+// TrafficExportStatus is the state of the exporter shown in the server status.
+type TrafficExportStatus struct {
+	Enabled     bool   `json:"enabled"`
+	Backlog     int64  `json:"backlog"`
+	LastSuccess int64  `json:"lastSuccess"` // unix seconds, 0 if nothing was sent since the panel started
+	LastError   string `json:"lastError"`
+}
+
+// trafficExportState is shared by every copy of the service; it resets with the panel.
+var trafficExportState struct {
+	sync.Mutex
+	failures    int
+	nextAttempt time.Time
+	lastSuccess int64
+	lastError   string
+}
+
+var trafficExportFlushLock sync.Mutex
+
+// TrafficExportService buffers the collected traffic on disk and sends it to the external
+// traffic URI in batches, retrying with back-off until the receiver accepts it.
+type TrafficExportService struct {
+	settingService SettingService
+}
+
+// Enqueue stores the traffic of one collection for export. Nothing is stored while the exporter is disabled.
+func (s *TrafficExportService) Enqueue(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) error {
+	enable, err := s.settingService.GetExternalTrafficInformEnable()
+	if err != nil || !enable {
+		return err
+	}
+	record := &TrafficExportRecord{}
+	for _, traffic := range traffics {
+		if traffic.Up == 0 && traffic.Down == 0 {
+			continue
+		}
+		amount := &TrafficExportAmount{Tag: traffic.Tag, Up: traffic.Up, Down: traffic.Down}
+		if traffic.IsInbound {
+			record.Inbounds = append(record.Inbounds, amount)
+		} else if traffic.IsOutbound {
+			record.Outbounds = append(record.Outbounds, amount)
+		}
+	}
+	for _, traffic := range clientTraffics {
+		if traffic.Up == 0 && traffic.Down == 0 {
+			continue
+		}
+		record.Clients = append(record.Clients, &TrafficExportAmount{Email: traffic.Email, Up: traffic.Up, Down: traffic.Down})
+	}
+	if len(record.Inbounds) == 0 && len(record.Outbounds) == 0 && len(record.Clients) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	db := database.GetDB()
+	err = db.Create(&model.TrafficExport{CreatedAt: time.Now().Unix(), Data: string(data)}).Error
+	if err != nil {
+		return err
+	}
+	var backlog int64
+	if err = db.Model(model.TrafficExport{}).Count(&backlog).Error; err != nil {
+		return err
+	}
+	if backlog > trafficExportMaxBacklog {
+		logger.Warningf("traffic export backlog is full, dropping the %d oldest records", backlog-trafficExportMaxBacklog)
+		err = db.Exec("DELETE FROM traffic_exports WHERE id IN (SELECT id FROM traffic_exports ORDER BY id ASC LIMIT ?)",
+			backlog-trafficExportMaxBacklog).Error
+	}
+	return err
+}
+
+// Flush sends the buffered records, oldest first. After a failure nothing is sent
+// until the back-off has passed, so records always arrive in order.
+func (s *TrafficExportService) Flush() error {
+	if !trafficExportFlushLock.TryLock() {
+		return nil
+	}
+	defer trafficExportFlushLock.Unlock()
+
+	enable, err := s.settingService.GetExternalTrafficInformEnable()
+	if err != nil || !enable {
+		return err
+	}
+	trafficExportState.Lock()
+	wait := time.Now().Before(trafficExportState.nextAttempt)
+	trafficExportState.Unlock()
+	if wait {
+		return nil
+	}
+
+	format, err := s.settingService.GetExternalTrafficInformFormat()
+	if err != nil {
+		return err
+	}
+	// The legacy body holds a single collection, so a run sends as many requests as a batch run holds records
+	batchSize, maxBatches := trafficExportBatchSize, trafficExportMaxBatches
+	if format == TrafficExportFormatLegacy {
+		batchSize, maxBatches = 1, trafficExportBatchSize*trafficExportMaxBatches
+	}
+	client, err := s.newClient()
+	if err != nil {
+		s.recordResult(err)
+		return err
+	}
+	for range maxBatches {
+		var rows []*model.TrafficExport
+		err = database.GetDB().Order("id asc").Limit(batchSize).Find(&rows).Error
+		if err != nil || len(rows) == 0 {
+			return err
+		}
+		err = s.send(client, format, rows)
+		s.recordResult(err)
+		if err != nil {
+			return err
+		}
+		err = database.GetDB().Where("id <= ?", rows[len(rows)-1].Id).Delete(model.TrafficExport{}).Error
+		if err != nil || len(rows) < batchSize {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordResult updates the back-off: 10s doubling up to 10 minutes while the receiver keeps failing
+func (s *TrafficExportService) recordResult(err error) {
+	trafficExportState.Lock()
+	defer trafficExportState.Unlock()
+	if err == nil {
+		trafficExportState.failures = 0
+		trafficExportState.nextAttempt = time.Time{}
+		trafficExportState.lastSuccess = time.Now().Unix()
+		trafficExportState.lastError = ""
+		return
+	}
+	trafficExportState.failures++
+	delay := min(10*time.Second<<min(trafficExportState.failures-1, 10), 10*time.Minute)
+	trafficExportState.nextAttempt = time.Now().Add(delay)
+	trafficExportState.lastError = err.Error()
+}
+
+// newClient builds the HTTP client, presenting the configured client certificate for mTLS
+// and trusting the configured CA in addition to the system ones.
+func (s *TrafficExportService) newClient() (*http.Client, error) {
+	certFile, err := s.settingService.GetExternalTrafficInformCertFile()
+	if err != nil {
+		return nil, err
+	}
+	keyFile, err := s.settingService.GetExternalTrafficInformKeyFile()
+	if err != nil {
+		return nil, err
+	}
+	caFile, err := s.settingService.GetExternalTrafficInformCAFile()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, common.NewError("no certificate found in", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Timeout: 15 * time.Second, Transport: transport}, nil
+}
+
+func (s *TrafficExportService) send(client *http.Client, format string, rows []*model.TrafficExport) error {
+	informURL, err := s.settingService.GetExternalTrafficInformURI()
+	if err != nil {
+		return err
+	}
+	if informURL == "" {
+		return errors.New("external traffic URI is empty")
+	}
+	auth, err := s.settingService.GetExternalTrafficInformAuth()
+	if err != nil {
+		return err
+	}
+	secret, err := s.settingService.GetExternalTrafficInformSecret()
+	if err != nil {
+		return err
+	}
+
+	batch := &TrafficExportBatch{FirstSeq: rows[0].Id, LastSeq: rows[len(rows)-1].Id}
+	for _, row := range rows {
+		record := &TrafficExportRecord{}
+		if err := json.Unmarshal([]byte(row.Data), record); err != nil {
+			logger.Warning("skip broken traffic export record", row.Id, ":", err)
+			continue
+		}
+		record.Seq = row.Id
+		record.Time = row.CreatedAt
+		batch.Records = append(batch.Records, record)
+	}
+	var body []byte
+	if format == TrafficExportFormatLegacy {
+		body, err = json.Marshal(legacyTrafficBody(batch.Records))
+	} else {
+		body, err = json.Marshal(batch)
+	}
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, informURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	request.Header.Set("User-Agent", "X-Panel-Traffic-Exporter")
+	request.Header.Set("X-Traffic-Sequence", strconv.FormatInt(batch.FirstSeq, 10)+"-"+strconv.FormatInt(batch.LastSeq, 10))
+	switch auth {
+	case TrafficExportAuthBearer:
+		request.Header.Set("Authorization", "Bearer "+secret)
+	case TrafficExportAuthHMAC:
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		request.Header.Set("X-Traffic-Timestamp", timestamp)
+		request.Header.Set("X-Traffic-Signature", signPayload(secret, timestamp, string(body)))
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 256))
+		return common.NewErrorf("unexpected status %d: %s", response.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// legacyTrafficBody converts records back into the body of the old traffic inform,
+// which receivers written for earlier versions expect
+func legacyTrafficBody(records []*TrafficExportRecord) map[string]any {
+	inboundTraffics := []*xray.Traffic{}
+	clientTraffics := []*xray.ClientTraffic{}
+	for _, record := range records {
+		for _, amount := range record.Inbounds {
+			inboundTraffics = append(inboundTraffics, &xray.Traffic{IsInbound: true, Tag: amount.Tag, Up: amount.Up, Down: amount.Down})
+		}
+		for _, amount := range record.Outbounds {
+			inboundTraffics = append(inboundTraffics, &xray.Traffic{IsOutbound: true, Tag: amount.Tag, Up: amount.Up, Down: amount.Down})
+		}
+		for _, amount := range record.Clients {
+			clientTraffics = append(clientTraffics, &xray.ClientTraffic{Email: amount.Email, Up: amount.Up, Down: amount.Down})
+		}
+	}
+	return map[string]any{"clientTraffics": clientTraffics, "inboundTraffics": inboundTraffics}
+}
+
+// GetStatus returns the backlog and the outcome of the last export attempts
+func (s *TrafficExportService) GetStatus() *TrafficExportStatus {
+	status := &TrafficExportStatus{}
+	status.Enabled, _ = s.settingService.GetExternalTrafficInformEnable()
+	if err := database.GetDB().Model(model.TrafficExport{}).Count(&status.Backlog).Error; err != nil {
+		logger.Warning("count traffic export backlog failed:", err)
+	}
+	trafficExportState.Lock()
+	status.LastSuccess = trafficExportState.lastSuccess
+	status.LastError = trafficExportState.lastError
+	trafficExportState.Unlock()
+	return status
+}
@@ -0,0 +1,315 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/xray"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TrafficQuery selects the traffic history to return. Times are unix seconds.
+type TrafficQuery struct {
+	Kind     model.TrafficKind `json:"kind" form:"kind"`
+	Name     string            `json:"name" form:"name"`         // empty sums every object of the kind
+	From     int64             `json:"from" form:"from"`         // defaults to 24 hours before To
+	To       int64             `json:"to" form:"to"`             // defaults to now
+	Interval string            `json:"interval" form:"interval"` // minute, hour or day; chosen from the range when empty
+	Limit    int               `json:"limit" form:"limit"`       // number of entries of the top list
+	// Names restricts the query to these objects when not nil (used for resellers)
+	Names []string `json:"-" form:"-"`
+}
+
+// TrafficPoint is the traffic of one interval of a history.
+type TrafficPoint struct {
+	Time int64 `json:"time"`
+	Up   int64 `json:"up"`
+	Down int64 `json:"down"`
+}
+
+// TrafficUsage is the traffic of one object over the queried range.
+type TrafficUsage struct {
+	Name  string `json:"name"`
+	Up    int64  `json:"up"`
+	Down  int64  `json:"down"`
+	Total int64  `json:"total"`
+}
+
+// TrafficStatService keeps the time-series traffic history. New traffic goes into
+// minute buckets, which are compacted into hour and day buckets as they age.
+type TrafficStatService struct {
+	settingService SettingService
+}
+
+var trafficIntervals = map[string]int64{
+	"minute": model.TrafficPeriodMinute,
+	"hour":   model.TrafficPeriodHour,
+	"day":    model.TrafficPeriodDay,
+}
+
+// maxTrafficPoints caps the length of a history before a coarser interval is used
+const maxTrafficPoints = 1500
+
+// recordTraffic adds the traffic collected from Xray to the current minute buckets.
+// It runs inside the traffic transaction; failures are only logged so that the
+// counters themselves are always updated.
+func (s *TrafficStatService) recordTraffic(tx *gorm.DB, traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) {
+	bucketStart := time.Now().Unix() / model.TrafficPeriodMinute * model.TrafficPeriodMinute
+	stats := make(map[model.TrafficKind]map[string]*model.TrafficStat)
+	add := func(kind model.TrafficKind, name string, up int64, down int64) {
+		if name == "" || (up == 0 && down == 0) {
+			return
+		}
+		if stats[kind] == nil {
+			stats[kind] = make(map[string]*model.TrafficStat)
+		}
+		stat, ok := stats[kind][name]
+		if !ok {
+			stat = &model.TrafficStat{Kind: kind, Name: name, Period: model.TrafficPeriodMinute, BucketStart: bucketStart}
+			stats[kind][name] = stat
+		}
+		stat.Up += up
+		stat.Down += down
+	}
+	for _, traffic := range traffics {
+		if traffic.IsInbound {
+			add(model.TrafficKindInbound, traffic.Tag, traffic.Up, traffic.Down)
+		} else if traffic.IsOutbound {
+			add(model.TrafficKindOutbound, traffic.Tag, traffic.Up, traffic.Down)
+		}
+	}
+	for _, traffic := range clientTraffics {
+		add(model.TrafficKindClient, traffic.Email, traffic.Up, traffic.Down)
+	}
+
+	rows := make([]*model.TrafficStat, 0)
+	for _, byName := range stats {
+		for _, stat := range byName {
+			rows = append(rows, stat)
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+	err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "kind"}, {Name: "name"}, {Name: "period"}, {Name: "bucket_start"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"up":   gorm.Expr("up + excluded.up"),
+			"down": gorm.Expr("down + excluded.down"),
+		}),
+	}).CreateInBatches(rows, 100).Error
+	if err != nil {
+		logger.Warning("record traffic history failed:", err)
+	}
+}
+
+// recordInboundTraffic and recordOutboundTraffic split the Xray traffic between
+// InboundService.AddTraffic and OutboundService.AddTraffic, so nothing is counted twice.
+func (s *TrafficStatService) recordInboundTraffic(tx *gorm.DB, traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) {
+	inbounds := make([]*xray.Traffic, 0, len(traffics))
+	for _, traffic := range traffics {
+		if traffic.IsInbound {
+			inbounds = append(inbounds, traffic)
+		}
+	}
+	s.recordTraffic(tx, inbounds, clientTraffics)
+}
+
+func (s *TrafficStatService) recordOutboundTraffic(tx *gorm.DB, traffics []*xray.Traffic) {
+	outbounds := make([]*xray.Traffic, 0, len(traffics))
+	for _, traffic := range traffics {
+		if traffic.IsOutbound {
+			outbounds = append(outbounds, traffic)
+		}
+	}
+	s.recordTraffic(tx, outbounds, nil)
+}
+
+// zoneOffset returns the offset of the panel time zone, so that day buckets start at local midnight
+func (s *TrafficStatService) zoneOffset() int64 {
+	loc, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		return 0
+	}
+	_, offset := time.Now().In(loc).Zone()
+	// keep the modulo in SQL positive
+	return int64((offset%86400 + 86400) % 86400)
+}
+
+// retention returns how long minute and hour buckets are kept, in seconds
+func (s *TrafficStatService) retention() (minute int64, hour int64, err error) {
+	minuteHours, err := s.settingService.GetTrafficMinuteRetention()
+	if err != nil {
+		return 0, 0, err
+	}
+	hourDays, err := s.settingService.GetTrafficHourRetention()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int64(max(minuteHours, 1)) * 3600, int64(max(hourDays, 1)) * 86400, nil
+}
+
+// Compact rolls aged minute buckets into hour buckets and aged hour buckets into
+// day buckets, then drops day buckets older than the configured retention.
+func (s *TrafficStatService) Compact() error {
+	minuteRetention, hourRetention, err := s.retention()
+	if err != nil {
+		return err
+	}
+	dayRetention, err := s.settingService.GetTrafficDayRetention()
+	if err != nil {
+		return err
+	}
+	offset := s.zoneOffset()
+	now := time.Now().Unix()
+
+	db := database.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := s.rollUp(tx, model.TrafficPeriodMinute, model.TrafficPeriodHour, alignBucket(now-minuteRetention, model.TrafficPeriodHour, offset), offset)
+		if err != nil {
+			return err
+		}
+		err = s.rollUp(tx, model.TrafficPeriodHour, model.TrafficPeriodDay, alignBucket(now-hourRetention, model.TrafficPeriodDay, offset), offset)
+		if err != nil {
+			return err
+		}
+		if dayRetention > 0 {
+			cutoff := now - int64(dayRetention)*86400
+			err = tx.Where("period = ? AND bucket_start < ?", model.TrafficPeriodDay, cutoff).Delete(model.TrafficStat{}).Error
+		}
+		return err
+	})
+}
+
+// rollUp merges the buckets of one period that start before cutoff into buckets of a longer period
+func (s *TrafficStatService) rollUp(tx *gorm.DB, from int64, to int64, cutoff int64, offset int64) error {
+	err := tx.Exec(`INSERT INTO traffic_stats (kind, name, period, bucket_start, up, down)
+		SELECT kind, name, ?, bucket_start - ((bucket_start + ?) % ?) AS start, SUM(up), SUM(down)
+		FROM traffic_stats
+		WHERE period = ? AND bucket_start < ?
+		GROUP BY kind, name, start
+		ON CONFLICT (kind, name, period, bucket_start) DO UPDATE SET up = up + excluded.up, down = down + excluded.down`,
+		to, offset, to, from, cutoff).Error
+	if err != nil {
+		return err
+	}
+	return tx.Where("period = ? AND bucket_start < ?", from, cutoff).Delete(model.TrafficStat{}).Error
+}
+
+func alignBucket(t int64, period int64, offset int64) int64 {
+	return t - (t+offset)%period
+}
+
+// normalize fills in the defaults of a query and returns the interval to group by, in seconds.
+// The interval is never finer than the buckets still stored for the start of the range.
+func (s *TrafficStatService) normalize(query *TrafficQuery) (int64, error) {
+	switch query.Kind {
+	case model.TrafficKindClient, model.TrafficKindInbound, model.TrafficKindOutbound:
+	default:
+		return 0, errors.New("invalid traffic kind")
+	}
+	now := time.Now().Unix()
+	if query.To <= 0 {
+		query.To = now
+	}
+	if query.From <= 0 {
+		query.From = query.To - 86400
+	}
+	if query.From >= query.To {
+		return 0, errors.New("invalid time range")
+	}
+
+	interval := model.TrafficPeriodMinute
+	if query.Interval != "" {
+		var ok bool
+		interval, ok = trafficIntervals[query.Interval]
+		if !ok {
+			return 0, errors.New("invalid interval")
+		}
+	}
+	minuteRetention, hourRetention, err := s.retention()
+	if err != nil {
+		return 0, err
+	}
+	if query.From < now-hourRetention {
+		interval = max(interval, model.TrafficPeriodDay)
+	} else if query.From < now-minuteRetention {
+		interval = max(interval, model.TrafficPeriodHour)
+	}
+	for interval < model.TrafficPeriodDay && (query.To-query.From)/interval > maxTrafficPoints {
+		if interval == model.TrafficPeriodMinute {
+			interval = model.TrafficPeriodHour
+		} else {
+			interval = model.TrafficPeriodDay
+		}
+	}
+	for name, seconds := range trafficIntervals {
+		if seconds == interval {
+			query.Interval = name
+		}
+	}
+	return interval, nil
+}
+
+func (s *TrafficStatService) filter(query *TrafficQuery) *gorm.DB {
+	db := database.GetDB().Model(model.TrafficStat{}).
+		Where("kind = ? AND bucket_start >= ? AND bucket_start < ?", query.Kind, query.From, query.To)
+	if query.Name != "" {
+		db = db.Where("name = ?", query.Name)
+	}
+	if query.Names != nil {
+		db = db.Where("name IN ?", query.Names)
+	}
+	return db
+}
+
+// GetHistory returns the traffic of one object, or of every object of the kind,
+// grouped by interval. Buckets are matched by their start time, so the bounds of
+// the range snap to the stored buckets.
+func (s *TrafficStatService) GetHistory(query *TrafficQuery) ([]*TrafficPoint, error) {
+	interval, err := s.normalize(query)
+	if err != nil {
+		return nil, err
+	}
+	offset := int64(0)
+	if interval == model.TrafficPeriodDay {
+		offset = s.zoneOffset()
+	}
+	points := make([]*TrafficPoint, 0)
+	err = s.filter(query).
+		Select("bucket_start - ((bucket_start + ?) % ?) AS time, SUM(up) AS up, SUM(down) AS down", offset, interval).
+		Where("period <= ?", interval).
+		Group("time").
+		Order("time asc").
+		Scan(&points).Error
+	if err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// GetTop returns the objects of the kind that used the most traffic in the range.
+func (s *TrafficStatService) GetTop(query *TrafficQuery) ([]*TrafficUsage, error) {
+	if _, err := s.normalize(query); err != nil {
+		return nil, err
+	}
+	if query.Limit <= 0 || query.Limit > 100 {
+		query.Limit = 10
+	}
+	usages := make([]*TrafficUsage, 0)
+	err := s.filter(query).
+		Select("name, SUM(up) AS up, SUM(down) AS down, SUM(up + down) AS total").
+		Group("name").
+		Order("total desc").
+		Limit(query.Limit).
+		Scan(&usages).Error
+	if err != nil {
+		return nil, err
+	}
+	return usages, nil
+}
@@ -6,6 +6,7 @@ import (
 	"x-ui/database"
 	"x-ui/database/model"
 	"x-ui/logger"
+	"x-ui/util/common"
 	"x-ui/util/crypto"
 
 	"github.com/xlzd/gotp"
@@ -13,23 +14,247 @@ import (
 )
 
 type UserService struct {
-	settingService SettingService
+	passkeyService PasskeyService
+	auditService   AuditService
+	actor          *model.AuditActor
 }
 
+// WithActor returns a copy of the service whose changes are audited as made by actor.
+func (s *UserService) WithActor(actor *model.AuditActor) *UserService {
+	scoped := *s
+	scoped.actor = actor
+	return &scoped
+}
+
+// GetFirstUser returns the oldest owner account, falling back to the first user
 func (s *UserService) GetFirstUser() (*model.User, error) {
 	db := database.GetDB()
 
 	user := &model.User{}
 	err := db.Model(model.User{}).
+		Where("role = ?", model.RoleOwner).
+		Order("id asc").
 		First(user).
 		Error
+	if database.IsNotFound(err) {
+		err = db.Model(model.User{}).Order("id asc").First(user).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *UserService) GetUserById(id int) (*model.User, error) {
+	db := database.GetDB()
+
+	user := &model.User{}
+	err := db.Model(model.User{}).Where("id = ?", id).First(user).Error
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (s *UserService) CheckUser(username string, password string, twoFactorCode string) *model.User {
+func (s *UserService) GetUserByUsername(username string) (*model.User, error) {
+	db := database.GetDB()
+
+	user := &model.User{}
+	err := db.Model(model.User{}).Where("username = ?", username).First(user).Error
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetUsers lists all panel accounts without their password hashes
+func (s *UserService) GetUsers() ([]*model.User, error) {
+	db := database.GetDB()
+
+	var users []*model.User
+	err := db.Model(model.User{}).Order("id asc").Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		user.Password = ""
+	}
+	return users, nil
+}
+
+func (s *UserService) checkUsernameExist(username string, ignoreId int) (bool, error) {
+	db := database.GetDB()
+
+	var count int64
+	query := db.Model(model.User{}).Where("username = ?", username)
+	if ignoreId > 0 {
+		query = query.Where("id != ?", ignoreId)
+	}
+	err := query.Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *UserService) countOwners(ignoreId int) (int64, error) {
+	db := database.GetDB()
+
+	var count int64
+	err := db.Model(model.User{}).
+		Where("role = ? AND id != ?", model.RoleOwner, ignoreId).
+		Count(&count).
+		Error
+	return count, err
+}
+
+func (s *UserService) AddUser(username string, password string, role model.Role, maxClients int, maxTotalGB int) (*model.User, error) {
+	if username == "" {
+		return nil, errors.New("username can not be empty")
+	} else if password == "" {
+		return nil, errors.New("password can not be empty")
+	}
+	if !role.IsValid() {
+		return nil, common.NewError("invalid role:", role)
+	}
+	if maxClients < 0 || maxTotalGB < 0 {
+		return nil, errors.New("quota can not be negative")
+	}
+	exist, err := s.checkUsernameExist(username, 0)
+	if err != nil {
+		return nil, err
+	}
+	if exist {
+		return nil, common.NewError("username already exists:", username)
+	}
+
+	hashedPassword, err := crypto.HashPasswordAsBcrypt(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &model.User{
+		Username:   username,
+		Password:   hashedPassword,
+		Role:       role,
+		MaxClients: maxClients,
+		MaxTotalGB: maxTotalGB,
+	}
+	err = database.GetDB().Create(user).Error
+	if err != nil {
+		return nil, err
+	}
+	user.Password = ""
+	s.auditService.Record(s.actor, "user.add", username, nil, user)
+	return user, nil
+}
+
+// UpdateUserAccount changes another account's username, role, reseller quotas and,
+// if given, password. The last owner can never be demoted.
+func (s *UserService) UpdateUserAccount(id int, username string, password string, role model.Role, maxClients int, maxTotalGB int) error {
+	if username == "" {
+		return errors.New("username can not be empty")
+	}
+	if !role.IsValid() {
+		return common.NewError("invalid role:", role)
+	}
+	if maxClients < 0 || maxTotalGB < 0 {
+		return errors.New("quota can not be negative")
+	}
+	user, err := s.GetUserById(id)
+	if err != nil {
+		return err
+	}
+	exist, err := s.checkUsernameExist(username, id)
+	if err != nil {
+		return err
+	}
+	if exist {
+		return common.NewError("username already exists:", username)
+	}
+	if user.Role == model.RoleOwner && role != model.RoleOwner {
+		owners, err := s.countOwners(id)
+		if err != nil {
+			return err
+		}
+		if owners == 0 {
+			return errors.New("can not demote the last owner")
+		}
+	}
+
+	updates := map[string]any{
+		"username":     username,
+		"role":         role,
+		"max_clients":  maxClients,
+		"max_total_gb": maxTotalGB,
+	}
+	if password != "" {
+		hashedPassword, err := crypto.HashPasswordAsBcrypt(password)
+		if err != nil {
+			return err
+		}
+		updates["password"] = hashedPassword
+	}
+	err = database.GetDB().Model(model.User{}).Where("id = ?", id).Updates(updates).Error
+	if err == nil && password != "" {
+		// A new password logs the account out everywhere
+		err = revokeUserSessions(id, "")
+	}
+	if err == nil {
+		user.Password = ""
+		s.auditService.Record(s.actor, "user.update", username, user, updates)
+	}
+	return err
+}
+
+func (s *UserService) DelUser(id int) error {
+	user, err := s.GetUserById(id)
+	if err != nil {
+		return err
+	}
+	if user.Role == model.RoleOwner {
+		owners, err := s.countOwners(id)
+		if err != nil {
+			return err
+		}
+		if owners == 0 {
+			return errors.New("can not delete the last owner")
+		}
+	}
+
+	// Inbounds of the removed account are handed over to the primary owner
+	db := database.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		owner, err := s.GetFirstUser()
+		if err != nil {
+			return err
+		}
+		if owner.Id != id {
+			err = tx.Model(&model.Inbound{}).Where("user_id = ?", id).Update("user_id", owner.Id).Error
+			if err != nil {
+				return err
+			}
+		}
+		err = tx.Where("user_id = ?", id).Delete(model.APIToken{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("user_id = ?", id).Delete(model.LoginSession{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Delete(model.User{}, id).Error
+		if err == nil {
+			user.Password = ""
+			s.auditService.RecordTx(tx, s.actor, "user.delete", user.Username, user, nil)
+		}
+		return err
+	})
+}
+
+// CheckUser verifies the login credentials. The result tells why a login was rejected.
+// With LoginResultPasskeyRequired the user is returned but must not be logged in yet.
+func (s *UserService) CheckUser(username string, password string, twoFactorCode string) (*model.User, model.LoginResult) {
 	db := database.GetDB()
 
 	user := &model.User{}
@@ -39,62 +264,144 @@ func (s *UserService) CheckUser(username string, password string, twoFactorCode
 		First(user).
 		Error
 	if err == gorm.ErrRecordNotFound {
-		return nil
+		return nil, model.LoginResultUnknownUser
 	} else if err != nil {
 		logger.Warning("check user err:", err)
-		return nil
+		return nil, model.LoginResultError
 	}
 
 	if !crypto.CheckPasswordHash(user.Password, password) {
-		return nil
+		return nil, model.LoginResultWrongPassword
 	}
 
-	twoFactorEnable, err := s.settingService.GetTwoFactorEnable()
+	hasPasskeys, err := s.passkeyService.HasPasskeys(user.Id)
 	if err != nil {
-		logger.Warning("check two factor err:", err)
-		return nil
+		logger.Warning("check passkeys err:", err)
+		return nil, model.LoginResultError
+	}
+	if !user.TwoFactorEnable && !hasPasskeys {
+		return user, model.LoginResultSuccess
 	}
 
-	if twoFactorEnable {
-		twoFactorToken, err := s.settingService.GetTwoFactorToken()
-
-		if err != nil {
-			logger.Warning("check two factor token err:", err)
-			return nil
+	if twoFactorCode != "" {
+		if checkTwoFactorCode(user, twoFactorCode) {
+			return user, model.LoginResultSuccess
 		}
-
-		if gotp.NewDefaultTOTP(twoFactorToken).Now() != twoFactorCode {
-			return nil
+		// A recovery code replaces any second factor
+		if s.passkeyService.UseRecoveryCode(user.Id, twoFactorCode) {
+			return user, model.LoginResultSuccess
 		}
+		return nil, model.LoginResultWrongTwoFactor
 	}
 
-	return user
+	// The caller still has to verify a passkey before logging the user in
+	if hasPasskeys {
+		return user, model.LoginResultPasskeyRequired
+	}
+	return nil, model.LoginResultWrongTwoFactor
 }
 
 func (s *UserService) UpdateUser(id int, username string, password string) error {
 	db := database.GetDB()
+	exist, err := s.checkUsernameExist(username, id)
+	if err != nil {
+		return err
+	}
+	if exist {
+		return common.NewError("username already exists:", username)
+	}
 	hashedPassword, err := crypto.HashPasswordAsBcrypt(password)
 
 	if err != nil {
 		return err
 	}
 
-	twoFactorEnable, err := s.settingService.GetTwoFactorEnable()
+	// New credentials start without the old second factor, it is set up again afterwards
+	err = db.Model(model.User{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"username":          username,
+			"password":          hashedPassword,
+			"two_factor_enable": false,
+			"two_factor_token":  "",
+		}).
+		Error
+	if err == nil {
+		// Invalidate every session, including stolen cookies, after a credential change
+		err = revokeUserSessions(id, "")
+	}
+	if err == nil {
+		s.auditService.Record(s.actor, "user.updateCredentials", username, nil, map[string]any{"username": username, "password": "changed"})
+	}
+	return err
+}
+
+// checkTwoFactorCode reports whether code is the current TOTP code of the account
+func checkTwoFactorCode(user *model.User, code string) bool {
+	return user.TwoFactorEnable && user.TwoFactorToken != "" && gotp.NewDefaultTOTP(user.TwoFactorToken).Now() == code
+}
+
+// HasTwoFactor reports whether any account uses TOTP, the login page then asks for a code
+func (s *UserService) HasTwoFactor() (bool, error) {
+	var count int64
+	err := database.GetDB().Model(model.User{}).Where("two_factor_enable = ?", true).Count(&count).Error
+	return count > 0, err
+}
+
+// CheckTwoFactor verifies a TOTP code of the account, accounts without TOTP need none
+func (s *UserService) CheckTwoFactor(id int, code string) (bool, error) {
+	user, err := s.GetUserById(id)
+	if err != nil {
+		return false, err
+	}
+	return !user.TwoFactorEnable || checkTwoFactorCode(user, code), nil
+}
+
+// EnableTwoFactor sets a new TOTP secret for the account. code must already be
+// generated from the secret, so a mistyped secret can not lock the account out.
+func (s *UserService) EnableTwoFactor(id int, token string, code string) error {
+	user, err := s.GetUserById(id)
 	if err != nil {
 		return err
 	}
+	if token == "" || gotp.NewDefaultTOTP(token).Now() != code {
+		return errors.New("invalid two-factor code")
+	}
+	err = database.GetDB().Model(model.User{}).
+		Where("id = ?", id).
+		Updates(map[string]any{"two_factor_enable": true, "two_factor_token": token}).
+		Error
+	if err == nil {
+		s.auditService.Record(s.actor, "user.enableTwoFactor", user.Username, nil, nil)
+	}
+	return err
+}
 
-	if twoFactorEnable {
-		s.settingService.SetTwoFactorEnable(false)
-		s.settingService.SetTwoFactorToken("")
+// DisableTwoFactor removes the TOTP secret of the account after checking a current code
+func (s *UserService) DisableTwoFactor(id int, code string) error {
+	user, err := s.GetUserById(id)
+	if err != nil {
+		return err
+	}
+	if user.TwoFactorEnable && !checkTwoFactorCode(user, code) {
+		return errors.New("invalid two-factor code")
 	}
+	return s.ResetTwoFactor(user)
+}
 
-	return db.Model(model.User{}).
-		Where("id = ?", id).
-		Updates(map[string]any{"username": username, "password": hashedPassword}).
+// ResetTwoFactor removes the TOTP secret of the account without a code (used by the CLI)
+func (s *UserService) ResetTwoFactor(user *model.User) error {
+	err := database.GetDB().Model(model.User{}).
+		Where("id = ?", user.Id).
+		Updates(map[string]any{"two_factor_enable": false, "two_factor_token": ""}).
 		Error
+	if err == nil {
+		s.auditService.Record(s.actor, "user.disableTwoFactor", user.Username, nil, nil)
+	}
+	return err
 }
 
+// UpdateFirstUser resets the credentials of the primary owner account (used by the CLI)
 func (s *UserService) UpdateFirstUser(username string, password string) error {
 	if username == "" {
 		return errors.New("username can not be empty")
@@ -108,16 +415,30 @@ func (s *UserService) UpdateFirstUser(username string, password string) error {
 	}
 
 	db := database.GetDB()
-	user := &model.User{}
-	err := db.Model(model.User{}).First(user).Error
+	user, err := s.GetFirstUser()
 	if database.IsNotFound(err) {
-		user.Username = username
-		user.Password = hashedPassword
+		user = &model.User{
+			Username: username,
+			Password: hashedPassword,
+			Role:     model.RoleOwner,
+		}
 		return db.Model(model.User{}).Create(user).Error
 	} else if err != nil {
 		return err
 	}
+	exist, err := s.checkUsernameExist(username, user.Id)
+	if err != nil {
+		return err
+	}
+	if exist {
+		return common.NewError("username already exists:", username)
+	}
 	user.Username = username
 	user.Password = hashedPassword
-	return db.Save(user).Error
+	user.Role = model.RoleOwner
+	err = db.Save(user).Error
+	if err != nil {
+		return err
+	}
+	return revokeUserSessions(user.Id, "")
 }
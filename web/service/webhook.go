@@ -0,0 +1,419 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/util/crypto"
+
+	"gorm.io/gorm"
+)
+
+// WebhookDeliveryFilter narrows down the delivery log listing. Empty fields match everything.
+type WebhookDeliveryFilter struct {
+	Page      int    `json:"page" form:"page"`
+	PageSize  int    `json:"pageSize" form:"pageSize"`
+	WebhookId int    `json:"webhookId" form:"webhookId"`
+	Event     string `json:"event" form:"event"`
+	Status    string `json:"status" form:"status"`
+}
+
+// webhookPayload is the JSON body POSTed to webhook endpoints
+type webhookPayload struct {
+	Event     model.WebhookEvent `json:"event"`
+	Timestamp int64              `json:"timestamp"`
+	Data      any                `json:"data"`
+}
+
+const (
+	// webhookMaxAttempts is how often a delivery is tried before it is marked as failed
+	webhookMaxAttempts = 8
+	// webhookBatchSize caps the deliveries sent by one run of the delivery job
+	webhookBatchSize = 50
+	// webhookLogRetention is how long finished deliveries are kept in the log
+	webhookLogRetention = 7 * 24 * time.Hour
+)
+
+var (
+	webhookSendLock sync.Mutex
+	webhookClient   = &http.Client{Timeout: 10 * time.Second}
+)
+
+// WebhookService manages the outgoing webhooks and delivers panel events to them.
+// Events are stored as deliveries first, so they survive restarts and are retried with back-off.
+type WebhookService struct {
+	auditService AuditService
+	actor        *model.AuditActor
+}
+
+// WithActor returns a copy of the service whose changes are audited as made by actor.
+func (s *WebhookService) WithActor(actor *model.AuditActor) *WebhookService {
+	scoped := *s
+	scoped.actor = actor
+	return &scoped
+}
+
+func (s *WebhookService) GetWebhooks() ([]*model.Webhook, error) {
+	var webhooks []*model.Webhook
+	err := database.GetDB().Model(model.Webhook{}).Order("id asc").Find(&webhooks).Error
+	return webhooks, err
+}
+
+// checkWebhook validates a webhook and brings its event list into canonical form
+func (s *WebhookService) checkWebhook(webhook *model.Webhook) error {
+	webhook.Name = strings.TrimSpace(webhook.Name)
+	if webhook.Name == "" {
+		return errors.New("webhook name can not be empty")
+	}
+	target, err := url.Parse(strings.TrimSpace(webhook.URL))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return common.NewError("invalid webhook url:", webhook.URL)
+	}
+	webhook.URL = target.String()
+
+	var events []string
+	for _, part := range strings.Split(webhook.Events, ",") {
+		event := strings.TrimSpace(part)
+		if event == "" || slices.Contains(events, event) {
+			continue
+		}
+		if event != "*" && !slices.Contains(model.WebhookEvents, model.WebhookEvent(event)) {
+			return common.NewError("invalid webhook event:", event)
+		}
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		return errors.New("at least one event is required")
+	}
+	webhook.Events = strings.Join(events, ",")
+	return nil
+}
+
+// AddWebhook stores a new webhook. A signing secret is generated when none is given.
+func (s *WebhookService) AddWebhook(webhook *model.Webhook) error {
+	if err := s.checkWebhook(webhook); err != nil {
+		return err
+	}
+	if webhook.Secret == "" {
+		secret, err := crypto.RandomHex(24)
+		if err != nil {
+			return err
+		}
+		webhook.Secret = secret
+	}
+	webhook.Id = 0
+	webhook.CreatedAt = time.Now().Unix()
+	err := database.GetDB().Create(webhook).Error
+	if err == nil {
+		s.auditService.Record(s.actor, "webhook.add", webhook.Name, nil, webhook)
+	}
+	return err
+}
+
+// UpdateWebhook changes a webhook. An empty secret keeps the current one.
+func (s *WebhookService) UpdateWebhook(webhook *model.Webhook) error {
+	if err := s.checkWebhook(webhook); err != nil {
+		return err
+	}
+	db := database.GetDB()
+	old := &model.Webhook{}
+	if err := db.First(old, webhook.Id).Error; err != nil {
+		return err
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = old.Secret
+	}
+	webhook.CreatedAt = old.CreatedAt
+	err := db.Save(webhook).Error
+	if err == nil {
+		s.auditService.Record(s.actor, "webhook.update", webhook.Name, old, webhook)
+	}
+	return err
+}
+
+// DelWebhook removes a webhook together with its delivery log and pending deliveries
+func (s *WebhookService) DelWebhook(id int) error {
+	db := database.GetDB()
+	webhook := &model.Webhook{}
+	if err := db.First(webhook, id).Error; err != nil {
+		return err
+	}
+	err := db.Where("webhook_id = ?", id).Delete(model.WebhookDelivery{}).Error
+	if err != nil {
+		return err
+	}
+	err = db.Delete(model.Webhook{}, id).Error
+	if err == nil {
+		s.auditService.Record(s.actor, "webhook.delete", webhook.Name, webhook, nil)
+	}
+	return err
+}
+
+// TestWebhook queues a ping event for one webhook, whether or not it is enabled
+func (s *WebhookService) TestWebhook(id int) (*model.WebhookDelivery, error) {
+	webhook := &model.Webhook{}
+	if err := database.GetDB().First(webhook, id).Error; err != nil {
+		return nil, err
+	}
+	event := &webhookPayload{
+		Event:     model.WebhookEventPing,
+		Timestamp: time.Now().Unix(),
+		Data:      map[string]any{"webhookId": webhook.Id, "name": webhook.Name},
+	}
+	deliveries, err := s.createDeliveries(database.GetDB(), event, []*model.Webhook{webhook})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries[0], nil
+}
+
+// GetDeliveries returns one page of the delivery log, newest first, and the total number of matches.
+func (s *WebhookService) GetDeliveries(filter *WebhookDeliveryFilter) ([]*model.WebhookDelivery, int64, error) {
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PageSize < 1 || filter.PageSize > 500 {
+		filter.PageSize = 50
+	}
+	query := database.GetDB().Model(model.WebhookDelivery{})
+	if filter.WebhookId > 0 {
+		query = query.Where("webhook_id = ?", filter.WebhookId)
+	}
+	if filter.Event != "" {
+		query = query.Where("event = ?", filter.Event)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var deliveries []*model.WebhookDelivery
+	err := query.Order("id desc").
+		Offset((filter.Page - 1) * filter.PageSize).
+		Limit(filter.PageSize).
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return deliveries, total, nil
+}
+
+// RetryDelivery schedules a delivery to be sent again on the next run of the delivery job
+func (s *WebhookService) RetryDelivery(id int) error {
+	result := database.GetDB().Model(model.WebhookDelivery{}).
+		Where("id = ? AND status != ?", id, model.WebhookDeliverySuccess).
+		Updates(map[string]any{"status": model.WebhookDeliveryPending, "next_attempt_at": time.Now().Unix()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("no failed delivery with id", id)
+	}
+	return nil
+}
+
+// Emit stores a pending delivery of the event for every enabled webhook subscribed to it,
+// which DeliverPending sends later. Events raised inside a transaction pass it as tx, so
+// the deliveries are only kept when the change they announce is committed; nil uses the
+// database directly. It never fails the caller; data must be JSON-serialisable.
+func (s *WebhookService) Emit(tx *gorm.DB, event model.WebhookEvent, data any) {
+	if tx == nil {
+		tx = database.GetDB()
+	}
+	var webhooks []*model.Webhook
+	err := tx.Where("enable = ?", true).Find(&webhooks).Error
+	if err != nil {
+		logger.Warning("load webhooks failed:", err)
+		return
+	}
+	subscribed := make([]*model.Webhook, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		if webhook.Subscribes(event) {
+			subscribed = append(subscribed, webhook)
+		}
+	}
+	if len(subscribed) == 0 {
+		return
+	}
+	payload := &webhookPayload{Event: event, Timestamp: time.Now().Unix(), Data: data}
+	if _, err := s.createDeliveries(tx, payload, subscribed); err != nil {
+		logger.Warning("queue webhook deliveries failed:", err)
+	}
+}
+
+func (s *WebhookService) createDeliveries(tx *gorm.DB, event *webhookPayload, webhooks []*model.Webhook) ([]*model.WebhookDelivery, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	deliveries := make([]*model.WebhookDelivery, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		deliveries = append(deliveries, &model.WebhookDelivery{
+			WebhookId:     webhook.Id,
+			Event:         event.Event,
+			Payload:       string(payload),
+			Status:        model.WebhookDeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+	err = tx.Create(&deliveries).Error
+	return deliveries, err
+}
+
+// DeliverPending sends the deliveries that are due, oldest first, and drops old log entries.
+// Runs never overlap; a webhook that fails is left out of the following batches of the run,
+// so the due deliveries of one dead endpoint can not hold up the others.
+func (s *WebhookService) DeliverPending() error {
+	if !webhookSendLock.TryLock() {
+		return nil
+	}
+	defer webhookSendLock.Unlock()
+
+	db := database.GetDB()
+	now := time.Now()
+	err := db.Where("status != ? AND created_at < ?", model.WebhookDeliveryPending, now.Add(-webhookLogRetention).Unix()).
+		Delete(model.WebhookDelivery{}).Error
+	if err != nil {
+		return err
+	}
+
+	webhooks, err := s.GetWebhooks()
+	if err != nil {
+		return err
+	}
+	byId := make(map[int]*model.Webhook, len(webhooks))
+	for _, webhook := range webhooks {
+		byId[webhook.Id] = webhook
+	}
+
+	// Every delivery sent is no longer due afterwards, or its webhook is skipped from then on
+	failing := make(map[int]bool)
+	var skipped []int
+	for {
+		var deliveries []*model.WebhookDelivery
+		query := db.Where("status = ? AND next_attempt_at <= ?", model.WebhookDeliveryPending, now.Unix())
+		if len(skipped) > 0 {
+			query = query.Where("webhook_id NOT IN ?", skipped)
+		}
+		err = query.Order("id asc").Limit(webhookBatchSize).Find(&deliveries).Error
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+		for _, delivery := range deliveries {
+			if failing[delivery.WebhookId] {
+				continue
+			}
+			webhook, ok := byId[delivery.WebhookId]
+			if !ok {
+				failing[delivery.WebhookId] = true
+				skipped = append(skipped, delivery.WebhookId)
+				continue
+			}
+			if !webhook.Enable && delivery.Event != model.WebhookEventPing {
+				delivery.Status = model.WebhookDeliveryFailed
+				delivery.Error = "webhook is disabled"
+			} else if !s.send(webhook, delivery) {
+				failing[webhook.Id] = true
+				skipped = append(skipped, webhook.Id)
+			}
+			err = db.Model(delivery).Select("status", "attempts", "next_attempt_at", "last_attempt_at", "status_code", "error").Updates(delivery).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// webhookRetryDelay is the back-off after the given number of failed attempts: 30s doubling up to an hour
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := 30 * time.Second << (attempts - 1)
+	return min(delay, time.Hour)
+}
+
+// send POSTs one delivery and records the outcome on it. It reports whether the endpoint accepted it.
+//
+// The body is signed with HMAC-SHA256 over "<timestamp>.<body>" using the webhook secret;
+// receivers recompute it from the X-Webhook-Timestamp header and compare it with X-Webhook-Signature.
+func (s *WebhookService) send(webhook *model.Webhook, delivery *model.WebhookDelivery) bool {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = now.Unix()
+	delivery.StatusCode = 0
+	delivery.Error = ""
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewBufferString(delivery.Payload))
+	if err == nil {
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		request.Header.Set("User-Agent", "X-Panel-Webhook")
+		request.Header.Set("X-Webhook-Event", string(delivery.Event))
+		request.Header.Set("X-Webhook-Delivery", strconv.Itoa(delivery.Id))
+		request.Header.Set("X-Webhook-Timestamp", timestamp)
+		request.Header.Set("X-Webhook-Signature", signPayload(webhook.Secret, timestamp, delivery.Payload))
+
+		var response *http.Response
+		response, err = webhookClient.Do(request)
+		if err == nil {
+			body, _ := io.ReadAll(io.LimitReader(response.Body, 256))
+			response.Body.Close()
+			delivery.StatusCode = response.StatusCode
+			if response.StatusCode < 200 || response.StatusCode > 299 {
+				err = common.NewErrorf("unexpected status %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+			}
+		}
+	}
+
+	if err == nil {
+		delivery.Status = model.WebhookDeliverySuccess
+		return true
+	}
+	delivery.Error = truncateRunes(err.Error(), 512)
+	if delivery.Attempts >= webhookMaxAttempts {
+		delivery.Status = model.WebhookDeliveryFailed
+	} else {
+		delivery.NextAttemptAt = now.Add(webhookRetryDelay(delivery.Attempts)).Unix()
+	}
+	logger.Debugf("webhook %s delivery %d failed (attempt %d): %v", webhook.Name, delivery.Id, delivery.Attempts, err)
+	return false
+}
+
+// signPayload returns the HMAC-SHA256 signature of "<timestamp>.<body>" as "sha256=<hex>"
+func signPayload(secret string, timestamp string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookClientData describes a client in event data, leaving out its credentials
+func webhookClientData(inboundId int, client *model.Client) map[string]any {
+	return map[string]any{
+		"email":      client.Email,
+		"inboundId":  inboundId,
+		"group":      client.Group,
+		"subId":      client.SubID,
+		"tgId":       client.TgID,
+		"totalGB":    client.TotalGB,
+		"expiryTime": client.ExpiryTime,
+		"enable":     client.Enable,
+	}
+}
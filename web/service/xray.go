@@ -7,6 +7,7 @@ import (
 	"sync"
     "strconv"
 
+	"x-ui/database/model"
 	"x-ui/logger"
 	"x-ui/xray"
 	json_util "x-ui/util/json_util"
@@ -23,9 +24,11 @@ var (
 )
 
 type XrayService struct {
-	inboundService InboundService
-	settingService SettingService
-	xrayAPI        xray.XrayAPI
+	inboundService     InboundService
+	settingService     SettingService
+	deviceLimitService DeviceLimitService
+	speedRuleService   SpeedRuleService
+	xrayAPI            xray.XrayAPI
 }
 
 // SetXrayAPI 用于从外部注入 XrayAPI 实例
@@ -38,6 +41,14 @@ func (s *XrayService) IsXrayRunning() bool {
 	return p != nil && p.IsRunning()
 }
 
+// GetXrayUptime 返回 Xray 进程已运行的秒数，Xray 未启动时为 0
+func (s *XrayService) GetXrayUptime() uint64 {
+	if p == nil {
+		return 0
+	}
+	return p.GetUptime()
+}
+
 // 中文注释:
 // 新增 GetApiPort 函数。
 // 这个函数的作用是安全地返回当前 Xray 进程正在监听的 API 端口号。
@@ -120,8 +131,13 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 	// =================================================================
 	// 中文注释: 动态限速核心逻辑 - 第一步: 收集所有限速值 
 	// =================================================================
-    // 创建一个 map 用于存储所有出现过的、不为0的限速值
-	uniqueSpeeds := make(map[int]bool)
+    // 创建一个 map 用于存储所有出现过的限速组合，键是它们的 Key
+	uniqueSpeeds := make(map[string]model.SpeedProfile)
+	// 中文注释: 自动限速规则会把客户端的限速压到规则的限速以内，这些组合也要提前生成
+	ruleSpeeds, err := s.speedRuleService.RuleSpeeds()
+	if err != nil {
+		logger.Warning("读取自动限速规则失败:", err)
+	}
 	for _, inbound := range inbounds {
 		if !inbound.Enable {
 			continue
@@ -130,12 +146,40 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
         // 获取该入站下的所有客户端设置
 		dbClients, _ := s.inboundService.GetClients(inbound)
 		for _, dbClient := range dbClients {
-			if dbClient.SpeedLimit > 0 {
-				uniqueSpeeds[dbClient.SpeedLimit] = true
+			profile := dbClient.SpeedProfile()
+			if !profile.IsZero() {
+				uniqueSpeeds[profile.Key()] = profile
+			}
+			for _, speed := range ruleSpeeds {
+				capped := profile.Cap(speed)
+				uniqueSpeeds[capped.Key()] = capped
 			}
 		}
 	}
 
+	// 中文注释: 设备超限时 throttle 策略使用的限速等级也要提前生成
+	throttleSpeeds, err := s.deviceLimitService.ThrottleSpeeds()
+	if err != nil {
+		logger.Warning("读取设备限制的限速等级失败:", err)
+	}
+	for _, speed := range throttleSpeeds {
+		profile := model.SpeedProfile{Up: speed, Down: speed}
+		uniqueSpeeds[profile.Key()] = profile
+	}
+
+	// 中文注释: 每个档位预先生成一个上下行相同的 level，最多 MaxSpeedTiers 个，把客户端调到任意档位都可以
+	// 直接通过 API 生效，无需重启 Xray。上下行不同的组合只生成正在使用的，新的组合仍需重启一次
+	speedTiers, err := s.settingService.GetSpeedTiers()
+	if err != nil {
+		logger.Warning("读取限速档位失败:", err)
+	}
+	for _, tier := range speedTiers {
+		profile := model.SpeedProfile{Up: tier, Down: tier}
+		uniqueSpeeds[profile.Key()] = profile
+	}
+	// 中文注释: 为每个组合分配 level，哈希编号冲突的组合顺延到下一个空闲编号
+	speedLevels := assignSpeedLevels(uniqueSpeeds)
+
 	// =================================================================
 	// 中文注释: 动态限速核心逻辑 - 第二步: 根据收集到的限速值，动态生成 Policy Levels
 	// =================================================================
@@ -185,19 +229,11 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 	// 〔中文注释〕: 将完整配置好的 level 0 写回 policyLevels，确保最终生成的 config.json 是正确的。
 	policyLevels["0"] = level0
 
-	// 4. 遍历所有收集到的限速值，为每个独立的限速值创建对应的 level
-	for speed := range uniqueSpeeds {
-		// 为每个速率创建一个 level，level 的名字就是速率的字符串形式
-		// 例如，速率 1024 KB/s 对应 level "1024"
-		policyLevels[strconv.Itoa(speed)] = map[string]interface{}{
-			"downlinkOnly": speed,
-			"uplinkOnly":   speed,
-			"handshake":         4,
-			"connIdle":          300,
-			"statsUserUplink":   true,
-			"statsUserDownlink": true,
-			"statsUserOnline": true,
-		}
+	// 4. 遍历所有收集到的限速组合，为每个组合创建对应的 level
+	for key, profile := range uniqueSpeeds {
+		// 上下行相同的限速，level 的名字就是速率的字符串形式，例如 1024 KB/s 对应 level "1024"；
+		// 上下行不同的组合使用 assignSpeedLevels 分配的编号
+		policyLevels[strconv.Itoa(speedLevels[key])] = profile.PolicyLevel()
 	}
 
 	// 5. 将修改后的 levels 写回 policy 对象，并序列化回 xrayConfig.Policy，将生成的 policy 应用到 Xray 配置中
@@ -230,17 +266,23 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 		// 先生成一个 inboundConfig（后面会覆盖 Settings/StreamSettings）
 		inboundConfig := inbound.GenXrayInboundConfig()
 
-		// 从 DB clients 建立 email/id -> speedLimit 映射（优先使用 DB 的值）
+		// 从 DB clients 建立 email/id -> 限速 level 映射（优先使用 DB 的值）
 		speedByEmail := make(map[string]int)
 		speedById := make(map[string]int)
+		profileByEmail := make(map[string]model.SpeedProfile)
 		dbClients, _ := s.inboundService.GetClients(inbound)
 		for _, dbc := range dbClients {
+			profile := dbc.SpeedProfile()
+			level := speedLevels[profile.Key()]
+			if dbc.Email != "" {
+				profileByEmail[dbc.Email] = profile
+			}
 			if dbc.Email != "" {
-				speedByEmail[dbc.Email] = dbc.SpeedLimit
+				speedByEmail[dbc.Email] = level
 			}
 			// 如果有 id 字段也建立映射（以防 email 不存在）
 			if dbc.ID != "" {
-				speedById[dbc.ID] = dbc.SpeedLimit
+				speedById[dbc.ID] = level
 			}
 		}
 
@@ -349,7 +391,11 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 				// 【新增功能】在这里添加日志记录
 				// 只有当最终计算出的 level 大于 0，且 email 存在时，才记录日志
 				if level > 0 && email != "" {
-					logger.Infof("为用户 %s 应用〔独立限速〕: %d KB/s", email, level)
+					if profile, ok := profileByEmail[email]; ok && profile.Up != profile.Down {
+						logger.Infof("为用户 %s 应用〔独立限速〕: 上传 %d KB/s, 下载 %d KB/s", email, profile.Up, profile.Down)
+					} else {
+						logger.Infof("为用户 %s 应用〔独立限速〕: %d KB/s", email, level)
+					}
 				}
 				// =================================================================
 
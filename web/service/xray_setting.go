@@ -16,7 +16,12 @@ func (s *XraySettingService) SaveXraySetting(newXraySettings string) error {
 	if err := s.CheckXrayConfig(newXraySettings); err != nil {
 		return err
 	}
-	return s.SettingService.saveSetting("xrayTemplateConfig", newXraySettings)
+	oldXraySettings, _ := s.SettingService.getString("xrayTemplateConfig")
+	err := s.SettingService.saveSetting("xrayTemplateConfig", newXraySettings)
+	if err == nil && oldXraySettings != newXraySettings {
+		s.SettingService.emitSettingChanged([]string{"xrayTemplateConfig"})
+	}
+	return err
 }
 
 func (s *XraySettingService) CheckXrayConfig(XrayTemplateConfig string) error {
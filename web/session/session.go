@@ -10,12 +10,21 @@ import (
 )
 
 const (
-	loginUserKey = "LOGIN_USER"
-	defaultPath  = "/"
+	loginUserKey        = "LOGIN_USER"
+	passkeyChallengeKey = "PASSKEY_CHALLENGE"
+	defaultPath         = "/"
 )
 
+// PasskeyChallenge is a WebAuthn ceremony waiting for the browser's answer.
+type PasskeyChallenge struct {
+	Login  bool // login assertion, otherwise registration
+	UserId int
+	Data   string // serialized webauthn.SessionData
+}
+
 func init() {
 	gob.Register(model.User{})
+	gob.Register(PasskeyChallenge{})
 }
 
 func SetLoginUser(c *gin.Context, user *model.User) {
@@ -63,3 +72,24 @@ func ClearSession(c *gin.Context) {
 		HttpOnly: true,
 	})
 }
+
+func SetPasskeyChallenge(c *gin.Context, challenge *PasskeyChallenge) {
+	s := sessions.Default(c)
+	s.Set(passkeyChallengeKey, *challenge)
+}
+
+// TakePasskeyChallenge returns the pending ceremony of the given kind and removes it,
+// so that every challenge can be answered only once.
+func TakePasskeyChallenge(c *gin.Context, login bool) *PasskeyChallenge {
+	s := sessions.Default(c)
+	obj := s.Get(passkeyChallengeKey)
+	if obj == nil {
+		return nil
+	}
+	s.Delete(passkeyChallengeKey)
+	challenge, ok := obj.(PasskeyChallenge)
+	if !ok || challenge.Login != login {
+		return nil
+	}
+	return &challenge
+}
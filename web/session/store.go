@@ -0,0 +1,206 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/crypto"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+)
+
+const (
+	// lastSeenInterval throttles the last-seen updates written on every request
+	lastSeenInterval = time.Minute
+	// defaultSessionMaxAge is used for sessions saved without an explicit max age
+	defaultSessionMaxAge = 86400
+)
+
+var cleanupOnce sync.Once
+
+// DBStore keeps session data in the database and only a signed session ID in
+// the cookie, so that sessions can be listed and revoked from the server.
+type DBStore struct {
+	codecs  []securecookie.Codec
+	options *gsessions.Options
+}
+
+// NewDBStore creates the store and starts removing expired sessions periodically.
+func NewDBStore(keyPairs ...[]byte) *DBStore {
+	s := &DBStore{
+		codecs: securecookie.CodecsFromPairs(keyPairs...),
+		options: &gsessions.Options{
+			Path:     defaultPath,
+			MaxAge:   defaultSessionMaxAge,
+			HttpOnly: true,
+		},
+	}
+	// The panel restarts in-process, only one cleanup loop is needed
+	cleanupOnce.Do(func() {
+		go func() {
+			for {
+				s.cleanup()
+				time.Sleep(time.Hour)
+			}
+		}()
+	})
+	return s
+}
+
+func (s *DBStore) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+}
+
+func (s *DBStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session referenced by the cookie, or returns an empty one
+// when the cookie is missing, forged, expired or revoked.
+func (s *DBStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	options := *s.options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err = securecookie.DecodeMulti(name, cookie.Value, &id, s.codecs...); err != nil {
+		// Cookies of the previous cookie store or signed with an old secret
+		return session, nil
+	}
+
+	db := database.GetDB()
+	record := &model.LoginSession{}
+	err = db.Where("id = ? AND expires_at > ?", id, time.Now().Unix()).First(record).Error
+	if err != nil {
+		return session, nil
+	}
+	if err = gob.NewDecoder(bytes.NewReader(record.Data)).Decode(&session.Values); err != nil {
+		logger.Warning("decode session failed:", err)
+		return session, nil
+	}
+	session.ID = record.Id
+	session.IsNew = false
+
+	now := time.Now()
+	// Saving the session again keeps the lifetime chosen at login
+	session.Options.MaxAge = int(record.ExpiresAt - now.Unix())
+	if now.Sub(time.Unix(record.LastSeenAt, 0)) > lastSeenInterval {
+		err = db.Model(model.LoginSession{}).Where("id = ?", record.Id).
+			Updates(map[string]any{"last_seen_at": now.Unix(), "ip": requestIP(r)}).Error
+		if err != nil {
+			logger.Warning("update session last seen failed:", err)
+		}
+	}
+	return session, nil
+}
+
+// Save persists the session and refreshes the cookie. A negative max age deletes it.
+func (s *DBStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	db := database.GetDB()
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := db.Where("id = ?", session.ID).Delete(model.LoginSession{}).Error; err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	userId := 0
+	if user, ok := session.Values[loginUserKey].(model.User); ok {
+		userId = user.Id
+	}
+	now := time.Now()
+	record := &model.LoginSession{}
+	if session.ID != "" {
+		err := db.Where("id = ?", session.ID).First(record).Error
+		// A login on an existing session gets a fresh ID against session fixation
+		if err != nil || record.UserId != userId {
+			db.Where("id = ?", session.ID).Delete(model.LoginSession{})
+			session.ID = ""
+			record = &model.LoginSession{}
+		}
+	}
+	if session.ID == "" {
+		id, err := crypto.RandomHex(32)
+		if err != nil {
+			return err
+		}
+		session.ID = id
+		record.Id = id
+		record.CreatedAt = now.Unix()
+		record.UserAgent = r.UserAgent()
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(session.Values); err != nil {
+		return err
+	}
+	maxAge := session.Options.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultSessionMaxAge
+	}
+	record.UserId = userId
+	record.Data = data.Bytes()
+	record.IP = requestIP(r)
+	record.LastSeenAt = now.Unix()
+	record.ExpiresAt = now.Add(time.Duration(maxAge) * time.Second).Unix()
+	if err := db.Save(record).Error; err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func (s *DBStore) cleanup() {
+	err := database.GetDB().Where("expires_at <= ?", time.Now().Unix()).Delete(model.LoginSession{}).Error
+	if err != nil {
+		logger.Warning("clean up expired sessions failed:", err)
+	}
+}
+
+type clientIPKey struct{}
+
+// ClientIP passes the client IP resolved by gin, which only believes forwarded headers
+// from the trusted proxies, to the store. It must run before the sessions middleware,
+// which keeps the request it was given.
+func ClientIP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), clientIPKey{}, c.ClientIP()))
+		c.Next()
+	}
+}
+
+// requestIP returns the client IP stored by ClientIP, or the peer address without it.
+func requestIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok && ip != "" {
+		return ip
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
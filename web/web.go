@@ -23,10 +23,10 @@ import (
 	"x-ui/web/middleware"
 	"x-ui/web/network"
 	"x-ui/web/service"
+	"x-ui/web/session"
 
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	"github.com/robfig/cron/v3"
 )
@@ -202,6 +202,24 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 
 	engine := gin.Default()
 
+	// Forwarded client IPs are only believed from the configured proxies, by default from
+	// a reverse proxy on the same host, such as the nginx of the install script
+	trustedProxies, err := s.settingService.GetTrustedProxies()
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := common.ParseCIDRs(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	var proxyCIDRs []string
+	for _, proxy := range proxies {
+		proxyCIDRs = append(proxyCIDRs, proxy.String())
+	}
+	if err = engine.SetTrustedProxies(proxyCIDRs); err != nil {
+		return nil, err
+	}
+
 	webDomain, err := s.settingService.GetWebDomain()
 	if err != nil {
 		return nil, err
@@ -210,6 +228,30 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	if webDomain != "" {
 		engine.Use(middleware.DomainValidatorMiddleware(webDomain))
 	}
+	engine.Use(func(c *gin.Context) {
+		c.Set("web_domain", webDomain)
+		c.Set("trusted_proxy", common.ContainsIP(proxies, c.RemoteIP()))
+	})
+
+	allowCIDRs, err := s.settingService.GetPanelAllowCIDRs()
+	if err != nil {
+		return nil, err
+	}
+	denyCIDRs, err := s.settingService.GetPanelDenyCIDRs()
+	if err != nil {
+		return nil, err
+	}
+	if allowCIDRs != "" || denyCIDRs != "" {
+		allow, err := common.ParseCIDRs(allowCIDRs)
+		if err != nil {
+			return nil, err
+		}
+		deny, err := common.ParseCIDRs(denyCIDRs)
+		if err != nil {
+			return nil, err
+		}
+		engine.Use(middleware.IPFilterMiddleware(allow, deny))
+	}
 
 	secret, err := s.settingService.GetSecret()
 	if err != nil {
@@ -223,7 +265,8 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	engine.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPaths([]string{basePath + "panel/api/"})))
 	assetsBasePath := basePath + "assets/"
 
-	store := cookie.NewStore(secret)
+	store := session.NewDBStore(secret)
+	engine.Use(session.ClientIP())
 	engine.Use(sessions.Sessions("3x-ui", store))
 	engine.Use(func(c *gin.Context) {
 		c.Set("base_path", basePath)
@@ -287,29 +330,39 @@ func (s *Server) startTask() {
 		logger.Warning("start xray failed:", err)
 	}
 	// Check whether xray is running every second
-	s.cron.AddJob("@every 1s", job.NewCheckXrayRunningJob())
+	s.cron.AddJob("@every 1s", job.Instrument("check_xray_running", job.NewCheckXrayRunningJob()))
 
 	// Check if xray needs to be restarted every 30 seconds
-	s.cron.AddFunc("@every 30s", func() {
+	s.cron.AddJob("@every 30s", job.InstrumentFunc("restart_xray", func() {
 		if s.xrayService.IsNeedRestartAndSetFalse() {
 			err := s.xrayService.RestartXray(false)
 			if err != nil {
 				logger.Error("restart xray failed:", err)
 			}
 		}
-	})
+	}))
 
 	go func() {
 		time.Sleep(time.Second * 5)
 		// Statistics every 10 seconds, start the delay for 5 seconds for the first time, and staggered with the time to restart xray
-		s.cron.AddJob("@every 10s", job.NewXrayTrafficJob())
+		s.cron.AddJob("@every 10s", job.Instrument("xray_traffic", job.NewXrayTrafficJob()))
+		// Move clients to and from the speed of the speed rules that match them
+		s.cron.AddJob("@every 30s", job.Instrument("speed_rule", job.NewSpeedRuleJob()))
+		// Send the collected traffic to the external traffic URI
+		s.cron.AddJob("@every 10s", job.Instrument("traffic_export", job.NewTrafficExportJob()))
 	}()
 
-	// check client ips from log file every 10 sec
-	s.cron.AddJob("@every 10s", job.NewCheckClientIpJob())
-
 	// check client ips from log file every day
-	s.cron.AddJob("@daily", job.NewClearLogsJob())
+	s.cron.AddJob("@daily", job.Instrument("clear_logs", job.NewClearLogsJob()))
+
+	// Compact the traffic history into hourly and daily buckets
+	s.cron.AddJob("@every 10m", job.Instrument("traffic_rollup", job.NewTrafficRollupJob()))
+
+	// Drop login attempts past their retention
+	s.cron.AddJob("@hourly", job.Instrument("login_history", job.NewLoginHistoryJob()))
+
+	// Send queued webhook deliveries and retry the failed ones
+	s.cron.AddJob("@every 5s", job.Instrument("webhook", job.NewWebhookJob()))
 
 	// Make a traffic condition every day, 8:30
 	var entry cron.EntryID
@@ -324,7 +377,7 @@ func (s *Server) startTask() {
 
 		// 【中文注释】在注册每日任务时增加运行时检查，防止 Bot 未启动导致中断。
 		// ======================================================
-		_, err = s.cron.AddFunc(runtime, func() {
+		_, err = s.cron.AddJob(runtime, job.InstrumentFunc("stats_notify", func() {
 			// 【中文注释】: 若 bot 尚未初始化或未运行，则跳过
 			if s.tgbotService == nil {
 				logger.Warning("StatsNotifyJob: tgbotService 为 nil，跳过执行。")
@@ -346,19 +399,22 @@ func (s *Server) startTask() {
 
 			// 【中文注释】: 调用原有每日报告任务
 			job.NewStatsNotifyJob().Run()
-		})
+		}))
 		if err != nil {
 			logger.Warning("Add NewStatsNotifyJob error", err)
 			return
 		}
 
+		// Quota and expiry alerts of the clients
+		s.cron.AddJob("@every 1m", job.Instrument("client_alert", job.NewClientAlertJob()))
+
 		// check for Telegram bot callback query hash storage reset
-		s.cron.AddJob("@every 2m", job.NewCheckHashStorageJob())
+		s.cron.AddJob("@every 2m", job.Instrument("check_hash_storage", job.NewCheckHashStorageJob()))
 
 		// Check CPU load and alarm to TgBot if threshold passes
 		cpuThreshold, err := s.settingService.GetTgCpu()
 		if (err == nil) && (cpuThreshold > 0) {
-			s.cron.AddJob("@every 10s", job.NewCheckCpuJob())
+			s.cron.AddJob("@every 10s", job.Instrument("check_cpu", job.NewCheckCpuJob()))
 		}
 	} else {
 		s.cron.Remove(entry)
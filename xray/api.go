@@ -153,6 +153,7 @@ func (x *XrayAPI) AddUser(Protocol string, inboundTag string, user map[string]an
 		Operation: serial.ToTypedMessage(&command.AddUserOperation{
 			User: &protocol.User{
 				Email:   user["email"].(string),
+				Level:   userLevel(user),
 				Account: account,
 			},
 		}),
@@ -167,6 +168,21 @@ func (x *XrayAPI) AddUser(Protocol string, inboundTag string, user map[string]an
 	return nil
 }
 
+// userLevel reads the optional "level" of a user map, which selects the policy level (speed limit)
+func userLevel(user map[string]any) uint32 {
+	switch level := user["level"].(type) {
+	case int:
+		if level > 0 {
+			return uint32(level)
+		}
+	case float64:
+		if level > 0 {
+			return uint32(level)
+		}
+	}
+	return 0
+}
+
 func (x *XrayAPI) RemoveUser(inboundTag, email string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()